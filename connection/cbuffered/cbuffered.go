@@ -61,3 +61,8 @@ func (sf *Conn) UnreadByte() error {
 func (sf *Conn) Buffered() int {
 	return sf.reader.Buffered()
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
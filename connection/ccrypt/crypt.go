@@ -106,3 +106,14 @@ func (sf *Conn) Read(p []byte) (int, error) {
 func (sf *Conn) Write(p []byte) (int, error) {
 	return sf.w.Write(p)
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// FinishWrite implement connection.WriteFinisher,
+// the data is encrypted and written on each write without trailer, nothing to do.
+func (sf *Conn) FinishWrite() error {
+	return nil
+}
@@ -48,3 +48,14 @@ func (sf *Conn) Read(b []byte) (n int, err error) {
 func (sf *Conn) Write(b []byte) (n int, err error) {
 	return sf.w.Write(b)
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// FinishWrite implement connection.WriteFinisher,
+// the data is encrypted and written on each write without trailer, nothing to do.
+func (sf *Conn) FinishWrite() error {
+	return nil
+}
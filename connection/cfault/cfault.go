@@ -0,0 +1,142 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cfault 实现 net.conn 及 net.PacketConn 故障注入,用于混沌测试.
+// 支持延迟,抖动,带宽限制,随机重置连接,写截断,字节损坏及丢包,采用带种子的随机数,故障序列可复现
+package cfault
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrReset connection reset by fault injection
+var ErrReset = errors.New("cfault: connection reset by fault injection")
+
+// Conn conn with fault injection
+type Conn struct {
+	net.Conn
+	f *injector
+}
+
+// New new a fault injection conn with option.
+// if not set, it will not inject any fault.
+func New(c net.Conn, opts ...Option) *Conn {
+	return &Conn{c, newInjector(opts...)}
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(p []byte) (int, error) {
+	if sf.f.hit(sf.f.resetRate) {
+		sf.Conn.Close()
+		return 0, ErrReset
+	}
+	n, err := sf.Conn.Read(p)
+	if n > 0 {
+		sf.f.delay()
+		sf.f.throttle(n)
+		if sf.f.hit(sf.f.corruptRate) {
+			sf.f.corrupt(p[:n])
+		}
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(p []byte) (int, error) {
+	if sf.f.hit(sf.f.resetRate) {
+		sf.Conn.Close()
+		return 0, ErrReset
+	}
+	sf.f.delay()
+	sf.f.throttle(len(p))
+
+	var err error
+
+	b := p
+	if len(b) > 1 && sf.f.hit(sf.f.truncateRate) {
+		b = b[:1+sf.f.intn(len(b)-1)]
+		err = io.ErrShortWrite
+	}
+	if sf.f.hit(sf.f.corruptRate) {
+		b = append([]byte(nil), b...) // do not modify caller's buffer
+		sf.f.corrupt(b)
+	}
+	n, e := sf.Conn.Write(b)
+	if e != nil {
+		err = e
+	}
+	return n, err
+}
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// PacketConn packet conn with fault injection
+type PacketConn struct {
+	net.PacketConn
+	f *injector
+}
+
+// NewPacketConn new a fault injection packet conn with option.
+// if not set, it will not inject any fault.
+func NewPacketConn(c net.PacketConn, opts ...Option) *PacketConn {
+	return &PacketConn{c, newInjector(opts...)}
+}
+
+// ReadFrom reads a packet from the connection,
+// the lost packet will be discarded and read the next.
+func (sf *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		if sf.f.hit(sf.f.resetRate) {
+			sf.PacketConn.Close()
+			return 0, nil, ErrReset
+		}
+		n, addr, err := sf.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if sf.f.hit(sf.f.lossRate) {
+			continue
+		}
+		sf.f.delay()
+		sf.f.throttle(n)
+		if sf.f.hit(sf.f.corruptRate) {
+			sf.f.corrupt(p[:n])
+		}
+		return n, addr, err
+	}
+}
+
+// WriteTo writes a packet with payload p to addr,
+// the lost packet will not be sent but report success.
+func (sf *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if sf.f.hit(sf.f.resetRate) {
+		sf.PacketConn.Close()
+		return 0, ErrReset
+	}
+	if sf.f.hit(sf.f.lossRate) {
+		return len(p), nil
+	}
+	sf.f.delay()
+	sf.f.throttle(len(p))
+	if sf.f.hit(sf.f.corruptRate) {
+		p = append([]byte(nil), p...) // do not modify caller's buffer
+		sf.f.corrupt(p)
+	}
+	return sf.PacketConn.WriteTo(p, addr)
+}
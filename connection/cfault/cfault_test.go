@@ -0,0 +1,174 @@
+package cfault
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+func TestConn(t *testing.T) {
+	data := []byte("hello world")
+
+	t.Run("no fault", func(t *testing.T) {
+		conn := New(mock.New(new(bytes.Buffer)))
+
+		n, err := conn.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+
+		rd := make([]byte, len(data))
+		n, err = conn.Read(rd)
+		require.NoError(t, err)
+		require.Equal(t, data, rd[:n])
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		conn := New(mock.New(new(bytes.Buffer)), WithLatency(time.Millisecond*50, time.Millisecond*10))
+
+		start := time.Now()
+		_, err := conn.Write(data)
+		require.NoError(t, err)
+		assert.True(t, time.Since(start) >= time.Millisecond*50)
+	})
+
+	t.Run("bandwidth", func(t *testing.T) {
+		conn := New(mock.New(new(bytes.Buffer)), WithBandwidth(1000))
+
+		start := time.Now()
+		_, err := conn.Write(make([]byte, 200))
+		require.NoError(t, err)
+		assert.True(t, time.Since(start) >= time.Millisecond*150)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		conn := New(mock.New(new(bytes.Buffer)), WithResetRate(1))
+
+		_, err := conn.Write(data)
+		require.Equal(t, ErrReset, err)
+		_, err = conn.Read(make([]byte, 10))
+		require.Equal(t, ErrReset, err)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		conn := New(mock.New(buf), WithTruncateRate(1))
+
+		n, err := conn.Write(data)
+		require.Equal(t, io.ErrShortWrite, err)
+		require.True(t, n > 0 && n < len(data))
+		require.Equal(t, data[:n], buf.Bytes())
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		conn := New(mock.New(buf), WithCorruptRate(1))
+		want := append([]byte(nil), data...)
+
+		n, err := conn.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		require.Equal(t, want, data, "caller's buffer should not be modified")
+		require.Equal(t, 1, diff(data, buf.Bytes()))
+	})
+
+	t.Run("seed reproducible", func(t *testing.T) {
+		result := func() []byte {
+			buf := new(bytes.Buffer)
+			conn := New(mock.New(buf), WithSeed(100), WithCorruptRate(0.5))
+			for i := 0; i < 10; i++ {
+				conn.Write(data) // nolint: errcheck
+			}
+			return buf.Bytes()
+		}
+		assert.Equal(t, result(), result())
+	})
+}
+
+func TestPacketConn(t *testing.T) {
+	data := []byte("hello world")
+
+	newPair := func(t *testing.T, opts ...Option) (*PacketConn, net.PacketConn) {
+		pc1, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		pc2, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			pc1.Close()
+			pc2.Close()
+		})
+		return NewPacketConn(pc1, opts...), pc2
+	}
+
+	t.Run("no fault", func(t *testing.T) {
+		pc, peer := newPair(t)
+
+		_, err := pc.WriteTo(data, peer.LocalAddr())
+		require.NoError(t, err)
+
+		rd := make([]byte, 64)
+		n, _, err := peer.ReadFrom(rd)
+		require.NoError(t, err)
+		require.Equal(t, data, rd[:n])
+
+		_, err = peer.WriteTo(data, pc.LocalAddr())
+		require.NoError(t, err)
+		n, addr, err := pc.ReadFrom(rd)
+		require.NoError(t, err)
+		require.Equal(t, data, rd[:n])
+		require.Equal(t, peer.LocalAddr().String(), addr.String())
+	})
+
+	t.Run("loss", func(t *testing.T) {
+		pc, peer := newPair(t, WithLossRate(1))
+
+		n, err := pc.WriteTo(data, peer.LocalAddr())
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+
+		peer.SetReadDeadline(time.Now().Add(time.Millisecond * 100)) // nolint: errcheck
+		_, _, err = peer.ReadFrom(make([]byte, 64))
+		require.Error(t, err)
+
+		_, err = peer.WriteTo(data, pc.LocalAddr())
+		require.NoError(t, err)
+		pc.SetReadDeadline(time.Now().Add(time.Millisecond * 100)) // nolint: errcheck
+		_, _, err = pc.ReadFrom(make([]byte, 64))
+		require.Error(t, err)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		pc, peer := newPair(t, WithCorruptRate(1))
+
+		_, err := pc.WriteTo(data, peer.LocalAddr())
+		require.NoError(t, err)
+		rd := make([]byte, 64)
+		n, _, err := peer.ReadFrom(rd)
+		require.NoError(t, err)
+		require.Equal(t, 1, diff(data, rd[:n]))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		pc, peer := newPair(t, WithResetRate(1))
+
+		_, err := pc.WriteTo(data, peer.LocalAddr())
+		require.Equal(t, ErrReset, err)
+	})
+}
+
+// diff returns the number of different bytes
+func diff(a, b []byte) int {
+	cnt := 0
+	for i := range a {
+		if a[i] != b[i] {
+			cnt++
+		}
+	}
+	return cnt
+}
@@ -0,0 +1,91 @@
+package cfault
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// injector 故障注入器, 带种子的随机数保证故障序列可复现
+type injector struct {
+	seed         int64
+	latency      time.Duration
+	jitter       time.Duration
+	bandwidth    int
+	resetRate    float64
+	truncateRate float64
+	corruptRate  float64
+	lossRate     float64
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	limiter *rate.Limiter
+}
+
+func newInjector(opts ...Option) *injector {
+	f := &injector{seed: 1}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.rand = rand.New(rand.NewSource(f.seed)) // nolint: gosec
+	if f.bandwidth > 0 {
+		f.limiter = rate.NewLimiter(rate.Limit(f.bandwidth), f.bandwidth)
+		f.limiter.AllowN(time.Now(), f.bandwidth) // spend initial burst
+	}
+	return f
+}
+
+// hit returns true with the probability p
+func (sf *injector) hit(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.rand.Float64() < p
+}
+
+// intn returns a random number in [0,n)
+func (sf *injector) intn(n int) int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.rand.Intn(n)
+}
+
+// delay sleep latency with jitter
+func (sf *injector) delay() {
+	d := sf.latency
+	if sf.jitter > 0 {
+		sf.mu.Lock()
+		d += time.Duration(sf.rand.Int63n(int64(sf.jitter)))
+		sf.mu.Unlock()
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// throttle wait until n bytes can pass through the bandwidth cap
+func (sf *injector) throttle(n int) {
+	if sf.limiter == nil {
+		return
+	}
+	for n > 0 {
+		m := n
+		if m > sf.bandwidth {
+			m = sf.bandwidth
+		}
+		sf.limiter.WaitN(context.Background(), m) // nolint: errcheck
+		n -= m
+	}
+}
+
+// corrupt flip one random byte of b in place
+func (sf *injector) corrupt(b []byte) {
+	if len(b) > 0 {
+		b[sf.intn(len(b))] ^= 0xff
+	}
+}
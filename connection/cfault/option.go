@@ -0,0 +1,58 @@
+package cfault
+
+import (
+	"time"
+)
+
+// Option fault injector option
+type Option func(f *injector)
+
+// WithSeed 随机数种子, 相同的种子及调用顺序产生相同的故障序列, default: 1
+func WithSeed(seed int64) Option {
+	return func(f *injector) {
+		f.seed = seed
+	}
+}
+
+// WithLatency 每次读写增加的延迟, 实际延迟为 latency + [0,jitter) 之间的随机值
+func WithLatency(latency, jitter time.Duration) Option {
+	return func(f *injector) {
+		f.latency = latency
+		f.jitter = jitter
+	}
+}
+
+// WithBandwidth 读写带宽限制(bytes/sec), <=0 不限制
+func WithBandwidth(bytesPerSec int) Option {
+	return func(f *injector) {
+		f.bandwidth = bytesPerSec
+	}
+}
+
+// WithResetRate 每次读写以rate的概率重置连接, 连接将被关闭并返回ErrReset
+func WithResetRate(rate float64) Option {
+	return func(f *injector) {
+		f.resetRate = rate
+	}
+}
+
+// WithTruncateRate 每次写以rate的概率只写入部分数据, 并返回io.ErrShortWrite
+func WithTruncateRate(rate float64) Option {
+	return func(f *injector) {
+		f.truncateRate = rate
+	}
+}
+
+// WithCorruptRate 每次读写以rate的概率翻转其中一个字节
+func WithCorruptRate(rate float64) Option {
+	return func(f *injector) {
+		f.corruptRate = rate
+	}
+}
+
+// WithLossRate 每个数据包以rate的概率丢弃, 仅PacketConn有效
+func WithLossRate(rate float64) Option {
+	return func(f *injector) {
+		f.lossRate = rate
+	}
+}
@@ -58,3 +58,28 @@ func (sf *Conn) Write(p []byte) (int, error) {
 	}
 	return n, err
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// CanBypass always true, cflow only count the bytes.
+func (sf *Conn) CanBypass() bool { return true }
+
+// Bypassed count the bytes transferred directly on the inner conn.
+func (sf *Conn) Bypassed(isRead bool, n int64) {
+	if n <= 0 {
+		return
+	}
+	cnt := uint64(n)
+	if isRead && sf.Rc != nil {
+		sf.Rc.Add(cnt)
+	}
+	if !isRead && sf.Wc != nil {
+		sf.Wc.Add(cnt)
+	}
+	if sf.Tc != nil {
+		sf.Tc.Add(cnt)
+	}
+}
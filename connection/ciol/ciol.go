@@ -62,6 +62,19 @@ func (sf *Conn) Write(p []byte) (int, error) {
 	return n, sf.wLimiter.WaitN(sf.ctx, n)
 }
 
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// CanBypass reports true only if there is no any limiter.
+func (sf *Conn) CanBypass() bool {
+	return sf.rLimiter == nil && sf.wLimiter == nil
+}
+
+// Bypassed nothing to do.
+func (sf *Conn) Bypassed(bool, int64) {}
+
 // Close close the Conn
 func (sf *Conn) Close() (err error) {
 	return sf.Conn.Close()
@@ -16,10 +16,14 @@ package connection
 
 import (
 	"context"
+	"errors"
 	"net"
 	"time"
 )
 
+// ErrHalfCloseNotSupported the conn not support half-close
+var ErrHalfCloseNotSupported = errors.New("half-close not supported")
+
 // Dialer A Dialer is a means to establish a connection.
 type Dialer interface {
 	Dial(network, address string) (net.Conn, error)
@@ -30,6 +34,61 @@ type ContextDialer interface {
 	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
+// Unwrapper is implemented by the adorn conn, Unwrap returns the inner conn.
+// NOTE: the adorn conn which transforms bytes should implement WriteFinisher too,
+// otherwise CloseWrite will half-close the inner conn without finishing its stream.
+type Unwrapper interface {
+	Unwrap() net.Conn
+}
+
+// WriteFinisher is implemented by the adorn conn which transforms bytes,
+// FinishWrite completes its output stream(flush the buffered data and write the trailer if any),
+// so that the inner conn can be half-closed, return error if it can not.
+type WriteFinisher interface {
+	Unwrapper
+	FinishWrite() error
+}
+
+// Bypasser is implemented by the adorn conn which does not transform bytes,
+// so the data can be transferred on the inner conn directly(such as splice),
+// bypassing the adorn.
+type Bypasser interface {
+	Unwrapper
+	// CanBypass reports whether the adorn can be bypassed currently.
+	CanBypass() bool
+	// Bypassed reports the n bytes read(isRead = true) or written directly on the inner conn,
+	// it may be called many times during a transfer, once per transferred chunk.
+	Bypassed(isRead bool, n int64)
+}
+
+// CloseWriter is implemented by the conn which support half-close,
+// such as *net.TCPConn, *net.UnixConn and *tls.Conn.
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// CloseWrite shuts down the writing side of the conn, it looks through the adorn conns
+// until one implement CloseWriter, return ErrHalfCloseNotSupported if none.
+// the adorn conn which implement WriteFinisher will finish its stream first,
+// return the error if it can not.
+func CloseWrite(c interface{}) error {
+	for {
+		switch v := c.(type) {
+		case CloseWriter:
+			return v.CloseWrite()
+		case WriteFinisher:
+			if err := v.FinishWrite(); err != nil {
+				return err
+			}
+			c = v.Unwrap()
+		case Unwrapper:
+			c = v.Unwrap()
+		default:
+			return ErrHalfCloseNotSupported
+		}
+	}
+}
+
 // AdornConn defines the conn decorate.
 type AdornConn func(conn net.Conn) net.Conn
 
@@ -24,9 +24,11 @@ import (
 	"go.uber.org/atomic"
 
 	"github.com/thinkgos/jocasta/connection/cencrypt"
+	"github.com/thinkgos/jocasta/connection/cfault"
 	"github.com/thinkgos/jocasta/connection/cflow"
 	"github.com/thinkgos/jocasta/connection/cgzip"
 	"github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/cpcap"
 	"github.com/thinkgos/jocasta/connection/csnappy"
 	"github.com/thinkgos/jocasta/connection/czlib"
 )
@@ -121,3 +123,23 @@ func AdornIol(opts ...ciol.Options) AdornConn {
 		return ciol.New(conn, opts...)
 	}
 }
+
+// AdornFault cfault chain, inject fault for chaos testing
+func AdornFault(opts ...cfault.Option) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return cfault.New(conn, opts...)
+	}
+}
+
+// AdornPcap cpcap chain, record the plaintext at its position in the chain,
+// if w is nil, it will not capture anything.
+func AdornPcap(w *cpcap.Writer) AdornConn {
+	if w != nil {
+		return func(conn net.Conn) net.Conn {
+			return cpcap.New(conn, w)
+		}
+	}
+	return func(conn net.Conn) net.Conn {
+		return conn
+	}
+}
@@ -1,14 +1,18 @@
 package connection
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/things-go/encrypt"
@@ -402,3 +406,70 @@ func TestTCP_Forward(t *testing.T) {
 		}()
 	}
 }
+
+func TestCloseWrite(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	result := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		result <- b
+	}()
+
+	d := &Client{AdornChains: AdornConnsChain{AdornSnappy(true), AdornIol()}}
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, CloseWrite(conn))
+	select {
+	case b := <-result:
+		require.Equal(t, "ping", string(csnappyDecode(t, b)))
+	case <-time.After(time.Second):
+		t.Fatal("peer should receive EOF")
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	require.Equal(t, ErrHalfCloseNotSupported, CloseWrite(AdornIol()(c1)))
+
+	// gzip, zlib 无法结束压缩流, 不关闭内层conn的写
+	for _, adorn := range []AdornConn{AdornGzip(true), AdornZlib(true)} {
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200)) // nolint: errcheck
+			_, err = ioutil.ReadAll(conn)
+			result <- []byte(fmt.Sprint(err))
+		}()
+		d := &Client{AdornChains: AdornConnsChain{adorn, AdornIol()}}
+		conn, err := d.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		err = CloseWrite(conn)
+		require.Error(t, err)
+		require.NotEqual(t, ErrHalfCloseNotSupported, err)
+		require.Contains(t, string(<-result), "timeout")
+		conn.Close()
+	}
+}
+
+func csnappyDecode(t *testing.T, b []byte) []byte {
+	got, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+	return got
+}
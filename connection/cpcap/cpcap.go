@@ -0,0 +1,118 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cpcap 实现net.conn读写数据抓包,以pcapng格式写入文件,可直接使用wireshark打开.
+// 记录的是conn在链上所在位置的明文数据,并根据LocalAddr,RemoteAddr合成tcp/ip头部
+package cpcap
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn conn with traffic capture
+type Conn struct {
+	net.Conn
+	w *Writer
+
+	mu     sync.Mutex
+	local  endpoint
+	remote endpoint
+	lSeq   uint32 // local -> remote 序列号
+	rSeq   uint32 // remote -> local 序列号
+	closed bool
+}
+
+// New new a capture conn, it write a synthesized tcp handshake first.
+func New(conn net.Conn, w *Writer) *Conn {
+	local, remote := normalize(endpointOf(conn.LocalAddr()), endpointOf(conn.RemoteAddr()))
+	c := &Conn{
+		Conn:   conn,
+		w:      w,
+		local:  local,
+		remote: remote,
+		lSeq:   rand.Uint32(),
+		rSeq:   rand.Uint32(),
+	}
+
+	now := time.Now()
+	c.write(now, c.local, c.remote, c.lSeq, 0, flagSYN, nil)
+	c.write(now, c.remote, c.local, c.rSeq, c.lSeq+1, flagSYN|flagACK, nil)
+	c.lSeq++
+	c.rSeq++
+	c.write(now, c.local, c.remote, c.lSeq, c.rSeq, flagACK, nil)
+	return c
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(p []byte) (int, error) {
+	n, err := sf.Conn.Read(p)
+	if n > 0 {
+		sf.mu.Lock()
+		sf.rSeq = sf.record(sf.remote, sf.local, sf.rSeq, sf.lSeq, p[:n])
+		sf.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(p []byte) (int, error) {
+	n, err := sf.Conn.Write(p)
+	if n > 0 {
+		sf.mu.Lock()
+		sf.lSeq = sf.record(sf.local, sf.remote, sf.lSeq, sf.rSeq, p[:n])
+		sf.mu.Unlock()
+	}
+	return n, err
+}
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// Close close the Conn, it write a synthesized tcp fin first.
+func (sf *Conn) Close() error {
+	sf.mu.Lock()
+	if !sf.closed {
+		sf.closed = true
+		now := time.Now()
+		sf.write(now, sf.local, sf.remote, sf.lSeq, sf.rSeq, flagFIN|flagACK, nil)
+		sf.write(now, sf.remote, sf.local, sf.rSeq, sf.lSeq+1, flagFIN|flagACK, nil)
+		sf.write(now, sf.local, sf.remote, sf.lSeq+1, sf.rSeq+1, flagACK, nil)
+	}
+	sf.mu.Unlock()
+	return sf.Conn.Close()
+}
+
+// record split data into segments, and return the next sequence number.
+func (sf *Conn) record(src, dst endpoint, seq, ack uint32, data []byte) uint32 {
+	now := time.Now()
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxSegmentSize {
+			n = maxSegmentSize
+		}
+		sf.write(now, src, dst, seq, ack, flagPSH|flagACK, data[:n])
+		seq += uint32(n)
+		data = data[n:]
+	}
+	return seq
+}
+
+func (sf *Conn) write(ts time.Time, src, dst endpoint, seq, ack uint32, flags byte, payload []byte) {
+	sf.w.WritePacket(ts, buildPacket(src, dst, seq, ack, flags, payload)) // nolint: errcheck
+}
@@ -0,0 +1,192 @@
+package cpcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+// readBlocks parse pcapng file, return block types and enhanced packet data
+func readBlocks(t *testing.T, filename string) ([]uint32, [][]byte) {
+	b, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+
+	var types []uint32
+	var packets [][]byte
+	for len(b) > 0 {
+		require.True(t, len(b) >= 12)
+		typ := binary.LittleEndian.Uint32(b)
+		length := binary.LittleEndian.Uint32(b[4:])
+		require.True(t, int(length) <= len(b))
+		require.Equal(t, length, binary.LittleEndian.Uint32(b[length-4:]))
+		types = append(types, typ)
+		if typ == blockTypeEPB {
+			capLen := binary.LittleEndian.Uint32(b[20:])
+			packets = append(packets, b[28:28+capLen])
+		}
+		b = b[length:]
+	}
+	return types, packets
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cpcap")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (sf *addrConn) LocalAddr() net.Addr  { return sf.local }
+func (sf *addrConn) RemoteAddr() net.Addr { return sf.remote }
+
+func TestConn(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		local, remote net.Addr
+		ipHeaderLen   int
+	}{
+		{"ipv4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}, ipv4HeaderLen},
+		{"ipv6", &net.TCPAddr{IP: net.IPv6loopback, Port: 1000}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}, ipv6HeaderLen},
+		{"nil addr", nil, nil, ipv4HeaderLen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(tempDir(t), "capture.pcapng")
+			w, err := NewWriter(filename)
+			require.NoError(t, err)
+
+			data := []byte("hello world")
+			conn := New(&addrConn{mock.New(new(bytes.Buffer)), tc.local, tc.remote}, w)
+
+			n, err := conn.Write(data)
+			require.NoError(t, err)
+			require.Equal(t, len(data), n)
+
+			rd := make([]byte, len(data))
+			n, err = conn.Read(rd)
+			require.NoError(t, err)
+			require.Equal(t, data, rd[:n])
+
+			require.NoError(t, conn.Close())
+			require.NoError(t, w.Close())
+
+			types, packets := readBlocks(t, filename)
+			require.Equal(t, []uint32{blockTypeSHB, blockTypeIDB}, types[:2])
+			// handshake 3 + write + read + fin 3
+			require.Len(t, packets, 8)
+			for _, packet := range packets {
+				tcp := packet[tc.ipHeaderLen:]
+				if tc.ipHeaderLen == ipv4HeaderLen {
+					assert.Equal(t, uint16(0), checksum(packet[:ipv4HeaderLen], 0))
+				}
+				var sum uint32
+				if tc.ipHeaderLen == ipv4HeaderLen {
+					sum = sumBytes(packet[12:20], 0)
+				} else {
+					sum = sumBytes(packet[8:40], 0)
+				}
+				assert.Equal(t, uint16(0), checksum(tcp, sum+6+uint32(len(tcp))))
+			}
+			assert.Equal(t, data, packets[3][tc.ipHeaderLen+tcpHeaderLen:])
+			assert.Equal(t, data, packets[4][tc.ipHeaderLen+tcpHeaderLen:])
+
+			// sequence continuity
+			wSeq := binary.BigEndian.Uint32(packets[3][tc.ipHeaderLen+4:])
+			finSeq := binary.BigEndian.Uint32(packets[5][tc.ipHeaderLen+4:])
+			assert.Equal(t, wSeq+uint32(len(data)), finSeq)
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	t.Run("rotate", func(t *testing.T) {
+		dir := tempDir(t)
+		filename := filepath.Join(dir, "capture.pcapng")
+		w, err := NewWriter(filename, WithMaxSize(256), WithMaxBackups(1))
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, w.WritePacket(time.Now(), make([]byte, 200)))
+			time.Sleep(time.Millisecond * 2)
+		}
+		require.NoError(t, w.Close())
+
+		matches, err := filepath.Glob(filepath.Join(dir, "capture-*.pcapng"))
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+
+		types, packets := readBlocks(t, filename)
+		require.Equal(t, []uint32{blockTypeSHB, blockTypeIDB, blockTypeEPB}, types)
+		require.Len(t, packets[0], 200)
+	})
+
+	t.Run("rotate failed", func(t *testing.T) {
+		dir := filepath.Join(tempDir(t), "sub")
+		filename := filepath.Join(dir, "capture.pcapng")
+		w, err := NewWriter(filename, WithMaxSize(256))
+		require.NoError(t, err)
+		defer w.Close()
+
+		// 目录被删除, 轮转失败, 继续写入旧文件
+		require.NoError(t, os.RemoveAll(dir))
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 200)))
+		require.False(t, w.retryAt.IsZero())
+		retrySize := w.retrySize
+
+		// 恢复后, 再写入maxSize字节前不重试
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(filename, nil, 0644))
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 10)))
+		require.Equal(t, retrySize, w.retrySize)
+		types, _ := readBlocks(t, filename)
+		require.Empty(t, types)
+
+		// 到达重试时间后重新轮转
+		w.retryAt = time.Now()
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 200)))
+		require.True(t, w.retryAt.IsZero())
+		types, packets := readBlocks(t, filename)
+		require.Equal(t, []uint32{blockTypeSHB, blockTypeIDB, blockTypeEPB}, types)
+		require.Len(t, packets[0], 200)
+	})
+
+	t.Run("rotate retry after max size", func(t *testing.T) {
+		dir := filepath.Join(tempDir(t), "sub")
+		filename := filepath.Join(dir, "capture.pcapng")
+		w, err := NewWriter(filename, WithMaxSize(256))
+		require.NoError(t, err)
+		defer w.Close()
+
+		require.NoError(t, os.RemoveAll(dir))
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 200)))
+		retrySize := w.retrySize
+		// 未超过retrySize, 不重试
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 100)))
+		require.Equal(t, retrySize, w.retrySize)
+		// 超过retrySize, 重试失败, 更新retrySize
+		require.NoError(t, w.WritePacket(time.Now(), make([]byte, 200)))
+		require.Greater(t, w.retrySize, retrySize)
+	})
+
+	t.Run("write after close", func(t *testing.T) {
+		w, err := NewWriter(filepath.Join(tempDir(t), "capture.pcapng"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+		require.Equal(t, os.ErrClosed, w.WritePacket(time.Now(), []byte{1}))
+	})
+}
@@ -0,0 +1,136 @@
+package cpcap
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// tcp flags
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+	// maxSegmentSize 单个包最大负载, 同时满足ipv4及ipv6长度字段限制
+	maxSegmentSize = 65535 - ipv6HeaderLen - tcpHeaderLen
+)
+
+// endpoint tcp/ip endpoint
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+// endpointOf parse net.Addr to endpoint, invalid or nil addr will be 0.0.0.0:0
+func endpointOf(addr net.Addr) endpoint {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return endpoint{v.IP, uint16(v.Port)}
+	case *net.UDPAddr:
+		return endpoint{v.IP, uint16(v.Port)}
+	case nil:
+		return endpoint{net.IPv4zero, 0}
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return endpoint{net.IPv4zero, 0}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return endpoint{ip, uint16(p)}
+}
+
+// normalize the ip of two endpoint to the same family,
+// if any is ipv6, both use 16 bytes form, otherwise both use 4 bytes form.
+func normalize(a, b endpoint) (endpoint, endpoint) {
+	a4, b4 := a.ip.To4(), b.ip.To4()
+	if a4 != nil && b4 != nil {
+		return endpoint{a4, a.port}, endpoint{b4, b.port}
+	}
+	a.ip, b.ip = a.ip.To16(), b.ip.To16()
+	if a.ip == nil {
+		a.ip = net.IPv6zero
+	}
+	if b.ip == nil {
+		b.ip = net.IPv6zero
+	}
+	return a, b
+}
+
+// buildPacket build a raw ip packet with tcp header and payload
+func buildPacket(src, dst endpoint, seq, ack uint32, flags byte, payload []byte) []byte {
+	isV4 := len(src.ip) == net.IPv4len
+	ipHeaderLen := ipv6HeaderLen
+	if isV4 {
+		ipHeaderLen = ipv4HeaderLen
+	}
+	b := make([]byte, ipHeaderLen+tcpHeaderLen+len(payload))
+
+	if isV4 {
+		ip := b[:ipv4HeaderLen]
+		ip[0] = 0x45 // version 4, header length 5*4
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(b)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64                                 // ttl
+		ip[9] = 6                                  // tcp
+		copy(ip[12:16], src.ip)
+		copy(ip[16:20], dst.ip)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+	} else {
+		ip := b[:ipv6HeaderLen]
+		ip[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(tcpHeaderLen+len(payload)))
+		ip[6] = 6  // next header tcp
+		ip[7] = 64 // hop limit
+		copy(ip[8:24], src.ip)
+		copy(ip[24:40], dst.ip)
+	}
+
+	tcp := b[ipHeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = (tcpHeaderLen / 4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff) // window
+	copy(tcp[tcpHeaderLen:], payload)
+
+	// pseudo header
+	var sum uint32
+	sum = sumBytes(src.ip, sum)
+	sum = sumBytes(dst.ip, sum)
+	sum += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum))
+	return b
+}
+
+func sumBytes(b []byte, sum uint32) uint32 {
+	n := len(b)
+	for i := 0; i+1 < n; i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if n%2 == 1 {
+		sum += uint32(b[n-1]) << 8
+	}
+	return sum
+}
+
+// checksum internet checksum, see rfc1071
+func checksum(b []byte, initial uint32) uint16 {
+	sum := sumBytes(b, initial)
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,253 @@
+package cpcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/logger"
+)
+
+// pcapng block type
+const (
+	blockTypeSHB = 0x0A0D0D0A // Section Header Block
+	blockTypeIDB = 0x00000001 // Interface Description Block
+	blockTypeEPB = 0x00000006 // Enhanced Packet Block
+
+	byteOrderMagic = 0x1A2B3C4D
+	// linkTypeRaw 原始ip包,无链路层头部
+	linkTypeRaw = 101
+)
+
+// backupTimeFormat 备份文件时间戳格式
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetryInterval 轮转失败后的重试间隔, 期间再写入maxSize字节也将重试
+const rotateRetryInterval = time.Minute
+
+// WriterOption Writer option
+type WriterOption func(w *Writer)
+
+// WithMaxSize 单个文件最大尺寸(bytes), 超过将轮转, <=0 不轮转
+func WithMaxSize(size int64) WriterOption {
+	return func(w *Writer) {
+		w.maxSize = size
+	}
+}
+
+// WithMaxBackups 轮转后保留的备份文件数, <=0 全部保留
+func WithMaxBackups(n int) WriterOption {
+	return func(w *Writer) {
+		w.maxBackups = n
+	}
+}
+
+// WithLogger 设置日志, 用于记录轮转失败等不影响写入的错误
+func WithLogger(l logger.Logger) WriterOption {
+	return func(w *Writer) {
+		if l != nil {
+			w.log = l
+		}
+	}
+}
+
+// Writer pcapng file writer, safe for concurrent use
+type Writer struct {
+	mu         sync.Mutex
+	filename   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	log        logger.Logger
+	// 轮转失败后, 文件尺寸超过retrySize或到达retryAt时才重试
+	retrySize int64
+	retryAt   time.Time
+}
+
+// NewWriter new a pcapng writer with file name,
+// if file exist, it will append a new section to the file.
+func NewWriter(filename string, opts ...WriterOption) (*Writer, error) {
+	w := &Writer{filename: filename, log: logger.NewDiscard()}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WritePacket write a raw ip packet with timestamp
+func (sf *Writer) WritePacket(ts time.Time, packet []byte) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if sf.file == nil {
+		return os.ErrClosed
+	}
+	b := appendEPB(make([]byte, 0, 32+len(packet)+3), ts, packet)
+	if size := sf.size + int64(len(b)); sf.maxSize > 0 && size > sf.maxSize &&
+		(sf.retryAt.IsZero() || size > sf.retrySize || !time.Now().Before(sf.retryAt)) {
+		// 轮转失败时继续写入当前文件, 再写入maxSize字节或经过rotateRetryInterval后重试,
+		// 避免每次写入都重试并记录日志
+		if err := sf.rotate(); err != nil {
+			sf.log.Warnf("[ Capture ] rotate %s, %v", sf.filename, err)
+			sf.retrySize = size + sf.maxSize
+			sf.retryAt = time.Now().Add(rotateRetryInterval)
+		} else {
+			sf.retryAt = time.Time{}
+		}
+	}
+	n, err := sf.file.Write(b)
+	sf.size += int64(n)
+	return err
+}
+
+// Close close the writer
+func (sf *Writer) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return nil
+	}
+	err := sf.file.Close()
+	sf.file = nil
+	return err
+}
+
+func (sf *Writer) openFile() error {
+	if dir := filepath.Dir(sf.filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(sf.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	b := appendIDB(appendSHB(make([]byte, 0, 48)))
+	n, err := f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	sf.file = f
+	sf.size = int64(n)
+	return nil
+}
+
+// rotate 备份当前文件并创建新文件, 新文件创建成功后才关闭旧文件,
+// 失败时保持写入旧文件
+func (sf *Writer) rotate() error {
+	backup := backupName(sf.filename, time.Now())
+	if err := os.Rename(sf.filename, backup); err != nil {
+		return err
+	}
+	old := sf.file
+	if err := sf.openFile(); err != nil {
+		os.Rename(backup, sf.filename) // nolint: errcheck
+		return err
+	}
+	old.Close() // nolint: errcheck
+	return sf.removeOldBackups()
+}
+
+func (sf *Writer) removeOldBackups() error {
+	if sf.maxBackups <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(sf.filename)
+	prefix, ext := prefixAndExt(sf.filename)
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"+ext))
+	if err != nil {
+		return err
+	}
+	backups := make([]string, 0, len(matches))
+	for _, name := range matches {
+		ts := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= sf.maxBackups {
+		return nil
+	}
+	// 时间戳格式可按字典序排序
+	sort.Strings(backups)
+	var err1 error
+	for _, name := range backups[:len(backups)-sf.maxBackups] {
+		if err := os.Remove(name); err != nil && err1 == nil {
+			err1 = err
+		}
+	}
+	return err1
+}
+
+// prefixAndExt returns the backup file prefix and extension, e.g. foo.pcapng -> foo-, .pcapng
+func prefixAndExt(filename string) (prefix, ext string) {
+	base := filepath.Base(filename)
+	ext = filepath.Ext(base)
+	return base[:len(base)-len(ext)] + "-", ext
+}
+
+func backupName(filename string, t time.Time) string {
+	prefix, ext := prefixAndExt(filename)
+	return filepath.Join(filepath.Dir(filename), fmt.Sprintf("%s%s%s", prefix, t.Format(backupTimeFormat), ext))
+}
+
+func appendSHB(b []byte) []byte {
+	const length = 28
+	b = appendUint32(b, blockTypeSHB)
+	b = appendUint32(b, length)
+	b = appendUint32(b, byteOrderMagic)
+	b = appendUint16(b, 1) // major version
+	b = appendUint16(b, 0) // minor version
+	b = appendUint32(b, 0xffffffff)
+	b = appendUint32(b, 0xffffffff) // section length -1, not specified
+	return appendUint32(b, length)
+}
+
+func appendIDB(b []byte) []byte {
+	const length = 20
+	b = appendUint32(b, blockTypeIDB)
+	b = appendUint32(b, length)
+	b = appendUint16(b, linkTypeRaw)
+	b = appendUint16(b, 0) // reserved
+	b = appendUint32(b, 0) // snap len, no limit
+	return appendUint32(b, length)
+}
+
+func appendEPB(b []byte, ts time.Time, packet []byte) []byte {
+	padding := (4 - len(packet)%4) % 4
+	length := uint32(32 + len(packet) + padding)
+	us := uint64(ts.UnixNano() / int64(time.Microsecond))
+
+	b = appendUint32(b, blockTypeEPB)
+	b = appendUint32(b, length)
+	b = appendUint32(b, 0) // interface id
+	b = appendUint32(b, uint32(us>>32))
+	b = appendUint32(b, uint32(us))
+	b = appendUint32(b, uint32(len(packet))) // captured length
+	b = appendUint32(b, uint32(len(packet))) // original length
+	b = append(b, packet...)
+	b = append(b, make([]byte, padding)...)
+	return appendUint32(b, length)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var tmp [2]byte
+	binary.LittleEndian.PutUint16(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
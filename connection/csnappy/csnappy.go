@@ -48,3 +48,14 @@ func (sf *Conn) Write(p []byte) (int, error) {
 	err := sf.w.Flush()
 	return n, err
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// FinishWrite implement connection.WriteFinisher, it flushes the buffered data,
+// snappy stream has no trailer.
+func (sf *Conn) FinishWrite() error {
+	return sf.w.Flush()
+}
@@ -17,9 +17,14 @@ package czlib
 
 import (
 	"compress/zlib"
+	"errors"
 	"net"
 )
 
+// ErrHalfCloseNotSupported each write is a flushed but unfinished zlib stream,
+// it can not be finished without closing the conn, so half-close is not supported.
+var ErrHalfCloseNotSupported = errors.New("czlib: half-close not supported")
+
 // Conn is a generic stream-oriented network connection with gzip
 type Conn struct {
 	net.Conn
@@ -67,3 +72,13 @@ func (sf *Conn) Write(p []byte) (int, error) {
 	err = w.Flush()
 	return n, err
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// FinishWrite implement connection.WriteFinisher, always return ErrHalfCloseNotSupported.
+func (sf *Conn) FinishWrite() error {
+	return ErrHalfCloseNotSupported
+}
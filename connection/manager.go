@@ -6,6 +6,7 @@ import (
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map"
+	"go.uber.org/atomic"
 )
 
 // Manager 管理key
@@ -14,39 +15,197 @@ type Manager struct {
 	interval time.Duration
 	// gc回调,返回true将删除对应的key
 	gcIterCb func(key string, value interface{}, now time.Time) bool
+
+	// 过期
+	precision   time.Duration
+	wheelSlots  int
+	wheel       *timingWheel
+	deadlines   cmap.ConcurrentMap // key -> *timer
+	onExpiredCb func(key string, value interface{})
+}
+
+// ManagerOption Manager option
+type ManagerOption func(m *Manager)
+
+// WithExpiry 使能key过期, 过期检查精度precision, key过期删除后将调用回调cb(可为nil)
+// 使用SetWithTimeout设置带过期时间的key, Touch刷新过期时间,
+// 基于时间轮实现, 每次检查只处理到期的key,而不是遍历所有key.
+func WithExpiry(precision time.Duration, cb func(key string, value interface{})) ManagerOption {
+	return func(m *Manager) {
+		m.precision = precision
+		m.onExpiredCb = cb
+	}
+}
+
+// WithWheelSlots 时间轮槽数, default: DefaultWheelSlots
+func WithWheelSlots(slots int) ManagerOption {
+	return func(m *Manager) {
+		if slots > 1 {
+			m.wheelSlots = slots
+		}
+	}
 }
 
 // New a manager
 // gcInterval: 回收间隔, <=0将不启动
 // gcIterCb: 回收回调函数, 当间隔到后,检查所有的key,value,返回true将删除对应的key
-func New(gcInterval time.Duration, gcIterCb func(key string, value interface{}, now time.Time) bool) *Manager {
-	return &Manager{
-		cmap.New(),
-		gcInterval,
-		gcIterCb,
+func New(gcInterval time.Duration, gcIterCb func(key string, value interface{}, now time.Time) bool, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		ConcurrentMap: cmap.New(),
+		interval:      gcInterval,
+		gcIterCb:      gcIterCb,
+		wheelSlots:    DefaultWheelSlots,
+		deadlines:     cmap.New(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.precision > 0 {
+		m.wheel = newTimingWheel(m.precision, m.wheelSlots, time.Now())
+	}
+	return m
+}
+
+// SetWithTimeout sets the given value under the specified key, and it will be
+// expired after ttl if not touched. if not enable expiry, it same as Set.
+func (sf *Manager) SetWithTimeout(key string, value interface{}, ttl time.Duration) {
+	if sf.wheel == nil {
+		sf.ConcurrentMap.Set(key, value)
+		return
+	}
+	t := &timer{
+		key:      key,
+		ttl:      ttl,
+		deadline: atomic.NewInt64(time.Now().Add(ttl).UnixNano()),
+	}
+	// 持有key的锁同时设置值和过期时间, 与expire互斥
+	sf.ConcurrentMap.Upsert(key, value, func(bool, interface{}, interface{}) interface{} {
+		sf.deadlines.Set(key, t)
+		return value
+	})
+	sf.wheel.add(t)
+}
+
+// Touch refresh the deadline of the key with its ttl,
+// returns false if the key not exist or has no deadline.
+func (sf *Manager) Touch(key string) bool {
+	v, ok := sf.deadlines.Get(key)
+	if !ok {
+		return false
+	}
+	t := v.(*timer)
+	t.deadline.Store(time.Now().Add(t.ttl).UnixNano())
+	return true
+}
+
+// Set sets the given value under the specified key without deadline,
+// the deadline set by SetWithTimeout before is cleared.
+func (sf *Manager) Set(key string, value interface{}) {
+	sf.ConcurrentMap.Upsert(key, value, func(bool, interface{}, interface{}) interface{} {
+		sf.deadlines.Remove(key)
+		return value
+	})
+}
+
+// MSet sets the given values without deadline, same as Set each of them.
+func (sf *Manager) MSet(data map[string]interface{}) {
+	for key, value := range data {
+		sf.Set(key, value)
+	}
+}
+
+// Remove removes an element and its deadline from the map.
+func (sf *Manager) Remove(key string) {
+	sf.ConcurrentMap.RemoveCb(key, func(string, interface{}, bool) bool {
+		sf.deadlines.Remove(key)
+		return true
+	})
+}
+
+// RemoveCb locks the shard containing the key, calls cb with the key and its value,
+// removes the element and its deadline if cb returns true.
+func (sf *Manager) RemoveCb(key string, cb cmap.RemoveCb) bool {
+	return sf.ConcurrentMap.RemoveCb(key, func(key string, v interface{}, exists bool) bool {
+		remove := cb(key, v, exists)
+		if remove {
+			sf.deadlines.Remove(key)
+		}
+		return remove
+	})
+}
+
+// Pop removes an element and its deadline from the map and returns it.
+func (sf *Manager) Pop(key string) (v interface{}, exists bool) {
+	sf.ConcurrentMap.RemoveCb(key, func(_ string, value interface{}, ok bool) bool {
+		v, exists = value, ok
+		sf.deadlines.Remove(key)
+		return true
+	})
+	return v, exists
+}
+
+// Clear removes all elements and their deadlines from the map.
+func (sf *Manager) Clear() {
+	for _, key := range sf.Keys() {
+		sf.Remove(key)
 	}
 }
 
 // Watch watch conn interval,should run in a goroutine
 func (sf *Manager) Watch(ctx context.Context) {
-	if sf.interval <= 0 || sf.gcIterCb == nil {
+	var gcC, wheelC <-chan time.Time
+
+	if sf.interval > 0 && sf.gcIterCb != nil {
+		ticker := time.NewTicker(sf.interval)
+		defer ticker.Stop()
+		gcC = ticker.C
+	}
+	if sf.wheel != nil {
+		ticker := time.NewTicker(sf.precision)
+		defer ticker.Stop()
+		wheelC = ticker.C
+	}
+	if gcC == nil && wheelC == nil {
 		return
 	}
-	var now time.Time
 
-	timer := time.NewTicker(sf.interval)
-	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case now = <-timer.C:
+		case now := <-gcC:
+			for k, v := range sf.Items() {
+				if sf.gcIterCb(k, v, now) {
+					sf.Remove(k)
+				}
+			}
+		case now := <-wheelC:
+			sf.expire(now)
 		}
-		// TODO: 优化删除策略
-		for k, v := range sf.Items() {
-			if sf.gcIterCb(k, v, now) {
-				sf.Remove(k)
+	}
+}
+
+// expire the timers which deadline is before now
+func (sf *Manager) expire(now time.Time) {
+	nowNano := now.UnixNano()
+	for _, t := range sf.wheel.advance(now) {
+		t := t
+		var value interface{}
+		// 持有key的锁检查过期时间后删除, 防止删除并发SetWithTimeout设置的新值
+		removed := sf.ConcurrentMap.RemoveCb(t.key, func(key string, v interface{}, exists bool) bool {
+			if dv, ok := sf.deadlines.Get(key); !ok || dv != t {
+				return false // the key has been reset or removed
+			}
+			if t.deadline.Load() > nowNano {
+				sf.wheel.add(t) // touched, reschedule
+				return false
 			}
+			sf.deadlines.Remove(key)
+			value = v
+			return exists
+		})
+		if removed && sf.onExpiredCb != nil {
+			sf.onExpiredCb(t.key, value)
 		}
 	}
 }
@@ -39,6 +39,128 @@ func TestManager(t *testing.T) {
 		<-ctx.Done()
 	})
 
+	t.Run("expiry", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		expired := make(chan string, 10)
+		mag := New(0, nil, WithExpiry(time.Millisecond*10, func(key string, value interface{}) {
+			expired <- key
+		}), WithWheelSlots(8))
+		go mag.Watch(ctx)
+
+		mag.SetWithTimeout("foo", "fooValue", time.Millisecond*50)
+		mag.SetWithTimeout("bar", "barValue", time.Millisecond*200) // beyond the wheel span
+		mag.SetWithTimeout("car", "carValue", time.Millisecond*50)
+		mag.Set("baz", "bazValue")
+		mag.Remove("car")
+
+		// keep foo alive
+		for i := 0; i < 10; i++ {
+			require.True(t, mag.Touch("foo"))
+			time.Sleep(time.Millisecond * 20)
+		}
+		require.False(t, mag.Touch("baz"))
+
+		select {
+		case key := <-expired:
+			require.Equal(t, "bar", key)
+		case <-time.After(time.Second):
+			t.Fatal("bar should be expired")
+		}
+		require.True(t, mag.Has("foo"))
+		require.False(t, mag.Has("bar"))
+		require.False(t, mag.Has("car"))
+
+		select {
+		case key := <-expired:
+			require.Equal(t, "foo", key)
+		case <-time.After(time.Second):
+			t.Fatal("foo should be expired")
+		}
+		require.False(t, mag.Has("foo"))
+		require.True(t, mag.Has("baz"))
+		require.Len(t, expired, 0)
+	})
+
+	t.Run("reset while expiring", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mag := New(0, nil, WithExpiry(time.Millisecond, nil))
+		go mag.Watch(ctx)
+		for i := 0; i < 100; i++ {
+			mag.SetWithTimeout("foo", i, time.Millisecond)
+			time.Sleep(time.Millisecond * time.Duration(i%3))
+			mag.SetWithTimeout("foo", "live", time.Hour)
+			time.Sleep(time.Millisecond * 2)
+			v, ok := mag.Get("foo")
+			require.True(t, ok)
+			require.Equal(t, "live", v)
+			require.True(t, mag.Touch("foo"))
+		}
+		mag.Remove("foo")
+		require.False(t, mag.Has("foo"))
+		require.False(t, mag.Touch("foo"))
+	})
+
+	t.Run("clear deadline", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		expired := make(chan string, 10)
+		mag := New(0, nil, WithExpiry(time.Millisecond*10, func(key string, value interface{}) {
+			expired <- key
+		}))
+		go mag.Watch(ctx)
+
+		// Set覆盖后不再过期
+		mag.SetWithTimeout("set", 1, time.Millisecond*30)
+		mag.Set("set", 2)
+		require.False(t, mag.Touch("set"))
+		mag.SetWithTimeout("mset", 1, time.Millisecond*30)
+		mag.MSet(map[string]interface{}{"mset": 2})
+		require.False(t, mag.Touch("mset"))
+
+		// Pop, RemoveCb, Clear 同时删除过期时间
+		mag.SetWithTimeout("pop", 1, time.Millisecond*30)
+		v, ok := mag.Pop("pop")
+		require.True(t, ok)
+		require.Equal(t, 1, v)
+		require.False(t, mag.Touch("pop"))
+		_, ok = mag.Pop("pop")
+		require.False(t, ok)
+
+		mag.SetWithTimeout("removecb", 1, time.Millisecond*30)
+		require.False(t, mag.RemoveCb("removecb", func(string, interface{}, bool) bool { return false }))
+		require.True(t, mag.Touch("removecb"))
+		require.True(t, mag.RemoveCb("removecb", func(string, interface{}, bool) bool { return true }))
+		require.False(t, mag.Touch("removecb"))
+
+		mag.SetWithTimeout("clear", 1, time.Millisecond*30)
+		mag.Clear()
+		require.False(t, mag.Touch("clear"))
+		require.Equal(t, 0, mag.deadlines.Count())
+
+		// 重新以无过期时间设置, 旧的过期时间不应删除新值
+		mag.Set("pop", 3)
+		mag.Set("removecb", 3)
+		mag.Set("clear", 3)
+		mag.Set("set", 3)
+		time.Sleep(time.Millisecond * 100)
+		require.Len(t, expired, 0)
+		require.Equal(t, 4, mag.Count())
+	})
+
+	t.Run("expiry not enabled", func(t *testing.T) {
+		mag := New(0, nil)
+		mag.SetWithTimeout("foo", "fooValue", time.Millisecond)
+		require.False(t, mag.Touch("foo"))
+		v, ok := mag.Get("foo")
+		require.True(t, ok)
+		assert.Equal(t, "fooValue", v.(string))
+	})
+
 	t.Run("improve coverage", func(t *testing.T) {
 		mag := New(-1, nil)
 		mag.Watch(context.Background())
@@ -141,3 +141,14 @@ func (sf *Conn) Write(b []byte) (n int, err error) {
 	n, err = sf.Conn.Write(cipherData)
 	return n - len(iv), err
 }
+
+// Unwrap returns the inner conn.
+func (sf *Conn) Unwrap() net.Conn {
+	return sf.Conn
+}
+
+// FinishWrite implement connection.WriteFinisher,
+// the data is encrypted and written on each write without trailer, nothing to do.
+func (sf *Conn) FinishWrite() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package connection
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// DefaultWheelSlots default timing wheel slots
+const DefaultWheelSlots = 512
+
+// timer key deadline timer, deadline can be refreshed lock-free
+type timer struct {
+	key      string
+	ttl      time.Duration
+	deadline *atomic.Int64 // unix nano
+}
+
+// timingWheel hashed timing wheel with lazy rescheduling.
+// the refreshed timer will not move until its slot expires, then it will be
+// rescheduled to the slot of the new deadline, timer beyond the wheel span is
+// placed at the farthest slot and rescheduled when reached.
+// so each tick only cost O(timers in the slot).
+type timingWheel struct {
+	mu      sync.Mutex
+	tick    time.Duration
+	slots   [][]*timer
+	pos     int
+	current time.Time // time of current position
+}
+
+func newTimingWheel(tick time.Duration, slots int, now time.Time) *timingWheel {
+	return &timingWheel{
+		tick:    tick,
+		slots:   make([][]*timer, slots),
+		current: now,
+	}
+}
+
+// add timer to wheel
+func (sf *timingWheel) add(t *timer) {
+	sf.mu.Lock()
+	sf.addLocked(t)
+	sf.mu.Unlock()
+}
+
+func (sf *timingWheel) addLocked(t *timer) {
+	ticks := int((time.Duration(t.deadline.Load()-sf.current.UnixNano()) + sf.tick - 1) / sf.tick)
+	if ticks < 1 {
+		ticks = 1
+	} else if ticks >= len(sf.slots) {
+		ticks = len(sf.slots) - 1
+	}
+	idx := (sf.pos + ticks) % len(sf.slots)
+	sf.slots[idx] = append(sf.slots[idx], t)
+}
+
+// advance the wheel to now, the not yet expired timer will be rescheduled,
+// returns the timers which deadline is before now.
+func (sf *timingWheel) advance(now time.Time) []*timer {
+	var expired []*timer
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	nowNano := now.UnixNano()
+	for steps := 0; !sf.current.Add(sf.tick).After(now); steps++ {
+		if steps >= len(sf.slots) { // fall behind too much, skip to now
+			sf.current = now
+			break
+		}
+		sf.pos = (sf.pos + 1) % len(sf.slots)
+		sf.current = sf.current.Add(sf.tick)
+
+		timers := sf.slots[sf.pos]
+		sf.slots[sf.pos] = nil
+		for _, t := range timers {
+			if t.deadline.Load() <= nowNano {
+				expired = append(expired, t)
+			} else {
+				sf.addLocked(t)
+			}
+		}
+	}
+	return expired
+}
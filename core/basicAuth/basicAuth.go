@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -35,12 +36,14 @@ func WithAuthURL(url string, timeout time.Duration, code int, retry uint) Option
 
 // Center basic auth center
 type Center struct {
-	passwords   cmap.ConcurrentMap // 用户名 -> 密码 映射
-	dns         *idns.Resolver     // 可选dns解析服务
-	url         string             // 可选上级授权中心
-	successCode int                // http请求成功码
-	timeout     time.Duration      // http请求超时时间,单位ms
-	retry       uint               // 重试次数
+	mu          sync.RWMutex        // 保护passwords重新加载时的替换
+	passwords   cmap.ConcurrentMap  // 用户名 -> 密码 映射
+	fileUsers   map[string]struct{} // 最近一次从文件加载的用户, 重新加载时替换
+	dns         *idns.Resolver      // 可选dns解析服务
+	url         string              // 可选上级授权中心
+	successCode int                 // http请求成功码
+	timeout     time.Duration       // http请求超时时间,单位ms
+	retry       uint                // 重试次数
 
 }
 
@@ -80,23 +83,76 @@ func (sf *Center) SetAuthURL(url string, timeout time.Duration, code int, retry
 // LoadFromFile 从文件加载用户-密码对,返回加载成功的数目
 // 一行一条,格式 user:password , # 为注释
 func (sf *Center) LoadFromFile(filename string) (n int, err error) {
-	content, err := ioutil.ReadFile(filename)
+	users, n, err := readUserFile(filename)
 	if err != nil {
 		return
 	}
-	userPasswords := strings.Split(strings.Replace(string(content), "\r", "", -1), "\n")
-	for _, up := range userPasswords {
-		up = strings.Trim(up, " ")
-		if up == "" || strings.HasPrefix(up, "#") { // 忽略注释
-			continue
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.fileUsers == nil {
+		sf.fileUsers = make(map[string]struct{}, len(users))
+	}
+	for u, p := range users {
+		sf.passwords.Set(u, p)
+		sf.fileUsers[u] = struct{}{}
+	}
+	return
+}
+
+// ReloadStat 重新加载用户文件的变化统计
+type ReloadStat struct {
+	Added   int // 新增用户数
+	Removed int // 删除用户数
+	Updated int // 修改密码的用户数
+	Total   int // 重新加载后用户总数
+}
+
+// String 统计信息
+func (sf ReloadStat) String() string {
+	return fmt.Sprintf("+%d -%d ~%d =%d", sf.Added, sf.Removed, sf.Updated, sf.Total)
+}
+
+// ReloadFromFile 重新加载用户文件, 解析成功后原子替换上次从文件加载的用户, 通过Add增加的用户保留,
+// 读取文件失败时保留原数据
+func (sf *Center) ReloadFromFile(filename string) (stat ReloadStat, err error) {
+	users, _, err := readUserFile(filename)
+	if err != nil {
+		return
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	passwords := cmap.New()
+	for u, p := range sf.passwords.Items() {
+		if _, ok := sf.fileUsers[u]; !ok {
+			passwords.Set(u, p)
 		}
-		n += sf.Add(up)
 	}
+	fileUsers := make(map[string]struct{}, len(users))
+	for u, p := range users {
+		if old, ok := sf.passwords.Get(u); !ok {
+			stat.Added++
+		} else if old.(string) != p {
+			stat.Updated++
+		}
+		passwords.Set(u, p)
+		fileUsers[u] = struct{}{}
+	}
+	for u := range sf.fileUsers {
+		if _, ok := fileUsers[u]; !ok && !passwords.Has(u) {
+			stat.Removed++
+		}
+	}
+	sf.passwords, sf.fileUsers = passwords, fileUsers
+	stat.Total = passwords.Count()
 	return
 }
 
 // Add 增加用户密码对,格式user:password
 func (sf *Center) Add(userPwdPair ...string) (n int) {
+	// 持有锁, 避免写入重新加载时正被替换的旧表
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
 	for _, up := range userPwdPair {
 		u := strings.Split(up, ":")
 		if len(u) == 2 {
@@ -109,19 +165,29 @@ func (sf *Center) Add(userPwdPair ...string) (n int) {
 
 // Delete 删除用户
 func (sf *Center) Delete(users ...string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
 	for _, u := range users {
 		sf.passwords.Remove(u)
+		delete(sf.fileUsers, u)
 	}
 }
 
 // Total 用户总数
 func (sf *Center) Total() int {
-	return sf.passwords.Count()
+	return sf.table().Count()
 }
 
 // Has 是否存在对应用户
 func (sf *Center) Has(user string) bool {
-	return sf.passwords.Has(user)
+	return sf.table().Has(user)
+}
+
+// table 当前用户表, 重新加载时会被替换
+func (sf *Center) table() cmap.ConcurrentMap {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.passwords
 }
 
 // Verify verify from local center,if url has set, it will verify from basic server
@@ -139,7 +205,7 @@ func (sf *Center) Verify(userPwdPair string, userIP, localIP, target string) boo
 
 // VerifyFromLocal 校验对应用户密码和账号
 func (sf *Center) VerifyFromLocal(user, pwd string) bool {
-	if p, found := sf.passwords.Get(user); found {
+	if p, found := sf.table().Get(user); found {
 		return p.(string) == pwd
 	}
 	return false
@@ -222,3 +288,24 @@ func httpGet(url string, timeout time.Duration, host ...string) (body []byte, co
 func Format(user, pwd string) string {
 	return user + ":" + pwd
 }
+
+// readUserFile 读取用户文件, 返回 用户 -> 密码, 以及有效行数
+func readUserFile(filename string) (map[string]string, int, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	users := make(map[string]string)
+	for _, up := range strings.Split(strings.Replace(string(content), "\r", "", -1), "\n") {
+		up = strings.Trim(up, " ")
+		if up == "" || strings.HasPrefix(up, "#") { // 忽略注释
+			continue
+		}
+		if u := strings.Split(up, ":"); len(u) == 2 {
+			users[u[0]] = u[1]
+			n++
+		}
+	}
+	return users, n, nil
+}
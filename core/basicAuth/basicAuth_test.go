@@ -1,7 +1,10 @@
 package basicAuth
 
 import (
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -71,3 +74,69 @@ func TestCenter(t *testing.T) {
 	ok = c1.Verify("invalid", "127.99.99.99", "127.6.6.6", "target")
 	require.False(t, ok)
 }
+
+func TestCenter_ReloadFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "basicAuth")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("u1:p1\nu2:p2\n# comment\n"), 0644))
+
+	c := New()
+	c.Add("static:pwd")
+	n, err := c.LoadFromFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 3, c.Total())
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("u1:p1\nu2:changed\nu3:p3\n"), 0644))
+	stat, err := c.ReloadFromFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, ReloadStat{Added: 1, Removed: 0, Updated: 1, Total: 4}, stat)
+	assert.True(t, c.VerifyFromLocal("u2", "changed"))
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("u3:p3\n"), 0644))
+	stat, err = c.ReloadFromFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "+0 -2 ~0 =2", stat.String())
+	assert.False(t, c.Has("u1"))
+	assert.True(t, c.Has("static"))
+
+	// 读取失败, 保留原数据
+	_, err = c.ReloadFromFile(filepath.Join(dir, "none"))
+	require.Error(t, err)
+	assert.True(t, c.Has("u3"))
+	assert.Equal(t, 2, c.Total())
+}
+
+func TestCenter_AddDuringReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "basicAuth")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "users")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("u1:p1\n"), 0644))
+
+	c := New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			c.Add("add" + strconv.Itoa(i) + ":pwd")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, err = c.ReloadFromFile(filename)
+		require.NoError(t, err)
+	}
+	<-done
+
+	// 重新加载期间增加的用户不丢失
+	for i := 0; i < 1000; i++ {
+		require.True(t, c.Has("add"+strconv.Itoa(i)), i)
+	}
+	c.Delete("u1")
+	assert.False(t, c.Has("u1"))
+	assert.Equal(t, 1000, c.Total())
+}
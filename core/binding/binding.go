@@ -2,15 +2,21 @@
 package binding
 
 import (
+	"errors"
 	"io"
 	"net"
 	"time"
 
 	"github.com/things-go/x/extnet"
+
+	"github.com/thinkgos/jocasta/connection"
 	"github.com/thinkgos/jocasta/pkg/bpool"
 	"github.com/thinkgos/jocasta/pkg/gopool"
 )
 
+// DefaultLinger 一个方向结束后,等待另一个方向结束的默认时间
+const DefaultLinger = 5 * time.Second
+
 // Option for Forward
 type Option func(c *Forward)
 
@@ -21,16 +27,35 @@ func WithGPool(pool gopool.Pool) Option {
 	}
 }
 
+// WithLinger 一个方向正常结束后,等待另一个方向结束的时间, 超时将中止另一个方向, <=0 立即中止.
+func WithLinger(linger time.Duration) Option {
+	return func(c *Forward) {
+		c.linger = linger
+	}
+}
+
+// WithZeroCopy 使能零拷贝, 当两端都是*net.TCPConn时(可穿过不转换数据的adorn),
+// 使用ReaderFrom零拷贝传输(linux上为splice), default: true
+func WithZeroCopy(enable bool) Option {
+	return func(c *Forward) {
+		c.zeroCopy = enable
+	}
+}
+
 // Forward forward stream
 type Forward struct {
 	bpool.BufferPool
-	gPool gopool.Pool
+	gPool    gopool.Pool
+	linger   time.Duration
+	zeroCopy bool
 }
 
 // New binding forward with buffer size 缓冲切片大小
 func New(size int, opts ...Option) *Forward {
 	c := &Forward{
 		BufferPool: bpool.NewPool(size),
+		linger:     DefaultLinger,
+		zeroCopy:   true,
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -38,25 +63,126 @@ func New(size int, opts ...Option) *Forward {
 	return c
 }
 
-// Proxy proxy rw1 and rw2 with binding
-func (sf *Forward) Proxy(rw1, rw2 io.ReadWriter) (err error) {
-	ech1 := make(chan error, 1)
-	ech2 := make(chan error, 1)
-	gopool.Go(sf.gPool, func() { ech1 <- sf.Copy(rw1, rw2) })
-	gopool.Go(sf.gPool, func() { ech2 <- sf.Copy(rw2, rw1) })
-	select {
-	case err = <-ech1:
-	case err = <-ech2:
+// Transfer transfer result of one direction
+type Transfer struct {
+	Written int64 // 传输字节数
+	Err     error // 传输错误, 正常结束(EOF)或被linger中止为nil
+}
+
+// Result proxy result of both direction
+type Result struct {
+	Upstream   Transfer // rw1 ---> rw2, read from rw1 and write to rw2
+	Downstream Transfer // rw2 ---> rw1, read from rw2 and write to rw1
+}
+
+// Err returns the first not nil error of upstream and downstream
+func (r Result) Err() error {
+	if r.Upstream.Err != nil {
+		return r.Upstream.Err
+	}
+	return r.Downstream.Err
+}
+
+// Proxy proxy rw1 and rw2 with binding, it returns after both direction finished.
+// when one direction finished normally, it propagates half-close(CloseWrite) to the
+// peer, then waits the other direction with linger.
+// when one direction failed, the peer not support half-close or linger timeout,
+// it aborts the other direction by setting deadline if supported, otherwise closing
+// it if it is a io.Closer, then waits the other direction.
+// NOTE: smux stream(used by mux services) not support half-close, so the mux streams
+// are aborted once one direction finished.
+func (sf *Forward) Proxy(rw1, rw2 io.ReadWriter) Result {
+	var res Result
+	var lingerC <-chan time.Time
+
+	ch1 := make(chan Transfer, 1)
+	ch2 := make(chan Transfer, 1)
+	gopool.Go(sf.gPool, func() { ch1 <- sf.transfer(rw2, rw1) })
+	gopool.Go(sf.gPool, func() { ch2 <- sf.transfer(rw1, rw2) })
+
+	aborted := false
+	abort := func() {
+		aborted = true
+		abortRW(rw1)
+		abortRW(rw2)
+	}
+
+	for done := 0; done < 2; {
+		var peer io.ReadWriter
+		var err error
+
+		select {
+		case res.Upstream = <-ch1:
+			peer, err = rw2, res.Upstream.Err
+		case res.Downstream = <-ch2:
+			peer, err = rw1, res.Downstream.Err
+		case <-lingerC:
+			lingerC = nil
+			abort()
+			continue
+		}
+		halfClosed := err == nil && connection.CloseWrite(peer) == nil
+		if done++; done == 2 {
+			break
+		}
+		// linger only if half-close propagated, otherwise the peer never knows the end
+		if halfClosed {
+			if sf.linger > 0 {
+				timer := time.NewTimer(sf.linger)
+				defer timer.Stop()
+				lingerC = timer.C
+				continue
+			}
+		}
+		abort()
+	}
+	if aborted {
+		// the direction aborted by us is not regarded as error
+		if isAbortErr(res.Upstream.Err) {
+			res.Upstream.Err = nil
+		}
+		if isAbortErr(res.Downstream.Err) {
+			res.Downstream.Err = nil
+		}
+	}
+	return res
+}
+
+// abortRW abort the read and write by setting deadline if v support, otherwise close it if v is a io.Closer.
+func abortRW(v interface{}) {
+	if setDeadline(v, time.Now()) {
+		return
+	}
+	if c, ok := v.(io.Closer); ok {
+		c.Close() // nolint: errcheck
 	}
-	return
+}
+
+func isAbortErr(err error) bool {
+	return extnet.IsErrTimeout(err) || extnet.IsErrClosed(err) || errors.Is(err, io.ErrClosedPipe)
 }
 
 // Copy stream src to dst
 func (sf *Forward) Copy(dst io.Writer, src io.Reader) error {
+	return sf.transfer(dst, src).Err
+}
+
+func (sf *Forward) transfer(dst io.Writer, src io.Reader) Transfer {
+	if sf.zeroCopy {
+		if t, ok := zeroCopy(dst, src); ok {
+			return t
+		}
+	}
 	buf := sf.Get()
 	defer sf.Put(buf)
-	_, err := io.CopyBuffer(dst, src, buf[:cap(buf)])
-	return err
+	n, err := io.CopyBuffer(dst, src, buf[:cap(buf)])
+	return Transfer{n, err}
+}
+
+// setDeadline set deadline if v support, return false if not support.
+func setDeadline(v interface{}, t time.Time) bool {
+	d, ok := v.(interface{ SetDeadline(time.Time) error })
+	return ok && d.SetDeadline(t) == nil
 }
 
 // RunUDPCopy ...
@@ -0,0 +1,232 @@
+package binding
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/thinkgos/jocasta/connection/cflow"
+	"github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/csnappy"
+)
+
+// tcpPair returns a connected tcp conn pair
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	c2, err := ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return c1.(*net.TCPConn), c2.(*net.TCPConn)
+}
+
+func TestProxy(t *testing.T) {
+	request := []byte("hello world")
+	response := []byte("hello jocasta, this is response")
+
+	t.Run("half close", func(t *testing.T) {
+		client, proxyIn := tcpPair(t)
+		proxyOut, server := tcpPair(t)
+
+		go func() {
+			b, _ := ioutil.ReadAll(server) // wait client half close
+			if string(b) == string(request) {
+				server.Write(response) // nolint: errcheck
+			}
+			server.Close()
+		}()
+
+		resCh := make(chan Result, 1)
+		go func() { resCh <- New(1024).Proxy(proxyIn, proxyOut) }()
+
+		_, err := client.Write(request)
+		require.NoError(t, err)
+		require.NoError(t, client.CloseWrite())
+
+		got, err := ioutil.ReadAll(client)
+		require.NoError(t, err)
+		require.Equal(t, response, got)
+
+		res := <-resCh
+		require.NoError(t, res.Err())
+		assert.Equal(t, int64(len(request)), res.Upstream.Written)
+		assert.Equal(t, int64(len(response)), res.Downstream.Written)
+	})
+
+	t.Run("linger timeout", func(t *testing.T) {
+		client, proxyIn := tcpPair(t)
+		proxyOut, _ := tcpPair(t)
+
+		_, err := client.Write(request)
+		require.NoError(t, err)
+		require.NoError(t, client.CloseWrite())
+
+		start := time.Now()
+		res := New(1024, WithLinger(time.Millisecond*100)).Proxy(proxyIn, proxyOut)
+		assert.True(t, time.Since(start) >= time.Millisecond*100)
+		require.NoError(t, res.Err())
+		assert.Equal(t, int64(len(request)), res.Upstream.Written)
+		assert.Equal(t, int64(0), res.Downstream.Written)
+	})
+
+	t.Run("not support half close", func(t *testing.T) {
+		client, proxyIn := net.Pipe()
+		proxyOut, server := net.Pipe()
+		defer server.Close()
+
+		go func() {
+			client.Write(request) // nolint: errcheck
+			client.Close()
+		}()
+		go ioutil.ReadAll(server) // nolint: errcheck
+
+		start := time.Now()
+		res := New(1024).Proxy(proxyIn, proxyOut)
+		assert.True(t, time.Since(start) < DefaultLinger)
+		require.NoError(t, res.Err())
+		assert.Equal(t, int64(len(request)), res.Upstream.Written)
+	})
+
+	t.Run("not support deadline", func(t *testing.T) {
+		client, proxyIn := net.Pipe()
+		proxyOut, server := net.Pipe()
+		defer server.Close()
+
+		go func() {
+			client.Write(request) // nolint: errcheck
+			client.Close()
+		}()
+		go ioutil.ReadAll(server) // nolint: errcheck
+
+		// 不支持deadline时关闭以中止另一方向, 并等待两个方向结束
+		res := New(1024).Proxy(struct{ io.ReadWriteCloser }{proxyIn}, struct{ io.ReadWriteCloser }{proxyOut})
+		require.NoError(t, res.Err())
+		assert.Equal(t, int64(len(request)), res.Upstream.Written)
+		_, err := proxyOut.Write([]byte{1})
+		assert.Equal(t, io.ErrClosedPipe, err)
+	})
+}
+
+func TestZeroCopy(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 10000)
+
+	t.Run("look through", func(t *testing.T) {
+		c1, c2 := tcpPair(t)
+
+		conn, adorns := lookThrough(&cflow.Conn{Conn: ciol.New(c1)})
+		require.Equal(t, c1, conn)
+		require.Len(t, adorns, 2)
+
+		conn, _ = lookThrough(ciol.New(c2, ciol.WithReadLimiter(1000)))
+		require.Nil(t, conn)
+		conn, _ = lookThrough(csnappy.New(c2))
+		require.Nil(t, conn)
+	})
+
+	t.Run("transfer with flow", func(t *testing.T) {
+		client, proxyIn := tcpPair(t)
+		proxyOut, server := tcpPair(t)
+
+		rc, wc := atomic.NewUint64(0), atomic.NewUint64(0)
+		src := &cflow.Conn{Conn: proxyIn, Rc: rc}
+		dst := &cflow.Conn{Conn: proxyOut, Wc: wc}
+
+		go func() {
+			client.Write(data) // nolint: errcheck
+			client.Close()
+		}()
+		got := make(chan []byte, 1)
+		go func() {
+			b, _ := ioutil.ReadAll(server)
+			got <- b
+		}()
+
+		tr := New(1024).transfer(dst, src)
+		require.NoError(t, tr.Err)
+		require.Equal(t, int64(len(data)), tr.Written)
+		proxyOut.CloseWrite() // nolint: errcheck
+		require.Equal(t, data, <-got)
+		assert.Equal(t, uint64(len(data)), rc.Load())
+		assert.Equal(t, uint64(len(data)), wc.Load())
+	})
+
+	t.Run("report during transfer", func(t *testing.T) {
+		client, proxyIn := tcpPair(t)
+		proxyOut, server := tcpPair(t)
+
+		rc := atomic.NewUint64(0)
+		done := make(chan Transfer, 1)
+		go func() { done <- New(1024).transfer(proxyOut, &cflow.Conn{Conn: proxyIn, Rc: rc}) }()
+		go ioutil.ReadAll(server) // nolint: errcheck
+
+		// 连接未结束时, 已传输的块应已统计
+		_, err := client.Write(make([]byte, zeroCopyChunk*2))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return rc.Load() >= zeroCopyChunk }, time.Second*3, time.Millisecond*10)
+		client.Close()
+		tr := <-done
+		require.NoError(t, tr.Err)
+		assert.Equal(t, int64(zeroCopyChunk*2), tr.Written)
+		assert.Equal(t, uint64(zeroCopyChunk*2), rc.Load())
+	})
+}
+
+func BenchmarkCopy(b *testing.B) {
+	const size = 32 * 1024 * 1024
+
+	for _, zc := range []bool{false, true} {
+		name := "buffer"
+		if zc {
+			name = "zerocopy"
+		}
+		b.Run(name, func(b *testing.B) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(b, err)
+			defer ln.Close()
+			pair := func() (net.Conn, net.Conn) {
+				c1, err := net.Dial("tcp", ln.Addr().String())
+				require.NoError(b, err)
+				c2, err := ln.Accept()
+				require.NoError(b, err)
+				return c1, c2
+			}
+
+			fw := New(32*1024, WithZeroCopy(zc))
+			buf := make([]byte, 32*1024)
+			b.ReportAllocs()
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client, proxyIn := pair()
+				proxyOut, server := pair()
+				go func() {
+					for n := 0; n < size; n += len(buf) {
+						client.Write(buf) // nolint: errcheck
+					}
+					client.Close()
+				}()
+				go func() {
+					fw.Copy(&cflow.Conn{Conn: proxyOut}, &cflow.Conn{Conn: proxyIn}) // nolint: errcheck
+					proxyOut.Close()
+				}()
+				io.Copy(ioutil.Discard, server) // nolint: errcheck
+				proxyIn.Close()
+				server.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package binding
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/things-go/x/extnet"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/pkg/gopool"
+)
+
+// DefaultUDPIdleTimeout 默认udp关联空闲超时时间
+const DefaultUDPIdleTimeout = 60 * time.Second
+
+// ErrTooManyAssociations the number of associations reach the limit
+var ErrTooManyAssociations = errors.New("too many udp associations")
+
+// NATMode udp association mapping mode
+type NATMode int
+
+// NATMode support
+const (
+	// EndpointIndependent 同一源地址发往任意目标地址都复用同一个关联(full-cone)
+	EndpointIndependent NATMode = iota
+	// AddressDependent 同一源地址发往不同目标地址使用不同的关联, 且只接收来自该目标地址的回复
+	AddressDependent
+)
+
+// UDPDialFunc dial the upstream packet conn for the association of src and dst,
+// the packet conn should support WriteTo, a connected udp conn should be wrapped
+// by NewConnectedPacketConn.
+type UDPDialFunc func(src, dst net.Addr) (net.PacketConn, error)
+
+// UDPReplyFunc reply the data from upstream remote addr to src
+type UDPReplyFunc func(src, from net.Addr, data []byte) error
+
+// UDPStats udp relay counters
+type UDPStats struct {
+	Active      int    // 当前关联数
+	Created     uint64 // 创建关联数
+	Expired     uint64 // 空闲超时关联数
+	Rejected    uint64 // 超过关联数限制被拒绝的包数
+	PacketsUp   uint64 // src ---> upstream 包数
+	PacketsDown uint64 // upstream ---> src 包数
+	BytesUp     uint64 // src ---> upstream 字节数
+	BytesDown   uint64 // upstream ---> src 字节数
+}
+
+// UDPRelayOption UDPRelay option
+type UDPRelayOption func(r *UDPRelay)
+
+// WithNATMode 关联映射模式, default: EndpointIndependent
+func WithNATMode(mode NATMode) UDPRelayOption {
+	return func(r *UDPRelay) {
+		r.mode = mode
+	}
+}
+
+// WithIdleTimeout 关联空闲超时时间, default: DefaultUDPIdleTimeout
+func WithIdleTimeout(timeout time.Duration) UDPRelayOption {
+	return func(r *UDPRelay) {
+		if timeout > 0 {
+			r.idleTimeout = timeout
+		}
+	}
+}
+
+// WithMaxAssociations 最大关联数, <=0 不限制, default: 0
+func WithMaxAssociations(n int) UDPRelayOption {
+	return func(r *UDPRelay) {
+		r.maxAssociations = n
+	}
+}
+
+// association src to upstream packet conn
+type association struct {
+	src net.Addr
+	dst net.Addr
+	pc  net.PacketConn
+}
+
+// UDPRelay udp association table, relay the packet from src to upstream
+// and reply the packet from upstream to src.
+type UDPRelay struct {
+	forward         *Forward
+	dial            UDPDialFunc
+	reply           UDPReplyFunc
+	mode            NATMode
+	idleTimeout     time.Duration
+	maxAssociations int
+
+	assocs *connection.Manager
+	single singleflight.Group
+
+	created     atomic.Uint64
+	expired     atomic.Uint64
+	rejected    atomic.Uint64
+	packetsUp   atomic.Uint64
+	packetsDown atomic.Uint64
+	bytesUp     atomic.Uint64
+	bytesDown   atomic.Uint64
+}
+
+// NewUDPRelay new a udp relay, which use the forward's buffer and goroutine pool.
+// call Watch in a goroutine to expire the idle associations.
+func (sf *Forward) NewUDPRelay(dial UDPDialFunc, reply UDPReplyFunc, opts ...UDPRelayOption) *UDPRelay {
+	r := &UDPRelay{
+		forward:     sf,
+		dial:        dial,
+		reply:       reply,
+		idleTimeout: DefaultUDPIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.assocs = connection.New(0, nil,
+		connection.WithExpiry(time.Second, func(key string, value interface{}) {
+			r.expired.Inc()
+			value.(*association).pc.Close()
+		}))
+	return r
+}
+
+// Watch expire the idle associations, should run in a goroutine
+func (sf *UDPRelay) Watch(ctx context.Context) {
+	sf.assocs.Watch(ctx)
+}
+
+// Send relay data from src to dst, it creates the association if not exist.
+func (sf *UDPRelay) Send(src, dst net.Addr, data []byte) error {
+	key := src.String()
+	if sf.mode == AddressDependent {
+		key += "|" + dst.String()
+	}
+
+	assoc, err := sf.get(key, src, dst)
+	if err != nil {
+		return err
+	}
+	sf.assocs.Touch(key)
+	n, err := assoc.pc.WriteTo(data, dst)
+	if err != nil {
+		return err
+	}
+	sf.packetsUp.Inc()
+	sf.bytesUp.Add(uint64(n))
+	return nil
+}
+
+// Stats returns the counters of the relay
+func (sf *UDPRelay) Stats() UDPStats {
+	return UDPStats{
+		Active:      sf.assocs.Count(),
+		Created:     sf.created.Load(),
+		Expired:     sf.expired.Load(),
+		Rejected:    sf.rejected.Load(),
+		PacketsUp:   sf.packetsUp.Load(),
+		PacketsDown: sf.packetsDown.Load(),
+		BytesUp:     sf.bytesUp.Load(),
+		BytesDown:   sf.bytesDown.Load(),
+	}
+}
+
+// Close close all the associations
+func (sf *UDPRelay) Close() {
+	for key, v := range sf.assocs.Items() {
+		sf.assocs.Remove(key)
+		v.(*association).pc.Close()
+	}
+}
+
+func (sf *UDPRelay) get(key string, src, dst net.Addr) (*association, error) {
+	if v, ok := sf.assocs.Get(key); ok {
+		return v.(*association), nil
+	}
+	v, err, _ := sf.single.Do(key, func() (interface{}, error) {
+		if v, ok := sf.assocs.Get(key); ok {
+			return v, nil
+		}
+		if sf.maxAssociations > 0 && sf.assocs.Count() >= sf.maxAssociations {
+			sf.rejected.Inc()
+			return nil, ErrTooManyAssociations
+		}
+		pc, err := sf.dial(src, dst)
+		if err != nil {
+			return nil, err
+		}
+		assoc := &association{src, dst, pc}
+		sf.assocs.SetWithTimeout(key, assoc, sf.idleTimeout)
+		sf.created.Inc()
+		gopool.Go(sf.forward.gPool, func() { sf.runReply(key, assoc) })
+		return assoc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*association), nil
+}
+
+// runReply read from upstream and reply to src until the association closed
+func (sf *UDPRelay) runReply(key string, assoc *association) {
+	buf := sf.forward.Get()
+	defer func() {
+		sf.forward.Put(buf)
+		if v, ok := sf.assocs.Get(key); ok && v == assoc {
+			sf.assocs.Remove(key)
+		}
+		assoc.pc.Close()
+	}()
+	for {
+		n, from, err := assoc.pc.ReadFrom(buf[:cap(buf)])
+		if err != nil {
+			// 仅临时错误继续读取, 持续性错误(如EBADF, ENETDOWN)结束关联, 避免空转
+			if ne, ok := err.(net.Error); ok && ne.Temporary() && !ne.Timeout() {
+				continue
+			}
+			return
+		}
+		// 地址相关模式丢弃非目标地址的包
+		if sf.mode == AddressDependent && !sameAddr(from, assoc.dst) {
+			continue
+		}
+		sf.assocs.Touch(key)
+		if err = sf.reply(assoc.src, from, buf[:n]); err != nil {
+			if extnet.IsErrClosed(err) {
+				return
+			}
+			continue
+		}
+		sf.packetsDown.Inc()
+		sf.bytesDown.Add(uint64(n))
+	}
+}
+
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if ok1 && ok2 {
+		return ua.Port == ub.Port && ua.IP.Equal(ub.IP)
+	}
+	return a.String() == b.String()
+}
+
+// connectedPacketConn connected udp conn as a net.PacketConn
+type connectedPacketConn struct {
+	*net.UDPConn
+}
+
+// NewConnectedPacketConn wrap a connected udp conn as the upstream packet conn,
+// WriteTo ignore the addr and write to the connected remote addr,
+// the kernel drop the packet not come from the connected remote addr.
+func NewConnectedPacketConn(conn *net.UDPConn) net.PacketConn {
+	return connectedPacketConn{conn}
+}
+
+// WriteTo implement net.PacketConn, the addr is ignored
+func (sf connectedPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return sf.UDPConn.Write(b)
+}
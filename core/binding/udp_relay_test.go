@@ -0,0 +1,232 @@
+package binding
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpEcho run a udp echo server
+func udpEcho(t *testing.T) net.Addr {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr) // nolint: errcheck
+		}
+	}()
+	return pc.LocalAddr()
+}
+
+type reply struct {
+	src, from net.Addr
+	data      string
+}
+
+func newTestRelay(t *testing.T, opts ...UDPRelayOption) (*UDPRelay, chan reply) {
+	replies := make(chan reply, 10)
+	r := New(2048).NewUDPRelay(
+		func(src, dst net.Addr) (net.PacketConn, error) {
+			return net.ListenPacket("udp", "127.0.0.1:0")
+		},
+		func(src, from net.Addr, data []byte) error {
+			replies <- reply{src, from, string(data)}
+			return nil
+		}, opts...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go r.Watch(ctx)
+	t.Cleanup(func() {
+		cancel()
+		r.Close()
+	})
+	return r, replies
+}
+
+func recvReply(t *testing.T, replies chan reply) reply {
+	select {
+	case r := <-replies:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("reply timeout")
+	}
+	return reply{}
+}
+
+func TestUDPRelay(t *testing.T) {
+	src1 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	src2 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}
+	dst1, dst2 := udpEcho(t), udpEcho(t)
+
+	t.Run("endpoint independent", func(t *testing.T) {
+		r, replies := newTestRelay(t)
+
+		require.NoError(t, r.Send(src1, dst1, []byte("hello")))
+		rp := recvReply(t, replies)
+		assert.Equal(t, src1, rp.src)
+		assert.Equal(t, dst1.String(), rp.from.String())
+		assert.Equal(t, "hello", rp.data)
+
+		require.NoError(t, r.Send(src1, dst2, []byte("world")))
+		rp = recvReply(t, replies)
+		assert.Equal(t, dst2.String(), rp.from.String())
+		assert.Equal(t, "world", rp.data)
+
+		require.NoError(t, r.Send(src2, dst1, []byte("foo")))
+		recvReply(t, replies)
+
+		stats := r.Stats()
+		assert.Equal(t, 2, stats.Active)
+		assert.Equal(t, uint64(2), stats.Created)
+		assert.Equal(t, uint64(3), stats.PacketsUp)
+		assert.Equal(t, uint64(3), stats.PacketsDown)
+		assert.Equal(t, uint64(13), stats.BytesUp)
+		assert.Equal(t, uint64(13), stats.BytesDown)
+	})
+
+	t.Run("address dependent", func(t *testing.T) {
+		r, replies := newTestRelay(t, WithNATMode(AddressDependent))
+
+		require.NoError(t, r.Send(src1, dst1, []byte("hello")))
+		recvReply(t, replies)
+		require.NoError(t, r.Send(src1, dst2, []byte("world")))
+		recvReply(t, replies)
+		require.NoError(t, r.Send(src1, dst2, []byte("world")))
+		recvReply(t, replies)
+		assert.Equal(t, uint64(2), r.Stats().Created)
+	})
+
+	t.Run("max associations", func(t *testing.T) {
+		r, replies := newTestRelay(t, WithMaxAssociations(1))
+
+		require.NoError(t, r.Send(src1, dst1, []byte("hello")))
+		recvReply(t, replies)
+		require.Equal(t, ErrTooManyAssociations, r.Send(src2, dst1, []byte("hello")))
+		assert.Equal(t, uint64(1), r.Stats().Rejected)
+	})
+
+	t.Run("idle expired", func(t *testing.T) {
+		r, replies := newTestRelay(t, WithIdleTimeout(time.Millisecond*100))
+
+		require.NoError(t, r.Send(src1, dst1, []byte("hello")))
+		recvReply(t, replies)
+		require.Eventually(t, func() bool {
+			stats := r.Stats()
+			return stats.Active == 0 && stats.Expired == 1
+		}, time.Second*3, time.Millisecond*100)
+
+		require.NoError(t, r.Send(src1, dst1, []byte("hello")))
+		recvReply(t, replies)
+		assert.Equal(t, uint64(2), r.Stats().Created)
+	})
+}
+
+func TestUDPRelay_Filter(t *testing.T) {
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	dst := udpEcho(t)
+	other, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer other.Close()
+
+	for _, tt := range []struct {
+		name   string
+		mode   NATMode
+		dial   func(dst net.Addr) (net.PacketConn, error)
+		inject bool // 是否接收非目标地址的包
+	}{
+		{
+			"endpoint independent",
+			EndpointIndependent,
+			func(net.Addr) (net.PacketConn, error) { return net.ListenPacket("udp", "127.0.0.1:0") },
+			true,
+		},
+		{
+			"address dependent",
+			AddressDependent,
+			func(net.Addr) (net.PacketConn, error) { return net.ListenPacket("udp", "127.0.0.1:0") },
+			false,
+		},
+		{
+			"connected",
+			EndpointIndependent,
+			func(dst net.Addr) (net.PacketConn, error) {
+				conn, err := net.DialUDP("udp", nil, dst.(*net.UDPAddr))
+				if err != nil {
+					return nil, err
+				}
+				return NewConnectedPacketConn(conn), nil
+			},
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			local := make(chan net.Addr, 1)
+			replies := make(chan reply, 10)
+			r := New(2048).NewUDPRelay(
+				func(src, dst net.Addr) (net.PacketConn, error) {
+					pc, err := tt.dial(dst)
+					if err == nil {
+						local <- pc.LocalAddr()
+					}
+					return pc, err
+				},
+				func(src, from net.Addr, data []byte) error {
+					replies <- reply{src, from, string(data)}
+					return nil
+				}, WithNATMode(tt.mode))
+			defer r.Close()
+
+			require.NoError(t, r.Send(src, dst, []byte("hello")))
+			rp := recvReply(t, replies)
+			assert.Equal(t, dst.String(), rp.from.String())
+
+			// 其它地址向关联的上游地址发包
+			_, err := other.WriteTo([]byte("inject"), <-local)
+			require.NoError(t, err)
+			select {
+			case rp = <-replies:
+				assert.True(t, tt.inject, "unexpected reply from %s", rp.from)
+				assert.Equal(t, other.LocalAddr().String(), rp.from.String())
+			case <-time.After(time.Millisecond * 200):
+				assert.False(t, tt.inject, "reply timeout")
+			}
+		})
+	}
+}
+
+// errPacketConn ReadFrom always return a persistent error
+type errPacketConn struct {
+	net.PacketConn
+	reads int
+}
+
+func (sf *errPacketConn) ReadFrom([]byte) (int, net.Addr, error) {
+	sf.reads++
+	return 0, nil, &net.OpError{Op: "read", Net: "udp", Err: errors.New("network is down")}
+}
+
+func TestUDPRelay_ReadError(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	epc := &errPacketConn{PacketConn: pc}
+	r := New(2048).NewUDPRelay(
+		func(src, dst net.Addr) (net.PacketConn, error) { return epc, nil },
+		func(src, from net.Addr, data []byte) error { return nil })
+	defer r.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+	require.NoError(t, r.Send(src, pc.LocalAddr(), []byte("hello")))
+	// 持续性错误结束关联, 不空转
+	require.Eventually(t, func() bool { return r.Stats().Active == 0 }, time.Second, time.Millisecond*10)
+	assert.Equal(t, 1, epc.reads)
+}
@@ -0,0 +1,66 @@
+package binding
+
+import (
+	"io"
+	"net"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// zeroCopyChunk 零拷贝每次传输的最大字节数, 每传输一块向被绕过的adorn报告一次,
+// 长连接的字节统计在传输过程中即可见, 而不是连接结束后才更新
+const zeroCopyChunk = 64 * 1024
+
+// zeroCopy transfer src to dst with zero copy if both of them are *net.TCPConn
+// after looking through the adorns which can be bypassed.
+// it uses (*net.TCPConn).ReadFrom which is splice on linux, in chunks of zeroCopyChunk,
+// and reports the transferred bytes of each chunk to the bypassed adorns.
+// returns false if not support.
+func zeroCopy(dst io.Writer, src io.Reader) (Transfer, bool) {
+	dstConn, dstAdorns := lookThrough(dst)
+	if dstConn == nil {
+		return Transfer{}, false
+	}
+	srcConn, srcAdorns := lookThrough(src)
+	if srcConn == nil {
+		return Transfer{}, false
+	}
+
+	var written int64
+	lr := &io.LimitedReader{R: srcConn}
+	for {
+		lr.N = zeroCopyChunk
+		n, err := dstConn.ReadFrom(lr)
+		written += n
+		for _, adorn := range srcAdorns {
+			adorn.Bypassed(true, n)
+		}
+		for _, adorn := range dstAdorns {
+			adorn.Bypassed(false, n)
+		}
+		// 未读满一块说明src已EOF
+		if err != nil || n < zeroCopyChunk {
+			return Transfer{written, err}, true
+		}
+	}
+}
+
+// lookThrough looks through the adorns which can be bypassed until *net.TCPConn,
+// returns nil if not found.
+func lookThrough(v interface{}) (*net.TCPConn, []connection.Bypasser) {
+	var adorns []connection.Bypasser
+	for {
+		switch c := v.(type) {
+		case *net.TCPConn:
+			return c, adorns
+		case connection.Bypasser:
+			if !c.CanBypass() {
+				return nil, nil
+			}
+			adorns = append(adorns, c)
+			v = c.Unwrap()
+		default:
+			return nil, nil
+		}
+	}
+}
@@ -16,6 +16,10 @@ type AddrSpec struct {
 	AddrType byte
 }
 
+// Network returns the network of the address, AddrSpec carried by datagram is always udp,
+// so *AddrSpec implements net.Addr.
+func (sf *AddrSpec) Network() string { return "udp" }
+
 // String returns a string suitable to dial; prefer returning IP-based
 // address, fallback to FQDN
 func (sf *AddrSpec) String() string {
@@ -42,86 +42,104 @@ func NewDatagram(destAddr string, data []byte) (da Datagram, err error) {
 
 // ParseDatagram parse to datagram from bytes
 func ParseDatagram(b []byte) (da Datagram, err error) {
-	if len(b) < 2+net.IPv4len+2 { // no enough data
-		err = errors.New("datagram to short")
+	if err = ParseDatagramInto(b, &da); err != nil {
 		return
 	}
+	switch da.Addr.AddrType {
+	case ATYPIPv4:
+		da.Addr.IP = net.IPv4(da.Addr.IP[0], da.Addr.IP[1], da.Addr.IP[2], da.Addr.IP[3])
+	case ATYPDomain:
+		da.Addr.FQDN = string(b[3 : 3+len(da.Addr.FQDN)])
+	}
+	return
+}
+
+// ParseDatagramInto parse datagram from bytes into da without allocation.
+// the IP, FQDN and Data of da reference b, so b should not be modified while da in use.
+func ParseDatagramInto(b []byte, da *Datagram) error {
+	if len(b) < 2+net.IPv4len+2 { // no enough data
+		return errors.New("datagram to short")
+	}
 	// ignore RSV And get Address  type
 	da.Reserved, da.Addr.AddrType = b[0], b[1]
+	da.Addr.IP, da.Addr.FQDN = nil, ""
 
 	headLen := 2
 	switch da.Addr.AddrType {
 	case ATYPIPv4:
 		headLen += net.IPv4len + 2
-		da.Addr.IP = net.IPv4(b[2], b[3], b[4], b[5])
-		da.Addr.Port = int(binary.BigEndian.Uint16((b[headLen-2:])))
+		da.Addr.IP = b[2 : 2+net.IPv4len]
 	case ATYPIPv6:
 		headLen += net.IPv6len + 2
-		if len(b) <= headLen {
-			err = errors.New("datagram to short")
-			return
+		if len(b) < headLen {
+			return errors.New("datagram to short")
 		}
-
 		da.Addr.IP = b[2 : 2+net.IPv6len]
-		da.Addr.Port = int(binary.BigEndian.Uint16(b[headLen-2:]))
 	case ATYPDomain:
 		addrLen := int(b[2])
 		headLen += 1 + addrLen + 2
-		if len(b) <= headLen {
-			err = errors.New("datagram to short")
-			return
+		if len(b) < headLen {
+			return errors.New("datagram to short")
 		}
-		da.Addr.FQDN = string(b[3 : 3+addrLen])
-		da.Addr.Port = int(binary.BigEndian.Uint16(b[headLen-2:]))
+		da.Addr.FQDN = bytesconv.Bytes2Str(b[3 : 3+addrLen])
 	default:
-		err = ErrUnrecognizedAddrType
-		return
+		return ErrUnrecognizedAddrType
 	}
+	da.Addr.Port = int(binary.BigEndian.Uint16(b[headLen-2:]))
 	da.Data = b[headLen:]
-	return
+	return nil
 }
 
 // Header returns s slice of datagram header except data
 func (sf *Datagram) Header() []byte {
-	return sf.values(false)
+	return sf.AppendHeader(make([]byte, 0, sf.headerLen()))
 }
 
 // Bytes datagram to bytes
 func (sf *Datagram) Bytes() []byte {
-	return sf.values(true)
+	return sf.Append(make([]byte, 0, sf.headerLen()+len(sf.Data)))
 }
 
-func (sf *Datagram) values(hasData bool) (bs []byte) {
-	var addr []byte
+// AppendHeader appends the datagram header except data to dst and returns the extended buffer.
+func (sf *Datagram) AppendHeader(dst []byte) []byte {
+	return appendAddr(append(dst, sf.Reserved, sf.Addr.AddrType), &sf.Addr)
+}
+
+// Append appends the datagram to dst and returns the extended buffer.
+func (sf *Datagram) Append(dst []byte) []byte {
+	return append(sf.AppendHeader(dst), sf.Data...)
+}
 
-	length := 4
-	switch sf.Addr.AddrType {
+func (sf *Datagram) headerLen() int {
+	return 2 + addrLen(&sf.Addr)
+}
+
+// addrLen returns the length of ADDR and PORT
+func addrLen(as *AddrSpec) int {
+	switch as.AddrType {
 	case ATYPIPv4:
-		length += net.IPv4len
-		addr = sf.Addr.IP.To4()
+		return net.IPv4len + 2
 	case ATYPIPv6:
-		length += net.IPv6len
-		addr = sf.Addr.IP.To16()
+		return net.IPv6len + 2
 	case ATYPDomain:
-		length += 1 + len(sf.Addr.FQDN)
-		addr = bytesconv.Str2Bytes(sf.Addr.FQDN)
+		return 1 + len(as.FQDN) + 2
 	default:
-		panic(fmt.Sprintf("invalid address type: %d", sf.Addr.AddrType))
-	}
-	if hasData {
-		bs = make([]byte, 0, length+len(sf.Data))
-	} else {
-		bs = make([]byte, 0, length)
+		panic(fmt.Sprintf("invalid address type: %d", as.AddrType))
 	}
+}
 
-	bs = append(bs, sf.Reserved, sf.Addr.AddrType)
-	if sf.Addr.AddrType == ATYPDomain {
-		bs = append(bs, byte(len(sf.Addr.FQDN)))
-	}
-	bs = append(bs, addr...)
-	bs = append(bs, byte(sf.Addr.Port>>8), byte(sf.Addr.Port))
-	if hasData {
-		bs = append(bs, sf.Data...)
+// appendAddr append ADDR and PORT to dst
+func appendAddr(dst []byte, as *AddrSpec) []byte {
+	switch as.AddrType {
+	case ATYPIPv4:
+		dst = append(dst, as.IP.To4()...)
+	case ATYPIPv6:
+		dst = append(dst, as.IP.To16()...)
+	case ATYPDomain:
+		dst = append(dst, byte(len(as.FQDN)))
+		dst = append(dst, as.FQDN...)
+	default:
+		panic(fmt.Sprintf("invalid address type: %d", as.AddrType))
 	}
-	return
+	return append(dst, byte(as.Port>>8), byte(as.Port))
 }
@@ -1,6 +1,7 @@
 package captain
 
 import (
+	"bytes"
 	"net"
 	"reflect"
 	"testing"
@@ -150,3 +151,50 @@ func TestParseDatagram(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDatagramInto(t *testing.T) {
+	data := []byte{1, 2, 3}
+	for _, addr := range []string{"127.0.0.1:8080", "[::1]:8080", "localhost:8080"} {
+		t.Run(addr, func(t *testing.T) {
+			d, err := NewDatagram(addr, data)
+			require.NoError(t, err)
+			b := d.Append(nil)
+			require.Equal(t, d.Bytes(), b)
+
+			var da Datagram
+			require.NoError(t, ParseDatagramInto(b, &da))
+			require.Equal(t, addr, da.Addr.String())
+			require.Equal(t, data, da.Data)
+
+			buf := make([]byte, 0, 64)
+			allocs := testing.AllocsPerRun(100, func() {
+				ParseDatagramInto(b, &da) // nolint: errcheck
+				d.Append(buf)
+			})
+			require.Zero(t, allocs)
+		})
+	}
+}
+
+func BenchmarkParseDatagram(b *testing.B) {
+	data := bytes.Repeat([]byte{1}, 512)
+	for _, addr := range []string{"127.0.0.1:8080", "[::1]:8080", "localhost:8080"} {
+		d, err := NewDatagram(addr, data)
+		require.NoError(b, err)
+		raw := d.Bytes()
+
+		b.Run(addr, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ParseDatagram(raw) // nolint: errcheck
+			}
+		})
+		b.Run(addr+" into", func(b *testing.B) {
+			var da Datagram
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ParseDatagramInto(raw, &da) // nolint: errcheck
+			}
+		})
+	}
+}
@@ -36,7 +36,11 @@ func DataLen(length int) (ds [3]byte, n int, err error) {
 
 // ParseDataLen parse data length from reader
 func ParseDataLen(r io.Reader) (int, error) {
-	tmp := []byte{0}
+	return parseDataLen(r, []byte{0})
+}
+
+// parseDataLen parse data length from reader, tmp is a one byte scratch buffer
+func parseDataLen(r io.Reader, tmp []byte) (int, error) {
 	// read remain data len
 	length, remain := 0, byte(0)
 	for i := 0; ; i++ {
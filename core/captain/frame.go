@@ -0,0 +1,289 @@
+package captain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FlagFrameV2 TYPES字段最高位, 置位表示v2帧, 因此帧类型只能使用低7位
+const FlagFrameV2 = byte(0x80)
+
+// MaxFrameDataLen v2帧数据最大长度
+const MaxFrameDataLen = 64 << 20
+
+// MaxFrameOptionsLen v2帧选项最大总长度
+const MaxFrameOptionsLen = 64 << 10
+
+// option type defined
+const (
+	OptionFlags       = byte(0x01) // 标志位
+	OptionCompression = byte(0x02) // 压缩方法
+	OptionAuthTag     = byte(0x03) // 认证标签
+)
+
+// frame error defined
+var (
+	ErrFrameTooLarge     = errors.New("frame too large")
+	ErrInvalidOption     = errors.New("invalid frame option")
+	ErrInvalidFrameTypes = errors.New("invalid frame types, should be less than 0x80")
+)
+
+// Option typed TLV option
+type Option struct {
+	Type  byte
+	Value []byte
+}
+
+// Options TLV option list, unknown options are kept as it is,
+// so the receiver can ignore options it not know.
+type Options []Option
+
+// Get returns the value of the first option with the type
+func (sf Options) Get(typ byte) ([]byte, bool) {
+	for _, o := range sf {
+		if o.Type == typ {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set set the option value, replace the first option with the same type if exist
+func (sf *Options) Set(typ byte, value []byte) {
+	for i, o := range *sf {
+		if o.Type == typ {
+			(*sf)[i].Value = value
+			return
+		}
+	}
+	*sf = append(*sf, Option{typ, value})
+}
+
+// Del delete all options with the type
+func (sf *Options) Del(typ byte) {
+	opts := (*sf)[:0]
+	for _, o := range *sf {
+		if o.Type != typ {
+			opts = append(opts, o)
+		}
+	}
+	*sf = opts
+}
+
+func (sf Options) size() int {
+	n := 0
+	for _, o := range sf {
+		n += 1 + uvarintLen(uint64(len(o.Value))) + len(o.Value)
+	}
+	return n
+}
+
+// Frame versioned frame, compatible with Request(v1).
+// v1 frame is formed as Request, v2 frame is formed as follows:
+// +---------+-------+------------+----------+------------+----------+
+// |  TYPES  |  VER  |  OPTS_LEN  |   OPTS   |  DATA_LEN  |   DATA   |
+// +---------+-------+------------+----------+------------+----------+
+// |    1    |   1   |   uvarint  | Variable |   uvarint  | Variable |
+// +---------+-------+------------+----------+------------+----------+
+// TYPES 类型, 最高位为FlagFrameV2
+// VER 版本
+// OPTS_LEN 选项总长度
+// OPTS 选项, TLV格式: TYPE(1) + LEN(uvarint) + VALUE
+// DATA_LEN 数据长度
+// DATA 数据
+type Frame struct {
+	Types   byte
+	Version byte
+	Options Options
+	Data    []byte
+}
+
+// ParseFrame parse v1 or v2 frame from reader, data length up to MaxFrameDataLen
+func ParseFrame(r io.Reader) (f Frame, err error) {
+	return ParseFrameLimit(r, MaxFrameDataLen)
+}
+
+// ParseFrameLimit parse v1 or v2 frame from reader, data length larger than
+// maxDataLen returns ErrFrameTooLarge, callers which only expect small control
+// frames should use a small limit. the length is not trusted, the buffer grows
+// as the data arrives instead of allocating the full length up front.
+func ParseFrameLimit(r io.Reader, maxDataLen int) (f Frame, err error) {
+	tmp := []byte{0, 0}
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	f.Types, f.Version = tmp[0]&^FlagFrameV2, tmp[1]
+
+	var length int
+	if tmp[0]&FlagFrameV2 == 0 {
+		// v1 frame
+		if length, err = ParseDataLen(r); err != nil {
+			return
+		}
+	} else {
+		var optsLen uint64
+		if optsLen, err = readUvarint(r, tmp[:1]); err != nil {
+			return
+		}
+		if optsLen > MaxFrameOptionsLen {
+			err = ErrFrameTooLarge
+			return
+		}
+		if optsLen > 0 {
+			var opts []byte
+			if opts, err = readBytes(r, int(optsLen)); err != nil {
+				return
+			}
+			if f.Options, err = parseOptions(opts); err != nil {
+				return
+			}
+		}
+		var dataLen uint64
+		if dataLen, err = readUvarint(r, tmp[:1]); err != nil {
+			return
+		}
+		if dataLen > MaxFrameDataLen {
+			err = ErrFrameTooLarge
+			return
+		}
+		length = int(dataLen)
+	}
+	if length > maxDataLen {
+		err = ErrFrameTooLarge
+		return
+	}
+	f.Data, err = readBytes(r, length)
+	return
+}
+
+// frameReadChunk 超过该长度时按到达的数据增长缓冲读取
+const frameReadChunk = 4 << 10
+
+// readBytes read exactly n bytes, the buffer grows as the data arrives if n is large,
+// so a peer lying about the length can not make us allocate much memory.
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	if n <= frameReadChunk {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, frameReadChunk))
+	if m, err := io.CopyN(buf, r, int64(n)); err != nil {
+		if err == io.EOF && m > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Bytes frame to bytes, it uses v1 format if no options and data length fit in,
+// so that old node can parse it, otherwise v2 format.
+func (sf *Frame) Bytes() ([]byte, error) {
+	return sf.Append(nil)
+}
+
+// Append appends the frame to dst and returns the extended buffer, see Bytes.
+func (sf *Frame) Append(dst []byte) ([]byte, error) {
+	if sf.Types&FlagFrameV2 != 0 {
+		return nil, ErrInvalidFrameTypes
+	}
+	if len(sf.Options) == 0 && len(sf.Data) <= MaxDataLen {
+		ds, n, err := DataLen(len(sf.Data))
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, sf.Types, sf.Version)
+		dst = append(dst, ds[:n]...)
+		return append(dst, sf.Data...), nil
+	}
+	return sf.AppendV2(dst)
+}
+
+// AppendV2 appends the frame with v2 format to dst and returns the extended buffer.
+func (sf *Frame) AppendV2(dst []byte) ([]byte, error) {
+	if sf.Types&FlagFrameV2 != 0 {
+		return nil, ErrInvalidFrameTypes
+	}
+	optsLen := sf.Options.size()
+	if optsLen > MaxFrameOptionsLen || len(sf.Data) > MaxFrameDataLen {
+		return nil, ErrFrameTooLarge
+	}
+	for _, o := range sf.Options {
+		if o.Type == 0 {
+			return nil, ErrInvalidOption
+		}
+	}
+	dst = append(dst, sf.Types|FlagFrameV2, sf.Version)
+	dst = appendUvarint(dst, uint64(optsLen))
+	for _, o := range sf.Options {
+		dst = append(dst, o.Type)
+		dst = appendUvarint(dst, uint64(len(o.Value)))
+		dst = append(dst, o.Value...)
+	}
+	dst = appendUvarint(dst, uint64(len(sf.Data)))
+	return append(dst, sf.Data...), nil
+}
+
+// parseOptions parse TLV options from b
+func parseOptions(b []byte) (Options, error) {
+	var opts Options
+	for len(b) > 0 {
+		typ := b[0]
+		length, n := binary.Uvarint(b[1:])
+		if typ == 0 || n <= 0 || length > uint64(len(b)-1-n) {
+			return nil, ErrInvalidOption
+		}
+		b = b[1+n:]
+		opts = append(opts, Option{typ, b[:length:length]})
+		b = b[length:]
+	}
+	return opts, nil
+}
+
+// readUvarint read uvarint from reader, tmp is a one byte scratch buffer
+func readUvarint(r io.Reader, tmp []byte) (uint64, error) {
+	var x uint64
+	var s uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if _, err := io.ReadFull(r, tmp); err != nil {
+			return 0, err
+		}
+		b := tmp[0]
+		if b < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b > 1 {
+				break
+			}
+			return x | uint64(b)<<s, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, errors.New("invalid uvarint")
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(dst, tmp[:n]...)
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; n++ {
+		v >>= 7
+	}
+	return n
+}
+
+// NegotiateVersion negotiate the version both side supported, it returns the lower one.
+func NegotiateVersion(local, remote byte) byte {
+	if remote < local {
+		return remote
+	}
+	return local
+}
@@ -0,0 +1,124 @@
+package captain
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	t.Run("v1 compatible", func(t *testing.T) {
+		f := Frame{Types: 0x5a, Version: 0x01, Data: []byte{1, 2, 3}}
+		b, err := f.Bytes()
+		require.NoError(t, err)
+
+		req, err := Request{Types: 0x5a, Version: 0x01, Data: []byte{1, 2, 3}}.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, req, b)
+
+		got, err := ParseFrame(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, f, got)
+
+		// v1 request can be parsed as frame
+		got, err = ParseFrame(bytes.NewReader(req))
+		require.NoError(t, err)
+		require.Equal(t, f, got)
+	})
+
+	t.Run("v2 options", func(t *testing.T) {
+		f := Frame{Types: 0x01, Version: 0x02, Data: []byte{1, 2, 3}}
+		f.Options.Set(OptionFlags, []byte{0x01})
+		f.Options.Set(OptionAuthTag, bytes.Repeat([]byte{0xaa}, 200))
+		f.Options.Set(0x7f, []byte("unknown"))
+		f.Options.Set(OptionFlags, []byte{0x03})
+
+		b, err := f.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, 0x01|FlagFrameV2, b[0])
+
+		got, err := ParseFrame(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, f, got)
+
+		v, ok := got.Options.Get(OptionFlags)
+		require.True(t, ok)
+		assert.Equal(t, []byte{0x03}, v)
+		_, ok = got.Options.Get(OptionCompression)
+		require.False(t, ok)
+
+		got.Options.Del(OptionAuthTag)
+		assert.Len(t, got.Options, 2)
+	})
+
+	t.Run("v2 large data", func(t *testing.T) {
+		f := Frame{Types: 0x01, Version: 0x02, Data: make([]byte, MaxDataLen+1)}
+		b, err := f.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, 0x01|FlagFrameV2, b[0])
+
+		got, err := ParseFrame(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, len(f.Data), len(got.Data))
+	})
+
+	t.Run("v2 without options", func(t *testing.T) {
+		f := Frame{Types: 0x01, Version: 0x02}
+		b, err := f.AppendV2(nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x81, 0x02, 0x00, 0x00}, b)
+
+		got, err := ParseFrame(bytes.NewReader(b))
+		require.NoError(t, err)
+		require.Equal(t, Frame{Types: 0x01, Version: 0x02, Data: []byte{}}, got)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := (&Frame{Types: 0x80}).Bytes()
+		require.Equal(t, ErrInvalidFrameTypes, err)
+		_, err = (&Frame{Options: Options{{0, nil}}}).Bytes()
+		require.Equal(t, ErrInvalidOption, err)
+		_, err = (&Frame{Data: make([]byte, MaxFrameDataLen+1)}).Bytes()
+		require.Equal(t, ErrFrameTooLarge, err)
+
+		// option length overflow
+		_, err = ParseFrame(bytes.NewReader([]byte{0x81, 0x02, 0x03, OptionFlags, 0x05, 0x01, 0x00}))
+		require.Equal(t, ErrInvalidOption, err)
+		// options too large
+		_, err = ParseFrame(bytes.NewReader([]byte{0x81, 0x02, 0xff, 0xff, 0xff, 0x7f}))
+		require.Equal(t, ErrFrameTooLarge, err)
+		// data too large
+		_, err = ParseFrame(bytes.NewReader([]byte{0x81, 0x02, 0x00, 0xff, 0xff, 0xff, 0x7f}))
+		require.Equal(t, ErrFrameTooLarge, err)
+		// short data
+		_, err = ParseFrame(bytes.NewReader([]byte{0x81, 0x02, 0x00, 0x03, 0x01}))
+		require.Error(t, err)
+		// short large data, lying length
+		_, err = ParseFrame(bytes.NewReader([]byte{0x81, 0x02, 0x00, 0xff, 0xff, 0xff, 0x1f, 0x01}))
+		require.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		b, err := (&Frame{Types: 0x01, Version: 0x02, Data: make([]byte, 100)}).Bytes()
+		require.NoError(t, err)
+		_, err = ParseFrameLimit(bytes.NewReader(b), 99)
+		require.Equal(t, ErrFrameTooLarge, err)
+		got, err := ParseFrameLimit(bytes.NewReader(b), 100)
+		require.NoError(t, err)
+		require.Len(t, got.Data, 100)
+
+		b, err = (&Frame{Types: 0x01, Version: 0x02, Data: make([]byte, 100)}).AppendV2(nil)
+		require.NoError(t, err)
+		_, err = ParseFrameLimit(bytes.NewReader(b), 99)
+		require.Equal(t, ErrFrameTooLarge, err)
+	})
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	assert.Equal(t, byte(1), NegotiateVersion(2, 1))
+	assert.Equal(t, byte(1), NegotiateVersion(1, 2))
+	assert.Equal(t, byte(2), NegotiateVersion(2, 2))
+}
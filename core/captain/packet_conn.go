@@ -0,0 +1,170 @@
+package captain
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/bpool"
+)
+
+// MaxDataLen max data length of stream datagram
+const MaxDataLen = 2097151
+
+// ErrDataTooLarge data too large to be framed as stream datagram
+var ErrDataTooLarge = errors.New("data too large")
+
+// PacketConn wraps a stream conn(tcp, tls, kcp, smux stream...) as a net.PacketConn,
+// each packet is framed as StreamDatagram, the address of the datagram is the
+// peer address of the packet, ReadFrom returns it and WriteTo carry it.
+// ReadFrom returns *net.UDPAddr if the address is ip, otherwise *AddrSpec.
+// It is safe to call ReadFrom and WriteTo concurrently.
+type PacketConn struct {
+	conn net.Conn
+
+	rMu  sync.Mutex
+	r    *bufio.Reader
+	rTmp [maxStreamHeaderLen]byte
+
+	wMu          sync.Mutex
+	pool         bpool.BufferPool
+	writeTimeout time.Duration
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// PacketConnOption PacketConn option
+type PacketConnOption func(pc *PacketConn)
+
+// WithBufferPool 写使用的缓冲池, 默认每次写分配
+func WithBufferPool(pool bpool.BufferPool) PacketConnOption {
+	return func(pc *PacketConn) {
+		pc.pool = pool
+	}
+}
+
+// WithWriteTimeout 每次写的超时时间, 避免对端阻塞时写一直阻塞, <= 0 不超时, 默认不超时
+func WithWriteTimeout(timeout time.Duration) PacketConnOption {
+	return func(pc *PacketConn) {
+		pc.writeTimeout = timeout
+	}
+}
+
+// NewPacketConn new a PacketConn with the stream conn
+func NewPacketConn(conn net.Conn, opts ...PacketConnOption) *PacketConn {
+	pc := &PacketConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	for _, opt := range opts {
+		opt(pc)
+	}
+	return pc
+}
+
+// ReadFrom reads a packet from the stream, copying the payload into p.
+// if p is not large enough, the remain data of the packet will be discarded.
+func (sf *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	sf.rMu.Lock()
+	defer sf.rMu.Unlock()
+
+	var da Datagram
+	length, err := parseStreamHeader(sf.r, &da, sf.rTmp[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if da.Addr.AddrType == ATYPDomain {
+		addr = &AddrSpec{FQDN: string(sf.rTmp[3 : 3+len(da.Addr.FQDN)]), Port: da.Addr.Port, AddrType: ATYPDomain}
+	} else {
+		addr = &net.UDPAddr{IP: append(net.IP(nil), da.Addr.IP...), Port: da.Addr.Port}
+	}
+	n = length
+	if n > len(p) {
+		n = len(p)
+	}
+	if _, err = io.ReadFull(sf.r, p[:n]); err != nil {
+		return 0, nil, err
+	}
+	if _, err = sf.r.Discard(length - n); err != nil {
+		return 0, nil, err
+	}
+	return n, addr, nil
+}
+
+// WriteTo writes a packet with payload p and addr to the stream.
+func (sf *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > MaxDataLen {
+		return 0, ErrDataTooLarge
+	}
+
+	var buf []byte
+	if sf.pool != nil {
+		buf = sf.pool.Get()
+		defer sf.pool.Put(buf)
+	}
+	b, err := appendPacket(buf[:0], addr, p)
+	if err != nil {
+		return 0, err
+	}
+
+	sf.wMu.Lock()
+	defer sf.wMu.Unlock()
+	if sf.writeTimeout > 0 {
+		sf.conn.SetWriteDeadline(time.Now().Add(sf.writeTimeout)) // nolint: errcheck
+		defer sf.conn.SetWriteDeadline(time.Time{})               // nolint: errcheck
+	}
+	if _, err = sf.conn.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying stream conn.
+func (sf *PacketConn) Close() error { return sf.conn.Close() }
+
+// LocalAddr returns the local network address of the underlying stream conn.
+func (sf *PacketConn) LocalAddr() net.Addr { return sf.conn.LocalAddr() }
+
+// RemoteAddr returns the remote network address of the underlying stream conn.
+func (sf *PacketConn) RemoteAddr() net.Addr { return sf.conn.RemoteAddr() }
+
+// SetDeadline sets the read and write deadlines of the underlying stream conn.
+func (sf *PacketConn) SetDeadline(t time.Time) error { return sf.conn.SetDeadline(t) }
+
+// SetReadDeadline sets the read deadline of the underlying stream conn.
+func (sf *PacketConn) SetReadDeadline(t time.Time) error { return sf.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline of the underlying stream conn.
+func (sf *PacketConn) SetWriteDeadline(t time.Time) error { return sf.conn.SetWriteDeadline(t) }
+
+// appendPacket append the stream datagram of addr and data to dst
+func appendPacket(dst []byte, addr net.Addr, data []byte) ([]byte, error) {
+	da := StreamDatagram{Data: data}
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		da.Addr.IP, da.Addr.Port = v.IP, v.Port
+	case *AddrSpec:
+		da.Addr = *v
+	default:
+		as, err := ParseAddrSpec(addr.String())
+		if err != nil {
+			return nil, err
+		}
+		da.Addr = as
+	}
+	if len(da.Addr.IP) > 0 {
+		da.Addr.AddrType = ATYPIPv6
+		if da.Addr.IP.To4() != nil {
+			da.Addr.AddrType = ATYPIPv4
+		}
+	} else if len(da.Addr.FQDN) > math.MaxUint8 {
+		return nil, errors.New("destination host name too long")
+	} else {
+		da.Addr.AddrType = ATYPDomain
+	}
+	return da.Append(dst)
+}
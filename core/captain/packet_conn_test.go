@@ -0,0 +1,88 @@
+package captain
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/pkg/bpool"
+)
+
+func TestPacketConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	pc1 := NewPacketConn(c1, WithBufferPool(bpool.NewPool(1024)))
+	pc2 := NewPacketConn(c2)
+	defer pc1.Close()
+	defer pc2.Close()
+
+	tests := []struct {
+		name string
+		addr net.Addr
+		want string
+		data []byte
+	}{
+		{"ipv4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}, "127.0.0.1:8080", []byte("hello")},
+		{"ipv6", &net.UDPAddr{IP: net.IPv6loopback, Port: 8080}, "[::1]:8080", []byte("world")},
+		{"domain", &AddrSpec{FQDN: "localhost", Port: 53}, "localhost:53", []byte{1, 2, 3}},
+		{"tcp addr", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}, "10.0.0.1:80", make([]byte, 2000)},
+		{"empty", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, "127.0.0.1:1", []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := make(chan int, 1)
+			go func() {
+				n, err := pc1.WriteTo(tt.data, tt.addr)
+				assert.NoError(t, err)
+				written <- n
+			}()
+			buf := make([]byte, 4096)
+			n, addr, err := pc2.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, len(tt.data), <-written)
+			require.Equal(t, tt.want, addr.String())
+			require.Equal(t, "udp", addr.Network())
+			require.Equal(t, tt.data, buf[:n])
+		})
+	}
+
+	t.Run("truncate", func(t *testing.T) {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+		go func() {
+			pc1.WriteTo([]byte("hello world"), addr) // nolint: errcheck
+			pc1.WriteTo([]byte("next"), addr)        // nolint: errcheck
+		}()
+		buf := make([]byte, 5)
+		n, _, err := pc2.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+		n, _, err = pc2.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "next", string(buf[:n]))
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := pc1.WriteTo(make([]byte, MaxDataLen+1), &net.UDPAddr{})
+		require.Equal(t, ErrDataTooLarge, err)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		require.NoError(t, pc2.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+		_, _, err := pc2.ReadFrom(make([]byte, 10))
+		require.Error(t, err)
+		require.NoError(t, pc2.SetReadDeadline(time.Time{}))
+	})
+}
+
+func TestPacketConn_WriteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	pc := NewPacketConn(c1, WithWriteTimeout(time.Millisecond*50))
+	defer pc.Close()
+	defer c2.Close()
+
+	// 对端不读取, 写应超时返回而非一直阻塞
+	_, err := pc.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080})
+	require.Error(t, err)
+}
@@ -41,113 +41,136 @@ func NewStreamDatagram(destAddr string, data []byte) (da StreamDatagram, err err
 	return
 }
 
+// maxStreamHeaderLen max length of stream datagram header
+const maxStreamHeaderLen = 2 + 1 + math.MaxUint8 + 2 + 3
+
 // ParseStreamDatagram parse datagram from stream
 func ParseStreamDatagram(r io.Reader) (da Datagram, err error) {
-	tmp := []byte{0, 0}
-	// ignore RSV and get Address type
-	if _, err = io.ReadFull(r, tmp); err != nil {
+	buf := make([]byte, maxStreamHeaderLen)
+	length, err := parseStreamHeader(r, &da, buf)
+	if err != nil {
 		return
 	}
-	da.Reserved, da.Addr.AddrType = tmp[0], tmp[1]
+	// detach from buf
+	if da.Addr.AddrType == ATYPIPv4 {
+		da.Addr.IP = net.IPv4(da.Addr.IP[0], da.Addr.IP[1], da.Addr.IP[2], da.Addr.IP[3])
+	}
+	if da.Addr.AddrType == ATYPDomain {
+		da.Addr.FQDN = string(buf[3 : 3+len(da.Addr.FQDN)])
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	da.Data = data
+	return
+}
+
+// ParseStreamDatagramInto parse datagram from stream into da, the whole datagram is read
+// into buf(such as buffer from bpool) without allocation, the IP, FQDN and Data of da
+// reference buf, so buf should not be modified while da in use.
+// it returns io.ErrShortBuffer if buf is not large enough to hold the datagram.
+func ParseStreamDatagramInto(r io.Reader, da *Datagram, buf []byte) error {
+	if len(buf) < maxStreamHeaderLen {
+		return io.ErrShortBuffer
+	}
+	length, err := parseStreamHeader(r, da, buf)
+	if err != nil {
+		return err
+	}
+	pos := 2 + addrLen(&da.Addr)
+	if len(buf)-pos < length {
+		return io.ErrShortBuffer
+	}
+	da.Data = buf[pos : pos+length]
+	_, err = io.ReadFull(r, da.Data)
+	return err
+}
+
+// parseStreamHeader parse stream datagram header into da which reference buf,
+// buf should be at least maxStreamHeaderLen, returns the data length.
+func parseStreamHeader(r io.Reader, da *Datagram, buf []byte) (int, error) {
+	// ignore RSV and get Address type
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return 0, err
+	}
+	da.Reserved, da.Addr.AddrType = buf[0], buf[1]
+	da.Addr.IP, da.Addr.FQDN, da.Data = nil, "", nil
 
+	pos := 2
 	switch da.Addr.AddrType {
-	case ATYPIPv4:
-		ip := make([]byte, net.IPv4len+2)
-		// get IPv4 and port
-		if _, err = io.ReadFull(r, ip); err != nil {
-			return
+	case ATYPIPv4, ATYPIPv6:
+		ipLen := net.IPv4len
+		if da.Addr.AddrType == ATYPIPv6 {
+			ipLen = net.IPv6len
 		}
-		da.Addr.IP = net.IPv4(ip[0], ip[1], ip[2], ip[3])
-		da.Addr.Port = int(binary.BigEndian.Uint16((ip[net.IPv4len:])))
-	case ATYPIPv6:
-		ip := make([]byte, net.IPv6len+2)
-		// get IPv6 and port
-		if _, err = io.ReadFull(r, ip); err != nil {
-			return
+		// get IP and port
+		if _, err := io.ReadFull(r, buf[pos:pos+ipLen+2]); err != nil {
+			return 0, err
 		}
-		da.Addr.IP = ip[:net.IPv6len]
-		da.Addr.Port = int(binary.BigEndian.Uint16(ip[net.IPv6len:]))
+		da.Addr.IP = buf[pos : pos+ipLen]
+		pos += ipLen
 	case ATYPDomain:
-		if _, err = io.ReadFull(r, tmp[:1]); err != nil {
-			return
+		if _, err := io.ReadFull(r, buf[pos:pos+1]); err != nil {
+			return 0, err
 		}
-		addrLen := int(tmp[0])
-		fqdn := make([]byte, addrLen+2)
+		addrLen := int(buf[pos])
+		pos++
 		// get FQDN and port
-		if _, err = io.ReadFull(r, fqdn); err != nil {
-			return
+		if _, err := io.ReadFull(r, buf[pos:pos+addrLen+2]); err != nil {
+			return 0, err
 		}
-		da.Addr.FQDN = string(fqdn[:addrLen])
-		da.Addr.Port = int(binary.BigEndian.Uint16(fqdn[addrLen:]))
+		da.Addr.FQDN = bytesconv.Bytes2Str(buf[pos : pos+addrLen])
+		pos += addrLen
 	default:
-		err = ErrUnrecognizedAddrType
-		return
+		return 0, ErrUnrecognizedAddrType
 	}
+	da.Addr.Port = int(binary.BigEndian.Uint16(buf[pos:]))
+	pos += 2
 
 	// data len
-	var length int
-	length, err = ParseDataLen(r)
-	if err != nil {
-		return
-	}
-	data := make([]byte, length)
-	if _, err = io.ReadFull(r, data); err != nil {
-		return
-	}
-	da.Data = data
-	return
+	return parseDataLen(r, buf[pos:pos+1])
 }
 
 // Header returns s slice of datagram header except data
 func (sf *StreamDatagram) Header() ([]byte, error) {
-	return sf.values(false)
+	return sf.AppendHeader(make([]byte, 0, sf.headerLen()))
 }
 
 // Bytes datagram to bytes
 func (sf *StreamDatagram) Bytes() ([]byte, error) {
-	return sf.values(true)
+	return sf.Append(make([]byte, 0, sf.headerLen()+len(sf.Data)))
 }
 
-func (sf *StreamDatagram) values(hasData bool) ([]byte, error) {
-	var addr []byte
-
-	length := 4
+// AppendHeader appends the stream datagram header except data to dst and returns the extended buffer.
+func (sf *StreamDatagram) AppendHeader(dst []byte) ([]byte, error) {
 	switch sf.Addr.AddrType {
-	case ATYPIPv4:
-		length += net.IPv4len
-		addr = sf.Addr.IP.To4()
-	case ATYPIPv6:
-		length += net.IPv6len
-		addr = sf.Addr.IP.To16()
-	case ATYPDomain:
-		length += 1 + len(sf.Addr.FQDN)
-		addr = bytesconv.Str2Bytes(sf.Addr.FQDN)
+	case ATYPIPv4, ATYPIPv6, ATYPDomain:
 	default:
 		return nil, fmt.Errorf("invalid address type: %d", sf.Addr.AddrType)
 	}
-
 	ds, n, err := DataLen(len(sf.Data))
 	if err != nil {
 		return nil, err
 	}
-	length += n
+	dst = appendAddr(append(dst, sf.Reserved, sf.Addr.AddrType), &sf.Addr)
+	return append(dst, ds[:n]...), nil
+}
 
-	var bs []byte
-	if hasData {
-		bs = make([]byte, 0, length+len(sf.Data))
-	} else {
-		bs = make([]byte, 0, length)
+// Append appends the stream datagram to dst and returns the extended buffer.
+func (sf *StreamDatagram) Append(dst []byte) ([]byte, error) {
+	dst, err := sf.AppendHeader(dst)
+	if err != nil {
+		return nil, err
 	}
+	return append(dst, sf.Data...), nil
+}
 
-	bs = append(bs, sf.Reserved, sf.Addr.AddrType)
-	if sf.Addr.AddrType == ATYPDomain {
-		bs = append(bs, byte(len(sf.Addr.FQDN)))
-	}
-	bs = append(bs, addr...)
-	bs = append(bs, byte(sf.Addr.Port>>8), byte(sf.Addr.Port))
-	bs = append(bs, ds[:n]...)
-	if hasData {
-		bs = append(bs, sf.Data...)
+func (sf *StreamDatagram) headerLen() int {
+	switch sf.Addr.AddrType {
+	case ATYPIPv4, ATYPIPv6, ATYPDomain:
+		return 2 + addrLen(&sf.Addr) + 3
+	default:
+		return 0
 	}
-	return bs, nil
 }
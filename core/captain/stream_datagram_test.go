@@ -167,3 +167,92 @@ func TestParseDatagramFromReader(t *testing.T) {
 		})
 	}
 }
+
+var benchStreamDatagrams = []struct {
+	name string
+	addr string
+}{
+	{"ipv4", "127.0.0.1:8080"},
+	{"ipv6", "[::1]:8080"},
+	{"domain", "localhost:8080"},
+}
+
+func TestParseStreamDatagramInto(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3}, 100)
+	for _, tt := range benchStreamDatagrams {
+		t.Run(tt.name, func(t *testing.T) {
+			sd, err := NewStreamDatagram(tt.addr, data)
+			require.NoError(t, err)
+			b, err := sd.Append(nil)
+			require.NoError(t, err)
+
+			var da Datagram
+			buf := make([]byte, 1024)
+			r := bytes.NewReader(b)
+			require.NoError(t, ParseStreamDatagramInto(r, &da, buf))
+			require.Equal(t, tt.addr, da.Addr.String())
+			require.Equal(t, data, da.Data)
+
+			allocs := testing.AllocsPerRun(100, func() {
+				r.Reset(b)
+				ParseStreamDatagramInto(r, &da, buf) // nolint: errcheck
+				sd.Append(buf[:0])                   // nolint: errcheck
+			})
+			require.Zero(t, allocs)
+
+			r.Reset(b)
+			require.Equal(t, io.ErrShortBuffer, ParseStreamDatagramInto(r, &da, buf[:maxStreamHeaderLen]))
+		})
+	}
+}
+
+func BenchmarkParseStreamDatagram(b *testing.B) {
+	data := bytes.Repeat([]byte{1}, 512)
+	for _, tt := range benchStreamDatagrams {
+		sd, err := NewStreamDatagram(tt.addr, data)
+		require.NoError(b, err)
+		raw, err := sd.Bytes()
+		require.NoError(b, err)
+
+		b.Run(tt.name, func(b *testing.B) {
+			r := bytes.NewReader(raw)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(raw)
+				ParseStreamDatagram(r) // nolint: errcheck
+			}
+		})
+		b.Run(tt.name+" into", func(b *testing.B) {
+			var da Datagram
+			buf := make([]byte, 2048)
+			r := bytes.NewReader(raw)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(raw)
+				ParseStreamDatagramInto(r, &da, buf) // nolint: errcheck
+			}
+		})
+	}
+}
+
+func BenchmarkStreamDatagramBytes(b *testing.B) {
+	data := bytes.Repeat([]byte{1}, 512)
+	for _, tt := range benchStreamDatagrams {
+		sd, err := NewStreamDatagram(tt.addr, data)
+		require.NoError(b, err)
+
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sd.Bytes() // nolint: errcheck
+			}
+		})
+		b.Run(tt.name+" append", func(b *testing.B) {
+			buf := make([]byte, 0, 2048)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sd.Append(buf[:0]) // nolint: errcheck
+			}
+		})
+	}
+}
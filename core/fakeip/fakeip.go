@@ -0,0 +1,553 @@
+// Package fakeip fake-ip 地址池, 为域名分配保留网段中的虚假ipv4地址, 维护 fake-ip <--> 域名 的双向映射,
+// 透明代理可据此将目标 fake-ip 还原为原始域名, 按域名进行过滤和父级选择.
+// 映射表可持久化到文件, 分配方(dns服务)追加写入, 其它进程以只读方式打开同一文件, 每次查询时若文件有变化则重新加载,
+// 双方查询命中时按间隔追加映射的最近使用时间, 分配方回收映射前合并只读方追加的记录
+package fakeip
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认配置
+const (
+	DefaultCIDR   = "198.18.0.0/15" // 默认地址池, RFC 2544 保留的基准测试网段
+	DefaultExpire = time.Hour * 24  // 映射默认闲置过期时间
+)
+
+// compactThreshold 追加写入的记录数超过 映射数+compactThreshold 时重写文件
+const compactThreshold = 1024
+
+// touchInterval 查询命中时追加最近使用时间的最小间隔, 单位s
+const touchInterval = 60
+
+// headerPrefix 持久化文件头, 记录地址池网段
+const headerPrefix = "# fakeip "
+
+// ErrReadOnly 只读地址池不能分配地址
+var ErrReadOnly = errors.New("fakeip: read only pool")
+
+// Pool fake-ip 地址池
+type Pool struct {
+	mu       sync.Mutex
+	ipNet    *net.IPNet
+	first    uint32        // 第一个可分配地址
+	last     uint32        // 最后一个可分配地址
+	cursor   uint32        // 下一个尝试分配的地址
+	expire   time.Duration // 映射闲置过期时间, <= 0 不过期
+	filename string        // 持久化文件, 为空不持久化
+	readOnly bool          // 只读, 由 Open 打开
+
+	ll       *list.List               // 映射按最近使用排序, front为最近使用
+	byIP     map[uint32]*list.Element // fake-ip --> entry
+	byDomain map[string]*list.Element // domain --> entry
+
+	file     *os.File  // 分配方追加写入的文件
+	appended int       // 追加写入的记录数
+	offset   int64     // 分配方已知的文件大小, 之后的内容为只读方追加的记录
+	modTime  time.Time // 只读方已加载文件的修改时间
+	size     int64     // 只读方已加载文件的大小
+}
+
+type entry struct {
+	ip       uint32
+	domain   string
+	lastUsed int64 // 最近使用时间, unix时间
+	touched  int64 // 最近写入文件的使用时间
+}
+
+// Option 配置选项
+type Option func(*Pool)
+
+// WithExpire 设置映射闲置过期时间, 超过该时间未被查询的映射被回收, <= 0 不过期, 默认 DefaultExpire
+func WithExpire(d time.Duration) Option {
+	return func(p *Pool) {
+		p.expire = d
+	}
+}
+
+// WithFile 设置持久化文件, 启动时加载未过期的映射, 新分配的映射追加写入
+func WithFile(filename string) Option {
+	return func(p *Pool) {
+		p.filename = filename
+	}
+}
+
+// New 创建可分配地址的地址池, cidr为空时使用 DefaultCIDR, 仅支持ipv4网段
+func New(cidr string, opts ...Option) (*Pool, error) {
+	if cidr == "" {
+		cidr = DefaultCIDR
+	}
+	p := &Pool{expire: DefaultExpire}
+	if err := p.setNet(cidr); err != nil {
+		return nil, err
+	}
+	p.reset()
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.filename != "" {
+		f, err := os.Open(p.filename)
+		switch {
+		case err == nil:
+			err = p.load(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		p.expireLocked(time.Now().Unix())
+		if err = p.saveLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Open 以只读方式打开分配方的持久化文件, 文件不存在时为空, 查询时若文件有变化将重新加载,
+// 只读方不分配地址, 仅在查询命中时向文件追加映射的最近使用时间
+func Open(filename string) (*Pool, error) {
+	p := &Pool{filename: filename, readOnly: true}
+	p.reset()
+	if err := p.refreshLocked(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CIDR 地址池网段
+func (sf *Pool) CIDR() string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.ipNet == nil {
+		return ""
+	}
+	return sf.ipNet.String()
+}
+
+// Len 映射数
+func (sf *Pool) Len() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.ll.Len()
+}
+
+// Contains ip是否属于地址池
+func (sf *Pool) Contains(ip net.IP) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.ipNet == nil && sf.readOnly {
+		sf.refreshLocked() // nolint: errcheck
+	}
+	return sf.ipNet != nil && sf.ipNet.Contains(ip)
+}
+
+// IPOf 获取域名的fake-ip, 不存在时分配, 地址池已满时回收最久未使用的映射
+func (sf *Pool) IPOf(domain string) (net.IP, error) {
+	if sf.readOnly {
+		return nil, ErrReadOnly
+	}
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return nil, errors.New("fakeip: empty domain")
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	now := time.Now().Unix()
+	if el, ok := sf.byDomain[domain]; ok {
+		sf.ll.MoveToFront(el)
+		e := el.Value.(*entry)
+		e.lastUsed = now
+		if now-e.touched < sf.touchInterval() {
+			return uint2ip(e.ip), nil
+		}
+		return uint2ip(e.ip), sf.appendLocked(e)
+	}
+
+	// 回收前合并只读方的使用记录, 避免回收仍在使用的映射
+	sf.mergeLocked() // nolint: errcheck
+	sf.expireLocked(now)
+	if uint32(sf.ll.Len()) > sf.last-sf.first {
+		sf.removeLocked(sf.ll.Back())
+	}
+	ip := sf.cursor
+	for {
+		if _, ok := sf.byIP[ip]; !ok {
+			break
+		}
+		if ip++; ip > sf.last {
+			ip = sf.first
+		}
+	}
+	if sf.cursor = ip + 1; sf.cursor > sf.last {
+		sf.cursor = sf.first
+	}
+	e := &entry{ip, domain, now, now}
+	sf.insertLocked(e)
+	return uint2ip(ip), sf.appendLocked(e)
+}
+
+// DomainOf 获取fake-ip对应的域名
+func (sf *Pool) DomainOf(ip net.IP) (string, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", false
+	}
+	n := binary.BigEndian.Uint32(ip4)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.readOnly {
+		// 分配方可能已回收该地址并分配给其它域名, 每次查询都检查文件变化
+		sf.refreshLocked() // nolint: errcheck
+	}
+	el, ok := sf.byIP[n]
+	if !ok {
+		return "", false
+	}
+	sf.ll.MoveToFront(el)
+	e := el.Value.(*entry)
+	e.lastUsed = time.Now().Unix()
+	if e.lastUsed-e.touched >= sf.touchInterval() {
+		if sf.readOnly {
+			sf.touchLocked(e) // nolint: errcheck
+		} else {
+			sf.appendLocked(e) // nolint: errcheck
+		}
+	}
+	return e.domain, true
+}
+
+// Restore 将地址中的fake-ip还原为域名, 格式 ip:port --> domain:port, 非fake-ip或无映射时返回原地址
+func (sf *Pool) Restore(address string) string {
+	if sf == nil {
+		return address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !sf.Contains(ip) {
+		return address
+	}
+	if domain, ok := sf.DomainOf(ip); ok {
+		return net.JoinHostPort(domain, port)
+	}
+	return address
+}
+
+// Save 重写持久化文件, 只读地址池或未设置文件时无操作
+func (sf *Pool) Save() error {
+	if sf.readOnly || sf.filename == "" {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.saveLocked()
+}
+
+// Close 保存并关闭持久化文件
+func (sf *Pool) Close() error {
+	if sf.readOnly || sf.filename == "" {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	err := sf.saveLocked()
+	if sf.file != nil {
+		sf.file.Close()
+		sf.file = nil
+	}
+	return err
+}
+
+func (sf *Pool) setNet(cidr string) error {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return fmt.Errorf("fakeip: %w", err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != net.IPv4len*8 || ones > 30 {
+		return fmt.Errorf("fakeip: cidr %s should be ipv4 and prefix length not greater than 30", cidr)
+	}
+	network := binary.BigEndian.Uint32(ipNet.IP.To4())
+	sf.ipNet = ipNet
+	sf.first = network + 1                             // 跳过网络地址
+	sf.last = (network | (1<<uint(bits-ones) - 1)) - 1 // 跳过广播地址
+	sf.cursor = sf.first
+	return nil
+}
+
+func (sf *Pool) reset() {
+	sf.ll = list.New()
+	sf.byIP = make(map[uint32]*list.Element)
+	sf.byDomain = make(map[string]*list.Element)
+}
+
+func (sf *Pool) insertLocked(e *entry) {
+	if old, ok := sf.byIP[e.ip]; ok {
+		sf.removeLocked(old)
+	}
+	if old, ok := sf.byDomain[e.domain]; ok {
+		sf.removeLocked(old)
+	}
+	el := sf.ll.PushFront(e)
+	sf.byIP[e.ip] = el
+	sf.byDomain[e.domain] = el
+}
+
+func (sf *Pool) removeLocked(el *list.Element) {
+	e := sf.ll.Remove(el).(*entry)
+	delete(sf.byIP, e.ip)
+	delete(sf.byDomain, e.domain)
+}
+
+// expireLocked 回收闲置过期的映射
+func (sf *Pool) expireLocked(now int64) {
+	if sf.expire <= 0 {
+		return
+	}
+	deadline := now - int64(sf.expire/time.Second)
+	for el := sf.ll.Back(); el != nil && el.Value.(*entry).lastUsed < deadline; el = sf.ll.Back() {
+		sf.removeLocked(el)
+	}
+}
+
+// load 加载持久化文件, 后出现的记录覆盖先前相同ip或域名的记录, 忽略无效行和不属于地址池的记录
+func (sf *Pool) load(r io.Reader) error {
+	var entries []*entry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, headerPrefix) {
+			if sf.ipNet == nil {
+				if err := sf.setNet(strings.TrimPrefix(line, headerPrefix)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if e := parseEntry(line); e != nil {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// 按最近使用时间排序插入, 时间相同时保持文件中的顺序
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].lastUsed < entries[j].lastUsed })
+	for _, e := range entries {
+		if sf.ipNet != nil && e.ip >= sf.first && e.ip <= sf.last {
+			sf.insertLocked(e)
+		}
+	}
+	if el := sf.ll.Front(); el != nil && !sf.readOnly {
+		if sf.cursor = el.Value.(*entry).ip + 1; sf.cursor > sf.last {
+			sf.cursor = sf.first
+		}
+	}
+	return nil
+}
+
+// refreshLocked 只读方在文件变化时重新加载
+func (sf *Pool) refreshLocked() error {
+	info, err := os.Stat(sf.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.ModTime().Equal(sf.modTime) && info.Size() == sf.size {
+		return nil
+	}
+	f, err := os.Open(sf.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sf.ipNet = nil
+	sf.reset()
+	if err = sf.load(f); err != nil {
+		return err
+	}
+	sf.modTime, sf.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// appendLocked 追加写入新分配的映射或映射的最近使用时间, 记录过多时重写文件
+func (sf *Pool) appendLocked(e *entry) error {
+	if sf.file == nil {
+		return nil
+	}
+	e.touched = e.lastUsed
+	if sf.appended >= sf.ll.Len()+compactThreshold {
+		return sf.saveLocked()
+	}
+	sf.appended++
+	n, err := fmt.Fprintf(sf.file, "%s %s %d\n", uint2ip(e.ip), e.domain, e.lastUsed)
+	if err != nil {
+		return err
+	}
+	// 期间有只读方追加时不移动偏移, 合并时将重新读取自己追加的记录, 不影响结果
+	if info, err := sf.file.Stat(); err == nil && info.Size() == sf.offset+int64(n) {
+		sf.offset = info.Size()
+	}
+	return nil
+}
+
+// touchLocked 只读方追加映射的最近使用时间, 文件不存在或无写权限时忽略
+func (sf *Pool) touchLocked(e *entry) error {
+	e.touched = e.lastUsed
+	f, err := os.OpenFile(sf.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := fmt.Fprintf(f, "%s %s %d\n", uint2ip(e.ip), e.domain, e.lastUsed)
+	if err != nil {
+		return err
+	}
+	// 仅自己追加时更新已加载的文件信息, 避免重新加载
+	if info, err := f.Stat(); err == nil && info.Size() == sf.size+int64(n) {
+		sf.modTime, sf.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+// mergeLocked 分配方合并只读方追加的最近使用时间, 仅更新仍存在且映射相同的记录
+func (sf *Pool) mergeLocked() error {
+	if sf.file == nil {
+		return nil
+	}
+	info, err := sf.file.Stat()
+	if err != nil || info.Size() <= sf.offset {
+		return err
+	}
+	f, err := os.Open(sf.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, sf.offset, info.Size()-sf.offset))
+	if err != nil {
+		return err
+	}
+	// 只处理完整的行
+	b = b[:bytes.LastIndexByte(b, '\n')+1]
+	sf.offset += int64(len(b))
+	for _, line := range strings.Split(string(b), "\n") {
+		t := parseEntry(line)
+		if t == nil {
+			continue
+		}
+		if el, ok := sf.byIP[t.ip]; ok {
+			if e := el.Value.(*entry); e.domain == t.domain && e.lastUsed < t.lastUsed {
+				e.lastUsed, e.touched = t.lastUsed, t.lastUsed
+				sf.ll.MoveToFront(el)
+			}
+		}
+	}
+	return nil
+}
+
+// touchInterval 查询命中时追加最近使用时间的间隔, 不超过过期时间的1/4
+func (sf *Pool) touchInterval() int64 {
+	interval := int64(touchInterval)
+	if expire := int64(sf.expire / time.Second); expire > 0 && expire/4 < interval {
+		interval = expire / 4
+	}
+	return interval
+}
+
+// saveLocked 按最近使用时间从旧到新重写文件, 然后以追加方式打开
+func (sf *Pool) saveLocked() error {
+	sf.mergeLocked() // nolint: errcheck
+	if sf.file != nil {
+		sf.file.Close()
+		sf.file = nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(sf.filename), filepath.Base(sf.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Chmod(0644) // nolint: errcheck
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintf(w, "%s%s\n", headerPrefix, sf.ipNet) // nolint: errcheck
+	for el := sf.ll.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*entry)
+		fmt.Fprintf(w, "%s %s %d\n", uint2ip(e.ip), e.domain, e.lastUsed) // nolint: errcheck
+		e.touched = e.lastUsed
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), sf.filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	sf.file, err = os.OpenFile(sf.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	sf.appended = 0
+	if err != nil {
+		return err
+	}
+	info, err := sf.file.Stat()
+	if err != nil {
+		return err
+	}
+	sf.offset = info.Size()
+	return nil
+}
+
+// parseEntry 解析记录行 ip domain lastUsed, 无效行返回nil
+func parseEntry(line string) *entry {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil
+	}
+	ip := net.ParseIP(fields[0]).To4()
+	lastUsed, err := strconv.ParseInt(fields[2], 10, 64)
+	if ip == nil || err != nil {
+		return nil
+	}
+	return &entry{binary.BigEndian.Uint32(ip), fields[1], lastUsed, lastUsed}
+}
+
+func uint2ip(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
@@ -0,0 +1,224 @@
+package fakeip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fakeip")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "fakeip")
+}
+
+func TestPool(t *testing.T) {
+	p, err := New("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCIDR, p.CIDR())
+
+	ip1, err := p.IPOf("www.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "198.18.0.1", ip1.String())
+	ip2, err := p.IPOf("example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "198.18.0.2", ip2.String())
+	ip, err := p.IPOf("WWW.Example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ip1, ip)
+	assert.Equal(t, 2, p.Len())
+
+	domain, ok := p.DomainOf(ip2)
+	require.True(t, ok)
+	assert.Equal(t, "example.org", domain)
+	_, ok = p.DomainOf(net.ParseIP("198.18.0.3"))
+	assert.False(t, ok)
+
+	assert.True(t, p.Contains(net.ParseIP("198.19.255.254")))
+	assert.False(t, p.Contains(net.ParseIP("198.20.0.1")))
+	assert.Equal(t, "www.example.com:443", p.Restore("198.18.0.1:443"))
+	assert.Equal(t, "198.18.0.3:443", p.Restore("198.18.0.3:443"))
+	assert.Equal(t, "10.0.0.1:443", p.Restore("10.0.0.1:443"))
+	assert.Equal(t, "10.0.0.1:443", (*Pool)(nil).Restore("10.0.0.1:443"))
+
+	_, err = p.IPOf("")
+	assert.Error(t, err)
+}
+
+func TestPool_Invalid(t *testing.T) {
+	for _, cidr := range []string{"198.18.0.0", "fd00::/64", "198.18.0.0/31"} {
+		_, err := New(cidr)
+		assert.Error(t, err, cidr)
+	}
+}
+
+func TestPool_Recycle(t *testing.T) {
+	// 可分配地址: 10.0.0.1, 10.0.0.2
+	p, err := New("10.0.0.0/30")
+	require.NoError(t, err)
+	ipA, _ := p.IPOf("a.com")
+	ipB, _ := p.IPOf("b.com")
+	_, err = p.IPOf("a.com") // b.com 成为最久未使用
+	require.NoError(t, err)
+
+	ipC, err := p.IPOf("c.com")
+	require.NoError(t, err)
+	assert.Equal(t, ipB, ipC)
+	domain, ok := p.DomainOf(ipA)
+	require.True(t, ok)
+	assert.Equal(t, "a.com", domain)
+	domain, ok = p.DomainOf(ipC)
+	require.True(t, ok)
+	assert.Equal(t, "c.com", domain)
+	assert.Equal(t, 2, p.Len())
+}
+
+func TestPool_Expire(t *testing.T) {
+	p, err := New("10.0.0.0/24", WithExpire(time.Minute))
+	require.NoError(t, err)
+	ipA, _ := p.IPOf("a.com")
+	p.byDomain["a.com"].Value.(*entry).lastUsed -= 61
+
+	_, err = p.IPOf("b.com")
+	require.NoError(t, err)
+	_, ok := p.DomainOf(ipA)
+	assert.False(t, ok)
+	assert.Equal(t, 1, p.Len())
+}
+
+func TestPool_Persist(t *testing.T) {
+	filename := tempFile(t)
+
+	p, err := New("10.0.0.0/24", WithFile(filename))
+	require.NoError(t, err)
+	ipA, err := p.IPOf("a.com")
+	require.NoError(t, err)
+
+	// 只读方能看到分配方之后追加的映射
+	r, err := Open(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/24", r.CIDR())
+	assert.Equal(t, "a.com:80", r.Restore(net.JoinHostPort(ipA.String(), "80")))
+	ipB, err := p.IPOf("b.com")
+	require.NoError(t, err)
+	assert.Equal(t, "b.com:80", r.Restore(net.JoinHostPort(ipB.String(), "80")))
+	_, err = r.IPOf("c.com")
+	assert.Equal(t, ErrReadOnly, err)
+	require.NoError(t, p.Close())
+
+	// 重启后恢复映射, 且不再分配已使用的地址
+	p, err = New("10.0.0.0/24", WithFile(filename))
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 2, p.Len())
+	ip, err := p.IPOf("a.com")
+	require.NoError(t, err)
+	assert.Equal(t, ipA, ip)
+	ipC, err := p.IPOf("c.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, ipA, ipC)
+	assert.NotEqual(t, ipB, ipC)
+}
+
+func TestPool_PersistExpire(t *testing.T) {
+	filename := tempFile(t)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	content := "# fakeip 10.0.0.0/24\n" +
+		"10.0.0.1 a.com " + old + "\n" +
+		"10.0.0.2 b.com " + now + "\n" +
+		"10.0.0.2 c.com " + now + "\n" + // 后出现的记录覆盖相同ip的记录
+		"192.168.0.1 d.com " + now + "\n" + // 不属于地址池
+		"invalid line\n"
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+
+	p, err := New("10.0.0.0/24", WithFile(filename), WithExpire(time.Minute))
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 1, p.Len())
+	domain, ok := p.DomainOf(net.ParseIP("10.0.0.2"))
+	require.True(t, ok)
+	assert.Equal(t, "c.com", domain)
+}
+
+func TestPool_PersistReassign(t *testing.T) {
+	filename := tempFile(t)
+
+	// 可分配地址: 10.0.0.1, 10.0.0.2
+	p, err := New("10.0.0.0/30", WithFile(filename))
+	require.NoError(t, err)
+	defer p.Close()
+	ipA, _ := p.IPOf("a.com")
+	ipB, _ := p.IPOf("b.com")
+
+	r, err := Open(filename)
+	require.NoError(t, err)
+	domain, ok := r.DomainOf(ipA)
+	require.True(t, ok)
+	assert.Equal(t, "a.com", domain)
+
+	// 地址回收后, 只读方命中旧映射时也能看到新映射
+	ipC, err := p.IPOf("c.com")
+	require.NoError(t, err)
+	require.Equal(t, ipA, ipC)
+	domain, ok = r.DomainOf(ipA)
+	require.True(t, ok)
+	assert.Equal(t, "c.com", domain)
+	domain, ok = r.DomainOf(ipB)
+	require.True(t, ok)
+	assert.Equal(t, "b.com", domain)
+}
+
+func TestPool_PersistTouch(t *testing.T) {
+	filename := tempFile(t)
+	old := strconv.FormatInt(time.Now().Add(-time.Second*200).Unix(), 10)
+	content := "# fakeip 10.0.0.0/24\n" +
+		"10.0.0.1 a.com " + old + "\n" +
+		"10.0.0.2 b.com " + old + "\n"
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+
+	p, err := New("10.0.0.0/24", WithFile(filename), WithExpire(time.Minute*5))
+	require.NoError(t, err)
+	defer p.Close()
+	r, err := Open(filename)
+	require.NoError(t, err)
+
+	// 分配方命中及只读方命中均追加最近使用时间
+	ipA, err := p.IPOf("a.com")
+	require.NoError(t, err)
+	ipB := net.ParseIP("10.0.0.2")
+	_, ok := r.DomainOf(ipB)
+	require.True(t, ok)
+
+	// 分配方合并只读方的记录, b.com 不会过期
+	p.byDomain["b.com"].Value.(*entry).lastUsed -= 120
+	_, err = p.IPOf("c.com")
+	require.NoError(t, err)
+	domain, ok := p.DomainOf(ipB)
+	require.True(t, ok)
+	assert.Equal(t, "b.com", domain)
+
+	// 未正常关闭时, 重启后映射仍未过期
+	p2, err := New("10.0.0.0/24", WithFile(filename), WithExpire(time.Minute*5))
+	require.NoError(t, err)
+	assert.Equal(t, 3, p2.Len())
+	domain, ok = p2.DomainOf(ipA)
+	require.True(t, ok)
+	assert.Equal(t, "a.com", domain)
+	require.NoError(t, p2.Close())
+}
+
+func TestOpen_NotExist(t *testing.T) {
+	r, err := Open(tempFile(t))
+	require.NoError(t, err)
+	assert.False(t, r.Contains(net.ParseIP("198.18.0.1")))
+	assert.Equal(t, "198.18.0.1:80", r.Restore("198.18.0.1:80"))
+}
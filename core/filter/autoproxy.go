@@ -0,0 +1,265 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// AutoProxyList AutoProxy/Adblock格式(如gfwlist)规则解析结果
+type AutoProxyList struct {
+	Proxies     []string   // 代理域名, 后缀匹配
+	Directs     []string   // 例外(@@)域名, 后缀匹配, 优先于代理表和直连表
+	URLRules    []*URLRule // url规则, 仅对明文http请求有效
+	Unsupported []string   // 不支持的行, 格式: line N: TEXT
+}
+
+// Len 有效条目数
+func (sf *AutoProxyList) Len() int {
+	return len(sf.Proxies) + len(sf.Directs) + len(sf.URLRules)
+}
+
+// URLRule url规则, 由 |http:// 前缀, 关键字, 通配符或 /regex/ 规则转换而来
+type URLRule struct {
+	Pattern   string // 原始规则, 不含@@
+	Exception bool   // 例外规则(@@), 匹配时直连
+	regex     *regexp.Regexp
+}
+
+// Match 是否匹配url
+func (sf *URLRule) Match(rawURL string) bool {
+	return sf.regex.MatchString(rawURL)
+}
+
+// ParseAutoProxy 解析AutoProxy/Adblock格式规则, 内容为base64编码时自动解码.
+// 支持:
+// 	||domain          域名及子域名
+// 	|http://prefix    url前缀, 仅明文http有效
+// 	|https://domain   域名
+// 	/regex/           url正则, 仅明文http有效
+// 	domain, .domain   域名
+// 	keyword           url关键字, 支持通配符*, 仅明文http有效
+// 	@@RULE            例外规则
+// 	!comment, [header] 注释和头部
+// 不支持元素隐藏(##)和规则选项($), 记录在 Unsupported 中
+func ParseAutoProxy(rd io.Reader) (*AutoProxyList, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &AutoProxyList{}
+	scanner := bufio.NewScanner(bytes.NewReader(decodeAutoProxy(data)))
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '!' || text[0] == '[' {
+			continue
+		}
+		if !list.add(text) {
+			list.Unsupported = append(list.Unsupported, fmt.Sprintf("line %d: %s", line, text))
+		}
+	}
+	return list, scanner.Err()
+}
+
+// add 解析一条规则, 不支持时返回false
+func (sf *AutoProxyList) add(text string) bool {
+	if strings.Contains(text, "##") || strings.Contains(text, "#@#") || strings.Contains(text, "#?#") {
+		return false
+	}
+	pattern := text
+	exception := strings.HasPrefix(pattern, "@@")
+	if exception {
+		pattern = pattern[2:]
+	}
+	if pattern == "" || strings.Contains(pattern, "$") {
+		return false
+	}
+
+	addDomain := func(domain string) {
+		domain = strings.ToLower(domain)
+		if exception {
+			sf.Directs = append(sf.Directs, domain)
+		} else {
+			sf.Proxies = append(sf.Proxies, domain)
+		}
+	}
+	addURL := func(expr string) bool {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false
+		}
+		sf.URLRules = append(sf.URLRules, &URLRule{pattern, exception, re})
+		return true
+	}
+
+	switch {
+	case len(pattern) > 2 && pattern[0] == '/' && pattern[len(pattern)-1] == '/':
+		return addURL(pattern[1 : len(pattern)-1])
+	case strings.HasPrefix(pattern, "||"):
+		rest := strings.TrimPrefix(pattern[2:], "*.")
+		host, path := splitURLHost(rest)
+		if isDomainLike(host) && (path == "" || path == "/" || path == "^") {
+			addDomain(host)
+			return true
+		}
+		return addURL(`(?i)^[\w\-]+:/+(?:[^/]+\.)?` + wildcard2Regexp(rest))
+	case strings.HasPrefix(pattern, "|https://"):
+		host, path := splitURLHost(pattern[len("|https://"):])
+		if isDomainLike(host) && (path == "" || path == "/") {
+			addDomain(host)
+			return true
+		}
+		return false
+	case pattern[0] == '|':
+		expr := "(?i)^" + wildcard2Regexp(strings.TrimSuffix(pattern[1:], "|"))
+		if strings.HasSuffix(pattern, "|") {
+			expr += "$"
+		}
+		return addURL(expr)
+	case isDomainLike(strings.TrimPrefix(pattern, ".")):
+		addDomain(strings.TrimPrefix(pattern, "."))
+		return true
+	default:
+		return addURL("(?i)" + wildcard2Regexp(pattern))
+	}
+}
+
+// LoadAutoProxyFile 加载AutoProxy/Adblock格式规则文件(如gfwlist), 追加到代理表, 例外表和url规则,
+// 文件不存在时不加载, 返回有效条目数和不支持的行
+func (sf *Filter) LoadAutoProxyFile(filename string) (int, []string, error) {
+	list, err := parseAutoProxyFile(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	sf.AddAutoProxy(list)
+	return list.Len(), list.Unsupported, nil
+}
+
+// AddAutoProxy 添加AutoProxy规则, 代理域名加入代理表, 例外域名加入例外表, url规则追加到已有url规则之后
+func (sf *Filter) AddAutoProxy(list *AutoProxyList) {
+	sf.proxies.Add(list.Proxies...)
+	sf.exceptions.Add(list.Directs...)
+	if len(list.URLRules) > 0 {
+		rules := append(append([]*URLRule{}, sf.URLRules()...), list.URLRules...)
+		sf.urlRules.Store(rules)
+	}
+}
+
+// URLRules 获取url规则
+func (sf *Filter) URLRules() []*URLRule {
+	rules, _ := sf.urlRules.Load().([]*URLRule)
+	return rules
+}
+
+// MatchURL 匹配明文http请求的url规则, 例外表中的域名和例外规则优先, 返回是否需要代理, 是否匹配.
+// 未匹配时调用方应回退到 IsProxy
+func (sf *Filter) MatchURL(rawURL string) (proxy, ok bool) {
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" &&
+		sf.exceptions.Match(strings.ToLower(u.Hostname())) {
+		return false, true
+	}
+	rules := sf.URLRules()
+	for _, r := range rules {
+		if r.Exception && r.Match(rawURL) {
+			return false, true
+		}
+	}
+	for _, r := range rules {
+		if !r.Exception && r.Match(rawURL) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// parseAutoProxyFile 解析AutoProxy文件, 文件不存在时返回空列表
+func parseAutoProxyFile(filename string) (*AutoProxyList, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &AutoProxyList{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAutoProxy(f)
+}
+
+// decodeAutoProxy 内容为base64编码(如gfwlist)时解码, 否则原样返回.
+// 解码结果以 [AutoProxy 开头, 或原内容为单行或等长折行的base64文本时才解码, 避免将多行关键字规则误作base64
+func decodeAutoProxy(data []byte) []byte {
+	lines := strings.Fields(string(data))
+	s := strings.Join(lines, "")
+	if s == "" {
+		return data
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	if err != nil || !utf8.Valid(b) {
+		return data
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("[AutoProxy")) && !isWrappedBase64(lines) {
+		return data
+	}
+	return b
+}
+
+// isWrappedBase64 是否为单行或按固定长度(4的倍数)折行的base64文本, 最后一行可以较短
+func isWrappedBase64(lines []string) bool {
+	for _, line := range lines[:len(lines)-1] {
+		if len(line) != len(lines[0]) || len(line)%4 != 0 {
+			return false
+		}
+	}
+	return len(lines[len(lines)-1]) <= len(lines[0])
+}
+
+// splitURLHost 拆分 host[:port][/path] 为 host 和 剩余部分
+func splitURLHost(s string) (host, rest string) {
+	i := strings.IndexAny(s, "/^:|")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// isDomainLike 是否为域名格式, 至少两级
+func isDomainLike(s string) bool {
+	if s == "" || !strings.Contains(s, ".") || s[0] == '.' || s[len(s)-1] == '.' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return !strings.Contains(s, "..")
+}
+
+// wildcard2Regexp 通配符规则转正则, * 匹配任意字符, ^ 匹配分隔符或结尾
+func wildcard2Regexp(pattern string) string {
+	var b strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '^':
+			b.WriteString(`(?:[^\w\-.%]|$)`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
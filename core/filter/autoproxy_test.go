@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAutoProxy = `[AutoProxy 0.2.9]
+! Checksum: xxx
+! comment
+||google.com
+||blogspot.com^
+||*.twimg.com/
+|https://www.example.net
+|http://example.org/blocked
+.bbc.co.uk
+wikipedia.org
+||example.com/path*
+|http://*.foo.com/bar|
+/^https?:\/\/[^\/]+blogspot\.(.*)/
+keyword*blocked
+@@||cn.google.com
+@@|http://example.org/blocked/allowed
+example.com##.ads
+||ads.example.com^$third-party
+|https://www.example.net/path
+`
+
+func TestParseAutoProxy(t *testing.T) {
+	list, err := ParseAutoProxy(strings.NewReader(testAutoProxy))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"google.com", "blogspot.com", "twimg.com", "www.example.net", "bbc.co.uk", "wikipedia.org"}, list.Proxies)
+	assert.Equal(t, []string{"cn.google.com"}, list.Directs)
+	require.Len(t, list.URLRules, 6)
+	assert.True(t, list.URLRules[5].Exception)
+	assert.Equal(t, "|http://example.org/blocked/allowed", list.URLRules[5].Pattern)
+	assert.Equal(t, []string{
+		"line 17: example.com##.ads",
+		"line 18: ||ads.example.com^$third-party",
+		"line 19: |https://www.example.net/path",
+	}, list.Unsupported)
+	assert.Equal(t, 13, list.Len())
+
+	// base64编码
+	encoded := base64.StdEncoding.EncodeToString([]byte(testAutoProxy))
+	var wrapped strings.Builder
+	for i := 0; i < len(encoded); i += 64 {
+		end := i + 64
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		wrapped.WriteString(encoded[i:end] + "\n")
+	}
+	list1, err := ParseAutoProxy(strings.NewReader(wrapped.String()))
+	require.NoError(t, err)
+	assert.Equal(t, list.Proxies, list1.Proxies)
+	assert.Equal(t, list.Directs, list1.Directs)
+	assert.Len(t, list1.URLRules, 6)
+	assert.Equal(t, list.Unsupported, list1.Unsupported)
+}
+
+func TestFilter_AutoProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autoproxy")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	f := New("intelligent", WithLivenessPeriod(0))
+	defer f.Close()
+
+	n, unsupported, err := f.LoadAutoProxyFile(filepath.Join(dir, "none"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, unsupported)
+
+	filename := filepath.Join(dir, "gfwlist.txt")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString([]byte(testAutoProxy))), 0644))
+	n, unsupported, err = f.LoadAutoProxyFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 13, n)
+	assert.Len(t, unsupported, 3)
+	assert.Equal(t, 6, f.ProxyItemCount())
+	assert.Equal(t, 1, f.ExceptionItemCount())
+	assert.Len(t, f.URLRules(), 6)
+
+	// 例外优先于代理表和直连表
+	f.directs.Add("google.com")
+	for _, tt := range []struct {
+		domain string
+		proxy  bool
+	}{
+		{"www.google.com:443", true},
+		{"cn.google.com:443", false},
+		{"a.cn.google.com:443", false},
+		{"pbs.twimg.com:443", true},
+		{"news.bbc.co.uk:80", true},
+	} {
+		proxy, inMap, _, _ := f.IsProxy(tt.domain)
+		assert.True(t, inMap, tt.domain)
+		assert.Equal(t, tt.proxy, proxy, tt.domain)
+	}
+	f.Add("cn.google.com:443", "1.1.1.1:443")
+	assert.Equal(t, 0, f.cache.Count())
+
+	for _, tt := range []struct {
+		url   string
+		proxy bool
+		ok    bool
+	}{
+		{"http://example.org/blocked/page", true, true},
+		{"http://example.org/blocked/allowed/page", false, true},
+		{"http://example.org/other", false, false},
+		{"http://www.example.com/path/to", true, true},
+		{"http://example.com/path", true, true},
+		{"http://example.com/other", false, false},
+		{"http://a.foo.com/bar", true, true},
+		{"http://a.foo.com/bar/baz", false, false},
+		{"http://test.blogspot.jp/", true, true},
+		{"http://host/keyword/x/blocked", true, true},
+	} {
+		proxy, ok := f.MatchURL(tt.url)
+		assert.Equal(t, tt.ok, ok, tt.url)
+		assert.Equal(t, tt.proxy, proxy, tt.url)
+	}
+
+	// 例外域名优先于url规则
+	f.exceptions.Add("www.example.com")
+	proxy, ok := f.MatchURL("http://www.example.com/path/to")
+	assert.True(t, ok)
+	assert.False(t, proxy)
+	proxy, ok = f.MatchURL("http://example.com/path")
+	assert.True(t, ok)
+	assert.True(t, proxy)
+}
+
+func TestDecodeAutoProxy(t *testing.T) {
+	// 无 [AutoProxy 头的base64, 等长折行
+	plain := "||google.com\n@@||cn.google.com\n"
+	encoded := base64.StdEncoding.EncodeToString([]byte(plain))
+	assert.Equal(t, plain, string(decodeAutoProxy([]byte(encoded))))
+	var wrapped strings.Builder
+	for i := 0; i < len(encoded); i += 8 {
+		end := i + 8
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		wrapped.WriteString(encoded[i:end] + "\n")
+	}
+	assert.Equal(t, plain, string(decodeAutoProxy([]byte(wrapped.String()))))
+
+	// 可解码为base64的多行关键字规则
+	for _, raw := range []string{"YWJjZGVm\nZ2hp\namts\n", "Z2hp\nYWJjZGVm\n"} {
+		assert.Equal(t, raw, string(decodeAutoProxy([]byte(raw))), raw)
+	}
+	assert.Equal(t, plain, string(decodeAutoProxy([]byte(plain))))
+}
@@ -0,0 +1,184 @@
+package filter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 默认cache表快照周期
+const defaultCacheSavePeriod = 5 * time.Minute
+
+// CacheEntry cache表条目, 用于导出, 导入和持久化
+type CacheEntry struct {
+	Domain         string  `json:"domain"`
+	Addr           string  `json:"addr"`
+	SuccessCount   uint    `json:"successCount"`
+	FailureCount   uint    `json:"failureCount"`
+	LastActiveTime int64   `json:"lastActiveTime"`         // 最后探测时间(unix时间),单位秒, 0 表示未探测
+	PassiveScore   float64 `json:"passiveScore,omitempty"` // 被动学习分值, 见 Report
+	PassiveTime    int64   `json:"passiveTime,omitempty"`  // 被动学习分值衰减起始时间(unix纳秒)
+}
+
+// ExportCache 导出cache表, 按域名排序
+func (sf *Filter) ExportCache() []CacheEntry {
+	items := sf.cache.Items()
+	entries := make([]CacheEntry, 0, len(items))
+	for domain, itm := range items {
+		item := itm.(Item)
+		entries = append(entries, CacheEntry{
+			domain,
+			item.addr,
+			item.successCount,
+			item.failureCount,
+			item.lastActiveTime,
+			item.passiveScore,
+			item.passiveTime,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Domain < entries[j].Domain })
+	return entries
+}
+
+// ImportCache 导入条目到cache表, 返回导入条目数.
+// 忽略以下条目:
+// 	域名或地址为空
+// 	探测结果已过期(距最后探测时间超过aliveThreshold)且无被动学习分值, 有分值时仅保留分值
+// 	域名在proxy表, direct表或exception表中
+// 	cache表中已有更新的探测结果
+func (sf *Filter) ImportCache(entries []CacheEntry) int {
+	n := 0
+	now := time.Now().Unix()
+	for _, e := range entries {
+		domain := hostname(e.Domain)
+		if domain == "" || e.Addr == "" ||
+			sf.Match(domain, false) || sf.Match(domain, true) ||
+			sf.exceptions.Match(domain) {
+			continue
+		}
+		if e.LastActiveTime != 0 && now-e.LastActiveTime >= sf.aliveThreshold {
+			if e.PassiveScore == 0 {
+				continue
+			}
+			e.SuccessCount, e.FailureCount, e.LastActiveTime = 0, 0, 0
+		}
+		item := Item{
+			addr:           e.Addr,
+			successCount:   e.SuccessCount,
+			failureCount:   e.FailureCount,
+			lastActiveTime: e.LastActiveTime,
+			passiveScore:   e.PassiveScore,
+			passiveTime:    e.PassiveTime,
+		}
+		imported := false
+		sf.cache.Upsert(domain, item, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+			if exist && valueInMap.(Item).lastActiveTime >= item.lastActiveTime {
+				return valueInMap
+			}
+			imported = true
+			return newValue
+		})
+		if imported {
+			n++
+		}
+	}
+	return n
+}
+
+// ClearCache 删除cache表中指定域名, 未指定域名时清空cache表, 返回删除条目数
+func (sf *Filter) ClearCache(domains ...string) int {
+	if len(domains) == 0 {
+		n := 0
+		for _, domain := range sf.cache.Keys() {
+			if _, ok := sf.cache.Pop(domain); ok {
+				n++
+			}
+		}
+		return n
+	}
+	n := 0
+	for _, domain := range domains {
+		if _, ok := sf.cache.Pop(hostname(domain)); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// CacheItemCount return cache item count.
+func (sf *Filter) CacheItemCount() int {
+	return sf.cache.Count()
+}
+
+// SaveCacheFile 保存cache表快照到文件(json格式), 先写临时文件再重命名, 避免写入中断损坏原文件
+func (sf *Filter) SaveCacheFile(filename string) error {
+	data, err := json.Marshal(sf.ExportCache())
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName) // nolint: errcheck
+	}
+	return err
+}
+
+// LoadCacheFile 从快照文件加载cache表, 文件不存在时不加载, 返回导入条目数, 规则见 ImportCache
+func (sf *Filter) LoadCacheFile(filename string) (int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var entries []CacheEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+	return sf.ImportCache(entries), nil
+}
+
+// LoadCache 从 WithCacheFile 设置的快照文件加载cache表, 然后开始周期保存快照, 返回导入条目数.
+// 须在加载proxy表, direct表和AutoProxy规则之后调用, 以便忽略已在表中的域名,
+// 未调用时关闭过滤器不保存快照, 避免覆盖未加载的快照文件
+func (sf *Filter) LoadCache() (int, error) {
+	if sf.cacheFile == "" || !atomic.CompareAndSwapUint32(&sf.cacheLoaded, 0, 1) {
+		return 0, nil
+	}
+	if sf.cacheSavePeriod > 0 {
+		go sf.runSnapshot()
+	}
+	return sf.LoadCacheFile(sf.cacheFile)
+}
+
+// runSnapshot 周期保存cache表快照
+func (sf *Filter) runSnapshot() {
+	tm := time.NewTicker(sf.cacheSavePeriod)
+	defer tm.Stop()
+	for {
+		select {
+		case <-sf.ctx.Done():
+			return
+		case <-tm.C:
+		}
+		if err := sf.SaveCacheFile(sf.cacheFile); err != nil {
+			sf.log.Warnf("save filter cache file(%s), %v", sf.cacheFile, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Cache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, "filter.cache")
+
+	now := time.Now().Unix()
+	f := New("intelligent", WithLivenessPeriod(0))
+	f.proxies.Add("blocked.com")
+	n := f.ImportCache([]CacheEntry{
+		{"a.com", "a.com:443", 0, 3, now, 0, 0},
+		{"b.com:80", "b.com:80", 3, 0, now - 60, 0, 0},
+		{"stale.com", "stale.com:443", 0, 3, now - defaultAliveThreshold, 0, 0},
+		{"new.com", "new.com:443", 0, 0, 0, 0, 0},
+		{"www.blocked.com", "www.blocked.com:443", 0, 3, now, 0, 0},
+		{"", "x.com:443", 0, 3, now, 0, 0},
+	})
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, f.CacheItemCount())
+	proxy, inMap, failN, _ := f.IsProxy("a.com:443")
+	assert.True(t, proxy)
+	assert.True(t, inMap)
+	assert.Equal(t, uint(3), failN)
+
+	// 已有更新的探测结果, 不覆盖
+	assert.Equal(t, 0, f.ImportCache([]CacheEntry{{"a.com", "a.com:443", 3, 0, now - 10, 0, 0}}))
+	proxy, _, _, _ = f.IsProxy("a.com:443")
+	assert.True(t, proxy)
+
+	assert.Equal(t, []CacheEntry{
+		{"a.com", "a.com:443", 0, 3, now, 0, 0},
+		{"b.com", "b.com:80", 3, 0, now - 60, 0, 0},
+		{"new.com", "new.com:443", 0, 0, 0, 0, 0},
+	}, f.ExportCache())
+
+	require.NoError(t, f.Close())
+	require.NoError(t, f.SaveCacheFile(filename))
+
+	// 未加载快照时关闭不保存快照
+	f0 := New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	require.NoError(t, f0.Close())
+
+	// 加载快照, 关闭时保存快照
+	f1 := New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	assert.Equal(t, 0, f1.CacheItemCount())
+	n, err = f1.LoadCache()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, f.ExportCache(), f1.ExportCache())
+	assert.Equal(t, 1, f1.ClearCache("b.com:80", "none.com"))
+	require.NoError(t, f1.Close())
+
+	// 在代理表加载之后加载快照, 忽略已在代理表中的域名
+	f2 := New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	f2.proxies.Add("a.com")
+	n, err = f2.LoadCache()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, f2.Close())
+	f2 = New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	_, err = f2.LoadCache()
+	require.NoError(t, err)
+	assert.Equal(t, 1, f2.CacheItemCount())
+	assert.Equal(t, 1, f2.ClearCache())
+	assert.Equal(t, 0, f2.CacheItemCount())
+	require.NoError(t, f2.Close())
+
+	// 文件不存在
+	n, err = f2.LoadCacheFile(filepath.Join(dir, "none"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	// 文件格式错误
+	require.NoError(t, ioutil.WriteFile(filename, []byte("{"), 0644))
+	_, err = f2.LoadCacheFile(filename)
+	require.Error(t, err)
+}
+
+func TestFilter_CachePassive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, "filter.cache")
+
+	f := New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	_, err = f.LoadCache()
+	require.NoError(t, err)
+	f.Add("a.com", "a.com:443")
+	for i := 0; i < 3; i++ {
+		f.Report("a.com", DialReset)
+	}
+	proxy, _, _, _ := f.IsProxy("a.com:443")
+	require.True(t, proxy)
+	require.NoError(t, f.Close())
+
+	// 被动学习分值随快照保存和加载
+	f1 := New("intelligent", WithLivenessPeriod(0), WithCacheFile(filename, 0))
+	defer f1.Close()
+	n, err := f1.LoadCache()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	proxy, _, _, _ = f1.IsProxy("a.com:443")
+	assert.True(t, proxy)
+
+	// 探测结果已过期, 仅保留分值
+	now := time.Now()
+	f2 := New("intelligent", WithLivenessPeriod(0))
+	defer f2.Close()
+	assert.Equal(t, 1, f2.ImportCache([]CacheEntry{
+		{"b.com", "b.com:443", 3, 0, now.Unix() - defaultAliveThreshold, 3, now.UnixNano()},
+		{"c.com", "c.com:443", 3, 0, now.Unix() - defaultAliveThreshold, 0, 0},
+	}))
+	assert.Equal(t, []CacheEntry{{"b.com", "b.com:443", 0, 0, 0, 3, now.UnixNano()}}, f2.ExportCache())
+}
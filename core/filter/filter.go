@@ -1,5 +1,5 @@
-// Package filter 过滤器, proxy代理表,direct直连表,
-// 如果域名在代理表和直连表都存在,只有代理表起作用
+// Package filter 过滤器, proxy代理表,direct直连表,exception例外表
+// 如果域名在代理表和直连表都存在,只有代理表起作用, 例外表(AutoProxy @@规则)优先于代理表和直连表
 package filter
 
 import (
@@ -9,6 +9,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map"
@@ -29,14 +30,25 @@ type Filter struct {
 	// intelligent <default> 智能选择
 	intelligent      string
 	cache            cmap.ConcurrentMap                                                  // cache表是动态添加的,周期检查连通性,如果不通,将走代理
-	proxies          cmap.ConcurrentMap                                                  // 代理表
-	directs          cmap.ConcurrentMap                                                  // 直连表
+	proxies          *domainSet                                                          // 代理表
+	directs          *domainSet                                                          // 直连表
+	exceptions       *domainSet                                                          // 例外表, AutoProxy @@规则, 优先于代理表和直连表
 	timeout          time.Duration                                                       // 域名检测超时时间, default: 1s
 	livenessPeriod   time.Duration                                                       // 域名存活控测周期, default: 30s
 	livenessProbe    func(ctx context.Context, addr string, timeout time.Duration) error // 域名探针接口, default: tcp dial
 	successThreshold uint
 	failureThreshold uint
 	aliveThreshold   int64
+	// 规则可使用的父级组, nil不检查
+	ruleGroups       map[string]struct{}
+	rules            atomic.Value  // 路由规则 []*Rule, 优先于代理表和直连表
+	resolver         Resolver      // IP-CIDR 规则的域名解析, default: 系统解析
+	urlRules         atomic.Value  // AutoProxy url规则 []*URLRule, 仅对明文http有效
+	passiveThreshold float64       // 被动学习阀值, <= 0 关闭, default: 3
+	passiveHalfLife  time.Duration // 被动学习分值半衰期, default: 10m
+	cacheFile        string        // cache表快照文件, 为空不持久化
+	cacheSavePeriod  time.Duration // cache表快照周期, default: 5m
+	cacheLoaded      uint32        // 是否已加载cache表快照, 见 LoadCache
 	cancel           context.CancelFunc
 	ctx              context.Context
 	gPool            gopool.Pool
@@ -49,6 +61,8 @@ type Item struct {
 	successCount   uint
 	failureCount   uint
 	lastActiveTime int64
+	passiveScore   float64 // 被动学习分值, 真实直连失败累计, 见 Report
+	passiveTime    int64   // 被动学习分值衰减起始时间(unix纳秒)
 }
 
 // isNeedLivenessProde 是否需要存活探测
@@ -68,14 +82,24 @@ func New(intelligent string, opts ...Option) *Filter {
 	f := &Filter{
 		intelligent,
 		cmap.New(),
-		cmap.New(),
-		cmap.New(),
+		newDomainSet(),
+		newDomainSet(),
+		newDomainSet(),
 		time.Second * 1,
 		time.Second * 30,
 		nil,
 		defaultThreshold,
 		defaultThreshold,
 		defaultAliveThreshold,
+		nil,
+		atomic.Value{},
+		sysResolver{},
+		atomic.Value{},
+		defaultPassiveThreshold,
+		defaultPassiveHalfLife,
+		"",
+		defaultCacheSavePeriod,
+		0,
 		cancel,
 		ctx,
 		nil,
@@ -92,19 +116,33 @@ func New(intelligent string, opts ...Option) *Filter {
 	return f
 }
 
+// Close 关闭过滤器, 已加载cache快照文件时保存一次快照
 func (sf *Filter) Close() error {
 	sf.cancel()
+	if sf.cacheFile != "" && atomic.LoadUint32(&sf.cacheLoaded) == 1 {
+		return sf.SaveCacheFile(sf.cacheFile)
+	}
 	return nil
 }
 
 // LoadProxyFile load proxy file with filename line byte line,return the count.
 func (sf *Filter) LoadProxyFile(filename string) (int, error) {
-	return loadfile2ConcurrentMap(&sf.proxies, filename)
+	domains, err := readDomainFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	sf.proxies.Add(domains...)
+	return len(domains), nil
 }
 
 // LoadDirectFile load direct file with filename line byte line,return the count.
 func (sf *Filter) LoadDirectFile(filename string) (int, error) {
-	return loadfile2ConcurrentMap(&sf.directs, filename)
+	domains, err := readDomainFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	sf.directs.Add(domains...)
+	return len(domains), nil
 }
 
 // ProxyItemCount return proxy item count.
@@ -117,17 +155,23 @@ func (sf *Filter) DirectItemCount() int {
 	return sf.directs.Count()
 }
 
-// Add 增加一个域名-->地址(host:port)映射到过滤表, 如果proxy表且direct表都不存在时才进行添加
+// ExceptionItemCount return exception item count.
+func (sf *Filter) ExceptionItemCount() int {
+	return sf.exceptions.Count()
+}
+
+// Add 增加一个域名-->地址(host:port)映射到过滤表, 如果proxy表,direct表和exception表都不存在时才进行添加
 func (sf *Filter) Add(domain, addr string) {
 	domain = hostname(domain)
-	if !sf.Match(domain, false) && !sf.Match(domain, true) {
+	if !sf.Match(domain, false) && !sf.Match(domain, true) && !sf.exceptions.Match(domain) {
 		sf.cache.SetIfAbsent(domain, Item{addr: addr})
 	}
 }
 
 // IsProxy domain代理检查,返回是否需要代理,是否在cache,proxy,direct表中.
 // 检查顺序:
-// 1. 先检查proxy表,在proxy表中直接返回,否则执行2.
+// 0. 先检查exception表,在exception表中直接返回直连.
+// 1. 再检查proxy表,在proxy表中直接返回,否则执行2.
 // 2. 再检查direct,在direct表中直接返回,否则执行3
 // 3. 检查是否在cache表,不在直接返回.否则执行4.
 // 4. 根据策略,如果是direct或proxy策略,直接返回策略规则,否则采用intelligent,进行智能判断.
@@ -135,6 +179,9 @@ func (sf *Filter) Add(domain, addr string) {
 //
 func (sf *Filter) IsProxy(domain string) (proxy, inMap bool, failN, successN uint) {
 	domain = hostname(domain)
+	if sf.exceptions.Match(domain) {
+		return false, true, 0, 0
+	}
 	if sf.Match(domain, true) {
 		return true, true, 0, 0
 	}
@@ -155,9 +202,7 @@ func (sf *Filter) IsProxy(domain string) (proxy, inMap bool, failN, successN uin
 		fallthrough
 	default:
 		item := itm.(Item)
-		return (item.successCount <= item.failureCount) &&
-				(time.Now().Unix()-item.lastActiveTime < sf.aliveThreshold),
-			true, item.failureCount, item.successCount
+		return sf.cacheIsProxy(item), true, item.failureCount, item.successCount
 	}
 }
 
@@ -167,21 +212,13 @@ func (sf *Filter) IsProxy(domain string) (proxy, inMap bool, failN, successN uin
 //	  - bar.com  --> return true
 //    - com  --> return true
 func (sf *Filter) Match(domain string, isProxy bool) bool {
-	hnSlice := strings.Split(hostname(domain), ".")
-	if len(hnSlice) <= 1 {
-		return false
+	if strings.IndexByte(domain, ':') >= 0 {
+		domain = hostname(domain)
 	}
-
-	tb := sf.directs
 	if isProxy {
-		tb = sf.proxies
-	}
-	for i := len(hnSlice) - 1; i >= 0; i-- {
-		if tb.Has(strings.Join(hnSlice[i:], ".")) {
-			return true
-		}
+		return sf.proxies.Match(domain)
 	}
-	return false
+	return sf.directs.Match(domain)
 }
 
 func (sf *Filter) run() {
@@ -228,34 +265,39 @@ func (sf *Filter) run() {
 						item.successCount++
 					}
 					item.lastActiveTime = now
-					sf.cache.Set(domain, item)
+					sf.cache.Upsert(domain, item, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+						v := newValue.(Item)
+						if exist { // 保留探测期间上报的被动学习结果
+							old := valueInMap.(Item)
+							v.passiveScore, v.passiveTime = old.passiveScore, old.passiveTime
+						}
+						return v
+					})
 				})
 			}
 		}
 	}
 }
 
-// loadfile2ConcurrentMap load file with filename line byte line to cmp,return the count in cmp.
-func loadfile2ConcurrentMap(cmp *cmap.ConcurrentMap, filename string) (int, error) {
-	var n int
-
-	_, err := os.Stat(filename)
-	if err == nil || !os.IsNotExist(err) {
-		contents, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return n, err
+// readDomainFile 读取域名文件, 一行一条, 文件不存在时返回空.
+func readDomainFile(filename string) ([]string, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
-		// DOS/Windows系统 采用CRLF表示下一行
-		// Linux/UNIX系统 采用LF表示下一行
-		// MAC系统 采用CR表示下一行
-		for _, line := range strings.Split(string(contents), "\n") {
-			if line = strings.Trim(line, "\r \t"); line != "" {
-				n++
-				cmp.Set(line, struct{}{})
-			}
+		return nil, err
+	}
+	var domains []string
+	// DOS/Windows系统 采用CRLF表示下一行
+	// Linux/UNIX系统 采用LF表示下一行
+	// MAC系统 采用CR表示下一行
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line = strings.Trim(line, "\r \t"); line != "" {
+			domains = append(domains, line)
 		}
 	}
-	return n, nil
+	return domains, nil
 }
 
 func hostname(domain string) string {
@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 
-	cmap "github.com/orcaman/concurrent-map"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/thinkgos/jocasta/pkg/logger"
@@ -202,13 +201,7 @@ func TestFilter_intelligent(t *testing.T) {
 }
 
 func TestFilter_Match(t *testing.T) {
-	directs := cmap.New()
-	directs.Set("bar.com", struct{}{})
-
-	proxies := cmap.New()
-	proxies.Set("foo.com", struct{}{})
-
-	filte := &Filter{directs: directs, proxies: proxies}
+	filte := &Filter{directs: newDomainSet("bar.com"), proxies: newDomainSet("foo.com")}
 
 	type args struct {
 		domain  string
@@ -261,16 +254,14 @@ func TestFilter_Match(t *testing.T) {
 	}
 }
 
-func Test_loadfile2ConcurrentMap(t *testing.T) {
-	cmp := cmap.New()
-
-	n, err := loadfile2ConcurrentMap(&cmp, "notExist.txt")
+func Test_readDomainFile(t *testing.T) {
+	domains, err := readDomainFile("notExist.txt")
 	require.NoError(t, err)
-	require.Equal(t, 0, n)
+	require.Len(t, domains, 0)
 
-	n, err = loadfile2ConcurrentMap(&cmp, "./testdata/direct.txt")
+	domains, err = readDomainFile("./testdata/direct.txt")
 	require.NoError(t, err)
-	require.Equal(t, 3, n)
+	require.Len(t, domains, 3)
 }
 
 func Test_hostname(t *testing.T) {
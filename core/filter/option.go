@@ -82,3 +82,49 @@ func WithFailureThreshold(cnt uint) Option {
 		f.failureThreshold = cnt
 	}
 }
+
+// WithResolver IP-CIDR 规则匹配域名目标时使用的域名解析, default: 系统解析
+func WithResolver(r Resolver) Option {
+	return func(f *Filter) {
+		if r != nil {
+			f.resolver = r
+		}
+	}
+}
+
+// WithRuleGroups 规则可使用的父级组, 加载规则时动作为不存在的父级组将返回错误
+func WithRuleGroups(groups map[string][]string) Option {
+	return func(f *Filter) {
+		f.ruleGroups = make(map[string]struct{}, len(groups))
+		for name := range groups {
+			f.ruleGroups[name] = struct{}{}
+		}
+	}
+}
+
+// WithCacheFile cache表快照文件, 调用 LoadCache 时加载未过期的条目, 之后每隔period保存一次快照, 关闭时保存一次.
+// period <= 0 时不周期保存, 仅在关闭时保存
+func WithCacheFile(filename string, period time.Duration) Option {
+	return func(f *Filter) {
+		f.cacheFile = filename
+		f.cacheSavePeriod = period
+	}
+}
+
+// WithPassiveThreshold 被动学习阀值, 服务上报的真实直连失败分值达到阀值时走代理, <= 0 关闭被动学习, default: 3
+func WithPassiveThreshold(threshold float64) Option {
+	return func(f *Filter) {
+		f.passiveThreshold = threshold
+	}
+}
+
+// WithPassiveHalfLife 被动学习分值半衰期, 分值随时间衰减, 使走代理的域名可重新尝试直连.
+// <= 0: use defaultPassiveHalfLife 10m
+func WithPassiveHalfLife(halfLife time.Duration) Option {
+	return func(f *Filter) {
+		if halfLife <= 0 {
+			halfLife = defaultPassiveHalfLife
+		}
+		f.passiveHalfLife = halfLife
+	}
+}
@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// PAC 根据过滤表生成PAC(proxy auto-config)文件, proxy为PAC代理返回值, 如 "PROXY 192.168.1.1:28080".
+// 判断顺序与 IsProxy 一致:
+// 	简单主机名(无'.')        直连
+// 	exception表             直连
+// 	proxy表                 代理
+// 	direct表                直连
+// 	cache表(按过滤模式判断)  代理或直连, 仅精确匹配
+// 	其它                     代理, 由代理服务进行路由规则, url规则和智能判断
+// 注意: 路由规则和url规则不写入PAC, direct表中的域名由浏览器直连, 不经过路由规则
+func (sf *Filter) PAC(proxy string) []byte {
+	cacheProxies, cacheDirects := make([]string, 0), make([]string, 0)
+	for domain, itm := range sf.cache.Items() {
+		if sf.cacheIsProxy(itm.(Item)) {
+			cacheProxies = append(cacheProxies, domain)
+		} else {
+			cacheDirects = append(cacheDirects, domain)
+		}
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString("// generated by jocasta at " + time.Now().Format(time.RFC3339) + "\n")
+	writePACVar(b, "proxy", proxy)
+	writePACVar(b, "direct", "DIRECT")
+	writePACVar(b, "exceptions", pacSet(sf.exceptions.Keys()))
+	writePACVar(b, "proxies", pacSet(sf.proxies.Keys()))
+	writePACVar(b, "directs", pacSet(sf.directs.Keys()))
+	writePACVar(b, "cacheProxies", pacSet(cacheProxies))
+	writePACVar(b, "cacheDirects", pacSet(cacheDirects))
+	b.WriteString(pacFunc)
+	return b.Bytes()
+}
+
+// cacheIsProxy cache表条目是否走代理, 规则同 IsProxy.
+// intelligent模式: 被动学习分值达到阀值, 或有效期内探测失败次数不少于成功次数时走代理
+func (sf *Filter) cacheIsProxy(item Item) bool {
+	switch sf.intelligent {
+	case "direct":
+		return false
+	case "proxy":
+		return true
+	default:
+		if sf.passiveThreshold > 0 &&
+			item.passive(time.Now().UnixNano(), sf.passiveHalfLife) >= sf.passiveThreshold {
+			return true
+		}
+		return (item.successCount <= item.failureCount) &&
+			(time.Now().Unix()-item.lastActiveTime < sf.aliveThreshold)
+	}
+}
+
+const pacFunc = `
+var hasOwn = Object.prototype.hasOwnProperty;
+
+function matchSuffix(tb, host) {
+    var pos = -1;
+    do {
+        if (hasOwn.call(tb, host.substring(pos + 1))) {
+            return true;
+        }
+        pos = host.indexOf(".", pos + 1);
+    } while (pos >= 0);
+    return false;
+}
+
+function FindProxyForURL(url, host) {
+    host = host.toLowerCase();
+    if (isPlainHostName(host)) {
+        return direct;
+    }
+    if (matchSuffix(exceptions, host)) {
+        return direct;
+    }
+    if (matchSuffix(proxies, host)) {
+        return proxy;
+    }
+    if (matchSuffix(directs, host)) {
+        return direct;
+    }
+    if (hasOwn.call(cacheProxies, host)) {
+        return proxy;
+    }
+    if (hasOwn.call(cacheDirects, host)) {
+        return direct;
+    }
+    return proxy;
+}
+`
+
+func writePACVar(b *bytes.Buffer, name string, v interface{}) {
+	data, _ := json.Marshal(v) // nolint: errcheck
+	b.WriteString("var " + name + " = ")
+	b.Write(data)
+	b.WriteString(";\n")
+}
+
+// pacSet 转为js对象 {"key":1,...}, json编码时key有序
+func pacSet(keys []string) map[string]int {
+	m := make(map[string]int, len(keys))
+	for _, k := range keys {
+		m[k] = 1
+	}
+	return m
+}
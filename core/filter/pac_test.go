@@ -0,0 +1,36 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_PAC(t *testing.T) {
+	f := New("intelligent", WithLivenessPeriod(0))
+	defer f.Close()
+	f.proxies.Add("google.com")
+	f.directs.Add("baidu.com")
+	f.exceptions.Add("cn.google.com")
+	now := time.Now().Unix()
+	f.ImportCache([]CacheEntry{
+		{"blocked.net", "blocked.net:443", 0, 3, now, 0, 0},
+		{"open.net", "open.net:443", 3, 0, now, 0, 0},
+	})
+
+	pac := string(f.PAC("PROXY 127.0.0.1:28080"))
+	assert.Contains(t, pac, `var proxy = "PROXY 127.0.0.1:28080";`)
+	assert.Contains(t, pac, `var exceptions = {"cn.google.com":1};`)
+	assert.Contains(t, pac, `var proxies = {"google.com":1};`)
+	assert.Contains(t, pac, `var directs = {"baidu.com":1};`)
+	assert.Contains(t, pac, `var cacheProxies = {"blocked.net":1};`)
+	assert.Contains(t, pac, `var cacheDirects = {"open.net":1};`)
+	assert.Contains(t, pac, "function FindProxyForURL(url, host) {")
+
+	// direct模式 cache表全部直连
+	f.intelligent = "direct"
+	pac = string(f.PAC("PROXY 127.0.0.1:28080"))
+	assert.Contains(t, pac, `var cacheProxies = {};`)
+	assert.Contains(t, pac, `var cacheDirects = {"blocked.net":1,"open.net":1};`)
+}
@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"errors"
+	"math"
+	"syscall"
+	"time"
+)
+
+// 被动学习默认阀值和半衰期
+const (
+	defaultPassiveThreshold = 3.0
+	defaultPassiveHalfLife  = 10 * time.Minute
+)
+
+// DialOutcome 客户端真实直连结果, 由服务上报
+type DialOutcome int
+
+// 直连结果
+const (
+	DialSuccess        DialOutcome = iota // 成功, 收到目标数据
+	DialConnectFailure                    // 连接失败
+	DialReset                             // 未收到目标数据前被重置, 如TLS握手期间RST
+	DialNoResponse                        // 已发送数据, 未收到目标任何数据且读取出错, 如超时
+)
+
+// String 直连结果
+func (d DialOutcome) String() string {
+	switch d {
+	case DialSuccess:
+		return "success"
+	case DialConnectFailure:
+		return "connect failure"
+	case DialReset:
+		return "reset"
+	case DialNoResponse:
+		return "no response"
+	default:
+		return "unknown"
+	}
+}
+
+// ClassifyOutcome 根据直连转发的发送字节数, 接收字节数和接收错误判断直连结果.
+// 收到数据为成功, 未发送数据(如客户端预连接后关闭)或未收到数据但无错误
+// (如目标正常关闭, 客户端先结束转发)时无法判断, ok 为false
+func ClassifyOutcome(sent, received int64, err error) (outcome DialOutcome, ok bool) {
+	switch {
+	case received > 0:
+		return DialSuccess, true
+	case sent == 0, err == nil:
+		return DialSuccess, false
+	case errors.Is(err, syscall.ECONNRESET):
+		return DialReset, true
+	default:
+		return DialNoResponse, true
+	}
+}
+
+// Report 上报域名的真实直连结果, 仅对cache表中的域名(由智能判断决定)有效.
+// 失败时被动学习分值加1, 成功时减1(不小于0), 分值每经过一个半衰期减半,
+// intelligent模式下分值达到阀值时该域名走代理, 直到分值衰减到阀值以下
+func (sf *Filter) Report(domain string, outcome DialOutcome) {
+	if sf.passiveThreshold <= 0 {
+		return
+	}
+	domain = hostname(domain)
+	itm, ok := sf.cache.Get(domain)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	var before, after float64
+	sf.cache.Upsert(domain, itm, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		item := newValue.(Item)
+		if exist {
+			item = valueInMap.(Item)
+		}
+		item.decay(now, sf.passiveHalfLife)
+		before = item.passiveScore
+		after = before + 1
+		if outcome == DialSuccess {
+			after = math.Max(before-1, 0)
+		}
+		item.passiveScore = after
+		return item
+	})
+	if before < sf.passiveThreshold && after >= sf.passiveThreshold {
+		sf.log.Infof("filter domain %s switch to proxy after direct %s", domain, outcome)
+	}
+}
+
+// passive 衰减后的被动学习分值, 自分值更新时间起每经过一个半衰期减半
+func (sf *Item) passive(now int64, halfLife time.Duration) float64 {
+	if sf.passiveScore == 0 || halfLife <= 0 {
+		return sf.passiveScore
+	}
+	return sf.passiveScore / math.Exp2(float64((now-sf.passiveTime)/int64(halfLife)))
+}
+
+// decay 衰减被动学习分值, 更新时间前进整数个半衰期, 分值为0时更新时间为now
+func (sf *Item) decay(now int64, halfLife time.Duration) {
+	if sf.passiveScore == 0 || halfLife <= 0 {
+		sf.passiveTime = now
+		return
+	}
+	sf.passiveScore = sf.passive(now, halfLife)
+	sf.passiveTime += (now - sf.passiveTime) / int64(halfLife) * int64(halfLife)
+}
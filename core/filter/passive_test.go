@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyOutcome(t *testing.T) {
+	for _, tt := range []struct {
+		sent, received int64
+		err            error
+		outcome        DialOutcome
+		ok             bool
+	}{
+		{100, 10, nil, DialSuccess, true},
+		{100, 10, syscall.ECONNRESET, DialSuccess, true},
+		{0, 0, nil, DialSuccess, false},
+		{100, 0, fmt.Errorf("read: %w", syscall.ECONNRESET), DialReset, true},
+		{100, 0, errors.New("i/o timeout"), DialNoResponse, true},
+		{100, 0, nil, DialSuccess, false},
+		{100, 0, io.ErrUnexpectedEOF, DialNoResponse, true},
+	} {
+		outcome, ok := ClassifyOutcome(tt.sent, tt.received, tt.err)
+		assert.Equal(t, tt.ok, ok)
+		if ok {
+			assert.Equal(t, tt.outcome, outcome)
+		}
+	}
+}
+
+func TestFilter_Report(t *testing.T) {
+	f := New("intelligent", WithLivenessPeriod(0), WithPassiveThreshold(2), WithPassiveHalfLife(time.Minute))
+	defer f.Close()
+
+	// 不在cache表, 忽略
+	f.Report("unknown.com:443", DialReset)
+	assert.Equal(t, 0, f.CacheItemCount())
+
+	f.Add("blocked.com:443", "1.1.1.1:443")
+	proxy, inMap, _, _ := f.IsProxy("blocked.com:443")
+	assert.True(t, inMap)
+	assert.False(t, proxy)
+
+	f.Report("blocked.com:443", DialReset)
+	proxy, _, _, _ = f.IsProxy("blocked.com:443")
+	assert.False(t, proxy)
+	f.Report("blocked.com:443", DialNoResponse)
+	proxy, _, _, _ = f.IsProxy("blocked.com:443")
+	assert.True(t, proxy)
+	assert.Contains(t, string(f.PAC("PROXY 127.0.0.1:1")), `var cacheProxies = {"blocked.com":1};`)
+
+	f.Report("blocked.com:443", DialSuccess)
+	proxy, _, _, _ = f.IsProxy("blocked.com:443")
+	assert.False(t, proxy)
+
+	// 衰减
+	itm, _ := f.cache.Get("blocked.com")
+	item := itm.(Item)
+	t0 := item.passiveTime
+	assert.Equal(t, 1.0, item.passive(t0+int64(time.Second*59), time.Minute))
+	assert.Equal(t, 0.5, item.passive(t0+int64(time.Second*61), time.Minute))
+	assert.Equal(t, 0.25, item.passive(t0+int64(time.Second*121), time.Minute))
+	assert.Equal(t, 1.0, item.passive(t0+int64(time.Second*121), 0))
+	item.passiveScore = 4
+	item.decay(t0+int64(time.Second*150), time.Minute)
+	assert.Equal(t, 1.0, item.passiveScore)
+	assert.Equal(t, t0+int64(time.Minute*2), item.passiveTime)
+
+	// 关闭被动学习
+	f.passiveThreshold = 0
+	f.Report("blocked.com:443", DialReset)
+	f.Report("blocked.com:443", DialReset)
+	proxy, _, _, _ = f.IsProxy("blocked.com:443")
+	assert.False(t, proxy)
+}
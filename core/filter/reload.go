@@ -0,0 +1,111 @@
+package filter
+
+import (
+	"fmt"
+)
+
+// Diff 重新加载前后条目的变化
+type Diff struct {
+	Added   int // 新增条目数
+	Removed int // 删除条目数
+	Total   int // 重新加载后条目数
+}
+
+// String diff格式: +Added -Removed =Total
+func (d Diff) String() string {
+	return fmt.Sprintf("+%d -%d =%d", d.Added, d.Removed, d.Total)
+}
+
+// ReloadStat 重新加载统计
+type ReloadStat struct {
+	Proxies     Diff     // 代理表
+	Directs     Diff     // 直连表
+	Exceptions  Diff     // 例外表
+	URLRules    Diff     // url规则
+	Unsupported []string // AutoProxy文件不支持的行
+}
+
+// String 统计信息
+func (sf ReloadStat) String() string {
+	return fmt.Sprintf("proxy %s, direct %s, exception %s, url rule %s, unsupported %d",
+		sf.Proxies, sf.Directs, sf.Exceptions, sf.URLRules, len(sf.Unsupported))
+}
+
+// Reload 重新加载代理表文件, 直连表文件和AutoProxy文件, 全部加载成功后分别原子替换代理表, 直连表, 例外表和url规则,
+// 任一文件加载失败时保留原数据. 文件名为空或文件不存在时对应数据为空, 动态添加的cache表不受影响
+func (sf *Filter) Reload(proxyFile, directFile, autoProxyFile string) (ReloadStat, error) {
+	var stat ReloadStat
+	var urlRules []*URLRule
+
+	var proxies, directs, exceptions []string
+	var err error
+	if proxyFile != "" {
+		if proxies, err = readDomainFile(proxyFile); err != nil {
+			return stat, fmt.Errorf("load proxy file, %w", err)
+		}
+	}
+	if directFile != "" {
+		if directs, err = readDomainFile(directFile); err != nil {
+			return stat, fmt.Errorf("load direct file, %w", err)
+		}
+	}
+	if autoProxyFile != "" {
+		list, err := parseAutoProxyFile(autoProxyFile)
+		if err != nil {
+			return stat, fmt.Errorf("load autoproxy file, %w", err)
+		}
+		proxies = append(proxies, list.Proxies...)
+		exceptions = list.Directs
+		urlRules = list.URLRules
+		stat.Unsupported = list.Unsupported
+	}
+
+	stat.Proxies = replaceTable(sf.proxies, proxies)
+	stat.Directs = replaceTable(sf.directs, directs)
+	stat.Exceptions = replaceTable(sf.exceptions, exceptions)
+	stat.URLRules = diffURLRules(sf.URLRules(), urlRules)
+	sf.urlRules.Store(urlRules)
+	return stat, nil
+}
+
+// replaceTable 替换域名表, 返回前后变化
+func replaceTable(s *domainSet, domains []string) Diff {
+	cur := newDomainTrie(domains)
+	old := s.Replace(cur)
+	d := Diff{Total: len(cur.keys)}
+	for _, k := range cur.keys {
+		if !old.has(k) {
+			d.Added++
+		}
+	}
+	for _, k := range old.keys {
+		if !cur.has(k) {
+			d.Removed++
+		}
+	}
+	return d
+}
+
+func diffURLRules(old, cur []*URLRule) Diff {
+	key := func(r *URLRule) string {
+		if r.Exception {
+			return "@@" + r.Pattern
+		}
+		return r.Pattern
+	}
+	oldSet := make(map[string]struct{}, len(old))
+	for _, r := range old {
+		oldSet[key(r)] = struct{}{}
+	}
+	d := Diff{Total: len(cur)}
+	for _, r := range cur {
+		k := key(r)
+		if _, ok := oldSet[k]; ok {
+			delete(oldSet, k)
+		} else {
+			d.Added++
+		}
+	}
+	d.Removed = len(oldSet)
+	return d
+}
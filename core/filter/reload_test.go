@@ -0,0 +1,62 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	proxyFile := filepath.Join(dir, "blocked")
+	directFile := filepath.Join(dir, "direct")
+	autoProxyFile := filepath.Join(dir, "gfwlist")
+	require.NoError(t, ioutil.WriteFile(proxyFile, []byte("foo.com\nbar.com\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(directFile, []byte("baidu.com\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(autoProxyFile, []byte("||google.com\n@@||cn.google.com\n|http://example.org/x\n"), 0644))
+
+	f := New("intelligent", WithLivenessPeriod(0))
+	defer f.Close()
+	_, err = f.LoadProxyFile(proxyFile)
+	require.NoError(t, err)
+	f.Add("cached.net:443", "1.1.1.1:443")
+
+	stat, err := f.Reload(proxyFile, directFile, autoProxyFile)
+	require.NoError(t, err)
+	assert.Equal(t, Diff{Added: 1, Removed: 0, Total: 3}, stat.Proxies)
+	assert.Equal(t, Diff{Added: 1, Removed: 0, Total: 1}, stat.Directs)
+	assert.Equal(t, Diff{Added: 1, Removed: 0, Total: 1}, stat.Exceptions)
+	assert.Equal(t, Diff{Added: 1, Removed: 0, Total: 1}, stat.URLRules)
+	assert.Equal(t, "proxy +1 -0 =3, direct +1 -0 =1, exception +1 -0 =1, url rule +1 -0 =1, unsupported 0", stat.String())
+	assert.True(t, f.Match("www.google.com", true))
+	assert.True(t, f.Match("www.baidu.com", false))
+	proxy, _, _, _ := f.IsProxy("cn.google.com:443")
+	assert.False(t, proxy)
+
+	require.NoError(t, ioutil.WriteFile(proxyFile, []byte("foo.com\nqux.com\n"), 0644))
+	require.NoError(t, os.Remove(autoProxyFile))
+	stat, err = f.Reload(proxyFile, directFile, autoProxyFile)
+	require.NoError(t, err)
+	assert.Equal(t, Diff{Added: 1, Removed: 2, Total: 2}, stat.Proxies)
+	assert.Equal(t, Diff{Added: 0, Removed: 0, Total: 1}, stat.Directs)
+	assert.Equal(t, Diff{Added: 0, Removed: 1, Total: 0}, stat.Exceptions)
+	assert.Equal(t, Diff{Added: 0, Removed: 1, Total: 0}, stat.URLRules)
+	assert.False(t, f.Match("bar.com", true))
+	assert.True(t, f.Match("qux.com", true))
+	_, ok := f.MatchURL("http://example.org/x")
+	assert.False(t, ok)
+	assert.Equal(t, 1, f.cache.Count())
+
+	// 加载失败, 保留原数据
+	_, err = f.Reload(dir, directFile, "")
+	require.Error(t, err)
+	assert.True(t, f.Match("qux.com", true))
+	assert.Equal(t, 2, f.ProxyItemCount())
+}
@@ -0,0 +1,287 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Action 规则动作, 除以下动作外为父级组名称
+type Action string
+
+// 规则动作
+const (
+	ActionProxy  Action = "PROXY"  // 使用默认父级
+	ActionDirect Action = "DIRECT" // 直连
+	ActionReject Action = "REJECT" // 拒绝连接
+)
+
+// IsGroup 是否为父级组
+func (a Action) IsGroup() bool {
+	return a != ActionProxy && a != ActionDirect && a != ActionReject
+}
+
+// RuleType 规则匹配类型
+type RuleType string
+
+// 规则匹配类型
+const (
+	RuleDomain        RuleType = "DOMAIN"         // 域名完全匹配
+	RuleDomainSuffix  RuleType = "DOMAIN-SUFFIX"  // 域名后缀匹配, 如 example.com 匹配 example.com 和 a.example.com
+	RuleDomainKeyword RuleType = "DOMAIN-KEYWORD" // 域名包含关键字
+	RuleDomainRegex   RuleType = "DOMAIN-REGEX"   // 域名正则匹配
+	RuleIPCIDR        RuleType = "IP-CIDR"        // 目标ip属于网段, 支持ipv4和ipv6
+	RuleIPCIDR6       RuleType = "IP-CIDR6"       // 同 IP-CIDR
+	RuleSrcIPCIDR     RuleType = "SRC-IP-CIDR"    // 来源ip属于网段
+	RuleDstPort       RuleType = "DST-PORT"       // 目标端口, 支持范围, 如 8000-9000
+	RuleUser          RuleType = "USER"           // 认证用户名
+	RuleMatch         RuleType = "MATCH"          // 匹配所有, 通常作为最后一条规则
+)
+
+// Resolver 域名解析, IP-CIDR 规则匹配域名目标时使用
+type Resolver interface {
+	LookupIP(host string) ([]net.IP, error)
+}
+
+// Metadata 规则匹配的连接信息
+type Metadata struct {
+	Src  string // 来源地址, 格式 ip:port
+	Dst  string // 目标地址, 格式 host:port, host为域名或ip
+	User string // 认证用户名, 无认证时为空
+
+	resolved bool
+	dstIP    net.IP
+}
+
+// Rule 路由规则
+type Rule struct {
+	Type      RuleType
+	Payload   string
+	Action    Action
+	NoResolve bool // IP-CIDR 规则目标为域名时不解析, 直接不匹配
+
+	ipNet    *net.IPNet
+	regex    *regexp.Regexp
+	portFrom int
+	portTo   int
+}
+
+// ParseRule 解析规则, 格式: TYPE,PAYLOAD,ACTION[,no-resolve], MATCH规则格式: MATCH,ACTION
+func ParseRule(line string) (*Rule, error) {
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	r := &Rule{Type: RuleType(strings.ToUpper(fields[0]))}
+	if r.Type == RuleMatch {
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, fmt.Errorf("invalid rule %q, should be like MATCH,ACTION", line)
+		}
+		r.Action = parseAction(fields[1])
+		return r, nil
+	}
+	if len(fields) < 3 || len(fields) > 4 || fields[1] == "" || fields[2] == "" {
+		return nil, fmt.Errorf("invalid rule %q, should be like TYPE,PAYLOAD,ACTION[,no-resolve]", line)
+	}
+	r.Payload, r.Action = fields[1], parseAction(fields[2])
+	if len(fields) == 4 {
+		if !strings.EqualFold(fields[3], "no-resolve") {
+			return nil, fmt.Errorf("invalid rule %q, unknown option %s", line, fields[3])
+		}
+		r.NoResolve = true
+	}
+
+	var err error
+	switch r.Type {
+	case RuleDomain, RuleDomainSuffix, RuleDomainKeyword:
+		r.Payload = strings.ToLower(strings.TrimSuffix(r.Payload, "."))
+	case RuleDomainRegex:
+		r.regex, err = regexp.Compile(r.Payload)
+	case RuleIPCIDR, RuleIPCIDR6, RuleSrcIPCIDR:
+		_, r.ipNet, err = net.ParseCIDR(r.Payload)
+	case RuleDstPort:
+		r.portFrom, r.portTo, err = parsePortRange(r.Payload)
+	case RuleUser:
+	default:
+		return nil, fmt.Errorf("invalid rule %q, unknown type %s", line, r.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %q, %w", line, err)
+	}
+	return r, nil
+}
+
+// ParseRules 解析规则列表, 一行一条, #之后为注释
+func ParseRules(rd io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+
+	scanner := bufio.NewScanner(rd)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		r, err := ParseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// Match 规则是否匹配连接
+func (sf *Rule) Match(m *Metadata, resolver Resolver) bool {
+	host, port := splitHostPort(m.Dst)
+	switch sf.Type {
+	case RuleDomain:
+		return host == sf.Payload
+	case RuleDomainSuffix:
+		return host == sf.Payload || strings.HasSuffix(host, "."+sf.Payload)
+	case RuleDomainKeyword:
+		return strings.Contains(host, sf.Payload)
+	case RuleDomainRegex:
+		return sf.regex.MatchString(host)
+	case RuleIPCIDR, RuleIPCIDR6:
+		ip := m.resolveDst(host, sf.NoResolve, resolver)
+		return ip != nil && sf.ipNet.Contains(ip)
+	case RuleSrcIPCIDR:
+		srcHost, _ := splitHostPort(m.Src)
+		ip := net.ParseIP(srcHost)
+		return ip != nil && sf.ipNet.Contains(ip)
+	case RuleDstPort:
+		p, err := strconv.Atoi(port)
+		return err == nil && p >= sf.portFrom && p <= sf.portTo
+	case RuleUser:
+		return m.User == sf.Payload
+	case RuleMatch:
+		return true
+	}
+	return false
+}
+
+// String 规则文本
+func (sf *Rule) String() string {
+	if sf.Type == RuleMatch {
+		return fmt.Sprintf("%s,%s", sf.Type, sf.Action)
+	}
+	s := fmt.Sprintf("%s,%s,%s", sf.Type, sf.Payload, sf.Action)
+	if sf.NoResolve {
+		s += ",no-resolve"
+	}
+	return s
+}
+
+// LoadRuleFile 加载规则文件, 替换已有规则, 返回规则条数, 文件不存在时清空规则,
+// 设置了 WithRuleGroups 时检查规则动作的父级组是否存在
+func (sf *Filter) LoadRuleFile(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			sf.SetRules(nil)
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	rules, err := ParseRules(f)
+	if err != nil {
+		return 0, err
+	}
+	if sf.ruleGroups != nil {
+		for _, r := range rules {
+			if _, ok := sf.ruleGroups[string(r.Action)]; r.Action.IsGroup() && !ok {
+				return 0, fmt.Errorf("rule %q, parent group %s not found", r, r.Action)
+			}
+		}
+	}
+	sf.SetRules(rules)
+	return len(rules), nil
+}
+
+// SetRules 设置规则, 按顺序匹配
+func (sf *Filter) SetRules(rules []*Rule) {
+	sf.rules.Store(rules)
+}
+
+// Rules 获取规则
+func (sf *Filter) Rules() []*Rule {
+	rules, _ := sf.rules.Load().([]*Rule)
+	return rules
+}
+
+// MatchRule 按顺序匹配规则, 返回第一条匹配规则的动作, 无规则匹配时返回false,
+// 调用方应回退到代理表, 直连表和智能判断
+func (sf *Filter) MatchRule(m *Metadata) (Action, bool) {
+	for _, r := range sf.Rules() {
+		if r.Match(m, sf.resolver) {
+			return r.Action, true
+		}
+	}
+	return "", false
+}
+
+// resolveDst 获取目标ip, 目标为域名时仅解析一次
+func (sf *Metadata) resolveDst(host string, noResolve bool, resolver Resolver) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	if noResolve {
+		return nil
+	}
+	if !sf.resolved {
+		sf.resolved = true
+		if ips, err := resolver.LookupIP(host); err == nil && len(ips) > 0 {
+			sf.dstIP = ips[0]
+		}
+	}
+	return sf.dstIP
+}
+
+// sysResolver 系统域名解析
+type sysResolver struct{}
+
+func (sysResolver) LookupIP(host string) ([]net.IP, error) { return net.LookupIP(host) }
+
+func parseAction(s string) Action {
+	switch a := Action(strings.ToUpper(s)); a {
+	case ActionProxy, ActionDirect, ActionReject:
+		return a
+	}
+	return Action(s)
+}
+
+func parsePortRange(s string) (from, to int, err error) {
+	ports := strings.SplitN(s, "-", 2)
+	if from, err = strconv.Atoi(strings.TrimSpace(ports[0])); err != nil {
+		return
+	}
+	to = from
+	if len(ports) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(ports[1])); err != nil {
+			return
+		}
+	}
+	if from < 0 || to > 65535 || from > to {
+		err = fmt.Errorf("invalid port range %s", s)
+	}
+	return
+}
+
+func splitHostPort(addr string) (host, port string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port
+}
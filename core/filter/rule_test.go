@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockResolver map[string]string
+
+func (sf mockResolver) LookupIP(host string) ([]net.IP, error) {
+	if ip, ok := sf[host]; ok {
+		return []net.IP{net.ParseIP(ip)}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+const testRules = `
+# comment
+DOMAIN,www.example.com,DIRECT
+DOMAIN-SUFFIX,google.com,PROXY   # inline comment
+DOMAIN-KEYWORD,ads,REJECT
+DOMAIN-REGEX,^video[0-9]+\.cdn\.net$,streaming
+USER,alice,vip
+SRC-IP-CIDR,192.168.100.0/24,REJECT
+IP-CIDR,10.0.0.0/8,DIRECT
+IP-CIDR6,fd00::/8,DIRECT
+IP-CIDR,172.16.0.0/12,DIRECT,no-resolve
+DST-PORT,22,DIRECT
+DST-PORT,8000-8100,proxy
+MATCH,streaming
+`
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+	require.Len(t, rules, 12)
+	assert.Equal(t, "DOMAIN-SUFFIX,google.com,PROXY", rules[1].String())
+	assert.Equal(t, "IP-CIDR,172.16.0.0/12,DIRECT,no-resolve", rules[8].String())
+	assert.Equal(t, ActionProxy, rules[10].Action)
+	assert.Equal(t, "MATCH,streaming", rules[11].String())
+	assert.True(t, rules[11].Action.IsGroup())
+	assert.False(t, ActionReject.IsGroup())
+
+	for _, line := range []string{
+		"DOMAIN,example.com",
+		"MATCH",
+		"UNKNOWN,example.com,PROXY",
+		"DOMAIN-REGEX,(,PROXY",
+		"IP-CIDR,10.0.0.1,PROXY",
+		"DST-PORT,80-70,PROXY",
+		"DST-PORT,http,PROXY",
+		"IP-CIDR,10.0.0.0/8,PROXY,resolve",
+	} {
+		_, err := ParseRule(line)
+		assert.Error(t, err, line)
+	}
+	_, err = ParseRules(strings.NewReader("DOMAIN,a.com,PROXY\nDOMAIN,b.com"))
+	assert.EqualError(t, err, `line 2: invalid rule "DOMAIN,b.com", should be like TYPE,PAYLOAD,ACTION[,no-resolve]`)
+}
+
+func TestFilter_MatchRule(t *testing.T) {
+	f := New("intelligent", WithLivenessPeriod(0), WithResolver(mockResolver{
+		"intranet.corp":   "10.1.1.1",
+		"intranet6.corp":  "fd00::1",
+		"private.example": "172.16.1.1",
+	}))
+	defer f.Close()
+
+	_, ok := f.MatchRule(&Metadata{Dst: "www.google.com:443"})
+	assert.False(t, ok)
+
+	rules, err := ParseRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+	f.SetRules(rules)
+
+	tests := []struct {
+		m    Metadata
+		want Action
+	}{
+		{Metadata{Dst: "www.example.com:443"}, ActionDirect},
+		{Metadata{Dst: "WWW.Example.COM.:443"}, ActionDirect},
+		{Metadata{Dst: "example.com:443"}, "streaming"},
+		{Metadata{Dst: "google.com:443"}, ActionProxy},
+		{Metadata{Dst: "mail.google.com:443"}, ActionProxy},
+		{Metadata{Dst: "notgoogle.com:443"}, "streaming"},
+		{Metadata{Dst: "ads.tracker.net:80"}, ActionReject},
+		{Metadata{Dst: "video12.cdn.net:443"}, "streaming"},
+		{Metadata{Dst: "example.org:443", User: "alice"}, "vip"},
+		{Metadata{Src: "192.168.100.2:5000", Dst: "example.org:443"}, ActionReject},
+		{Metadata{Dst: "10.2.3.4:443"}, ActionDirect},
+		{Metadata{Dst: "intranet.corp:443"}, ActionDirect},
+		{Metadata{Dst: "[fd00::2]:443"}, ActionDirect},
+		{Metadata{Dst: "intranet6.corp:443"}, ActionDirect},
+		{Metadata{Dst: "172.16.0.1:443"}, ActionDirect},
+		{Metadata{Dst: "private.example:443"}, "streaming"}, // no-resolve
+		{Metadata{Dst: "unknown.host:22"}, ActionDirect},
+		{Metadata{Dst: "unknown.host:8080"}, ActionProxy},
+		{Metadata{Dst: "unknown.host:8101"}, "streaming"},
+	}
+	for _, tt := range tests {
+		m := tt.m
+		got, ok := f.MatchRule(&m)
+		assert.True(t, ok, tt.m.Dst)
+		assert.Equal(t, tt.want, got, tt.m.Dst)
+	}
+}
+
+func TestFilter_LoadRuleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	f := New("intelligent", WithLivenessPeriod(0))
+	defer f.Close()
+
+	n, err := f.LoadRuleFile(filepath.Join(dir, "none"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	filename := filepath.Join(dir, "rules")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(testRules), 0644))
+	n, err = f.LoadRuleFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	assert.Len(t, f.Rules(), 12)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("DOMAIN,a.com"), 0644))
+	_, err = f.LoadRuleFile(filename)
+	assert.Error(t, err)
+	assert.Len(t, f.Rules(), 12)
+
+	// 文件删除后清空规则
+	require.NoError(t, os.Remove(filename))
+	n, err = f.LoadRuleFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, f.Rules(), 0)
+
+	// 检查父级组
+	require.NoError(t, ioutil.WriteFile(filename, []byte(testRules), 0644))
+	g := New("intelligent", WithLivenessPeriod(0), WithRuleGroups(map[string][]string{"streaming": {"1.1.1.1:80"}}))
+	defer g.Close()
+	_, err = g.LoadRuleFile(filename)
+	assert.Error(t, err)
+	assert.Len(t, g.Rules(), 0)
+	g = New("intelligent", WithLivenessPeriod(0), WithRuleGroups(map[string][]string{
+		"streaming": {"1.1.1.1:80"},
+		"vip":       {"2.2.2.2:80"},
+	}))
+	defer g.Close()
+	n, err = g.LoadRuleFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+}
@@ -0,0 +1,140 @@
+package filter
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// domainTrie 域名反向标签树, 如 www.google.com 存储为 com -> google -> www.
+// 构建后只读, 可并发读, 匹配时不分配内存
+type domainTrie struct {
+	root *trieNode
+	keys []string // 全部域名, 按添加顺序
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	end      bool // 到此节点为一个完整域名
+}
+
+// newDomainTrie 构建反向标签树, 忽略空域名和重复域名
+func newDomainTrie(domains []string) *domainTrie {
+	t := &domainTrie{root: &trieNode{}, keys: make([]string, 0, len(domains))}
+	for _, domain := range domains {
+		if domain != "" && t.insert(domain) {
+			t.keys = append(t.keys, domain)
+		}
+	}
+	return t
+}
+
+// insert 插入域名, 已存在时返回false
+func (sf *domainTrie) insert(domain string) bool {
+	node := sf.root
+	for end := len(domain); end >= 0; {
+		start := strings.LastIndexByte(domain[:end], '.')
+		label := domain[start+1 : end]
+		child, ok := node.children[label]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			child = &trieNode{}
+			node.children[label] = child
+		}
+		node = child
+		end = start
+	}
+	if node.end {
+		return false
+	}
+	node.end = true
+	return true
+}
+
+// walk 从顶级标签开始逐级匹配, suffix为true时任一后缀为完整域名即匹配, 否则需完全匹配
+func (sf *domainTrie) walk(domain string, suffix bool) bool {
+	node := sf.root
+	for end := len(domain); end >= 0; {
+		start := strings.LastIndexByte(domain[:end], '.')
+		node = node.children[domain[start+1:end]]
+		if node == nil {
+			return false
+		}
+		if suffix && node.end {
+			return true
+		}
+		end = start
+	}
+	return node.end
+}
+
+// has 域名是否在树中, 完全匹配
+func (sf *domainTrie) has(domain string) bool {
+	return domain != "" && sf.walk(domain, false)
+}
+
+// match 后缀型倒序匹配, 域名或其任一上级域名在树中则匹配, 单级域名(如 localhost)不匹配
+func (sf *domainTrie) match(domain string) bool {
+	if strings.IndexByte(domain, '.') < 0 {
+		return false
+	}
+	return sf.walk(domain, true)
+}
+
+// domainSet 域名表, 读取无锁, 写入时重建反向标签树并原子替换
+type domainSet struct {
+	mu   sync.Mutex   // 串行化写入
+	trie atomic.Value // *domainTrie
+}
+
+func newDomainSet(domains ...string) *domainSet {
+	s := &domainSet{}
+	s.trie.Store(newDomainTrie(domains))
+	return s
+}
+
+func (sf *domainSet) load() *domainTrie {
+	return sf.trie.Load().(*domainTrie)
+}
+
+// Add 添加域名, 批量添加只重建一次
+func (sf *domainSet) Add(domains ...string) {
+	if len(domains) == 0 {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	old := sf.load()
+	sf.trie.Store(newDomainTrie(append(append(make([]string, 0, len(old.keys)+len(domains)), old.keys...), domains...)))
+}
+
+// Replace 替换全部域名, 返回原树
+func (sf *domainSet) Replace(t *domainTrie) *domainTrie {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	old := sf.load()
+	sf.trie.Store(t)
+	return old
+}
+
+// Has 域名是否在表中, 完全匹配
+func (sf *domainSet) Has(domain string) bool {
+	return sf.load().has(domain)
+}
+
+// Match 后缀型倒序匹配, 见 domainTrie.match
+func (sf *domainSet) Match(domain string) bool {
+	return sf.load().match(domain)
+}
+
+// Count 域名数
+func (sf *domainSet) Count() int {
+	return len(sf.load().keys)
+}
+
+// Keys 全部域名
+func (sf *domainSet) Keys() []string {
+	return append([]string{}, sf.load().keys...)
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	cmap "github.com/orcaman/concurrent-map"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainTrie(t *testing.T) {
+	tr := newDomainTrie([]string{"google.com", "www.baidu.com", "cn", "google.com", ""})
+	assert.Equal(t, []string{"google.com", "www.baidu.com", "cn"}, tr.keys)
+
+	for _, tt := range []struct {
+		domain string
+		match  bool
+		has    bool
+	}{
+		{"google.com", true, true},
+		{"www.google.com", true, false},
+		{"a.b.google.com", true, false},
+		{"oogle.com", false, false},
+		{"com", false, false},
+		{"baidu.com", false, false},
+		{"www.baidu.com", true, true},
+		{"img.www.baidu.com", true, false},
+		{"example.cn", true, false},
+		{"cn", false, true},
+		{"localhost", false, false},
+		{"", false, false},
+	} {
+		assert.Equal(t, tt.match, tr.match(tt.domain), tt.domain)
+		assert.Equal(t, tt.has, tr.has(tt.domain), tt.domain)
+	}
+}
+
+func TestDomainSet(t *testing.T) {
+	s := newDomainSet("foo.com")
+	s.Add("bar.com", "foo.com")
+	assert.Equal(t, 2, s.Count())
+	assert.True(t, s.Match("a.bar.com"))
+	assert.True(t, s.Has("foo.com"))
+
+	old := s.Replace(newDomainTrie([]string{"qux.com"}))
+	assert.Equal(t, []string{"foo.com", "bar.com"}, old.keys)
+	assert.Equal(t, []string{"qux.com"}, s.Keys())
+	assert.False(t, s.Match("foo.com"))
+
+	// 并发读写
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Add(fmt.Sprintf("d%d-%d.com", i, j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.Match("www.qux.com")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 401, s.Count())
+}
+
+// benchDomains 生成n个域名, 形如 d123.example0.com
+func benchDomains(n int) []string {
+	tlds := []string{"com", "net", "org", "cn", "io"}
+	domains := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		domains = append(domains, fmt.Sprintf("d%d.example%d.%s", i, i%100, tlds[i%len(tlds)]))
+	}
+	return domains
+}
+
+var benchHosts = []string{
+	"www.d100.example0.com",      // 匹配上级域名
+	"d25001.example1.net",        // 完全匹配
+	"a.b.c.d.notfound.org",       // 不匹配
+	"img.static.notfound.com.cn", // 不匹配
+}
+
+// matchCmap 原实现: 拆分域名后逐级拼接后缀查询 cmap
+func matchCmap(tb cmap.ConcurrentMap, domain string) bool {
+	hnSlice := strings.Split(hostname(domain), ".")
+	if len(hnSlice) <= 1 {
+		return false
+	}
+	for i := len(hnSlice) - 1; i >= 0; i-- {
+		if tb.Has(strings.Join(hnSlice[i:], ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func BenchmarkMatch_Cmap(b *testing.B) {
+	tb := cmap.New()
+	for _, domain := range benchDomains(50000) {
+		tb.Set(domain, struct{}{})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matchCmap(tb, benchHosts[i%len(benchHosts)])
+	}
+}
+
+func BenchmarkMatch_Trie(b *testing.B) {
+	s := newDomainSet(benchDomains(50000)...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Match(benchHosts[i%len(benchHosts)])
+	}
+}
+
+func BenchmarkMatch_TrieParallel(b *testing.B) {
+	s := newDomainSet(benchDomains(50000)...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			s.Match(benchHosts[i%len(benchHosts)])
+		}
+	})
+}
+
+func BenchmarkNewDomainTrie(b *testing.B) {
+	domains := benchDomains(50000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newDomainTrie(domains)
+	}
+}
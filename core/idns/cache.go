@@ -0,0 +1,62 @@
+package idns
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache 有容量上限的lru缓存, 容量 <= 0 时不限制
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	item *Item
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get 获取条目, 并标记为最近使用
+func (sf *lruCache) Get(key string) (*Item, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if e, ok := sf.items[key]; ok {
+		sf.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).item, true
+	}
+	return nil, false
+}
+
+// Set 设置条目, 超出容量时淘汰最久未使用的条目
+func (sf *lruCache) Set(key string, item *Item) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if e, ok := sf.items[key]; ok {
+		sf.ll.MoveToFront(e)
+		e.Value.(*lruEntry).item = item
+		return
+	}
+	sf.items[key] = sf.ll.PushFront(&lruEntry{key, item})
+	if sf.capacity > 0 && sf.ll.Len() > sf.capacity {
+		e := sf.ll.Back()
+		sf.ll.Remove(e)
+		delete(sf.items, e.Value.(*lruEntry).key)
+	}
+}
+
+// Len 条目数
+func (sf *lruCache) Len() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.ll.Len()
+}
@@ -0,0 +1,123 @@
+package idns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Hosts 静态域名映射, 支持通配符
+// 精确匹配优先, 其次为最长的通配符后缀匹配
+// 通配符 *.example.com 匹配 example.com 的任意级子域名, 但不匹配 example.com 本身
+type Hosts struct {
+	mu       sync.RWMutex
+	exact    map[string][]net.IP // domain --> ips
+	wildcard map[string][]net.IP // 通配符去掉"*."后的后缀 --> ips
+}
+
+// NewHosts 创建空的静态域名映射
+func NewHosts() *Hosts {
+	return &Hosts{
+		exact:    make(map[string][]net.IP),
+		wildcard: make(map[string][]net.IP),
+	}
+}
+
+// LoadHostsFile 从hosts格式的文件加载静态域名映射
+func LoadHostsFile(filename string) (*Hosts, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := NewHosts()
+	if err = h.Load(f); err != nil {
+		return nil, fmt.Errorf("load hosts file %s, %w", filename, err)
+	}
+	return h, nil
+}
+
+// Load 加载hosts格式的映射, 每行格式: ip domain [domain...], #之后为注释
+func (sf *Hosts) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			return fmt.Errorf("line %d: invalid hosts record %q", line, scanner.Text())
+		}
+		if err := sf.Add(ip, fields[1:]...); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Add 增加ip到域名的映射, 域名可为通配符, 如: *.example.com
+func (sf *Hosts) Add(ip net.IP, domains ...string) error {
+	if ip == nil {
+		return fmt.Errorf("invalid hosts ip")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, domain := range domains {
+		domain = normalizeDomain(domain)
+		m := sf.exact
+		if strings.HasPrefix(domain, "*.") {
+			domain, m = domain[2:], sf.wildcard
+		}
+		if domain == "" || strings.Contains(domain, "*") {
+			return fmt.Errorf("invalid hosts domain %q", domain)
+		}
+		m[domain] = append(m[domain], ip)
+	}
+	return nil
+}
+
+// Lookup 查询域名的静态映射
+func (sf *Hosts) Lookup(domain string) ([]net.IP, bool) {
+	if sf == nil {
+		return nil, false
+	}
+	domain = normalizeDomain(domain)
+
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	if ips, ok := sf.exact[domain]; ok {
+		return ips, true
+	}
+	for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if ips, ok := sf.wildcard[domain]; ok {
+			return ips, true
+		}
+	}
+	return nil, false
+}
+
+// Len 映射条目数
+func (sf *Hosts) Len() int {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return len(sf.exact) + len(sf.wildcard)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
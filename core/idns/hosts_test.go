@@ -0,0 +1,75 @@
+package idns
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHosts(t *testing.T) {
+	h := NewHosts()
+	err := h.Load(strings.NewReader(`
+# comment
+127.0.0.1   localhost
+10.0.0.1    example.com  www.example.com # inline comment
+10.0.0.2    *.example.com
+10.0.0.3    *.a.example.com
+fd00::1     Example.COM.
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 5, h.Len())
+
+	tests := []struct {
+		domain string
+		want   []string
+	}{
+		{"localhost", []string{"127.0.0.1"}},
+		{"example.com", []string{"10.0.0.1", "fd00::1"}},
+		{"www.example.com", []string{"10.0.0.1"}},
+		{"b.example.com", []string{"10.0.0.2"}},
+		{"a.example.com", []string{"10.0.0.2"}},
+		{"x.y.a.example.com.", []string{"10.0.0.3"}},
+		{"example.org", nil},
+	}
+	for _, tt := range tests {
+		ips, ok := h.Lookup(tt.domain)
+		assert.Equal(t, tt.want != nil, ok, tt.domain)
+		if ok {
+			assert.Equal(t, tt.want, ipStrings(ips), tt.domain)
+		}
+	}
+
+	var nilHosts *Hosts
+	_, ok := nilHosts.Lookup("example.com")
+	assert.False(t, ok)
+}
+
+func TestHosts_Invalid(t *testing.T) {
+	for _, s := range []string{"10.0.0.1", "example.com 10.0.0.1", "10.0.0.1 a.*.com"} {
+		assert.Error(t, NewHosts().Load(strings.NewReader(s)), s)
+	}
+	assert.Error(t, NewHosts().Add(nil, "example.com"))
+}
+
+func TestLoadHostsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "hosts")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("192.168.1.1 router.lan\n"), 0644))
+	h, err := LoadHostsFile(filename)
+	require.NoError(t, err)
+	ips, ok := h.Lookup("router.lan")
+	require.True(t, ok)
+	assert.True(t, ips[0].Equal(net.ParseIP("192.168.1.1")))
+
+	_, err = LoadHostsFile(filepath.Join(dir, "none"))
+	assert.Error(t, err)
+}
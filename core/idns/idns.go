@@ -2,38 +2,95 @@
 package idns
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
-	cmap "github.com/orcaman/concurrent-map"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// 默认配置
+const (
+	DefaultTimeout     = time.Second * 5 // 单个上游查询默认超时时间
+	DefaultNegativeTTL = 30              // 否定缓存默认时间, 单位: 秒
+	DefaultCacheSize   = 4096            // 缓存默认最大条目数
+)
+
+// prefetchHits 条目在有效期内至少命中的次数, 达到才被认为是热点条目进行预取
+const prefetchHits = 2
+
+// lookup error defined
+var (
+	ErrNoAddress = errors.New("no address")     // 域名无可用地址
+	ErrNXDomain  = errors.New("no such host")   // 域名不存在
+	ErrServFail  = errors.New("server failure") // 上游服务器失败
 )
 
 // Resolver 本地 dns 解析服务
 type Resolver struct {
-	publicDNSAddr string             // 外部dns地址
-	ttl           int                // 单位: 秒
-	cache         cmap.ConcurrentMap // 缓存 domain --> Item
+	publicDNSAddr string                   // 外部dns地址
+	addrs         []string                 // 上游dns地址
+	upstreams     []Upstream               // 上游dns, 按顺序故障转移
+	dialer        connection.ContextDialer // 上游连接拨号器, nil 使用直连
+	ttl           int                      // 缓存最长时间, 实际为min(记录ttl, ttl), <= 0 时仅使用记录ttl, 单位: 秒
+	negativeTTL   int                      // 否定缓存时间, <= 0 时不缓存失败结果, 单位: 秒
+	cacheSize     int                      // 缓存最大条目数, <= 0 时不限制
+	prefetch      bool                     // 热点条目过期前预取
+	prefer        Prefer                   // 地址族偏好
+	race          bool                     // 并发查询所有上游
+	timeout       time.Duration            // 单个上游查询超时时间
+	hosts         *Hosts                   // 静态域名映射, 优先于上游查询
+	cache         *lruCache                // 缓存 domain --> Item
+	group         singleflight.Group       // 合并同一域名的并发查询
 }
 
 // Item 缓存条目
 type Item struct {
-	ip        string // ip地址
-	expiredAt int64  // 过期时间,unix时间
+	ips         []net.IP     // ip地址, 按地址族偏好排序
+	err         error        // 否定缓存的查询错误
+	ttl         uint32       // 缓存时长, 单位: 秒
+	expiredAt   int64        // 过期时间,unix时间
+	hits        atomic.Int32 // 命中次数
+	prefetching atomic.Bool  // 是否正在预取
 }
 
-// New 创建一个本地dns服务,提供公共dns地址和缓存ttl超时时间,单位s
-func New(publicDNSAddr string, ttl int) *Resolver {
-	return &Resolver{
-		publicDNSAddr,
-		ttl,
-		cmap.New(),
+// New 创建一个本地dns服务,提供公共dns地址和缓存ttl最长时间,单位s
+// publicDNSAddr 可为逗号分隔的多个地址, 地址格式见 NewUpstream, 无效的地址在查询时返回错误
+func New(publicDNSAddr string, ttl int, opts ...Option) *Resolver {
+	r := &Resolver{
+		publicDNSAddr: publicDNSAddr,
+		addrs:         strings.Split(publicDNSAddr, ","),
+		ttl:           ttl,
+		negativeTTL:   DefaultNegativeTTL,
+		cacheSize:     DefaultCacheSize,
+		timeout:       DefaultTimeout,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.cache = newLRUCache(r.cacheSize)
+	for _, addr := range r.addrs {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		u, err := NewUpstream(addr, r.dialer)
+		if err != nil {
+			u = &errUpstream{addr, err}
+		}
+		r.upstreams = append(r.upstreams, u)
+	}
+	return r
 }
 
-// TTL 获取缓存条目超时时间,单位秒
+// TTL 获取缓存条目最长超时时间,单位秒
 func (sf *Resolver) TTL() int {
 	return sf.ttl
 }
@@ -43,6 +100,15 @@ func (sf *Resolver) PublicDNSAddr() string {
 	return sf.publicDNSAddr
 }
 
+// Upstreams 获取所有上游dns地址
+func (sf *Resolver) Upstreams() []string {
+	addrs := make([]string, 0, len(sf.upstreams))
+	for _, u := range sf.upstreams {
+		addrs = append(addrs, u.Address())
+	}
+	return addrs
+}
+
 // MustResolve 域名解析,如果地址无法解析,将返回输入值
 func (sf *Resolver) MustResolve(address string) string {
 	ip, err := sf.Resolve(address)
@@ -52,7 +118,7 @@ func (sf *Resolver) MustResolve(address string) string {
 	return ip
 }
 
-// Resolve 域名解析,返回ip地址,返回格式由请求的格式决定
+// Resolve 域名解析,返回地址族偏好的第一个ip地址,返回格式由请求的格式决定
 // domain: 域名:port -> ip:port
 // domain: 域名 -> ip
 // domain: ip -> ip
@@ -62,7 +128,7 @@ func (sf *Resolver) Resolve(domain string) (string, error) {
 	var port string
 
 	dstDomain := domain
-	if strings.Contains(domain, ":") {
+	if strings.Contains(domain, ":") && net.ParseIP(domain) == nil {
 		if dstDomain, port, err = net.SplitHostPort(domain); err != nil {
 			return "", err
 		}
@@ -73,47 +139,292 @@ func (sf *Resolver) Resolve(domain string) (string, error) {
 		return joinIPPort(dstDomain, port), nil
 	}
 
-	var itm *Item
+	ips, err := sf.LookupIP(dstDomain)
+	if err != nil {
+		return "", err
+	}
+	return joinIPPort(ips[0].String(), port), nil
+}
+
+// LookupIP 查询域名的所有ip地址, 按地址族偏好排序
+func (sf *Resolver) LookupIP(host string) ([]net.IP, error) {
+	return sf.LookupIPContext(context.Background(), host)
+}
+
+// LookupIPContext 查询域名的所有ip地址, 按地址族偏好排序
+func (sf *Resolver) LookupIPContext(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := sf.LookupIPTTL(ctx, host)
+	return ips, err
+}
+
+// LookupIPTTL 查询域名的所有ip地址, 按地址族偏好排序, 同时返回剩余缓存时间, 单位: 秒
+// 查询顺序: 静态映射, 缓存(含否定缓存), 上游, 同一域名的并发查询仅向上游查询一次
+func (sf *Resolver) LookupIPTTL(ctx context.Context, host string) ([]net.IP, uint32, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, 0, nil
+	}
+
+	// 静态映射
+	if ips, ok := sf.hosts.Lookup(host); ok {
+		if ips = sf.preferred(ips); len(ips) == 0 {
+			return nil, 0, fmt.Errorf("lookup %s from hosts, %w", host, ErrNoAddress)
+		}
+		var ttl uint32
+		if sf.ttl > 0 {
+			ttl = uint32(sf.ttl)
+		}
+		return ips, ttl, nil
+	}
+
 	// 查缓存
-	_itm, ok := sf.cache.Get(dstDomain)
-	if ok {
-		itm = _itm.(*Item)
-		if itm.expiredAt > time.Now().Unix() {
-			return joinIPPort(itm.ip, port), nil
+	now := time.Now().Unix()
+	if itm, ok := sf.cache.Get(host); ok && itm.expiredAt > now {
+		remain := uint32(itm.expiredAt - now)
+		if itm.err != nil {
+			return nil, remain, itm.err
+		}
+		sf.tryPrefetch(host, itm, remain)
+		return itm.ips, remain, nil
+	}
+
+	ch := sf.group.DoChan(host, func() (interface{}, error) {
+		return sf.update(host), nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case rs := <-ch:
+		itm := rs.Val.(*Item)
+		if itm.err != nil {
+			return nil, 0, itm.err
+		}
+		return itm.ips, itm.ttl, nil
+	}
+}
+
+// update 向上游查询并更新缓存, 域名不存在, 无地址或上游服务器失败时按否定缓存时间缓存失败结果
+func (sf *Resolver) update(host string) *Item {
+	ips, ttl, err := sf.lookup(context.Background(), host)
+	if err != nil {
+		itm := &Item{err: err}
+		if sf.negativeTTL > 0 &&
+			(errors.Is(err, ErrNXDomain) || errors.Is(err, ErrNoAddress) || errors.Is(err, ErrServFail)) {
+			itm.ttl = uint32(sf.negativeTTL)
+			itm.expiredAt = time.Now().Unix() + int64(itm.ttl)
+			sf.cache.Set(host, itm)
 		}
-	} else {
-		itm = &Item{}
+		return itm
 	}
+	if sf.ttl > 0 && ttl > uint32(sf.ttl) {
+		ttl = uint32(sf.ttl)
+	}
+	itm := &Item{ips: ips, ttl: ttl, expiredAt: time.Now().Unix() + int64(ttl)}
+	sf.cache.Set(host, itm)
+	return itm
+}
 
-	cli := &dns.Client{
-		DialTimeout:  time.Millisecond * 5000,
-		ReadTimeout:  time.Millisecond * 5000,
-		WriteTimeout: time.Millisecond * 5000,
+// tryPrefetch 热点条目剩余缓存时间不足十分之一(至少1秒)时, 后台提前刷新, 同一条目同时仅有一个预取
+func (sf *Resolver) tryPrefetch(host string, itm *Item, remain uint32) {
+	if !sf.prefetch || itm.hits.Inc() < prefetchHits {
+		return
+	}
+	threshold := itm.ttl / 10
+	if threshold < 1 {
+		threshold = 1
 	}
+	if remain > threshold || !itm.prefetching.CAS(false, true) {
+		return
+	}
+	go func() {
+		// 预取失败时条目未被替换, 复位以便下次命中时重试
+		defer itm.prefetching.Store(false)
+		sf.group.Do(host, func() (interface{}, error) { // nolint: errcheck
+			return sf.update(host), nil
+		})
+	}()
+}
 
+// preferred 按地址族偏好过滤并排序
+func (sf *Resolver) preferred(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch sf.prefer {
+	case PreferIPv6:
+		return append(v6, v4...)
+	case IPv4Only:
+		return v4
+	case IPv6Only:
+		return v6
+	default:
+		return append(v4, v6...)
+	}
+}
+
+// Exchange 转发请求到上游, 按顺序故障转移或并发竞速, 不使用缓存
+func (sf *Resolver) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	r, _, err := sf.exchange(ctx, msg)
+	return r, err
+}
+
+// lookup 按地址族偏好查询, 返回合并后的地址和最小记录ttl
+func (sf *Resolver) lookup(ctx context.Context, host string) ([]net.IP, uint32, error) {
+	var qTypes []uint16
+
+	switch sf.prefer {
+	case PreferIPv6:
+		qTypes = []uint16{dns.TypeAAAA, dns.TypeA}
+	case IPv4Only:
+		qTypes = []uint16{dns.TypeA}
+	case IPv6Only:
+		qTypes = []uint16{dns.TypeAAAA}
+	default:
+		qTypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	type result struct {
+		ips []net.IP
+		ttl uint32
+		err error
+	}
+	results := make([]result, len(qTypes))
+	wg := sync.WaitGroup{}
+	for i, qType := range qTypes {
+		wg.Add(1)
+		go func(i int, qType uint16) {
+			defer wg.Done()
+			results[i].ips, results[i].ttl, results[i].err = sf.query(ctx, host, qType)
+		}(i, qType)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	var ttl uint32
+	var err error
+	for _, rs := range results {
+		if rs.err != nil {
+			if err == nil {
+				err = rs.err
+			}
+			continue
+		}
+		if len(rs.ips) == 0 {
+			continue
+		}
+		if len(ips) == 0 || rs.ttl < ttl {
+			ttl = rs.ttl
+		}
+		ips = append(ips, rs.ips...)
+	}
+	if len(ips) == 0 {
+		if err == nil {
+			err = fmt.Errorf("lookup %s, %w", host, ErrNoAddress)
+		}
+		return nil, 0, err
+	}
+	return ips, ttl, nil
+}
+
+// query 查询一种类型的记录, 返回地址和最小记录ttl
+func (sf *Resolver) query(ctx context.Context, host string, qType uint16) ([]net.IP, uint32, error) {
 	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(dstDomain), dns.TypeA)
+	msg.SetQuestion(dns.Fqdn(host), qType)
 	msg.RecursionDesired = true
-	r, _, err := cli.Exchange(msg, sf.publicDNSAddr)
-	if err != nil || r == nil {
-		return "", err
+
+	r, upstream, err := sf.exchange(ctx, msg)
+	if err != nil {
+		return nil, 0, err
 	}
-	if r.Rcode != dns.RcodeSuccess {
-		return "", fmt.Errorf("invalid answer name %s after A query for %s", dstDomain, sf.publicDNSAddr)
+	if r.Rcode == dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("lookup %s from %s, %w", host, upstream, ErrNXDomain)
 	}
 
+	var ips []net.IP
+	var ttl uint32
 	for _, answer := range r.Answer {
-		if answer.Header().Rrtype == dns.TypeA {
-			info := strings.Fields(answer.String())
-			if len(info) >= 5 {
-				itm.ip = info[4]
-				itm.expiredAt = time.Now().Unix() + int64(sf.ttl)
-				sf.cache.Set(dstDomain, itm)
-				return joinIPPort(info[4], port), nil
+		var ip net.IP
+		switch rr := answer.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		// 忽略CNAME等其它记录
+		if ip == nil || answer.Header().Rrtype != qType {
+			continue
+		}
+		if len(ips) == 0 || answer.Header().Ttl < ttl {
+			ttl = answer.Header().Ttl
+		}
+		ips = append(ips, ip)
+	}
+	return ips, ttl, nil
+}
+
+// exchange 向上游发送请求, 按顺序故障转移或并发竞速, 返回应答及应答的上游地址
+func (sf *Resolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, string, error) {
+	if len(sf.upstreams) == 0 {
+		return nil, "", errors.New("no upstream dns server")
+	}
+
+	if !sf.race || len(sf.upstreams) == 1 {
+		var err error
+		for _, upstream := range sf.upstreams {
+			var r *dns.Msg
+			if r, err = sf.exchangeOne(ctx, msg, upstream); err == nil {
+				return r, upstream.Address(), nil
 			}
 		}
+		return nil, "", err
+	}
+
+	type result struct {
+		r        *dns.Msg
+		upstream string
+		err      error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan result, len(sf.upstreams))
+	for _, upstream := range sf.upstreams {
+		go func(upstream Upstream) {
+			r, err := sf.exchangeOne(ctx, msg.Copy(), upstream)
+			ch <- result{r, upstream.Address(), err}
+		}(upstream)
+	}
+	var err error
+	for range sf.upstreams {
+		rs := <-ch
+		if rs.err == nil {
+			return rs.r, rs.upstream, nil
+		}
+		err = rs.err
+	}
+	return nil, "", err
+}
+
+// exchangeOne 向一个上游发送请求, 仅成功或域名不存在的应答被认为是有效的
+func (sf *Resolver) exchangeOne(ctx context.Context, msg *dns.Msg, upstream Upstream) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, sf.timeout)
+	defer cancel()
+
+	r, err := upstream.Exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	case dns.RcodeServerFailure:
+		return nil, fmt.Errorf("upstream %s response, %w", upstream.Address(), ErrServFail)
+	default:
+		return nil, fmt.Errorf("upstream %s response %s", upstream.Address(), dns.RcodeToString[r.Rcode])
 	}
-	return "", fmt.Errorf("unknow answer")
+	return r, nil
 }
 
 func joinIPPort(ip, port string) string {
@@ -1,8 +1,15 @@
 package idns
 
 import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -36,3 +43,307 @@ func TestIdns(t *testing.T) {
 	require.NoError(t, err)
 	t.Logf("resolve domain: %s - %s", domainButIpPort, ip)
 }
+
+// testHandler 返回固定记录
+func testHandler(ttl uint32, rcode int, count *int32) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(count, 1)
+		w.WriteMsg(testReply(req, ttl, rcode)) // nolint: errcheck
+	})
+}
+
+func testReply(req *dns.Msg, ttl uint32, rcode int) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetRcode(req, rcode)
+	q := req.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: ttl}
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = append(m.Answer,
+			&dns.CNAME{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}, Target: q.Name},
+			&dns.A{Hdr: hdr, A: net.ParseIP("10.0.0.1")},
+			&dns.A{Hdr: hdr, A: net.ParseIP("10.0.0.2")})
+	case dns.TypeAAAA:
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("fd00::1")})
+	}
+	return m
+}
+
+// testServer 本地udp dns服务, 返回固定记录
+func testServer(t *testing.T, ttl uint32, rcode int) (string, *int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	count := new(int32)
+	srv := &dns.Server{PacketConn: pc, Handler: testHandler(ttl, rcode, count)}
+	go srv.ActivateAndServe()            // nolint: errcheck
+	t.Cleanup(func() { srv.Shutdown() }) // nolint: errcheck
+	return pc.LocalAddr().String(), count
+}
+
+// deadUpstream 返回一个无响应的上游地址
+func deadUpstream(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	return pc.LocalAddr().String()
+}
+
+func TestResolver_Lookup(t *testing.T) {
+	addr, _ := testServer(t, 60, dns.RcodeSuccess)
+
+	t.Run("prefer ipv4", func(t *testing.T) {
+		r := New(addr, 300)
+		ips, err := r.LookupIP("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "fd00::1"}, ipStrings(ips))
+		ip, err := r.Resolve("example.com:80")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1:80", ip)
+	})
+
+	t.Run("prefer ipv6", func(t *testing.T) {
+		r := New(addr, 300, WithPrefer(PreferIPv6))
+		ip, err := r.Resolve("example.com:80")
+		require.NoError(t, err)
+		assert.Equal(t, "[fd00::1]:80", ip)
+	})
+
+	t.Run("only", func(t *testing.T) {
+		ips, err := New(addr, 300, WithPrefer(IPv4Only)).LookupIP("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, ipStrings(ips))
+		ips, err = New(addr, 300, WithPrefer(IPv6Only)).LookupIP("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"fd00::1"}, ipStrings(ips))
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		r := New(addr, 300)
+		ip, err := r.Resolve("::1")
+		require.NoError(t, err)
+		assert.Equal(t, "::1", ip)
+		ip, err = r.Resolve("[::1]:80")
+		require.NoError(t, err)
+		assert.Equal(t, "[::1]:80", ip)
+	})
+}
+
+func TestResolver_Upstreams(t *testing.T) {
+	addr, _ := testServer(t, 60, dns.RcodeSuccess)
+	failure, _ := testServer(t, 60, dns.RcodeServerFailure)
+
+	t.Run("failover", func(t *testing.T) {
+		r := New(deadUpstream(t)+","+failure, 300,
+			WithUpstreams(addr), WithTimeout(time.Millisecond*200), WithPrefer(IPv4Only))
+		assert.Len(t, r.Upstreams(), 3)
+		ip, err := r.Resolve("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", ip)
+	})
+
+	t.Run("race", func(t *testing.T) {
+		r := New(deadUpstream(t), 300,
+			WithUpstreams(failure, addr), WithRace(true), WithTimeout(time.Second*3), WithPrefer(IPv4Only))
+		start := time.Now()
+		ip, err := r.Resolve("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", ip)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		r := New(failure, 300, WithPrefer(IPv4Only))
+		_, err := r.Resolve("example.com")
+		require.Error(t, err)
+	})
+
+	t.Run("default port", func(t *testing.T) {
+		assert.Equal(t, []string{"223.5.5.5:53", "[2400:3200::1]:53"}, New("223.5.5.5, 2400:3200::1", 300).Upstreams())
+	})
+}
+
+func TestResolver_CacheTTL(t *testing.T) {
+	t.Run("record ttl", func(t *testing.T) {
+		addr, count := testServer(t, 0, dns.RcodeSuccess)
+		r := New(addr, 300, WithPrefer(IPv4Only))
+		_, err := r.LookupIP("example.com")
+		require.NoError(t, err)
+		_, err = r.LookupIP("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(count))
+	})
+
+	t.Run("max ttl", func(t *testing.T) {
+		addr, count := testServer(t, 3600, dns.RcodeSuccess)
+		r := New(addr, 300, WithPrefer(IPv4Only))
+		_, err := r.LookupIP("example.com")
+		require.NoError(t, err)
+		_, err = r.LookupIP("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+
+		v, ok := r.cache.Get("example.com")
+		require.True(t, ok)
+		assert.LessOrEqual(t, v.expiredAt, time.Now().Unix()+300)
+	})
+}
+
+func TestResolver_NegativeCache(t *testing.T) {
+	for _, rcode := range []int{dns.RcodeNameError, dns.RcodeServerFailure} {
+		addr, count := testServer(t, 60, rcode)
+		r := New(addr, 300, WithPrefer(IPv4Only), WithNegativeTTL(60))
+		_, err := r.LookupIP("example.com")
+		require.Error(t, err)
+		_, err2 := r.LookupIP("example.com")
+		require.Error(t, err2)
+		assert.Equal(t, err, err2)
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		addr, count := testServer(t, 60, dns.RcodeNameError)
+		r := New(addr, 300, WithPrefer(IPv4Only), WithNegativeTTL(0))
+		_, err := r.LookupIP("example.com")
+		require.True(t, errors.Is(err, ErrNXDomain))
+		_, err = r.LookupIP("example.com")
+		require.True(t, errors.Is(err, ErrNXDomain))
+		assert.Equal(t, int32(2), atomic.LoadInt32(count))
+	})
+}
+
+func TestResolver_Coalescing(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	count := new(int32)
+	slow := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(count, 1)
+		time.Sleep(time.Millisecond * 100)
+		w.WriteMsg(testReply(req, 60, dns.RcodeSuccess)) // nolint: errcheck
+	})
+	srv := &dns.Server{PacketConn: pc, Handler: slow}
+	go srv.ActivateAndServe()            // nolint: errcheck
+	t.Cleanup(func() { srv.Shutdown() }) // nolint: errcheck
+
+	r := New(pc.LocalAddr().String(), 300, WithPrefer(IPv4Only))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := r.LookupIP("example.com")
+			assert.NoError(t, err)
+			assert.Len(t, ips, 2)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(count))
+}
+
+func TestResolver_Prefetch(t *testing.T) {
+	addr, count := testServer(t, 2, dns.RcodeSuccess)
+	r := New(addr, 300, WithPrefer(IPv4Only), WithPrefetch(true))
+	_, err := r.LookupIP("example.com")
+	require.NoError(t, err)
+
+	// 剩余1秒内的热点条目被预取
+	time.Sleep(time.Millisecond * 1100)
+	for i := 0; i < prefetchHits; i++ {
+		_, err = r.LookupIP("example.com")
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		_, remain, err := r.LookupIPTTL(context.Background(), "example.com")
+		return err == nil && remain > 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, int32(2), atomic.LoadInt32(count))
+}
+
+func TestResolver_PrefetchRetry(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	count := new(int32)
+	// 第二次查询(即首次预取)被拒绝
+	h := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		rcode := dns.RcodeSuccess
+		if atomic.AddInt32(count, 1) == 2 {
+			rcode = dns.RcodeRefused
+		}
+		w.WriteMsg(testReply(req, 60, rcode)) // nolint: errcheck
+	})
+	srv := &dns.Server{PacketConn: pc, Handler: h}
+	go srv.ActivateAndServe()            // nolint: errcheck
+	t.Cleanup(func() { srv.Shutdown() }) // nolint: errcheck
+
+	r := New(pc.LocalAddr().String(), 300, WithPrefer(IPv4Only), WithPrefetch(true))
+	_, err = r.LookupIP("example.com")
+	require.NoError(t, err)
+	itm, ok := r.cache.Get("example.com")
+	require.True(t, ok)
+	itm.expiredAt = time.Now().Unix() + 5
+
+	// 预取失败, 原条目保留
+	for i := 0; i < prefetchHits; i++ {
+		_, err = r.LookupIP("example.com")
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(count) == 2 && !itm.prefetching.Load()
+	}, time.Second, time.Millisecond*10)
+	got, _ := r.cache.Get("example.com")
+	require.True(t, itm == got)
+
+	// 再次命中时重试预取
+	_, err = r.LookupIP("example.com")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, remain, err := r.LookupIPTTL(context.Background(), "example.com")
+		return err == nil && remain > 5
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, int32(3), atomic.LoadInt32(count))
+}
+
+func TestResolver_CacheSize(t *testing.T) {
+	addr, count := testServer(t, 60, dns.RcodeSuccess)
+	r := New(addr, 300, WithPrefer(IPv4Only), WithCacheSize(2))
+	for _, host := range []string{"a.com", "b.com", "a.com", "c.com"} {
+		_, err := r.LookupIP(host)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, r.cache.Len())
+	assert.Equal(t, int32(3), atomic.LoadInt32(count))
+
+	// b.com 最久未使用, 已被淘汰
+	_, err := r.LookupIP("a.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(count))
+	_, err = r.LookupIP("b.com")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(count))
+}
+
+func TestResolver_Hosts(t *testing.T) {
+	addr, count := testServer(t, 60, dns.RcodeSuccess)
+	h := NewHosts()
+	require.NoError(t, h.Add(net.ParseIP("192.168.1.1"), "static.lan"))
+	require.NoError(t, h.Add(net.ParseIP("fd00::9"), "static.lan", "*.static.lan"))
+
+	r := New(addr, 300, WithHosts(h))
+	ips, err := r.LookupIP("static.lan")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.1", "fd00::9"}, ipStrings(ips))
+	ip, err := r.Resolve("a.b.static.lan:80")
+	require.NoError(t, err)
+	assert.Equal(t, "[fd00::9]:80", ip)
+
+	_, err = New(addr, 300, WithHosts(h), WithPrefer(IPv4Only)).LookupIP("x.static.lan")
+	assert.True(t, errors.Is(err, ErrNoAddress))
+	assert.Equal(t, int32(0), atomic.LoadInt32(count))
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return s
+}
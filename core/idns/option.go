@@ -0,0 +1,87 @@
+package idns
+
+import (
+	"time"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// Prefer 地址族偏好
+type Prefer int
+
+// 地址族偏好
+const (
+	PreferIPv4 Prefer = iota // 同时查询A和AAAA, ipv4优先, 默认
+	PreferIPv6               // 同时查询A和AAAA, ipv6优先
+	IPv4Only                 // 仅查询A
+	IPv6Only                 // 仅查询AAAA
+)
+
+// Option 配置选项
+type Option func(*Resolver)
+
+// WithUpstreams 增加上游dns服务器地址, 格式见 NewUpstream
+func WithUpstreams(addrs ...string) Option {
+	return func(r *Resolver) {
+		r.addrs = append(r.addrs, addrs...)
+	}
+}
+
+// WithDialer 上游查询经由dialer建立的连接, 例如经由上级代理 *ccs.Dialer, 此时明文udp使用tcp查询
+func WithDialer(d connection.ContextDialer) Option {
+	return func(r *Resolver) {
+		r.dialer = d
+	}
+}
+
+// WithPrefer 设置地址族偏好, 默认 PreferIPv4
+func WithPrefer(p Prefer) Option {
+	return func(r *Resolver) {
+		r.prefer = p
+	}
+}
+
+// WithRace 是否并发查询所有上游, 取最先返回的有效应答, 默认 false 按顺序故障转移
+func WithRace(b bool) Option {
+	return func(r *Resolver) {
+		r.race = b
+	}
+}
+
+// WithTimeout 设置单个上游查询超时时间, 默认 5s
+func WithTimeout(t time.Duration) Option {
+	return func(r *Resolver) {
+		if t > 0 {
+			r.timeout = t
+		}
+	}
+}
+
+// WithHosts 设置静态域名映射, 优先于缓存和上游查询
+func WithHosts(h *Hosts) Option {
+	return func(r *Resolver) {
+		r.hosts = h
+	}
+}
+
+// WithNegativeTTL 设置否定缓存时间, 域名不存在, 无地址或上游服务器失败的结果缓存该时间,
+// <= 0 时不缓存, 默认 DefaultNegativeTTL, 单位: 秒
+func WithNegativeTTL(ttl int) Option {
+	return func(r *Resolver) {
+		r.negativeTTL = ttl
+	}
+}
+
+// WithCacheSize 设置缓存最大条目数, 超出时淘汰最久未使用的条目, <= 0 时不限制, 默认 DefaultCacheSize
+func WithCacheSize(size int) Option {
+	return func(r *Resolver) {
+		r.cacheSize = size
+	}
+}
+
+// WithPrefetch 是否在热点条目过期前后台预取, 默认 false
+func WithPrefetch(b bool) Option {
+	return func(r *Resolver) {
+		r.prefetch = b
+	}
+}
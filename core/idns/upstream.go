@@ -0,0 +1,291 @@
+package idns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/http2"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// 上游默认端口
+const (
+	DefaultPort    = "53"
+	DefaultTLSPort = "853"
+)
+
+// maxIdleConns tls上游最大空闲连接数
+const maxIdleConns = 4
+
+// dnsMessageType RFC 8484 dns消息媒体类型
+const dnsMessageType = "application/dns-message"
+
+// Upstream 上游dns服务器
+type Upstream interface {
+	// Exchange 发送请求并返回应答
+	Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+	// Address 上游地址
+	Address() string
+}
+
+// NewUpstream 创建上游dns服务器, 支持以下格式:
+// ip:port, udp://ip:port 明文udp, 应答被截断时使用tcp重试, 端口缺省时为53
+// tcp://ip:port 明文tcp, 端口缺省时为53
+// tls://host:port DNS-over-TLS, 端口缺省时为853
+// https://host[:port]/path DNS-over-HTTPS(RFC 8484), 使用http2, 路径缺省时为/dns-query
+// dialer 不为nil时, 所有查询经由dialer建立的连接, 此时明文udp使用tcp查询
+func NewUpstream(addr string, dialer connection.ContextDialer) (Upstream, error) {
+	addr = strings.TrimSpace(addr)
+	if !strings.Contains(addr, "://") {
+		return &plainUpstream{network: "udp", addr: hostWithPort(addr, DefaultPort), dialer: dialer}, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return &plainUpstream{
+			network: u.Scheme,
+			addr:    hostWithPort(u.Host, DefaultPort),
+			dialer:  dialer,
+		}, nil
+	case "tls":
+		host := hostWithPort(u.Host, DefaultTLSPort)
+		serverName, _, _ := net.SplitHostPort(host)
+		return &tlsUpstream{
+			addr:   host,
+			config: &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12},
+			dialer: withDefaultDialer(dialer),
+		}, nil
+	case "https":
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		d := withDefaultDialer(dialer)
+		return &httpsUpstream{
+			url: u.String(),
+			client: &http.Client{
+				Transport: &http2.Transport{
+					DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+						ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+						defer cancel()
+						return dialTLS(ctx, d, network, addr, cfg)
+					},
+				},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme %s", u.Scheme)
+	}
+}
+
+// plainUpstream 明文udp/tcp上游
+type plainUpstream struct {
+	network string
+	addr    string
+	dialer  connection.ContextDialer
+}
+
+func (sf *plainUpstream) Address() string {
+	if sf.network == "udp" {
+		return sf.addr
+	}
+	return sf.network + "://" + sf.addr
+}
+
+func (sf *plainUpstream) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if sf.dialer != nil {
+		conn, err := sf.dialer.DialContext(ctx, "tcp", sf.addr)
+		if err != nil {
+			return nil, err
+		}
+		co := &dns.Conn{Conn: conn}
+		defer co.Close()
+		return exchangeConn(ctx, co, msg)
+	}
+
+	cli := &dns.Client{Net: sf.network}
+	r, _, err := cli.ExchangeContext(ctx, msg, sf.addr)
+	if err == nil && r.Truncated && sf.network == "udp" {
+		cli.Net = "tcp"
+		r, _, err = cli.ExchangeContext(ctx, msg, sf.addr)
+	}
+	return r, err
+}
+
+// tlsUpstream DNS-over-TLS上游, 复用连接
+type tlsUpstream struct {
+	addr   string
+	config *tls.Config
+	dialer connection.ContextDialer
+	mu     sync.Mutex
+	idle   []*dns.Conn
+}
+
+func (sf *tlsUpstream) Address() string { return "tls://" + sf.addr }
+
+func (sf *tlsUpstream) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// 空闲连接可能已被服务端关闭, 失败时使用新连接重试
+	if co := sf.get(); co != nil {
+		if r, err := exchangeConn(ctx, co, msg); err == nil {
+			sf.put(co)
+			return r, nil
+		}
+		co.Close()
+	}
+
+	conn, err := dialTLS(ctx, sf.dialer, "tcp", sf.addr, sf.config)
+	if err != nil {
+		return nil, err
+	}
+	co := &dns.Conn{Conn: conn}
+	r, err := exchangeConn(ctx, co, msg)
+	if err != nil {
+		co.Close()
+		return nil, err
+	}
+	sf.put(co)
+	return r, nil
+}
+
+func (sf *tlsUpstream) get() *dns.Conn {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if n := len(sf.idle); n > 0 {
+		co := sf.idle[n-1]
+		sf.idle = sf.idle[:n-1]
+		return co
+	}
+	return nil
+}
+
+func (sf *tlsUpstream) put(co *dns.Conn) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if len(sf.idle) >= maxIdleConns {
+		co.Close()
+		return
+	}
+	sf.idle = append(sf.idle, co)
+}
+
+// httpsUpstream DNS-over-HTTPS上游, http2复用连接
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (sf *httpsUpstream) Address() string { return sf.url }
+
+func (sf *httpsUpstream) Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 建议id为0, 以便http缓存
+	id := msg.Id
+	msg.Id = 0
+	b, err := msg.Pack()
+	msg.Id = id
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sf.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+	resp, err := sf.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body) // nolint: errcheck
+		return nil, fmt.Errorf("upstream %s http status %d", sf.url, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, err
+	}
+	r.Id = id
+	return r, nil
+}
+
+// errUpstream 无效的上游, 查询时返回错误
+type errUpstream struct {
+	addr string
+	err  error
+}
+
+func (sf *errUpstream) Address() string { return sf.addr }
+
+func (sf *errUpstream) Exchange(context.Context, *dns.Msg) (*dns.Msg, error) { return nil, sf.err }
+
+// exchangeConn 在流连接上发送请求并读取应答
+func exchangeConn(ctx context.Context, co *dns.Conn, msg *dns.Msg) (*dns.Msg, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		co.SetDeadline(deadline) // nolint: errcheck
+	} else {
+		co.SetDeadline(time.Now().Add(DefaultTimeout)) // nolint: errcheck
+	}
+	if err := co.WriteMsg(msg); err != nil {
+		return nil, err
+	}
+	r, err := co.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if r.Id != msg.Id {
+		return nil, dns.ErrId
+	}
+	return r, nil
+}
+
+// dialTLS 经由dialer建立tls连接
+func dialTLS(ctx context.Context, dialer connection.ContextDialer, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		tc.SetDeadline(deadline) // nolint: errcheck
+	}
+	if err = tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{}) // nolint: errcheck
+	return tc, nil
+}
+
+func withDefaultDialer(dialer connection.ContextDialer) connection.ContextDialer {
+	if dialer == nil {
+		return &net.Dialer{}
+	}
+	return dialer
+}
+
+func hostWithPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	return host
+}
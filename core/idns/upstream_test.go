@@ -0,0 +1,143 @@
+package idns
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// countDialer 记录拨号次数
+type countDialer struct {
+	net.Dialer
+	count int32
+}
+
+func (sf *countDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	atomic.AddInt32(&sf.count, 1)
+	return sf.Dialer.DialContext(ctx, network, addr)
+}
+
+func TestNewUpstream(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{"8.8.8.8", "8.8.8.8:53", false},
+		{"udp://8.8.8.8:5353", "8.8.8.8:5353", false},
+		{"tcp://8.8.8.8", "tcp://8.8.8.8:53", false},
+		{"tls://1.1.1.1", "tls://1.1.1.1:853", false},
+		{"tls://dns.google:8853", "tls://dns.google:8853", false},
+		{"https://dns.google", "https://dns.google/dns-query", false},
+		{"https://1.1.1.1/resolve", "https://1.1.1.1/resolve", false},
+		{"quic://1.1.1.1", "", true},
+	}
+	for _, tt := range tests {
+		u, err := NewUpstream(tt.addr, nil)
+		if tt.wantErr {
+			require.Error(t, err, tt.addr)
+			continue
+		}
+		require.NoError(t, err, tt.addr)
+		assert.Equal(t, tt.want, u.Address())
+	}
+
+	r := New("quic://1.1.1.1", 300)
+	_, err := r.LookupIP("example.com")
+	require.Error(t, err)
+}
+
+func TestUpstream_TCPWithDialer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	count := new(int32)
+	srv := &dns.Server{Listener: ln, Handler: testHandler(60, dns.RcodeSuccess, count)}
+	go srv.ActivateAndServe()            // nolint: errcheck
+	t.Cleanup(func() { srv.Shutdown() }) // nolint: errcheck
+
+	// udp上游经由dialer时使用tcp
+	d := &countDialer{}
+	r := New(ln.Addr().String(), 300, WithDialer(d), WithPrefer(IPv4Only))
+	ip, err := r.Resolve("example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d.count))
+	assert.Equal(t, int32(1), atomic.LoadInt32(count))
+}
+
+func TestUpstream_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	require.NoError(t, err)
+	count := new(int32)
+	srv := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: testHandler(60, dns.RcodeSuccess, count)}
+	go srv.ActivateAndServe()            // nolint: errcheck
+	t.Cleanup(func() { srv.Shutdown() }) // nolint: errcheck
+
+	d := &countDialer{}
+	u, err := NewUpstream("tls://"+ln.Addr().String(), d)
+	require.NoError(t, err)
+	u.(*tlsUpstream).config.InsecureSkipVerify = true
+
+	for i := 0; i < 3; i++ {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		r, err := u.Exchange(context.Background(), msg)
+		require.NoError(t, err)
+		require.Len(t, r.Answer, 3)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(count))
+	// 连接复用
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d.count))
+}
+
+func TestUpstream_HTTPS(t *testing.T) {
+	var proto atomic.Value
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proto.Store(req.Proto)
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		msg := new(dns.Msg)
+		if err := msg.Unpack(b); err != nil || msg.Id != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ = testReply(msg, 60, dns.RcodeSuccess).Pack()
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(b) // nolint: errcheck
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	d := &countDialer{}
+	u, err := NewUpstream(ts.URL, d)
+	require.NoError(t, err)
+	u.(*httpsUpstream).client.Transport.(*http2.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	for i := 0; i < 3; i++ {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeAAAA)
+		r, err := u.Exchange(context.Background(), msg)
+		require.NoError(t, err)
+		assert.Equal(t, msg.Id, r.Id)
+		require.Len(t, r.Answer, 1)
+		assert.Equal(t, "fd00::1", r.Answer[0].(*dns.AAAA).AAAA.String())
+	}
+	assert.Equal(t, "HTTP/2.0", proto.Load())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d.count))
+}
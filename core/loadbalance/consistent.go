@@ -0,0 +1,243 @@
+package loadbalance
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// 一致性hash参数
+const (
+	ketamaPointsPerWeight = 160   // ketama 每单位权重的虚拟节点数
+	maglevTableSize       = 65537 // maglev 查找表大小, 须为质数且远大于后端虚拟节点数
+)
+
+// Ketama ketama 一致性hash实现, 每个后端按权重在hash环上生成虚拟节点,
+// 增减后端时只有该后端相邻区间的客户端会迁移, 后端不可用时顺时针选择下一个可用后端
+type Ketama struct {
+	ring atomic.Value // *ketamaRing
+}
+
+type ketamaRing struct {
+	pool   UpstreamPool
+	points []uint32 // 虚拟节点hash值, 升序
+	owners []int    // 虚拟节点对应的后端索引
+}
+
+// Select implement Selector, if srcAddr is empty it will use random mode
+func (sf *Ketama) Select(pool UpstreamPool, srcAddr string) *Upstream {
+	if srcAddr == "" {
+		return Random{}.Select(pool, srcAddr)
+	}
+	if len(pool) == 0 {
+		return nil
+	}
+	ring, _ := sf.ring.Load().(*ketamaRing)
+	if ring == nil || !samePool(ring.pool, pool) {
+		ring = newKetamaRing(pool)
+		sf.ring.Store(ring)
+	}
+
+	h := md5Hash(hashKey(srcAddr))
+	start := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	// 顺时针查找第一个可用后端, 每个不可用后端只检查一次
+	var unavailable []bool
+	for i, count := 0, 0; i < len(ring.points) && count < len(pool); i++ {
+		idx := ring.owners[(start+i)%len(ring.points)]
+		if unavailable != nil && unavailable[idx] {
+			continue
+		}
+		if pool[idx].Available() {
+			return pool[idx]
+		}
+		if unavailable == nil {
+			unavailable = make([]bool, len(pool))
+		}
+		unavailable[idx] = true
+		count++
+	}
+	return nil
+}
+
+func newKetamaRing(pool UpstreamPool) *ketamaRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+
+	points := make([]point, 0, len(pool)*ketamaPointsPerWeight)
+	for idx, ups := range pool {
+		n := ketamaPointsPerWeight * upstreamWeight(ups) / 4
+		for i := 0; i < n; i++ {
+			// 每个md5摘要生成4个虚拟节点, 同 libketama
+			digest := md5.Sum([]byte(ups.Addr + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				points = append(points, point{binary.LittleEndian.Uint32(digest[j*4:]), idx})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return pool[points[i].owner].Addr < pool[points[j].owner].Addr
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	ring := &ketamaRing{
+		pool:   pool,
+		points: make([]uint32, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		ring.points[i], ring.owners[i] = p.hash, p.owner
+	}
+	return ring
+}
+
+func md5Hash(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(digest[:4])
+}
+
+// Maglev maglev 一致性hash实现, 每个后端按权重占用查找表的槽位,
+// 查找表仅由健康后端生成, 后端增减或健康状态变化时重建查找表, 只有少量客户端迁移,
+// 后端连接数满时顺序选择查找表中下一个可用后端
+type Maglev struct {
+	table atomic.Value // *maglevTable
+}
+
+type maglevTable struct {
+	pool    UpstreamPool
+	healthy []bool // 生成查找表时各后端的健康状态
+	entries []int  // 槽位对应的后端索引, 无健康后端时为nil
+}
+
+// Select implement Selector, if srcAddr is empty it will use random mode
+func (sf *Maglev) Select(pool UpstreamPool, srcAddr string) *Upstream {
+	if srcAddr == "" {
+		return Random{}.Select(pool, srcAddr)
+	}
+	table, _ := sf.table.Load().(*maglevTable)
+	if table == nil || !samePool(table.pool, pool) || !table.isHealthy(pool) {
+		healthy := make([]bool, len(pool))
+		for i, ups := range pool {
+			healthy[i] = ups.Healthy()
+		}
+		table = newMaglevTable(pool, healthy)
+		sf.table.Store(table)
+	}
+	if table.entries == nil {
+		return nil
+	}
+
+	start := md5Hash(hashKey(srcAddr)) % maglevTableSize
+	var full []bool
+	for i, count := uint32(0), 0; i < maglevTableSize && count < len(pool); i++ {
+		idx := table.entries[(start+i)%maglevTableSize]
+		if full != nil && full[idx] {
+			continue
+		}
+		if pool[idx].Available() {
+			return pool[idx]
+		}
+		if full == nil {
+			full = make([]bool, len(pool))
+		}
+		full[idx] = true
+		count++
+	}
+	return nil
+}
+
+func newMaglevTable(pool UpstreamPool, healthy []bool) *maglevTable {
+	table := &maglevTable{pool: pool, healthy: healthy}
+
+	type backend struct {
+		idx, weight  int
+		offset, skip uint64
+		next         uint64 // 排列中下一个待检查的位置
+	}
+	backends := make([]*backend, 0, len(pool))
+	for i, ups := range pool {
+		if !healthy[i] {
+			continue
+		}
+		digest := md5.Sum([]byte(ups.Addr))
+		backends = append(backends, &backend{
+			idx:    i,
+			weight: upstreamWeight(ups),
+			offset: binary.LittleEndian.Uint64(digest[:8]) % maglevTableSize,
+			skip:   binary.LittleEndian.Uint64(digest[8:])%(maglevTableSize-1) + 1,
+		})
+	}
+	if len(backends) == 0 {
+		return table
+	}
+	// 地址排序, 保证查找表与后端顺序无关
+	sort.Slice(backends, func(i, j int) bool { return pool[backends[i].idx].Addr < pool[backends[j].idx].Addr })
+
+	entries := make([]int, maglevTableSize)
+	for i := range entries {
+		entries[i] = -1
+	}
+	// 轮流填充, 每轮每个后端按权重占用槽位, 槽位为后端排列中下一个空闲位置
+	for filled := 0; ; {
+		for _, b := range backends {
+			for w := 0; w < b.weight; w++ {
+				c := (b.offset + b.next*b.skip) % maglevTableSize
+				for entries[c] >= 0 {
+					b.next++
+					c = (b.offset + b.next*b.skip) % maglevTableSize
+				}
+				entries[c] = b.idx
+				b.next++
+				if filled++; filled == maglevTableSize {
+					table.entries = entries
+					return table
+				}
+			}
+		}
+	}
+}
+
+// hashKey 一致性hash的键, 同 IPHash 使用ip
+func hashKey(srcAddr string) string {
+	host, _, err := net.SplitHostPort(srcAddr)
+	if err != nil {
+		return srcAddr
+	}
+	return host
+}
+
+func upstreamWeight(ups *Upstream) int {
+	if ups.Weight <= 0 {
+		return 1
+	}
+	return ups.Weight
+}
+
+// samePool 是否同一后端池
+func samePool(a, b UpstreamPool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isHealthy 后端健康状态是否与生成查找表时一致
+func (sf *maglevTable) isHealthy(pool UpstreamPool) bool {
+	for i, ups := range pool {
+		if ups.Healthy() != sf.healthy[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package loadbalance
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func consistentPool(n int, weights ...int) UpstreamPool {
+	pool := make(UpstreamPool, 0, n)
+	for i := 0; i < n; i++ {
+		ups := &Upstream{Config: Config{Addr: fmt.Sprintf("10.0.0.%d:8080", i+1), Weight: 1}, health: 1}
+		if i < len(weights) {
+			ups.Weight = weights[i]
+		}
+		pool = append(pool, ups)
+	}
+	return pool
+}
+
+func consistentKeys(n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, fmt.Sprintf("192.168.%d.%d:%d", i/250, i%250, 10000+i))
+	}
+	return keys
+}
+
+// selectAll 各客户端选择的后端地址
+func selectAll(sel Selector, pool UpstreamPool, keys []string) map[string]string {
+	result := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if ups := sel.Select(pool, key); ups != nil {
+			result[key] = ups.Addr
+		}
+	}
+	return result
+}
+
+func moved(a, b map[string]string) int {
+	n := 0
+	for k, v := range a {
+		if b[k] != v {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConsistentHash_Select(t *testing.T) {
+	keys := consistentKeys(10000)
+
+	for _, tt := range []struct {
+		name string
+		new  func() Selector
+	}{
+		{"ketama", func() Selector { return new(Ketama) }},
+		{"maglev", func() Selector { return new(Maglev) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, HasSupportMethod(tt.name))
+
+			pool := consistentPool(4)
+			sel := tt.new()
+			require.NotNil(t, sel.Select(pool, ""))
+			assert.Nil(t, sel.Select(pool[:0], "172.0.0.1:80"))
+
+			// 同一ip选择同一后端, 与端口无关
+			h := sel.Select(pool, "172.0.0.1:80")
+			require.NotNil(t, h)
+			assert.Equal(t, h, sel.Select(pool, "172.0.0.1:8080"))
+			assert.Equal(t, h, sel.Select(pool, "172.0.0.1"))
+
+			// 与后端顺序无关
+			before := selectAll(sel, pool, keys)
+			reversed := UpstreamPool{pool[3], pool[2], pool[1], pool[0]}
+			assert.Equal(t, before, selectAll(tt.new(), reversed, keys))
+
+			// 分布均匀
+			count := make(map[string]int)
+			for _, addr := range before {
+				count[addr]++
+			}
+			for _, ups := range pool {
+				assert.InDelta(t, len(keys)/4, count[ups.Addr], float64(len(keys))/4*0.2, ups.Addr)
+			}
+
+			// 后端不可用, 该后端的客户端迁移, 其他客户端基本不受影响(maglev 有少量迁移)
+			pool[1].health = 0
+			down := selectAll(sel, pool, keys)
+			n := moved(before, down)
+			assert.GreaterOrEqual(t, n, count[pool[1].Addr])
+			assert.LessOrEqual(t, n, count[pool[1].Addr]*11/10)
+			for _, addr := range down {
+				assert.NotEqual(t, pool[1].Addr, addr)
+			}
+			// 后端恢复, 客户端回到原后端
+			pool[1].health = 1
+			assert.Equal(t, before, selectAll(sel, pool, keys))
+
+			// 增加一个后端, 约1/5的客户端迁移
+			added := append(UpstreamPool{}, pool...)
+			added = append(added, consistentPool(5)[4])
+			n = moved(before, selectAll(tt.new(), added, keys))
+			assert.InDelta(t, len(keys)/5, n, float64(len(keys))/5*0.2)
+
+			// 连接数满时选择其他后端
+			h.MaxConnections, h.connections = 1, 1
+			assert.NotEqual(t, h, sel.Select(pool, "172.0.0.1:80"))
+			h.MaxConnections, h.connections = 0, 0
+
+			// 全部不可用
+			for _, ups := range pool {
+				ups.health = 0
+			}
+			assert.Nil(t, sel.Select(pool, "172.0.0.1:80"))
+		})
+	}
+}
+
+func TestConsistentHash_Weight(t *testing.T) {
+	keys := consistentKeys(10000)
+	for _, sel := range []Selector{new(Ketama), new(Maglev)} {
+		pool := consistentPool(3, 1, 2, 3)
+		count := make(map[string]int)
+		for _, addr := range selectAll(sel, pool, keys) {
+			count[addr]++
+		}
+		for _, ups := range pool {
+			want := len(keys) * ups.Weight / 6
+			assert.InDelta(t, want, count[ups.Addr], float64(want)*0.2, "%T %s", sel, ups.Addr)
+		}
+	}
+}
+
+func BenchmarkKetama_Select(b *testing.B) {
+	pool := consistentPool(10)
+	sel := new(Ketama)
+	keys := consistentKeys(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sel.Select(pool, keys[i%len(keys)])
+	}
+}
+
+func BenchmarkMaglev_Select(b *testing.B) {
+	pool := consistentPool(10)
+	sel := new(Maglev)
+	keys := consistentKeys(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sel.Select(pool, keys[i%len(keys)])
+	}
+}
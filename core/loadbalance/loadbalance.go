@@ -2,9 +2,11 @@ package loadbalance
 
 import (
 	"fmt"
+	"math/rand"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/thinkgos/jocasta/core/idns"
@@ -81,6 +83,7 @@ type Balanced struct {
 
 	rw        sync.RWMutex
 	closeChan chan struct{}
+	resetChan chan struct{} // 后端重置通知, 立即调度新后端的检查
 	upstreams UpstreamPool
 	selector  Selector
 }
@@ -95,6 +98,8 @@ type Balanced struct {
 //      addrhash
 // 		leasttime
 // 		weight
+// 		ketama
+// 		maglev
 func New(method string, configs []Config, opts ...Option) *Balanced {
 	lb := &Balanced{
 		method:    method,
@@ -103,6 +108,7 @@ func New(method string, configs []Config, opts ...Option) *Balanced {
 		upstreams: NewUpstreamPool(configs),
 		log:       logger.NewDiscard(),
 		closeChan: make(chan struct{}),
+		resetChan: make(chan struct{}, 1),
 	}
 	for _, opt := range opts {
 		opt(lb)
@@ -180,6 +186,10 @@ func (sf *Balanced) Reset(configs []Config) {
 	defer sf.rw.Unlock()
 	sf.upstreams = NewUpstreamPool(configs)
 	sf.selector = getNewSelectorFunction(sf.method)()
+	select {
+	case sf.resetChan <- struct{}{}:
+	default:
+	}
 }
 
 // resolve resolve the addr to ip:port
@@ -191,28 +201,72 @@ func (sf *Balanced) resolve(addr string) string {
 }
 
 // activeHealthChecker healthy checker
+// 各后端按自身检查间隔(Period, 未设置时使用interval)调度, 并加上不超过间隔1/10的随机抖动,
+// 避免同时检查所有后端. 新后端在加入后的抖动时间内首次检查.
 // it must be run in a goroutine
 func (sf *Balanced) activeHealthChecker() {
-	ticker := time.NewTicker(sf.interval)
-	defer ticker.Stop()
+	next := make(map[*Upstream]time.Time)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
+		case <-sf.resetChan:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
 		case <-sf.closeChan:
 			return
 		}
-		sf.rw.Lock()
-		for _, upstream := range sf.upstreams {
-			ups := upstream
-			gopool.Go(sf.goPool, func() {
-				defer func() {
-					if err := recover(); err != nil {
-						sf.log.DPanicf("active health checks: %v\n%s", err, debug.Stack())
-					}
-				}()
-				ups.healthyCheck(sf.resolve(ups.Addr))
-			})
+
+		now := time.Now()
+		earliest := now.Add(sf.interval)
+		sf.rw.RLock()
+		current := make(map[*Upstream]time.Time, len(sf.upstreams))
+		for _, ups := range sf.upstreams {
+			period := ups.Period
+			if period <= 0 {
+				period = sf.interval
+			}
+			at, ok := next[ups]
+			if !ok {
+				at = now.Add(jitter(period))
+			}
+			if !at.After(now) {
+				sf.check(ups)
+				at = now.Add(period + jitter(period))
+			}
+			current[ups] = at
+			if at.Before(earliest) {
+				earliest = at
+			}
 		}
-		sf.rw.Unlock()
+		sf.rw.RUnlock()
+		next = current
+		timer.Reset(earliest.Sub(now))
 	}
 }
+
+// check 在协程中检查后端, 上一次检查未结束时跳过
+func (sf *Balanced) check(ups *Upstream) {
+	if !atomic.CompareAndSwapInt32(&ups.checking, 0, 1) {
+		return
+	}
+	gopool.Go(sf.goPool, func() {
+		defer func() {
+			atomic.StoreInt32(&ups.checking, 0)
+			if err := recover(); err != nil {
+				sf.log.DPanicf("active health checks: %v\n%s", err, debug.Stack())
+			}
+		}()
+		ups.healthyCheck(sf.resolve(ups.Addr))
+	})
+}
+
+// jitter 随机抖动, [0, period/10]
+func jitter(period time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(period/10) + 1))
+}
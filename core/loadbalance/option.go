@@ -39,7 +39,7 @@ func WithGPool(pool gopool.Pool) Option {
 	}
 }
 
-// WithInterval 活性探测间隔
+// WithInterval 活性探测间隔, 后端未设置检查间隔(Period)时使用, <= 0 关闭活性探测 default: 30s
 func WithInterval(interval time.Duration) Option {
 	return func(g *Balanced) {
 		g.interval = interval
@@ -0,0 +1,220 @@
+package loadbalance
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 内置探针类型
+const (
+	ProbeTCP     = "tcp"     // tcp连接
+	ProbeTLS     = "tls"     // tls握手
+	ProbeHTTP    = "http"    // http GET请求, 检查响应状态码
+	ProbeJocasta = "jocasta" // 经父级传输(加密,压缩等)连接后按父级代理协议握手, 适用于jocasta socks/sps/http父级
+)
+
+// jocasta探针支持的父级代理协议
+const (
+	ProbeProtocolSocks5 = "socks5" // socks5 方法协商
+	ProbeProtocolHTTP   = "http"   // http CONNECT 请求
+)
+
+// ProbeConfig 内置探针配置
+type ProbeConfig struct {
+	Type      string      // 探针类型, tcp|tls|http|jocasta default: tcp
+	TLSConfig *tls.Config // tls探针配置, 为nil时不校验证书, ServerName为空时使用地址的host
+	// http探针请求url, 以'/'开头时请求父级本身, 绝对url时作为代理请求经父级访问该url default: /
+	URL    string
+	Status int // http探针期望状态码 default: 200
+	// jocasta探针的父级代理协议, socks5|http default: socks5
+	Protocol string
+	// http和jocasta探针连接父级的方法, 包含父级传输协议, 加密和压缩等, 为nil时使用tcp连接
+	Dial func(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// HasProbe return the probe type supported or not
+func HasProbe(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "", ProbeTCP, ProbeTLS, ProbeHTTP, ProbeJocasta:
+		return true
+	}
+	return false
+}
+
+// HasProbeProtocol return the parent protocol supported by jocasta probe or not
+func HasProbeProtocol(protocol string) bool {
+	switch strings.ToLower(protocol) {
+	case "", ProbeProtocolSocks5, ProbeProtocolHTTP:
+		return true
+	}
+	return false
+}
+
+// NewLivenessProbe 根据配置创建内置探针, 不支持的类型使用tcp探针
+func NewLivenessProbe(c ProbeConfig) func(ctx context.Context, addr string, timeout time.Duration) error {
+	dial := c.Dial
+	if dial == nil {
+		dial = tcpDial
+	}
+	switch strings.ToLower(c.Type) {
+	case ProbeTLS:
+		return func(ctx context.Context, addr string, timeout time.Duration) error {
+			return tlsLivenessProbe(ctx, addr, timeout, c.TLSConfig)
+		}
+	case ProbeHTTP:
+		status := c.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return func(ctx context.Context, addr string, timeout time.Duration) error {
+			return probeWithConn(ctx, dial, addr, timeout, func(conn net.Conn) error {
+				return httpHandshake(conn, addr, c.URL, status)
+			})
+		}
+	case ProbeJocasta:
+		if strings.ToLower(c.Protocol) == ProbeProtocolHTTP {
+			return func(ctx context.Context, addr string, timeout time.Duration) error {
+				return probeWithConn(ctx, dial, addr, timeout, func(conn net.Conn) error {
+					return httpConnectHandshake(conn, addr, c.URL)
+				})
+			}
+		}
+		return func(ctx context.Context, addr string, timeout time.Duration) error {
+			return probeWithConn(ctx, dial, addr, timeout, socks5Handshake)
+		}
+	default:
+		return tcpLivenessProbe
+	}
+}
+
+func tcpDial(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func tcpLivenessProbe(_ context.Context, addr string, timeout time.Duration) error {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	c.Close() // nolint: errcheck
+	return nil
+}
+
+func tlsLivenessProbe(ctx context.Context, addr string, timeout time.Duration, config *tls.Config) error {
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: true} // nolint: gosec
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		config.ServerName = host
+	}
+	return probeWithConn(ctx, tcpDial, addr, timeout, func(conn net.Conn) error {
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		return tc.Close()
+	})
+}
+
+// probeWithConn 连接后在超时时间内完成握手
+func probeWithConn(ctx context.Context,
+	dial func(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error),
+	addr string, timeout time.Duration, handshake func(conn net.Conn) error) error {
+	deadline := time.Now().Add(timeout)
+	conn, err := dial(ctx, addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline) // nolint: errcheck
+	return handshake(conn)
+}
+
+// httpHandshake 发送GET请求, 检查响应状态码
+func httpHandshake(conn net.Conn, addr, rawURL string, status int) error {
+	host := addr
+	if rawURL == "" {
+		rawURL = "/"
+	}
+	if !strings.HasPrefix(rawURL, "/") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		host = u.Host
+	}
+	_, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: jocasta\r\nConnection: close\r\n\r\n", rawURL, host)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != status {
+		return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, status)
+	}
+	return nil
+}
+
+// httpConnectHandshake 发送CONNECT请求, 目标为绝对url的host, 否则为父级本身,
+// 响应2xx或407(需要认证)即认为父级可用
+func httpConnectHandshake(conn net.Conn, addr, rawURL string) error {
+	target := addr
+	if rawURL != "" && !strings.HasPrefix(rawURL, "/") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		target = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			target = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+	_, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: jocasta\r\n\r\n", target, target)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusProxyAuthRequired {
+		return fmt.Errorf("unexpected CONNECT status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// socks5Handshake socks5 无认证方法协商, 回复版本为5即认为父级可用
+func socks5Handshake(conn net.Conn) error {
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("invalid socks5 version reply")
+	}
+	return nil
+}
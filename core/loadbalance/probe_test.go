@@ -0,0 +1,167 @@
+package loadbalance
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLivenessProbe(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("tcp", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		probe := NewLivenessProbe(ProbeConfig{})
+		require.NoError(t, probe(ctx, ts.Listener.Addr().String(), time.Second))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		ts := httptest.NewTLSServer(http.NotFoundHandler())
+		defer ts.Close()
+		probe := NewLivenessProbe(ProbeConfig{Type: ProbeTLS})
+		require.NoError(t, probe(ctx, ts.Listener.Addr().String(), time.Second))
+
+		// 明文服务tls握手失败
+		plain := httptest.NewServer(http.NotFoundHandler())
+		defer plain.Close()
+		require.Error(t, probe(ctx, plain.Listener.Addr().String(), time.Second))
+	})
+
+	t.Run("http", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Host == "www.example.com": // 代理请求
+				w.WriteHeader(http.StatusNoContent)
+			case r.URL.Path == "/health":
+				w.WriteHeader(http.StatusOK)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+		addr := ts.Listener.Addr().String()
+
+		require.NoError(t, NewLivenessProbe(ProbeConfig{Type: ProbeHTTP, URL: "/health"})(ctx, addr, time.Second))
+		require.Error(t, NewLivenessProbe(ProbeConfig{Type: ProbeHTTP})(ctx, addr, time.Second))
+		require.NoError(t, NewLivenessProbe(ProbeConfig{Type: ProbeHTTP, URL: "/", Status: http.StatusNotFound})(ctx, addr, time.Second))
+		require.NoError(t, NewLivenessProbe(ProbeConfig{
+			Type:   ProbeHTTP,
+			URL:    "http://www.example.com/generate_204",
+			Status: http.StatusNoContent,
+		})(ctx, addr, time.Second))
+	})
+
+	t.Run("jocasta", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:")
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					buf := make([]byte, 3)
+					if _, err := conn.Read(buf); err == nil && buf[0] == 0x05 {
+						conn.Write([]byte{0x05, 0x00}) // nolint: errcheck
+					}
+				}()
+			}
+		}()
+
+		var dialed int32
+		probe := NewLivenessProbe(ProbeConfig{
+			Type: ProbeJocasta,
+			Dial: func(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+				atomic.AddInt32(&dialed, 1)
+				return net.DialTimeout("tcp", addr, timeout)
+			},
+		})
+		require.NoError(t, probe(ctx, ln.Addr().String(), time.Second))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&dialed))
+
+		// http服务不是socks5
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		require.Error(t, probe(ctx, ts.Listener.Addr().String(), time.Millisecond*500))
+	})
+
+	t.Run("jocasta http", func(t *testing.T) {
+		var target string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			target = r.Host
+			if r.Host == "auth.example.com:443" {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+		addr := ts.Listener.Addr().String()
+
+		probe := NewLivenessProbe(ProbeConfig{Type: ProbeJocasta, Protocol: ProbeProtocolHTTP})
+		require.NoError(t, probe(ctx, addr, time.Second))
+		assert.Equal(t, addr, target)
+		require.NoError(t, NewLivenessProbe(ProbeConfig{
+			Type:     ProbeJocasta,
+			Protocol: ProbeProtocolHTTP,
+			URL:      "https://auth.example.com/",
+		})(ctx, addr, time.Second))
+		assert.Equal(t, "auth.example.com:443", target)
+
+		// 非http代理
+		plain := httptest.NewServer(http.NotFoundHandler())
+		defer plain.Close()
+		require.Error(t, probe(ctx, plain.Listener.Addr().String(), time.Second))
+		// socks5探针无法探测http代理
+		require.Error(t, NewLivenessProbe(ProbeConfig{Type: ProbeJocasta})(ctx, addr, time.Millisecond*500))
+
+		assert.True(t, HasProbeProtocol("HTTP"))
+		assert.False(t, HasProbeProtocol("ss"))
+	})
+
+	assert.True(t, HasProbe("HTTP"))
+	assert.True(t, HasProbe(""))
+	assert.False(t, HasProbe("icmp"))
+}
+
+func TestBalanced_PerUpstreamPeriod(t *testing.T) {
+	var fast, slow int32
+	probe := func(counter *int32) func(context.Context, string, time.Duration) error {
+		return func(context.Context, string, time.Duration) error {
+			atomic.AddInt32(counter, 1)
+			return nil
+		}
+	}
+	lb := New("roundrobin", []Config{
+		{Addr: "127.0.0.1:1", Period: time.Millisecond * 100, SuccessThreshold: 1, LivenessProbe: probe(&fast)},
+		{Addr: "127.0.0.1:2", Period: time.Second * 10, SuccessThreshold: 1, LivenessProbe: probe(&slow)},
+	}, WithInterval(time.Minute))
+	defer lb.Close()
+
+	time.Sleep(time.Millisecond * 1100)
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&fast), int32(6))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slow))
+	assert.Equal(t, 2, lb.HealthyCount())
+
+	// 重置后新后端立即调度
+	var reset int32
+	lb.Reset([]Config{{Addr: "127.0.0.1:3", Period: time.Second * 10, SuccessThreshold: 1, LivenessProbe: probe(&reset)}})
+	time.Sleep(time.Millisecond * 1100)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reset))
+	assert.Equal(t, "127.0.0.1:3", lb.Select(""))
+}
@@ -18,6 +18,8 @@ func init() {
 	RegisterSelector("addrhash", func() Selector { return new(AddrHash) })
 	RegisterSelector("leasttime", func() Selector { return new(LeastTime) })
 	RegisterSelector("weight", func() Selector { return NewWeight() })
+	RegisterSelector("ketama", func() Selector { return new(Ketama) })
+	RegisterSelector("maglev", func() Selector { return new(Maglev) })
 }
 
 // Random is a policy that selects an available backend at random.
@@ -15,9 +15,10 @@ type Config struct {
 	MaxConnections   int                                                                 // 最大连接数,<= 0表示不限制, default: 0
 	SuccessThreshold uint32                                                              // liveness成功阀值 default: 3
 	FailureThreshold uint32                                                              // liveness失败阀值 default: 3
-	Period           time.Duration                                                       // 检查时间间隔  default: 2s TODO: Not used
+	Period           time.Duration                                                       // 检查时间间隔, <= 0 使用 Balanced 的检查间隔(见 WithInterval)
 	Timeout          time.Duration                                                       // dial 连接超时时间 default: 1s
-	LivenessProbe    func(ctx context.Context, addr string, timeout time.Duration) error // liveness 自定义探针
+	LivenessProbe    func(ctx context.Context, addr string, timeout time.Duration) error // liveness 自定义探针, 优先于Probe
+	Probe            ProbeConfig                                                         // 内置探针配置 default: tcp
 }
 
 // Upstream 后端
@@ -27,6 +28,7 @@ type Upstream struct {
 	successCount uint32       // success count
 	failureCount uint32       // failure count
 	connections  int64        // 连接数
+	checking     int32        // 是否正在检查, 防止探针耗时超过检查间隔时重复检查
 	leastTime    atomic.Value // time.Duration 最小响应时间
 }
 
@@ -48,8 +50,8 @@ func NewUpstream(config Config) (*Upstream, error) {
 	if config.Timeout <= 0 {
 		config.Timeout = time.Second * 1
 	}
-	if config.Period <= 0 {
-		config.Period = time.Second * 30
+	if config.LivenessProbe == nil {
+		config.LivenessProbe = NewLivenessProbe(config.Probe)
 	}
 
 	b := &Upstream{Config: config}
@@ -104,15 +106,6 @@ func (sf *Upstream) healthyCheck(addr string) {
 	}
 }
 
-func tcpLivenessProbe(_ context.Context, addr string, timeout time.Duration) error {
-	c, err := net.DialTimeout("tcp", addr, timeout)
-	if err != nil {
-		return err
-	}
-	c.Close() // nolint: errcheck
-	return nil
-}
-
 /******************************************************************************/
 
 // UpstreamPool upstream pool
@@ -62,6 +62,7 @@ type Server struct {
 	UDPConnListener *net.UDPConn
 	enableUDP       bool
 	udpIP           string
+	connectCheck    func(target string, auth proxy.Auth) error
 }
 
 func NewServer(conn net.Conn, timeout time.Duration, auth *basicAuth.Center, enableUDP bool, udpHost string, header []byte) *Server {
@@ -75,6 +76,12 @@ func NewServer(conn net.Conn, timeout time.Duration, auth *basicAuth.Center, ena
 		udpIP:           udpHost,
 	}
 }
+
+// SetConnectCheck 设置CONNECT请求回复前的目标检查, 返回错误时回复 REP_RULE_FORBIDDEN 并结束握手
+func (s *Server) SetConnectCheck(check func(target string, auth proxy.Auth) error) {
+	s.connectCheck = check
+}
+
 func (s *Server) Close() {
 	if s.conn != nil {
 		s.conn.Close()
@@ -195,6 +202,15 @@ func (s *Server) Handshake() (err error) {
 		err = fmt.Errorf("cmd bind not supported, form: %s", remoteAddr)
 		return
 	case CMD_CONNECT:
+		if s.connectCheck != nil {
+			if e := s.connectCheck(request.Addr(), s.pAuth); e != nil {
+				s.conn.SetDeadline(time.Now().Add(s.timeout))
+				request.TCPReply(REP_RULE_FORBIDDEN)
+				s.conn.SetDeadline(time.Time{})
+				err = fmt.Errorf("connect %s forbidden, from: %s, %s", request.Addr(), remoteAddr, e)
+				return
+			}
+		}
 		err = request.TCPReply(REP_SUCCESS)
 		if err != nil {
 			err = fmt.Errorf("TCPReply REP_SUCCESS to %s fail,ERR: %s", remoteAddr, err)
@@ -1,11 +1,15 @@
 package ccs
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/thinkgos/jocasta/connection/cpcap"
+	"github.com/thinkgos/jocasta/core/loadbalance"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/internal/bytesconv"
 	"github.com/thinkgos/jocasta/pkg/extssh"
@@ -22,6 +26,50 @@ type FilterConfig struct {
 	DirectFile  string        // 直连域文件名 default: direct
 	Timeout     time.Duration // dial超时时间 default: 3s
 	Interval    time.Duration // 域名探测间隔 default: 10s
+	// AutoProxy/Adblock格式规则文件(如gfwlist), 支持base64编码, @@例外规则优先于代理表和直连表, default: empty
+	AutoProxyFile string
+	// intelligent模式cache表快照文件, 启动时加载未过期的探测结果, 为空不持久化 default: empty
+	CacheFile string
+	// cache表快照周期, 0 仅在关闭时保存 default: 5m
+	CacheSavePeriod time.Duration
+	// 被动学习阀值, 真实直连失败(连接失败, 重置, 无响应)分值达到阀值时走代理, <= 0 关闭 default: 3
+	PassiveThreshold float64
+	// 被动学习分值半衰期 default: 10m
+	PassiveHalfLife time.Duration
+}
+
+// RuleConfig 路由规则配置
+type RuleConfig struct {
+	// 规则文件, 一行一条, 格式: TYPE,PAYLOAD,ACTION[,no-resolve], 按顺序匹配,
+	// 优先于代理表和直连表, 见 filter.ParseRule, default: empty
+	File string
+	// 父级组, 格式: name=addr1[@weight],addr2[@weight], 规则动作为组名时使用该组的父级, default: empty
+	Groups []string
+}
+
+// ParseGroups 解析父级组, 返回 组名 --> 父级地址列表
+func (sf RuleConfig) ParseGroups() (map[string][]string, error) {
+	groups := make(map[string][]string, len(sf.Groups))
+	for _, g := range sf.Groups {
+		kv := strings.SplitN(g, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid parent group %s, should be like name=addr1,addr2", g)
+		}
+		name := strings.TrimSpace(kv[0])
+		switch strings.ToUpper(name) {
+		case "PROXY", "DIRECT", "REJECT":
+			return nil, fmt.Errorf("invalid parent group %s, name %s reserved", g, name)
+		}
+		for _, addr := range strings.Split(kv[1], ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				groups[name] = append(groups[name], addr)
+			}
+		}
+		if len(groups[name]) == 0 {
+			return nil, fmt.Errorf("invalid parent group %s, no parent address", g)
+		}
+	}
+	return groups, nil
 }
 
 // AuthConfig basic auth 配置
@@ -36,16 +84,83 @@ type AuthConfig struct {
 
 // DNSConfig 自定义dns服务
 type DNSConfig struct {
-	Addr string // dns 解析服务器地址 default: empty
-	TTL  int    // dns 解析结果缓存时间,单位秒 default: 300s
+	Addr string // dns 解析服务器地址, 支持 ip:port, tcp://, tls://, https:// 格式, 多个地址以逗号分隔, 按顺序故障转移 default: empty
+	TTL  int    // dns 解析结果最长缓存时间, 实际不超过记录ttl, 单位秒 default: 300s
+	// dns服务的fake-ip映射文件, 设置时目标地址为fake-ip的连接还原为域名后再过滤和选择父级 default: empty
+	FakeIPFile string
+}
+
+// CaptureConfig 抓包配置, 以pcapng格式记录明文流量, tcp, http, socks, sps及redir服务支持
+type CaptureConfig struct {
+	File       string // 抓包文件, 为空不抓包, default: empty
+	MaxSize    int    // 每个抓包文件最大尺寸(MB), 超过将轮转, default: 100
+	MaxBackups int    // 轮转保留的备份数, default: 0 全部保留
+}
+
+// New new a cpcap.Writer, if File is empty, return nil writer and nil error
+func (sf *CaptureConfig) New(opts ...cpcap.WriterOption) (*cpcap.Writer, error) {
+	if sf.File == "" {
+		return nil, nil
+	}
+	return cpcap.NewWriter(sf.File, append([]cpcap.WriterOption{
+		cpcap.WithMaxSize(int64(sf.MaxSize) * 1024 * 1024),
+		cpcap.WithMaxBackups(sf.MaxBackups),
+	}, opts...)...)
 }
 
 // LbConfig loadbalance config
 type LbConfig struct {
-	Method     string        // 负载均衡方法, random|roundrobin|leastconn|hash|addrhash|leasttime|weight default: roundrobin
+	Method     string        // 负载均衡方法, random|roundrobin|leastconn|hash|addrhash|leasttime|weight|ketama|maglev default: roundrobin
 	Timeout    time.Duration // 负载均衡dial超时时间 default 500ms
-	RetryTime  time.Duration // 负载均衡重试时间间隔 default 1000ms
-	HashTarget bool          // hash方法时,选择hash的目标, default: false
+	HashTarget bool          // hash, ketama, maglev方法时,选择hash的目标, default: false
+	Period     time.Duration // 健康检查间隔 default: 30s
+	// 健康检查探针, tcp|tls|http|jocasta default: tcp
+	// 	tcp: tcp连接; tls: tls握手; http: 经父级传输发送GET请求并检查状态码;
+	// 	jocasta: 经父级传输(加密,压缩等)按父级代理协议握手, socks5父级方法协商, http父级CONNECT请求
+	Probe       string
+	ProbeURL    string            // http探针请求url, default: /
+	ProbeStatus int               // http探针期望状态码, default: 200
+	Probes      map[string]string // 按父级地址指定探针, addr --> probe, 未指定的父级使用Probe
+}
+
+// IsHashTarget 是否使用目标地址选择父级, 仅hash, ketama, maglev方法有效
+func (sf *LbConfig) IsHashTarget() bool {
+	switch sf.Method {
+	case "hash", "ketama", "maglev":
+		return sf.HashTarget
+	}
+	return false
+}
+
+// ProbeOf 父级使用的健康检查探针
+func (sf *LbConfig) ProbeOf(addr string) string {
+	if probe, ok := sf.Probes[addr]; ok {
+		return probe
+	}
+	return sf.Probe
+}
+
+// CheckProbes 检查探针是否支持, protocol 为父级代理协议, jocasta探针仅支持socks5和http父级
+func (sf *LbConfig) CheckProbes(protocol string) error {
+	if err := checkProbe(sf.Probe, protocol); err != nil {
+		return fmt.Errorf("load balance probe %s %v", sf.Probe, err)
+	}
+	for addr, probe := range sf.Probes {
+		if err := checkProbe(probe, protocol); err != nil {
+			return fmt.Errorf("load balance probe %s of parent %s %v", probe, addr, err)
+		}
+	}
+	return nil
+}
+
+func checkProbe(probe, protocol string) error {
+	if !loadbalance.HasProbe(probe) {
+		return errors.New("not support")
+	}
+	if strings.EqualFold(probe, loadbalance.ProbeJocasta) && !loadbalance.HasProbeProtocol(protocol) {
+		return fmt.Errorf("not support %s parent", protocol)
+	}
+	return nil
 }
 
 // SSHConfig ssh config
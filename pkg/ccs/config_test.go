@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSKcpMode(t *testing.T) {
@@ -31,3 +32,39 @@ func TestSKcpMode(t *testing.T) {
 	assert.Equal(t, 2, resend)
 	assert.Equal(t, 1, noCongestion)
 }
+
+func TestRuleConfig_ParseGroups(t *testing.T) {
+	groups, err := RuleConfig{Groups: []string{
+		"hk=1.1.1.1:8080@2, 2.2.2.2:8080",
+		"us = 3.3.3.3:8080",
+		"hk=4.4.4.4:8080",
+	}}.ParseGroups()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"hk": {"1.1.1.1:8080@2", "2.2.2.2:8080", "4.4.4.4:8080"},
+		"us": {"3.3.3.3:8080"},
+	}, groups)
+
+	for _, g := range []string{"hk", "=1.1.1.1:8080", "hk=", "direct=1.1.1.1:8080"} {
+		_, err = RuleConfig{Groups: []string{g}}.ParseGroups()
+		assert.Error(t, err, g)
+	}
+}
+
+func TestLbConfig_CheckProbes(t *testing.T) {
+	c := LbConfig{Probe: "tcp"}
+	require.NoError(t, c.CheckProbes("ss"))
+
+	c.Probe = "icmp"
+	require.Error(t, c.CheckProbes("socks5"))
+
+	// jocasta探针仅支持socks5和http父级
+	c.Probe = "jocasta"
+	require.NoError(t, c.CheckProbes("socks5"))
+	require.NoError(t, c.CheckProbes("http"))
+	require.Error(t, c.CheckProbes("ss"))
+
+	c = LbConfig{Probe: "tcp", Probes: map[string]string{"127.0.0.1:8080": "jocasta"}}
+	require.NoError(t, c.CheckProbes("http"))
+	require.Error(t, c.CheckProbes("ss"))
+}
@@ -0,0 +1,109 @@
+// Package fwatch 文件变化监视, 周期轮询文件的修改时间和大小, 文件修改, 创建或删除时回调
+package fwatch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval 默认轮询间隔
+const DefaultInterval = 5 * time.Second
+
+// Watcher 文件监视器
+type Watcher struct {
+	interval time.Duration
+	mu       sync.Mutex
+	groups   []*group
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// group 一组文件, 任一文件变化时回调一次
+type group struct {
+	files    []string
+	stats    []fileStat
+	onChange func()
+}
+
+type fileStat struct {
+	exist   bool
+	size    int64
+	modTime int64 // unix纳秒
+}
+
+// New 新建文件监视器, interval 为轮询间隔, 小于等于0时使用 DefaultInterval
+func New(interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	w := &Watcher{
+		interval: interval,
+		closed:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Add 监视一组文件, 忽略空文件名, 任一文件变化时在监视协程中调用一次onChange
+func (sf *Watcher) Add(onChange func(), filenames ...string) {
+	g := &group{onChange: onChange}
+	for _, filename := range filenames {
+		if filename != "" {
+			g.files = append(g.files, filename)
+			g.stats = append(g.stats, stat(filename))
+		}
+	}
+	if len(g.files) == 0 {
+		return
+	}
+	sf.mu.Lock()
+	sf.groups = append(sf.groups, g)
+	sf.mu.Unlock()
+}
+
+// Close 停止监视
+func (sf *Watcher) Close() error {
+	sf.once.Do(func() { close(sf.closed) })
+	return nil
+}
+
+func (sf *Watcher) run() {
+	tick := time.NewTicker(sf.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-sf.closed:
+			return
+		case <-tick.C:
+		}
+		sf.mu.Lock()
+		groups := append([]*group{}, sf.groups...)
+		sf.mu.Unlock()
+		for _, g := range groups {
+			if g.changed() {
+				g.onChange()
+			}
+		}
+	}
+}
+
+// changed 检查文件是否变化, 并更新文件状态
+func (sf *group) changed() bool {
+	changed := false
+	for i, filename := range sf.files {
+		if st := stat(filename); st != sf.stats[i] {
+			sf.stats[i] = st
+			changed = true
+		}
+	}
+	return changed
+}
+
+func stat(filename string) fileStat {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{true, fi.Size(), fi.ModTime().UnixNano()}
+}
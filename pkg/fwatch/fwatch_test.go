@@ -0,0 +1,50 @@
+package fwatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fwatch")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(dir, "file2")
+	require.NoError(t, ioutil.WriteFile(file1, []byte("a"), 0644))
+
+	w := New(time.Millisecond * 20)
+	defer w.Close()
+
+	count := atomic.NewInt32(0)
+	w.Add(func() { count.Inc() }, file1, "", file2)
+	w.Add(func() { t.Error("empty group should not be watched") }, "")
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int32(0), count.Load())
+
+	// 修改和创建在同一周期内只回调一次
+	require.NoError(t, ioutil.WriteFile(file1, []byte("ab"), 0644))
+	require.NoError(t, ioutil.WriteFile(file2, []byte("b"), 0644))
+	assert.Eventually(t, func() bool { return count.Load() >= 1 }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 100)
+	n := count.Load()
+	assert.LessOrEqual(t, n, int32(2))
+
+	// 删除
+	require.NoError(t, os.Remove(file2))
+	assert.Eventually(t, func() bool { return count.Load() == n+1 }, time.Second, time.Millisecond*10)
+
+	w.Close()
+	require.NoError(t, ioutil.WriteFile(file1, []byte("abc"), 0644))
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, n+1, count.Load())
+}
@@ -24,8 +24,10 @@ type Request struct {
 	RawURL          string
 	hostOrURL       string
 	basicAuthCenter *basicAuth.Center
+	localPaths      []string
 	log             logger.Logger
 	IsSNI           bool
+	IsLocal         bool // 请求本地服务路径(如 GET /proxy.pac), 不进行认证, 由服务本身处理
 }
 
 func New(inConn net.Conn, bufSize int, opts ...Option) (req Request, err error) {
@@ -66,9 +68,12 @@ func New(inConn net.Conn, bufSize int, opts ...Option) (req Request, err error)
 		req.IsSNI = true
 	}
 	req.Method = strings.ToUpper(req.Method)
+	req.IsLocal = req.isLocalPath()
 
-	if err = req.BasicAuth(); err != nil {
-		return
+	if !req.IsLocal {
+		if err = req.BasicAuth(); err != nil {
+			return
+		}
 	}
 
 	var port string
@@ -162,6 +167,23 @@ func (sf *Request) BasicAuth() error {
 	return nil
 }
 
+// isLocalPath 是否为origin-form请求(如 GET /proxy.pac)且路径(不含query)在本地服务路径中
+func (sf *Request) isLocalPath() bool {
+	if sf.IsSNI || !strings.HasPrefix(sf.hostOrURL, "/") {
+		return false
+	}
+	path := sf.hostOrURL
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	for _, p := range sf.localPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
 // getHTTPRawURL get http raw url
 func (sf *Request) getHTTPRawURL() string {
 	if !strings.HasPrefix(sf.hostOrURL, "/") {
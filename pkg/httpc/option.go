@@ -14,6 +14,13 @@ func WithBasicAuth(center *basicAuth.Center) Option {
 	}
 }
 
+// WithLocalPaths 本地服务路径, origin-form请求路径匹配时不进行认证, 设置 Request.IsLocal
+func WithLocalPaths(paths ...string) Option {
+	return func(r *Request) {
+		r.localPaths = paths
+	}
+}
+
 func WithLogger(log logger.Logger) Option {
 	return func(r *Request) {
 		r.log = log
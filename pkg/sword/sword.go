@@ -13,6 +13,12 @@ const BindingSize = 4096
 // Binding binding
 var Binding = binding.New(BindingSize, binding.WithGPool(GoPool))
 
+// DatagramSize udp datagram buffer size, 可容纳最大udp数据报及其StreamDatagram帧头
+const DatagramSize = 64*1024 + 512
+
+// Datagram udp datagram binding
+var Datagram = binding.New(DatagramSize, binding.WithGPool(GoPool))
+
 // Validate validator
 var Validate = validator.New()
 
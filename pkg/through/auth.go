@@ -0,0 +1,284 @@
+package through
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/pkg/through/ddt"
+)
+
+// FlagAuth captain.OptionFlags 标志位, 节点请求挑战应答认证
+const FlagAuth = byte(0x01)
+
+// through option type defined, see captain.Option
+const (
+	OptionNonce     = byte(0x10) // 挑战随机数
+	OptionTimestamp = byte(0x11) // 应答时间戳, unix秒, 8字节大端
+	OptionKeyID     = byte(0x12) // 密钥标识, 由密钥派生, 不泄露密钥
+)
+
+// NonceSize 挑战随机数长度
+const NonceSize = 32
+
+// DefaultAuthWindow 应答时间戳允许的最大偏差
+const DefaultAuthWindow = 30 * time.Second
+
+// auth error defined
+var (
+	ErrAuthFailure      = errors.New("through: authentication failure")
+	ErrAuthNotSupported = errors.New("through: bridge not support authentication")
+)
+
+// KeyID returns the key identity derived from secret key,
+// it is used to find the secret key on bridge without sending the key.
+func KeyID(secretKey string) []byte {
+	sum := sha256.Sum256([]byte("jocasta-through-key-id:" + secretKey))
+	return sum[:8]
+}
+
+// AuthTag returns HMAC-SHA256 over nonce, timestamp, node type and id with secret key
+func AuthTag(secretKey string, nonce []byte, timestamp int64, types Types, id string) []byte {
+	var ts [8]byte
+
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write(nonce)               // nolint: errcheck
+	mac.Write(ts[:])               // nolint: errcheck
+	mac.Write([]byte{byte(types)}) // nolint: errcheck
+	mac.Write([]byte(id))          // nolint: errcheck
+	return mac.Sum(nil)
+}
+
+// Challenge bridge challenge
+// challenge is formed as captain.Frame v2 with OptionNonce
+type Challenge struct {
+	Version byte
+	Nonce   []byte
+}
+
+// NewChallenge new challenge with random nonce
+func NewChallenge(version byte) (*Challenge, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Challenge{version, nonce}, nil
+}
+
+// ParseChallenge parse challenge
+func ParseChallenge(r io.Reader) (*Challenge, error) {
+	f, err := captain.ParseFrameLimit(r, MaxControlFrameLen)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ok := f.Options.Get(OptionNonce)
+	if !ok || len(nonce) != NonceSize {
+		return nil, ErrAuthFailure
+	}
+	return &Challenge{f.Version, nonce}, nil
+}
+
+// Bytes to byte
+func (sf *Challenge) Bytes() ([]byte, error) {
+	f := captain.Frame{
+		Version: sf.Version,
+		Options: captain.Options{{Type: OptionNonce, Value: sf.Nonce}},
+	}
+	return f.Bytes()
+}
+
+// AuthResponse node response to the challenge
+// response is formed as captain.Frame v2 with OptionKeyID, OptionTimestamp and captain.OptionAuthTag
+type AuthResponse struct {
+	Version   byte
+	KeyID     []byte
+	Timestamp int64
+	Tag       []byte
+}
+
+// NewAuthResponse new response to the challenge nonce
+func NewAuthResponse(version byte, secretKey string, nonce []byte, types Types, id string) *AuthResponse {
+	ts := time.Now().Unix()
+	return &AuthResponse{
+		Version:   version,
+		KeyID:     KeyID(secretKey),
+		Timestamp: ts,
+		Tag:       AuthTag(secretKey, nonce, ts, types, id),
+	}
+}
+
+// ParseAuthResponse parse auth response
+func ParseAuthResponse(r io.Reader) (*AuthResponse, error) {
+	f, err := captain.ParseFrameLimit(r, MaxControlFrameLen)
+	if err != nil {
+		return nil, err
+	}
+	keyID, ok1 := f.Options.Get(OptionKeyID)
+	ts, ok2 := f.Options.Get(OptionTimestamp)
+	tag, ok3 := f.Options.Get(captain.OptionAuthTag)
+	if !ok1 || !ok2 || !ok3 || len(ts) != 8 {
+		return nil, ErrAuthFailure
+	}
+	return &AuthResponse{
+		Version:   f.Version,
+		KeyID:     keyID,
+		Timestamp: int64(binary.BigEndian.Uint64(ts)),
+		Tag:       tag,
+	}, nil
+}
+
+// Bytes to byte
+func (sf *AuthResponse) Bytes() ([]byte, error) {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(sf.Timestamp))
+	f := captain.Frame{
+		Version: sf.Version,
+		Options: captain.Options{
+			{Type: OptionKeyID, Value: sf.KeyID},
+			{Type: OptionTimestamp, Value: ts},
+			{Type: captain.OptionAuthTag, Value: sf.Tag},
+		},
+	}
+	return f.Bytes()
+}
+
+// Keyring secret keys allowed on bridge, key id ---> secret key
+type Keyring map[string]string
+
+// NewKeyring new keyring with secret keys
+func NewKeyring(secretKeys ...string) Keyring {
+	k := make(Keyring, len(secretKeys))
+	for _, sk := range secretKeys {
+		k[hex.EncodeToString(KeyID(sk))] = sk
+	}
+	return k
+}
+
+// Verify verify the response to the challenge nonce, it returns the matched secret key.
+// the timestamp of response should be within window of now, window <= 0 use DefaultAuthWindow.
+func (sf Keyring) Verify(resp *AuthResponse, nonce []byte, types Types, id string, window time.Duration) (string, error) {
+	if window <= 0 {
+		window = DefaultAuthWindow
+	}
+	sk, ok := sf[hex.EncodeToString(resp.KeyID)]
+	if !ok {
+		return "", ErrAuthFailure
+	}
+	if d := time.Since(time.Unix(resp.Timestamp, 0)); d > window || d < -window {
+		return "", ErrAuthFailure
+	}
+	if !hmac.Equal(resp.Tag, AuthTag(sk, nonce, resp.Timestamp, types, id)) {
+		return "", ErrAuthFailure
+	}
+	return sk, nil
+}
+
+// Authenticate the bridge side authentication after the negotiate request parsed,
+// it sends challenge to the node if the node requested, and verify the response.
+// it returns the secret key of the node, if keyring is empty, no authentication required,
+// and the secret key is taken from the request for compatibility.
+func (sf Keyring) Authenticate(rw io.ReadWriter, nego *NegotiateRequest, window time.Duration) (string, error) {
+	flags, _ := nego.Options.Get(captain.OptionFlags)
+	if len(flags) == 0 || flags[0]&FlagAuth == 0 {
+		if len(sf) > 0 { // authentication required
+			return "", ErrAuthFailure
+		}
+		return nego.Nego.SecretKey, nil
+	}
+	if len(sf) == 0 {
+		return "", ErrAuthNotSupported
+	}
+
+	version := captain.NegotiateVersion(Version, nego.Version)
+	challenge, err := NewChallenge(version)
+	if err != nil {
+		return "", err
+	}
+	b, err := challenge.Bytes()
+	if err != nil {
+		return "", err
+	}
+	if _, err = rw.Write(b); err != nil {
+		return "", err
+	}
+	resp, err := ParseAuthResponse(rw)
+	if err != nil {
+		return "", err
+	}
+	return sf.Verify(resp, challenge.Nonce, nego.Types, nego.Nego.Id, window)
+}
+
+// Negotiate the node side negotiation with bridge, it returns the negotiated version and settings.
+// if auth is true, the secret key never sent on the wire, the bridge should support
+// version >= Version2 and configure the secret key.
+// if caps is not nil, the capabilities is advertised to the bridge, and the bridge with
+// version >= Version3 replies the agreed settings, otherwise the returned settings is nil,
+// both sides should use the default settings.
+func Negotiate(rw io.ReadWriter, types Types, id, secretKey string, auth bool, caps *ddt.Capabilities) (byte, *ddt.Capabilities, error) {
+	nego := NegotiateRequest{
+		Types:   types,
+		Version: Version,
+		Nego:    ddt.NegotiateRequest{SecretKey: secretKey, Id: id, Capabilities: caps},
+	}
+	if auth {
+		nego.Nego.SecretKey = "" // never on the wire
+		nego.Options.Set(captain.OptionFlags, []byte{FlagAuth})
+	}
+	b, err := nego.Bytes()
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err = rw.Write(b); err != nil {
+		return 0, nil, err
+	}
+
+	// the bridge replies directly if it refuses, otherwise sends challenge first,
+	// the status of reply never has the v2 frame flag.
+	tmp := []byte{0, 0}
+	if _, err = io.ReadFull(rw, tmp); err != nil {
+		return 0, nil, err
+	}
+	if auth && tmp[0]&captain.FlagFrameV2 != 0 {
+		challenge, err := ParseChallenge(io.MultiReader(bytes.NewReader(tmp), rw))
+		if err != nil {
+			return 0, nil, err
+		}
+		resp := NewAuthResponse(challenge.Version, secretKey, challenge.Nonce, types, id)
+		if b, err = resp.Bytes(); err != nil {
+			return 0, nil, err
+		}
+		if _, err = rw.Write(b); err != nil {
+			return 0, nil, err
+		}
+		if _, err = io.ReadFull(rw, tmp); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	reply := captain.Reply{Status: tmp[0], Version: tmp[1]}
+	switch reply.Status {
+	case RepSuccess:
+	case RepAuthFailure:
+		return 0, nil, ErrAuthFailure
+	default:
+		return 0, nil, fmt.Errorf("through: bridge response %d", reply.Status)
+	}
+	version := captain.NegotiateVersion(Version, reply.Version)
+	if caps == nil || version < Version3 {
+		return version, nil, nil
+	}
+	settings, err := ParseNegotiateReply(rw)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, settings, nil
+}
@@ -0,0 +1,107 @@
+package through
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/core/captain"
+)
+
+// bridge simulate the bridge side negotiation
+func bridge(t *testing.T, conn net.Conn, keyring Keyring) chan string {
+	ch := make(chan string, 1)
+	go func() {
+		defer conn.Close()
+		nego, err := ParseNegotiateRequest(conn)
+		if !assert.NoError(t, err) {
+			return
+		}
+		sk, err := keyring.Authenticate(conn, nego, 0)
+		if err != nil {
+			captain.SendReply(conn, RepAuthFailure, Version) // nolint: errcheck
+			ch <- ""
+			return
+		}
+		captain.SendReply(conn, RepSuccess, captain.NegotiateVersion(Version, nego.Version)) // nolint: errcheck
+		ch <- sk
+	}()
+	return ch
+}
+
+func TestNegotiate(t *testing.T) {
+	keyring := NewKeyring("sk1", "sk2")
+
+	t.Run("auth", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		ch := bridge(t, c2, keyring)
+		version, _, err := Negotiate(c1, TypesClient, "id", "sk2", true, nil)
+		require.NoError(t, err)
+		assert.Equal(t, byte(Version), version)
+		assert.Equal(t, "sk2", <-ch)
+	})
+
+	t.Run("auth with unknown key", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		ch := bridge(t, c2, keyring)
+		_, _, err := Negotiate(c1, TypesClient, "id", "sk3", true, nil)
+		require.Equal(t, ErrAuthFailure, err)
+		assert.Equal(t, "", <-ch)
+	})
+
+	t.Run("plaintext refused when auth required", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		ch := bridge(t, c2, keyring)
+		_, _, err := Negotiate(c1, TypesServer, "id", "sk1", false, nil)
+		require.Equal(t, ErrAuthFailure, err)
+		assert.Equal(t, "", <-ch)
+	})
+
+	t.Run("auth not supported", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		ch := bridge(t, c2, nil)
+		_, _, err := Negotiate(c1, TypesServer, "id", "sk1", true, nil)
+		require.Equal(t, ErrAuthFailure, err)
+		assert.Equal(t, "", <-ch)
+	})
+
+	t.Run("plaintext compatible", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		ch := bridge(t, c2, nil)
+		_, _, err := Negotiate(c1, TypesServer, "id", "sk1", false, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "sk1", <-ch)
+	})
+}
+
+func TestKeyringVerify(t *testing.T) {
+	keyring := NewKeyring("sk")
+	challenge, err := NewChallenge(Version)
+	require.NoError(t, err)
+
+	resp := NewAuthResponse(Version, "sk", challenge.Nonce, TypesClient, "id")
+	sk, err := keyring.Verify(resp, challenge.Nonce, TypesClient, "id", time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "sk", sk)
+
+	// replay with another nonce
+	other, err := NewChallenge(Version)
+	require.NoError(t, err)
+	_, err = keyring.Verify(resp, other.Nonce, TypesClient, "id", time.Second)
+	require.Equal(t, ErrAuthFailure, err)
+
+	// node type or id mismatch
+	_, err = keyring.Verify(resp, challenge.Nonce, TypesServer, "id", time.Second)
+	require.Equal(t, ErrAuthFailure, err)
+	_, err = keyring.Verify(resp, challenge.Nonce, TypesClient, "id2", time.Second)
+	require.Equal(t, ErrAuthFailure, err)
+
+	// expired
+	resp.Timestamp -= 10
+	resp.Tag = AuthTag("sk", challenge.Nonce, resp.Timestamp, TypesClient, "id")
+	_, err = keyring.Verify(resp, challenge.Nonce, TypesClient, "id", time.Second)
+	require.Equal(t, ErrAuthFailure, err)
+}
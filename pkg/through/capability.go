@@ -0,0 +1,131 @@
+package through
+
+import (
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/pkg/through/ddt"
+)
+
+// AgreeCapabilities 协商双方能力, 返回双方都支持的设置, 规则如下:
+// 压缩方法: 取节点优先级最高且bridge支持的, 都不支持时为NONE
+// smux版本: 取双方都支持的最高版本, 无交集时为1
+// 保活间隔,保活超时: 取较大值, 避免一方过早判定会话超时
+// 最大流数,接收窗口: 取非零的较小值, 0表示未指定
+// 网络类型: 取交集
+func AgreeCapabilities(node, bridge *ddt.Capabilities) *ddt.Capabilities {
+	settings := &ddt.Capabilities{
+		Compression:       []ddt.Compression{ddt.Compression_NONE},
+		SmuxVersion:       []uint32{1},
+		KeepAliveInterval: maxUint32(node.GetKeepAliveInterval(), bridge.GetKeepAliveInterval()),
+		KeepAliveTimeout:  maxUint32(node.GetKeepAliveTimeout(), bridge.GetKeepAliveTimeout()),
+		MaxStreams:        minNonZero(node.GetMaxStreams(), bridge.GetMaxStreams()),
+		MaxReceiveBuffer:  minNonZero(node.GetMaxReceiveBuffer(), bridge.GetMaxReceiveBuffer()),
+		MaxStreamBuffer:   minNonZero(node.GetMaxStreamBuffer(), bridge.GetMaxStreamBuffer()),
+	}
+
+	for _, c := range node.GetCompression() {
+		if containsCompression(bridge.GetCompression(), c) {
+			settings.Compression[0] = c
+			break
+		}
+	}
+	for _, v := range node.GetSmuxVersion() {
+		if v > settings.SmuxVersion[0] && containsUint32(bridge.GetSmuxVersion(), v) {
+			settings.SmuxVersion[0] = v
+		}
+	}
+	// 流窗口不能超过会话窗口
+	if settings.MaxReceiveBuffer > 0 && settings.MaxStreamBuffer > settings.MaxReceiveBuffer {
+		settings.MaxStreamBuffer = settings.MaxReceiveBuffer
+	}
+	for _, n := range node.GetNetworks() {
+		if containsNetwork(bridge.GetNetworks(), n) {
+			settings.Networks = append(settings.Networks, n)
+		}
+	}
+	return settings
+}
+
+// SupportNetwork 协商后的设置是否支持该网络类型, settings为nil表示未协商, 全部支持
+func SupportNetwork(settings *ddt.Capabilities, network ddt.Network) bool {
+	return settings == nil || containsNetwork(settings.GetNetworks(), network)
+}
+
+// SendNegotiateReply bridge回复节点协商请求.
+// 回复成功且协商版本 >= Version3且settings不为nil时, 随后发送协商后的设置,
+// 格式为captain.Frame, 数据为ddt.NegotiateReply
+func SendNegotiateReply(w io.Writer, status, version byte, settings *ddt.Capabilities) error {
+	b := []byte{status, version}
+	if status == RepSuccess && version >= Version3 && settings != nil {
+		data, err := proto.Marshal(&ddt.NegotiateReply{Settings: settings})
+		if err != nil {
+			return err
+		}
+		f := captain.Frame{Version: version, Data: data}
+		if b, err = f.Append(b); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// ParseNegotiateReply 解析bridge发送的协商后的设置
+func ParseNegotiateReply(r io.Reader) (*ddt.Capabilities, error) {
+	f, err := captain.ParseFrameLimit(r, MaxControlFrameLen)
+	if err != nil {
+		return nil, err
+	}
+	reply := &ddt.NegotiateReply{}
+	if err = proto.Unmarshal(f.Data, reply); err != nil {
+		return nil, err
+	}
+	if reply.Settings == nil {
+		return &ddt.Capabilities{}, nil
+	}
+	return reply.Settings, nil
+}
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minNonZero(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func containsUint32(s []uint32, v uint32) bool {
+	for _, vv := range s {
+		if vv == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCompression(s []ddt.Compression, v ddt.Compression) bool {
+	for _, vv := range s {
+		if vv == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNetwork(s []ddt.Network, v ddt.Network) bool {
+	for _, vv := range s {
+		if vv == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package through
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/pkg/through/ddt"
+)
+
+func TestAgreeCapabilities(t *testing.T) {
+	node := &ddt.Capabilities{
+		Compression:       []ddt.Compression{ddt.Compression_SNAPPY, ddt.Compression_NONE},
+		SmuxVersion:       []uint32{1, 2},
+		KeepAliveInterval: 10,
+		KeepAliveTimeout:  30,
+		MaxStreams:        0,
+		MaxReceiveBuffer:  4 << 20,
+		MaxStreamBuffer:   64 << 10,
+		Networks:          []ddt.Network{ddt.Network_TCP, ddt.Network_UDP},
+	}
+
+	t.Run("same", func(t *testing.T) {
+		got := AgreeCapabilities(node, node)
+		assert.Equal(t, []ddt.Compression{ddt.Compression_SNAPPY}, got.Compression)
+		assert.Equal(t, []uint32{2}, got.SmuxVersion)
+		assert.Equal(t, uint32(10), got.KeepAliveInterval)
+		assert.Equal(t, uint32(30), got.KeepAliveTimeout)
+		assert.Equal(t, uint32(0), got.MaxStreams)
+		assert.Equal(t, uint32(4<<20), got.MaxReceiveBuffer)
+		assert.Equal(t, uint32(64<<10), got.MaxStreamBuffer)
+		assert.Equal(t, []ddt.Network{ddt.Network_TCP, ddt.Network_UDP}, got.Networks)
+	})
+
+	t.Run("intersection", func(t *testing.T) {
+		bridge := &ddt.Capabilities{
+			Compression:       []ddt.Compression{ddt.Compression_NONE},
+			SmuxVersion:       []uint32{1},
+			KeepAliveInterval: 20,
+			KeepAliveTimeout:  20,
+			MaxStreams:        100,
+			MaxReceiveBuffer:  32 << 10,
+			Networks:          []ddt.Network{ddt.Network_TCP},
+		}
+		got := AgreeCapabilities(node, bridge)
+		assert.Equal(t, []ddt.Compression{ddt.Compression_NONE}, got.Compression)
+		assert.Equal(t, []uint32{1}, got.SmuxVersion)
+		assert.Equal(t, uint32(20), got.KeepAliveInterval)
+		assert.Equal(t, uint32(30), got.KeepAliveTimeout)
+		assert.Equal(t, uint32(100), got.MaxStreams)
+		assert.Equal(t, uint32(32<<10), got.MaxReceiveBuffer)
+		assert.Equal(t, uint32(32<<10), got.MaxStreamBuffer)
+		assert.Equal(t, []ddt.Network{ddt.Network_TCP}, got.Networks)
+		assert.True(t, SupportNetwork(got, ddt.Network_TCP))
+		assert.False(t, SupportNetwork(got, ddt.Network_UDP))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		got := AgreeCapabilities(&ddt.Capabilities{}, nil)
+		assert.Equal(t, []ddt.Compression{ddt.Compression_NONE}, got.Compression)
+		assert.Equal(t, []uint32{1}, got.SmuxVersion)
+		assert.Empty(t, got.Networks)
+		assert.True(t, SupportNetwork(nil, ddt.Network_UDP))
+	})
+}
+
+func TestNegotiateReply(t *testing.T) {
+	settings := &ddt.Capabilities{SmuxVersion: []uint32{2}, MaxStreams: 8}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, SendNegotiateReply(buf, RepSuccess, Version, settings))
+	rep, err := captain.ParseReply(buf)
+	require.NoError(t, err)
+	assert.Equal(t, byte(RepSuccess), rep.Status)
+	got, err := ParseNegotiateReply(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2}, got.SmuxVersion)
+	assert.Equal(t, uint32(8), got.MaxStreams)
+
+	// old version or failure reply without settings
+	buf.Reset()
+	require.NoError(t, SendNegotiateReply(buf, RepSuccess, Version2, settings))
+	assert.Equal(t, []byte{RepSuccess, Version2}, buf.Bytes())
+	buf.Reset()
+	require.NoError(t, SendNegotiateReply(buf, RepFailure, Version, settings))
+	assert.Equal(t, []byte{RepFailure, Version}, buf.Bytes())
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	caps := &ddt.Capabilities{
+		SmuxVersion: []uint32{1, 2},
+		Networks:    []ddt.Network{ddt.Network_TCP, ddt.Network_UDP},
+	}
+	bridgeCaps := &ddt.Capabilities{
+		SmuxVersion: []uint32{1, 2},
+		Networks:    []ddt.Network{ddt.Network_TCP},
+	}
+
+	t.Run("agreed", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			nego, err := ParseNegotiateRequest(c2)
+			if !assert.NoError(t, err) {
+				return
+			}
+			version := captain.NegotiateVersion(Version, nego.Version)
+			settings := AgreeCapabilities(nego.Nego.Capabilities, bridgeCaps)
+			SendNegotiateReply(c2, RepSuccess, version, settings) // nolint: errcheck
+		}()
+		version, settings, err := Negotiate(c1, TypesServer, "id", "sk", false, caps)
+		require.NoError(t, err)
+		assert.Equal(t, byte(Version), version)
+		require.NotNil(t, settings)
+		assert.Equal(t, []uint32{2}, settings.SmuxVersion)
+		assert.Equal(t, []ddt.Network{ddt.Network_TCP}, settings.Networks)
+	})
+
+	t.Run("old bridge", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			_, err := ParseNegotiateRequest(c2)
+			if !assert.NoError(t, err) {
+				return
+			}
+			captain.SendReply(c2, RepSuccess, Version1) // nolint: errcheck
+		}()
+		version, settings, err := Negotiate(c1, TypesServer, "id", "sk", false, caps)
+		require.NoError(t, err)
+		assert.Equal(t, byte(Version1), version)
+		assert.Nil(t, settings)
+	})
+}
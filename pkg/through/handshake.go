@@ -18,20 +18,23 @@ import (
 // VER 版本, 透传版本
 // DATA_LEN see package captain data length defined
 // DATA 数据
+// 带选项时为captain v2帧格式, see captain.Frame
 type HandshakeRequest struct {
 	Version byte
 	Hand    ddt.HandshakeRequest
+	Options captain.Options
 }
 
 // ParseHandshakeRequest parse negotiate request
 func ParseHandshakeRequest(r io.Reader) (*HandshakeRequest, error) {
-	tr, err := captain.ParseRequest(r)
+	tr, err := captain.ParseFrameLimit(r, MaxControlFrameLen)
 	if err != nil {
 		return nil, err
 	}
 
 	tnr := &HandshakeRequest{
 		Version: tr.Version,
+		Options: tr.Options,
 	}
 	if err = proto.Unmarshal(tr.Data, &tnr.Hand); err != nil {
 		return nil, err
@@ -45,8 +48,9 @@ func (sf *HandshakeRequest) Bytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	tr := captain.Request{
+	tr := captain.Frame{
 		Version: sf.Version,
+		Options: sf.Options,
 		Data:    data,
 	}
 	return tr.Bytes()
@@ -20,6 +20,7 @@ func TestHandshakeRequest(t *testing.T) {
 			Host:      "localhost",
 			Port:      8080,
 		},
+		nil,
 	}
 
 	b, err := nego.Bytes()
@@ -11,8 +11,21 @@ import (
 	"github.com/thinkgos/jocasta/pkg/through/ddt"
 )
 
-// Version 透传协议版本
-const Version = 1
+// 透传协议版本
+const (
+	// Version1 使用captain v1帧
+	Version1 = 1
+	// Version2 可使用captain v2帧(带选项)
+	Version2 = 2
+	// Version3 支持能力协商, 成功回复后bridge发送协商后的设置
+	Version3 = 3
+	// Version 当前透传协议版本, 协商时双方取较低版本
+	Version = Version3
+)
+
+// MaxControlFrameLen 协商, 认证和握手等控制帧数据的最大长度, 控制帧均为较小的protobuf消息,
+// 限制长度避免未认证的对端以超大长度消耗内存
+const MaxControlFrameLen = 64 << 10
 
 // Types 透传节点类型
 type Types byte
@@ -31,18 +44,22 @@ const (
 	RepNetworkUnreachable        // 网络不可达
 	RepTypesNotSupport           // 节点类型不支持
 	RepConnectionRefused         // 连接拒绝
+	RepAuthFailure               // 认证失败
 )
 
 // NegotiateRequest negotiate request
+// 无选项时以captain v1帧格式发送, 旧节点可以解析, VER为本节点支持的最高版本,
+// 回复的VER为协商后的版本.
 type NegotiateRequest struct {
 	Types   Types
 	Version byte
 	Nego    ddt.NegotiateRequest
+	Options captain.Options // 选项, 仅协商版本 >= Version2 时可用
 }
 
 // ParseNegotiateRequest parse negotiate request
 func ParseNegotiateRequest(r io.Reader) (*NegotiateRequest, error) {
-	tr, err := captain.ParseRequest(r)
+	tr, err := captain.ParseFrameLimit(r, MaxControlFrameLen)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +67,7 @@ func ParseNegotiateRequest(r io.Reader) (*NegotiateRequest, error) {
 	tnr := &NegotiateRequest{
 		Types:   Types(tr.Types),
 		Version: tr.Version,
+		Options: tr.Options,
 	}
 	if err = proto.Unmarshal(tr.Data, &tnr.Nego); err != nil {
 		return nil, err
@@ -63,9 +81,10 @@ func (sf *NegotiateRequest) Bytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	tr := captain.Request{
+	tr := captain.Frame{
 		Types:   byte(sf.Types),
 		Version: sf.Version,
+		Options: sf.Options,
 		Data:    data,
 	}
 	return tr.Bytes()
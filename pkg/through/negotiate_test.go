@@ -6,7 +6,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/thinkgos/jocasta/core/captain"
 	"github.com/thinkgos/jocasta/pkg/through/ddt"
 )
 
@@ -18,6 +20,7 @@ func TestNegotiateRequest(t *testing.T) {
 			SecretKey: "SecretKey",
 			Id:        "Id",
 		},
+		nil,
 	}
 
 	b, err := nego.Bytes()
@@ -31,3 +34,35 @@ func TestNegotiateRequest(t *testing.T) {
 	assert.Equal(t, nego.Nego.SecretKey, want.Nego.SecretKey)
 	assert.Equal(t, nego.Nego.Id, want.Nego.Id)
 }
+
+func TestNegotiateRequestOptions(t *testing.T) {
+	nego := &NegotiateRequest{
+		Types:   TypesServer,
+		Version: Version,
+		Nego: ddt.NegotiateRequest{
+			SecretKey: "SecretKey",
+			Id:        "Id",
+		},
+	}
+	nego.Options.Set(captain.OptionFlags, []byte{0x01})
+
+	b, err := nego.Bytes()
+	require.NoError(t, err)
+
+	want, err := ParseNegotiateRequest(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, nego.Types, want.Types)
+	assert.Equal(t, nego.Options, want.Options)
+	assert.Equal(t, nego.Nego.SecretKey, want.Nego.SecretKey)
+
+	// v1 request from old node
+	data, err := proto.Marshal(&nego.Nego)
+	require.NoError(t, err)
+	old, err := captain.Request{Types: byte(TypesClient), Version: Version1, Data: data}.Bytes()
+	require.NoError(t, err)
+	want, err = ParseNegotiateRequest(bytes.NewReader(old))
+	require.NoError(t, err)
+	assert.Equal(t, byte(Version1), want.Version)
+	assert.Equal(t, "SecretKey", want.Nego.SecretKey)
+	assert.Empty(t, want.Options)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	sdns "github.com/thinkgos/jocasta/services/dns"
+)
+
+var dnsCfg sdns.Config
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "proxy on dns mode",
+	Run: func(cmd *cobra.Command, args []string) {
+		if forever {
+			return
+		}
+		dnsCfg.SKCPConfig = kcpCfg
+
+		srv := sdns.New(dnsCfg, sdns.WithLogger(zap.S()))
+		err := srv.Start()
+		if err != nil {
+			log.Fatalf("run service [%s],%s", cmd.Name(), err)
+		}
+		server = srv
+	},
+}
+
+func init() {
+	flags := dnsCmd.Flags()
+	// local
+	flags.StringVarP(&dnsCfg.Local, "local", "p", ":53", "local ip:port to listen on udp and tcp")
+	// upstream
+	flags.StringVar(&dnsCfg.DirectDNS, "direct-dns", "223.5.5.5:53", "upstream for direct domains, multiple addresses separated by comma are tried in order, such as: 8.8.8.8:53,tls://1.1.1.1:853,https://dns.google/dns-query")
+	flags.StringVar(&dnsCfg.ProxyDNS, "proxy-dns", "", "upstream for proxy domains, format same as --direct-dns, only worked when --parent not set")
+	// parent
+	flags.StringVarP(&dnsCfg.ParentType, "parent-type", "T", "", "parent protocol type <tcp|tls|stcp|kcp>, the proxy domains are queried through the parent by tcp dns")
+	flags.StringVarP(&dnsCfg.Parent, "parent", "P", "", "parent address, such as: \"23.32.32.19:28008\", the parent should forward the stream to a dns server")
+	flags.BoolVarP(&dnsCfg.ParentCompress, "parent-compress", "M", false, "auto compress/decompress data on parent connection")
+	// tls
+	flags.StringVarP(&dnsCfg.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
+	flags.StringVarP(&dnsCfg.KeyFile, "key", "K", "proxy.key", "key file for tls")
+	flags.StringVar(&dnsCfg.CaCertFile, "ca", "", "ca cert file for tls")
+	// stcp
+	dnsCfg.STCPConfig = stcpCfg
+	// filter
+	flags.StringVar(&dnsCfg.FilterConfig.Intelligent, "intelligent", "intelligent", "setting dns split mode, can be <intelligent|direct|proxy>, domains not in any file are queried by proxy upstream")
+	flags.StringVarP(&dnsCfg.FilterConfig.ProxyFile, "blocked", "b", "blocked", "blocked domain file , one domain each line")
+	flags.StringVarP(&dnsCfg.FilterConfig.DirectFile, "direct", "d", "direct", "direct domain file , one domain each line")
+	// static
+	flags.StringArrayVar(&dnsCfg.Hosts, "host", nil, "static record, format is domain=ip, domain can be wildcard like *.example.com, can be set multiple times")
+	flags.StringVar(&dnsCfg.HostsFile, "hosts-file", "", "static records file in hosts format, one \"ip domain [domain...]\" each line")
+	// cache
+	flags.IntVar(&dnsCfg.TTL, "ttl", 300, "max caching seconds of dns query result, never longer than the record ttl, also the ttl of static records")
+	flags.IntVar(&dnsCfg.NegativeTTL, "negative-ttl", 30, "caching seconds of NXDOMAIN or SERVFAIL result, 0 means no caching")
+	flags.IntVar(&dnsCfg.CacheSize, "cache-size", 4096, "max entries of dns cache, least recently used entries are evicted, 0 means unlimited")
+	flags.BoolVar(&dnsCfg.Prefetch, "prefetch", false, "refresh hot entries in background before they expire")
+	// fake ip
+	flags.StringVar(&dnsCfg.FakeIP, "fake-ip", "", "answer A query of proxy domains with fake ip in this cidr, such as: 198.18.0.0/15, AAAA query answer empty")
+	flags.StringVar(&dnsCfg.FakeIPFile, "fake-ip-file", "", "file to persist fake ip mappings, socks/http/redir read it to map fake ip back to domain")
+	flags.DurationVar(&dnsCfg.FakeIPExpire, "fake-ip-expire", 24*time.Hour, "fake ip mappings not queried in this duration are recycled")
+	// 其它
+	flags.DurationVarP(&dnsCfg.Timeout, "timeout", "e", time.Second*2, "timeout duration when query upstream")
+
+	rootCmd.AddCommand(dnsCmd)
+}
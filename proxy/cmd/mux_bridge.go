@@ -36,6 +36,7 @@ func init() {
 	flags.StringVarP(&muxBridge.LocalType, "local-type", "t", "tcp", "local protocol type <tcp|tls|stcp|kcp>")
 	flags.StringVarP(&muxBridge.Local, "local", "p", ":22800", "local ip:port to listen")
 	flags.BoolVar(&muxBridge.Compress, "compress", false, "compress data when <tcp|tls|stcp|kcp> mode")
+	flags.StringSliceVar(&muxBridge.SecretKeys, "sk", nil, "keys of nodes allowed, nodes must use challenge-response authentication if set")
 	// tls
 	flags.StringVar(&tcpCfg.CaCertFile, "ca", "proxy.crt", "ca cert file for tls")
 	flags.StringVarP(&muxBridge.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
@@ -46,6 +47,19 @@ func init() {
 	muxBridge.SKCPConfig = kcpCfg
 	// 其它
 	flags.DurationVarP(&muxBridge.Timeout, "timeout", "e", 2*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	// session
+	muxSession(muxBridgeCmd, &muxBridge.Session)
 
 	rootCmd.AddCommand(muxBridgeCmd)
 }
+
+// muxSession mux session flags, the settings are negotiated between node and bridge
+func muxSession(cmd *cobra.Command, cfg *mux.SessionConfig) {
+	flags := cmd.Flags()
+	flags.BoolVar(&cfg.SessionCompress, "session-compress", false, "negotiate snappy compression of the session, not compressed if the peer not support")
+	flags.DurationVar(&cfg.KeepAliveInterval, "keepalive-interval", 10*time.Second, "session keepalive interval, the larger one of both sides is used")
+	flags.DurationVar(&cfg.KeepAliveTimeout, "keepalive-timeout", 30*time.Second, "session keepalive timeout, the larger one of both sides is used")
+	flags.IntVar(&cfg.MaxStreams, "max-streams", 0, "max concurrent streams of the session, 0 means unlimited, the smaller one of both sides is used")
+	flags.IntVar(&cfg.MaxReceiveBuffer, "max-receive-buffer", 4194304, "session receive window, the smaller one of both sides is used")
+	flags.IntVar(&cfg.MaxStreamBuffer, "max-stream-buffer", 65536, "stream receive window, the smaller one of both sides is used")
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/thinkgos/jocasta/services/redir"
+)
+
+var redirCfg redir.Config
+
+var redirCmd = &cobra.Command{
+	Use:   "redir",
+	Short: "proxy on transparent redirect mode, linux only",
+	Run: func(cmd *cobra.Command, args []string) {
+		if forever {
+			return
+		}
+		redirCfg.SKCPConfig = kcpCfg
+		redirCfg.Debug = hasDebug
+
+		server = redir.New(redirCfg, redir.WithLogger(zap.S()))
+		err := server.Start()
+		if err != nil {
+			log.Fatalf("run service [%s],%s", cmd.Name(), err)
+		}
+	},
+}
+
+func init() {
+	flags := redirCmd.Flags()
+
+	// parent
+	flags.StringVarP(&redirCfg.ParentType, "parent-type", "T", "", "parent protocol type <tcp|tls|stcp|kcp>, parent should be a socks5 proxy")
+	flags.StringSliceVarP(&redirCfg.Parent, "parent", "P", nil, "parent address, such as: \"23.32.32.19:28008\"")
+	flags.BoolVarP(&redirCfg.ParentCompress, "parent-compress", "M", false, "auto compress/decompress data on parent connection")
+	flags.StringVarP(&redirCfg.ParentKey, "parent-key", "Z", "", "the password for auto encrypt/decrypt parent connection data")
+	flags.StringVarP(&redirCfg.ParentAuth, "parent-auth", "A", "", "parent socks auth username and password, such as: -A user1:pass1")
+	// local
+	flags.StringVarP(&redirCfg.Local, "local", "p", ":28090", "local ip:port to listen, the target of iptables REDIRECT")
+	// tls
+	flags.StringVarP(&redirCfg.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
+	flags.StringVarP(&redirCfg.KeyFile, "key", "K", "proxy.key", "key file for tls")
+	flags.StringVar(&redirCfg.CaCertFile, "ca", "", "ca cert file for tls")
+	// stcp
+	redirCfg.STCPConfig = stcpCfg
+	// 其它
+	flags.DurationVar(&redirCfg.Timeout, "timeout", 5*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	flags.BoolVar(&redirCfg.Always, "always", false, "always use parent proxy")
+	// 代理过滤
+	flags.StringVar(&redirCfg.FilterConfig.Intelligent, "intelligent", "intelligent", "settting intelligent proxy mode, can be <intelligent|direct|parent>")
+	flags.StringVarP(&redirCfg.FilterConfig.ProxyFile, "blocked", "b", "blocked", "blocked domain file , one domain each line")
+	flags.StringVarP(&redirCfg.FilterConfig.DirectFile, "direct", "d", "direct", "direct domain file , one domain each line")
+	flags.StringVar(&redirCfg.FilterConfig.AutoProxyFile, "autoproxy", "", "AutoProxy or Adblock style rule file such as gfwlist, base64 encoded content is supported, @@ exceptions take precedence over blocked and direct domain files")
+	flags.StringVar(&redirCfg.FilterConfig.CacheFile, "filter-cache", "", "snapshot file of intelligent domain probe results, loaded at startup skipping expired entries, empty means not persisted")
+	flags.DurationVar(&redirCfg.FilterConfig.CacheSavePeriod, "filter-cache-period", 5*time.Minute, "period of saving the filter cache snapshot, 0 means only save when stopped")
+	flags.Float64Var(&redirCfg.FilterConfig.PassiveThreshold, "passive-threshold", 3, "switch a domain to parent proxy when its real direct dial failures (connect failure, reset, no response) reach the threshold, 0 means disabled")
+	flags.DurationVar(&redirCfg.FilterConfig.PassiveHalfLife, "passive-half-life", 10*time.Minute, "half life of the direct dial failure score")
+	flags.DurationVar(&redirCfg.FilterConfig.Interval, "interval", 10*time.Second, "check domain if blocked every interval duration")
+	// 路由规则
+	flags.StringVar(&redirCfg.RuleConfig.File, "rule-file", "", "rule file, one rule each line like TYPE,PAYLOAD,ACTION[,no-resolve], matched in order before blocked and direct domain files, ACTION can be <PROXY|DIRECT|REJECT> or a parent group name")
+	flags.StringArrayVar(&redirCfg.RuleConfig.Groups, "parent-group", nil, "named parent group used by rule action, format is name=addr1[@weight],addr2[@weight], can be set multiple times")
+	// dns域名解析
+	flags.StringVarP(&redirCfg.DNSConfig.Addr, "dns-address", "q", "", "if set this, proxy will use this dns for resolve doamin, multiple addresses separated by comma are tried in order, such as: 8.8.8.8:53,tls://1.1.1.1:853,https://dns.google/dns-query")
+	flags.IntVarP(&redirCfg.DNSConfig.TTL, "dns-ttl", "e", 300, "max caching seconds of dns query result, never longer than the record ttl")
+	flags.StringVar(&redirCfg.DNSConfig.FakeIPFile, "fake-ip-file", "", "fake ip mappings file of dns service, connections to fake ip are mapped back to domain")
+	// 负载均衡
+	flags.StringVar(&redirCfg.LbConfig.Method, "lb-method", "roundrobin", "load balance method when use multiple parent,can be <roundrobin|leastconn|leasttime|hash|weight|ketama|maglev>")
+	flags.DurationVar(&redirCfg.LbConfig.Timeout, "lb-timeout", 500*time.Millisecond, "tcp duration timeout of connecting to parent")
+	flags.BoolVar(&redirCfg.LbConfig.HashTarget, "lb-hashtarget", false, "use target address to choose parent for LB")
+	flags.DurationVar(&redirCfg.LbConfig.Period, "lb-period", 30*time.Second, "health check period of each parent")
+	flags.StringVar(&redirCfg.LbConfig.Probe, "lb-probe", "tcp", "health check probe of parent, can be <tcp|tls|http|jocasta>")
+	flags.StringVar(&redirCfg.LbConfig.ProbeURL, "lb-probe-url", "/", "request url of http probe, absolute url will be requested through parent")
+	flags.IntVar(&redirCfg.LbConfig.ProbeStatus, "lb-probe-status", 200, "expected response status of http probe")
+	flags.StringToStringVar(&redirCfg.LbConfig.Probes, "lb-probes", nil, "health check probe of specified parent, like: 1.2.3.4:8080=http")
+
+	// 抓包
+	flags.StringVar(&redirCfg.CaptureConfig.File, "capture", "", "pcapng file to record plaintext traffic of parent connections, empty means disabled")
+	flags.IntVar(&redirCfg.CaptureConfig.MaxSize, "capture-max-size", 100, "max size(MB) of a capture file before it gets rotated")
+	flags.IntVar(&redirCfg.CaptureConfig.MaxBackups, "capture-max-backups", 0, "max number of rotated capture files to retain, 0 means retain all")
+
+	rootCmd.AddCommand(redirCmd)
+}
@@ -57,12 +57,21 @@ func init() {
 	flags.StringVarP(&socksCfg.SSHConfig.Password, "ssh-password", "D", "", "password for ssh")
 	// 其它
 	flags.DurationVar(&socksCfg.Timeout, "timeout", 5*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	flags.DurationVar(&socksCfg.ReloadInterval, "reload-interval", 0, "check interval of filter, rule and auth files, reload them when changed, 0 means disabled")
 	flags.BoolVar(&socksCfg.Always, "always", false, "always use parent proxy")
 	// 代理过滤
 	flags.StringVar(&socksCfg.FilterConfig.Intelligent, "intelligent", "intelligent", "settting intelligent HTTP, SOCKS5 proxy mode, can be <intelligent|direct|parent>")
 	flags.StringVarP(&socksCfg.FilterConfig.ProxyFile, "blocked", "b", "blocked", "blocked domain file , one domain each line")
 	flags.StringVarP(&socksCfg.FilterConfig.DirectFile, "direct", "d", "direct", "direct domain file , one domain each line")
+	flags.StringVar(&socksCfg.FilterConfig.AutoProxyFile, "autoproxy", "", "AutoProxy or Adblock style rule file such as gfwlist, base64 encoded content is supported, @@ exceptions take precedence over blocked and direct domain files")
+	flags.StringVar(&socksCfg.FilterConfig.CacheFile, "filter-cache", "", "snapshot file of intelligent domain probe results, loaded at startup skipping expired entries, empty means not persisted")
+	flags.DurationVar(&socksCfg.FilterConfig.CacheSavePeriod, "filter-cache-period", 5*time.Minute, "period of saving the filter cache snapshot, 0 means only save when stopped")
+	flags.Float64Var(&socksCfg.FilterConfig.PassiveThreshold, "passive-threshold", 3, "switch a domain to parent proxy when its real direct dial failures (connect failure, reset, no response) reach the threshold, 0 means disabled")
+	flags.DurationVar(&socksCfg.FilterConfig.PassiveHalfLife, "passive-half-life", 10*time.Minute, "half life of the direct dial failure score")
 	flags.DurationVar(&socksCfg.FilterConfig.Interval, "interval", 10*time.Second, "check domain if blocked every interval duration")
+	// 路由规则
+	flags.StringVar(&socksCfg.RuleConfig.File, "rule-file", "", "rule file, one rule each line like TYPE,PAYLOAD,ACTION[,no-resolve], matched in order before blocked and direct domain files, ACTION can be <PROXY|DIRECT|REJECT> or a parent group name")
+	flags.StringArrayVar(&socksCfg.RuleConfig.Groups, "parent-group", nil, "named parent group used by rule action, format is name=addr1[@weight],addr2[@weight], can be set multiple times")
 	// basic auth 配置
 	flags.StringVarP(&socksCfg.AuthConfig.File, "auth-file", "F", "", "http basic auth file,\"username:password\" each line in file")
 	flags.StringSliceVarP(&socksCfg.AuthConfig.UserPasses, "auth", "a", nil, "http basic auth username and password, multiple user repeat -a ,such as: -a user1:pass1 -a user2:pass2")
@@ -71,17 +80,27 @@ func init() {
 	flags.IntVar(&socksCfg.AuthConfig.OkCode, "auth-code", 204, "access 'auth-url' success http code")
 	flags.UintVar(&socksCfg.AuthConfig.Retry, "auth-retry", 0, "access 'auth-url' fail and retry count")
 	// dns域名解析
-	flags.StringVarP(&socksCfg.DNSConfig.Addr, "dns-address", "q", "", "if set this, proxy will use this dns for resolve doamin")
-	flags.IntVarP(&socksCfg.DNSConfig.TTL, "dns-ttl", "e", 300, "caching seconds of dns query result")
+	flags.StringVarP(&socksCfg.DNSConfig.Addr, "dns-address", "q", "", "if set this, proxy will use this dns for resolve doamin, multiple addresses separated by comma are tried in order, such as: 8.8.8.8:53,tls://1.1.1.1:853,https://dns.google/dns-query")
+	flags.IntVarP(&socksCfg.DNSConfig.TTL, "dns-ttl", "e", 300, "max caching seconds of dns query result, never longer than the record ttl")
+	flags.StringVar(&socksCfg.DNSConfig.FakeIPFile, "fake-ip-file", "", "fake ip mappings file of dns service, connections to fake ip are mapped back to domain")
 	// 负载均衡
-	flags.StringVar(&socksCfg.LbConfig.Method, "lb-method", "roundrobin", "load balance method when use multiple parent,can be <roundrobin|leastconn|leasttime|hash|weight>")
+	flags.StringVar(&socksCfg.LbConfig.Method, "lb-method", "roundrobin", "load balance method when use multiple parent,can be <roundrobin|leastconn|leasttime|hash|weight|ketama|maglev>")
 	flags.DurationVar(&socksCfg.LbConfig.Timeout, "lb-timeout", 500*time.Millisecond, "tcp duration timeout of connecting to parent")
-	flags.DurationVar(&socksCfg.LbConfig.RetryTime, "lb-retrytime", 1*time.Second, "sleep time duration after checking")
 	flags.BoolVar(&socksCfg.LbConfig.HashTarget, "lb-hashtarget", false, "use target address to choose parent for LB")
+	flags.DurationVar(&socksCfg.LbConfig.Period, "lb-period", 30*time.Second, "health check period of each parent")
+	flags.StringVar(&socksCfg.LbConfig.Probe, "lb-probe", "tcp", "health check probe of parent, can be <tcp|tls|http|jocasta>")
+	flags.StringVar(&socksCfg.LbConfig.ProbeURL, "lb-probe-url", "/", "request url of http probe, absolute url will be requested through parent")
+	flags.IntVar(&socksCfg.LbConfig.ProbeStatus, "lb-probe-status", 200, "expected response status of http probe")
+	flags.StringToStringVar(&socksCfg.LbConfig.Probes, "lb-probes", nil, "health check probe of specified parent, like: 1.2.3.4:8080=http")
 	// 限速器
 	flags.StringVarP(&socksCfg.RateLimit, "rate-limit", "l", "0", "rate limit (bytes/second) of each connection, such as: 100K 1.5M . 0 means no limitation")
 	flags.StringSliceVarP(&socksCfg.LocalIPS, "local-bind-ips", "g", nil, "if your host behind a nat,set your public ip here avoid dead loop")
 	flags.BoolVarP(&socksCfg.BindListen, "bind-listen", "B", false, "using listener binding IP when connect to target")
 
+	// 抓包
+	flags.StringVar(&socksCfg.CaptureConfig.File, "capture", "", "pcapng file to record plaintext traffic of local and parent connections, empty means disabled")
+	flags.IntVar(&socksCfg.CaptureConfig.MaxSize, "capture-max-size", 100, "max size(MB) of a capture file before it gets rotated")
+	flags.IntVar(&socksCfg.CaptureConfig.MaxBackups, "capture-max-backups", 0, "max number of rotated capture files to retain, 0 means retain all")
+
 	rootCmd.AddCommand(socksCmd)
 }
@@ -54,6 +54,7 @@ func init() {
 	spsCfg.SKCPConfig = kcpCfg
 	// 其它
 	flags.DurationVar(&spsCfg.Timeout, "timeout", 5*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	flags.DurationVar(&spsCfg.ReloadInterval, "reload-interval", 0, "check interval of filter, rule and auth files, reload them when changed, 0 means disabled")
 	// basic auth 配置
 	flags.StringVarP(&spsCfg.AuthConfig.File, "auth-file", "F", "", "http basic auth file,\"username:password\" each line in file")
 	flags.StringSliceVarP(&spsCfg.AuthConfig.UserPasses, "auth", "a", nil, "http basic auth username and password, multiple user repeat -a ,such as: -a user1:pass1 -a user2:pass2")
@@ -62,13 +63,20 @@ func init() {
 	flags.IntVar(&spsCfg.AuthConfig.OkCode, "auth-code", 204, "access 'auth-url' success http code")
 	flags.UintVar(&spsCfg.AuthConfig.Retry, "auth-retry", 0, "access 'auth-url' fail and retry count")
 	// dns域名解析
-	flags.StringVarP(&spsCfg.DNSConfig.Addr, "dns-address", "q", "", "if set this, proxy will use this dns for resolve doamin")
-	flags.IntVarP(&spsCfg.DNSConfig.TTL, "dns-ttl", "e", 300, "caching seconds of dns query result")
+	flags.StringVarP(&spsCfg.DNSConfig.Addr, "dns-address", "q", "", "if set this, proxy will use this dns for resolve doamin, multiple addresses separated by comma are tried in order, such as: 8.8.8.8:53,tls://1.1.1.1:853,https://dns.google/dns-query")
+	flags.IntVarP(&spsCfg.DNSConfig.TTL, "dns-ttl", "e", 300, "max caching seconds of dns query result, never longer than the record ttl")
 	// 负载均衡
-	flags.StringVar(&spsCfg.LbConfig.Method, "lb-method", "roundrobin", "load balance method when use multiple parent,can be <roundrobin|leastconn|leasttime|hash|weight>")
+	flags.StringVar(&spsCfg.LbConfig.Method, "lb-method", "roundrobin", "load balance method when use multiple parent,can be <roundrobin|leastconn|leasttime|hash|weight|ketama|maglev>")
 	flags.DurationVar(&spsCfg.LbConfig.Timeout, "lb-timeout", 500*time.Millisecond, "tcp duration timeout of connecting to parent")
-	flags.DurationVar(&spsCfg.LbConfig.RetryTime, "lb-retrytime", time.Second, "sleep time duration after checking")
 	flags.BoolVar(&spsCfg.LbConfig.HashTarget, "lb-hashtarget", false, "use target address to choose parent for LB")
+	flags.DurationVar(&spsCfg.LbConfig.Period, "lb-period", 30*time.Second, "health check period of each parent")
+	flags.StringVar(&spsCfg.LbConfig.Probe, "lb-probe", "tcp", "health check probe of parent, can be <tcp|tls|http|jocasta>")
+	flags.StringVar(&spsCfg.LbConfig.ProbeURL, "lb-probe-url", "/", "request url of http probe, absolute url will be requested through parent")
+	flags.IntVar(&spsCfg.LbConfig.ProbeStatus, "lb-probe-status", 200, "expected response status of http probe")
+	flags.StringToStringVar(&spsCfg.LbConfig.Probes, "lb-probes", nil, "health check probe of specified parent, like: 1.2.3.4:8080=http")
+	// 路由规则
+	flags.StringVar(&spsCfg.RuleConfig.File, "rule-file", "", "rule file, one rule each line like TYPE,PAYLOAD,ACTION[,no-resolve], matched in order before blocked and direct domain files, ACTION can be <PROXY|DIRECT|REJECT> or a parent group name")
+	flags.StringArrayVar(&spsCfg.RuleConfig.Groups, "parent-group", nil, "named parent group used by rule action, format is name=addr1[@weight],addr2[@weight], can be set multiple times")
 	// 限速器
 	flags.StringVarP(&spsCfg.RateLimit, "rate-limit", "l", "0", "rate limit (bytes/second) of each connection, such as: 100K 1.5M . 0 means no limitation")
 	flags.StringSliceVarP(&spsCfg.LocalIPS, "local-bind-ips", "g", nil, "if your host behind a nat,set your public ip here avoid dead loop")
@@ -83,5 +91,10 @@ func init() {
 	flags.BoolVar(&spsCfg.DisableSocks5, "disable-socks", false, "disable socks proxy")
 	flags.BoolVar(&spsCfg.DisableSS, "disable-ss", false, "disable ss proxy")
 
+	// 抓包
+	flags.StringVar(&spsCfg.CaptureConfig.File, "capture", "", "pcapng file to record plaintext traffic of local and parent connections, empty means disabled")
+	flags.IntVar(&spsCfg.CaptureConfig.MaxSize, "capture-max-size", 100, "max size(MB) of a capture file before it gets rotated")
+	flags.IntVar(&spsCfg.CaptureConfig.MaxBackups, "capture-max-backups", 0, "max number of rotated capture files to retain, 0 means retain all")
+
 	rootCmd.AddCommand(spsCmd)
 }
@@ -0,0 +1,382 @@
+// Package dns 本地dns服务, 根据过滤器分流到直连或代理上游, 防止dns泄漏
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/things-go/encrypt"
+	"github.com/things-go/x/extstr"
+
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/fakeip"
+	"github.com/thinkgos/jocasta/core/filter"
+	"github.com/thinkgos/jocasta/core/idns"
+	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/ccs"
+	"github.com/thinkgos/jocasta/pkg/extcert"
+	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/sword"
+	"github.com/thinkgos/jocasta/services"
+)
+
+// Config config
+type Config struct {
+	// local
+	Local string `validate:"required"` // 本地监听地址, 同时监听udp和tcp default: :53
+	// 上游, 格式见 idns.NewUpstream, 多个地址以逗号分隔
+	DirectDNS string `validate:"required"` // 直连域名使用的上游 default: 223.5.5.5:53
+	ProxyDNS  string // 代理域名使用的上游, 未设置parent时有效, default: empty
+	// parent, 设置时代理域名的查询以tcp dns经由父级隧道发送,
+	// 父级(例如tcp服务)需将数据流转发至dns服务器
+	ParentType     string `validate:"omitempty,oneof=tcp tls stcp kcp"` // 父级协议,tcp|tls|stcp|kcp default: empty
+	Parent         string // 父级地址,格式addr:port, default: empty
+	ParentCompress bool   // 父级是否传输压缩, default: false
+	// tls有效
+	CertFile   string // cert文件 default: proxy.crt
+	KeyFile    string // key文件 default: proxy.key
+	CaCertFile string // ca文件 default: empty
+	// kcp有效
+	SKCPConfig ccs.SKCPConfig
+	// stcp有效
+	// stcp 加密方法 default: aes-192-cfb
+	// stcp 加密密钥 default: thinkgos's_jocasta
+	STCPConfig cs.StcpConfig
+	// 过滤器, 仅代理上游或parent存在时有效, 不在任何表中的域名, direct模式走直连, 否则走代理
+	FilterConfig ccs.FilterConfig
+	// 静态记录, 格式: domain=ip, 同一域名可多条, 域名可为通配符, 如: *.example.com, default: empty
+	Hosts []string
+	// hosts格式的静态记录文件, 每行格式: ip domain [domain...], default: empty
+	HostsFile string
+	// 缓存
+	TTL         int  // 解析结果最长缓存时间, 同时为静态记录的ttl, 单位秒 default: 300
+	NegativeTTL int  // 域名不存在或上游失败结果的缓存时间, 0 不缓存, 单位秒 default: 30
+	CacheSize   int  // 缓存最大条目数, 0 不限制, default: 4096
+	Prefetch    bool // 热点条目过期前预取, default: false
+	// fake-ip, 设置网段时代理域名的A查询应答该网段的虚假地址, AAAA查询应答空,
+	// 透明代理(socks, http, redir)通过映射文件将虚假地址还原为域名
+	FakeIP       string        // fake-ip 网段, 如: 198.18.0.0/15, default: empty
+	FakeIPFile   string        // fake-ip 映射持久化文件, default: empty
+	FakeIPExpire time.Duration // fake-ip 映射闲置过期时间, default: 24h
+	// 其它
+	Timeout time.Duration `validate:"required"` // 上游查询超时时间, default: 2s
+	// private
+	tcpTlsConfig cs.TLSConfig
+	hosts        *idns.Hosts
+}
+
+// fakeIPTTL fake-ip应答的ttl, 尽量使客户端每次连接前重新查询, 以刷新映射的使用时间
+const fakeIPTTL = 1
+
+// DNS 本地dns服务
+type DNS struct {
+	cfg       Config
+	direct    *idns.Resolver
+	proxy     *idns.Resolver // 为nil时全部直连
+	fakeIP    *fakeip.Pool   // 为nil时不启用fake-ip
+	filters   *filter.Filter
+	udpServer *mdns.Server
+	tcpServer *mdns.Server
+	log       logger.Logger
+}
+
+var _ services.Service = (*DNS)(nil)
+
+// New new dns service
+func New(cfg Config, opts ...Option) *DNS {
+	d := &DNS{
+		cfg: cfg,
+		log: logger.NewDiscard(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (sf *DNS) inspectConfig() (err error) {
+	if err = sword.Validate.Struct(&sf.cfg); err != nil {
+		return
+	}
+
+	if sf.cfg.Parent != "" && sf.cfg.ParentType == "" {
+		return errors.New("parent type required when parent set")
+	}
+
+	if sf.cfg.ParentType == "tls" {
+		sf.cfg.tcpTlsConfig.Cert, sf.cfg.tcpTlsConfig.Key, err = extcert.LoadPair(sf.cfg.CertFile, sf.cfg.KeyFile)
+		if err != nil {
+			return
+		}
+		if sf.cfg.CaCertFile != "" {
+			if sf.cfg.tcpTlsConfig.CaCert, err = ioutil.ReadFile(sf.cfg.CaCertFile); err != nil {
+				return fmt.Errorf("read ca file %+v", err)
+			}
+		}
+	}
+
+	// stcp 方法检查
+	if sf.cfg.ParentType == "stcp" && !extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
+		return fmt.Errorf("stcp cipher method support one of %s", strings.Join(encrypt.CipherMethods(), ","))
+	}
+
+	if sf.cfg.HostsFile != "" {
+		if sf.cfg.hosts, err = idns.LoadHostsFile(sf.cfg.HostsFile); err != nil {
+			return
+		}
+	} else {
+		sf.cfg.hosts = idns.NewHosts()
+	}
+	return parseHosts(sf.cfg.hosts, sf.cfg.Hosts)
+}
+
+func (sf *DNS) initService() (err error) {
+	opts := []idns.Option{
+		idns.WithTimeout(sf.cfg.Timeout),
+		idns.WithNegativeTTL(sf.cfg.NegativeTTL),
+		idns.WithCacheSize(sf.cfg.CacheSize),
+		idns.WithPrefetch(sf.cfg.Prefetch),
+	}
+	sf.direct = idns.New(sf.cfg.DirectDNS, sf.cfg.TTL, opts...)
+	switch {
+	case sf.cfg.Parent != "":
+		d := &ccs.Dialer{
+			Protocol: sf.cfg.ParentType,
+			Timeout:  sf.cfg.Timeout,
+			Config: ccs.Config{
+				TLSConfig:  sf.cfg.tcpTlsConfig,
+				StcpConfig: sf.cfg.STCPConfig,
+				KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+			},
+			AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.ParentCompress)},
+		}
+		// 经由父级隧道时, 上游为父级地址
+		sf.proxy = idns.New("tcp://"+sf.cfg.Parent, sf.cfg.TTL, append(opts, idns.WithDialer(d))...)
+	case sf.cfg.ProxyDNS != "":
+		sf.proxy = idns.New(sf.cfg.ProxyDNS, sf.cfg.TTL, opts...)
+	}
+
+	if sf.cfg.FakeIP != "" {
+		sf.fakeIP, err = fakeip.New(sf.cfg.FakeIP,
+			fakeip.WithFile(sf.cfg.FakeIPFile), fakeip.WithExpire(sf.cfg.FakeIPExpire))
+		if err != nil {
+			return err
+		}
+	}
+
+	if sf.proxy != nil || sf.fakeIP != nil {
+		sf.filters = filter.New(sf.cfg.FilterConfig.Intelligent,
+			filter.WithLivenessPeriod(0),
+			filter.WithGPool(sword.GoPool), filter.WithLogger(sf.log),
+		)
+		var count int
+		count, err = sf.filters.LoadProxyFile(sf.cfg.FilterConfig.ProxyFile)
+		if err != nil {
+			sf.log.Warnf("[ DNS ] load proxy file(%s) %+v", sf.cfg.FilterConfig.ProxyFile, err)
+		} else {
+			sf.log.Debugf("[ DNS ] load proxy file, domains count: %d", count)
+		}
+		count, err = sf.filters.LoadDirectFile(sf.cfg.FilterConfig.DirectFile)
+		if err != nil {
+			sf.log.Warnf("[ DNS ] load direct file(%s) %+v", sf.cfg.FilterConfig.DirectFile, err)
+		} else {
+			sf.log.Debugf("[ DNS ] load direct file, domains count: %d", count)
+		}
+	}
+	return nil
+}
+
+// Start 启动服务
+func (sf *DNS) Start() (err error) {
+	if err = sf.inspectConfig(); err != nil {
+		return
+	}
+	if err = sf.initService(); err != nil {
+		return
+	}
+
+	pc, err := net.ListenPacket("udp", sf.cfg.Local)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", sf.cfg.Local)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	sf.udpServer = &mdns.Server{PacketConn: pc, Handler: sf}
+	sf.tcpServer = &mdns.Server{Listener: ln, Handler: sf}
+	sword.Go(func() { sf.udpServer.ActivateAndServe() }) // nolint: errcheck
+	sword.Go(func() { sf.tcpServer.ActivateAndServe() }) // nolint: errcheck
+
+	sf.log.Infof("[ DNS ] use direct dns %s", sf.cfg.DirectDNS)
+	if sf.cfg.Parent != "" {
+		sf.log.Infof("[ DNS ] use parent %s< %s > for proxy dns", sf.cfg.ParentType, sf.cfg.Parent)
+	} else if sf.proxy != nil {
+		sf.log.Infof("[ DNS ] use proxy dns %s", sf.cfg.ProxyDNS)
+	}
+	if sf.fakeIP != nil {
+		sf.log.Infof("[ DNS ] use fake ip %s for proxy domains, %d mappings", sf.fakeIP.CIDR(), sf.fakeIP.Len())
+	}
+	sf.log.Infof("[ DNS ] dns server on udp/tcp %s", pc.LocalAddr())
+	return nil
+}
+
+// Stop 停止服务
+func (sf *DNS) Stop() {
+	if sf.udpServer != nil {
+		sf.udpServer.Shutdown() // nolint: errcheck
+	}
+	if sf.tcpServer != nil {
+		sf.tcpServer.Shutdown() // nolint: errcheck
+	}
+	if sf.filters != nil {
+		sf.filters.Close() // nolint: errcheck
+	}
+	if sf.fakeIP != nil {
+		if err := sf.fakeIP.Close(); err != nil {
+			sf.log.Warnf("[ DNS ] save fake ip file(%s) %+v", sf.cfg.FakeIPFile, err)
+		}
+	}
+	sf.log.Infof("[ DNS ] service dns stopped")
+}
+
+// LocalAddr 本地监听udp地址
+func (sf *DNS) LocalAddr() net.Addr {
+	if sf.udpServer == nil {
+		return nil
+	}
+	return sf.udpServer.PacketConn.LocalAddr()
+}
+
+// ServeDNS implement dns.Handler
+func (sf *DNS) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), sf.cfg.Timeout*2)
+	defer cancel()
+
+	m := sf.resolve(ctx, req)
+	// udp应答过大时截断, 客户端使用tcp重试
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := mdns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	w.WriteMsg(m) // nolint: errcheck
+}
+
+func (sf *DNS) resolve(ctx context.Context, req *mdns.Msg) *mdns.Msg {
+	m := new(mdns.Msg)
+	if len(req.Question) != 1 {
+		return m.SetRcode(req, mdns.RcodeFormatError)
+	}
+	m.SetReply(req)
+	m.RecursionAvailable = true
+
+	q := req.Question[0]
+	domain := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	isA, isAAAA := q.Qtype == mdns.TypeA, q.Qtype == mdns.TypeAAAA
+
+	// 静态记录
+	if ips, ok := sf.cfg.hosts.Lookup(domain); ok && (isA || isAAAA) {
+		m.Authoritative = true
+		m.Answer = answerIP(q, ips, uint32(sf.cfg.TTL))
+		return m
+	}
+
+	resolver, via := sf.direct, "direct"
+	if sf.filters != nil {
+		// 不在任何表中的域名, direct模式走直连, 否则走代理
+		proxy, inMap, _, _ := sf.filters.IsProxy(domain)
+		if !inMap {
+			proxy = sf.cfg.FilterConfig.Intelligent != "direct"
+		}
+		if proxy {
+			via = "proxy"
+			if sf.proxy != nil {
+				resolver = sf.proxy
+			}
+		}
+	}
+	sf.log.Debugf("[ DNS ] %s %s via %s", mdns.TypeToString[q.Qtype], domain, via)
+
+	// fake-ip, 仅应答ipv4虚假地址, 使连接的目标地址可还原为域名
+	if via == "proxy" && sf.fakeIP != nil && (isA || isAAAA) {
+		if isA {
+			ip, err := sf.fakeIP.IPOf(domain)
+			if ip == nil {
+				sf.log.Warnf("[ DNS ] fake ip %s, %v", domain, err)
+				m.Rcode = mdns.RcodeServerFailure
+				return m
+			}
+			if err != nil {
+				sf.log.Warnf("[ DNS ] save fake ip file(%s) %+v", sf.cfg.FakeIPFile, err)
+			}
+			m.Answer = answerIP(q, []net.IP{ip}, fakeIPTTL)
+		}
+		return m
+	}
+
+	if isA || isAAAA {
+		ips, ttl, err := resolver.LookupIPTTL(ctx, domain)
+		switch {
+		case err == nil:
+			m.Answer = answerIP(q, ips, ttl)
+		case errors.Is(err, idns.ErrNXDomain):
+			m.Rcode = mdns.RcodeNameError
+		case errors.Is(err, idns.ErrNoAddress):
+		default:
+			sf.log.Warnf("[ DNS ] lookup %s via %s, %v", domain, via, err)
+			m.Rcode = mdns.RcodeServerFailure
+		}
+		return m
+	}
+
+	// 其它类型直接转发
+	r, err := resolver.Exchange(ctx, req.Copy())
+	if err != nil {
+		sf.log.Warnf("[ DNS ] exchange %s %s via %s, %v", mdns.TypeToString[q.Qtype], domain, via, err)
+		m.Rcode = mdns.RcodeServerFailure
+		return m
+	}
+	r.Id = req.Id
+	return r
+}
+
+// answerIP 构造与请求地址族相同的应答记录
+func answerIP(q mdns.Question, ips []net.IP, ttl uint32) []mdns.RR {
+	var rrs []mdns.RR
+
+	hdr := mdns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: mdns.ClassINET, Ttl: ttl}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if q.Qtype == mdns.TypeA {
+				rrs = append(rrs, &mdns.A{Hdr: hdr, A: ip4})
+			}
+		} else if q.Qtype == mdns.TypeAAAA {
+			rrs = append(rrs, &mdns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return rrs
+}
+
+// parseHosts 解析静态记录到hosts, 格式: domain=ip
+func parseHosts(hosts *idns.Hosts, records []string) error {
+	for _, h := range records {
+		kv := strings.SplitN(h, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid host record %s, should be like domain=ip", h)
+		}
+		if err := hosts.Add(net.ParseIP(strings.TrimSpace(kv[1])), kv[0]); err != nil {
+			return fmt.Errorf("invalid host record %s, %w", h, err)
+		}
+	}
+	return nil
+}
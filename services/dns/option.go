@@ -0,0 +1,17 @@
+package dns
+
+import (
+	"github.com/thinkgos/jocasta/pkg/logger"
+)
+
+// Option 配置选项
+type Option func(d *DNS)
+
+// WithLogger 配置日志
+func WithLogger(l logger.Logger) Option {
+	return func(d *DNS) {
+		if l != nil {
+			d.log = l
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/thinkgos/jocasta/pkg/logger"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/pkg/through"
+	"github.com/thinkgos/jocasta/pkg/through/ddt"
 	"github.com/thinkgos/jocasta/services"
 )
 
@@ -41,6 +42,10 @@ type BridgeConfig struct {
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
 	STCPConfig cs.StcpConfig
+	// 允许的节点密钥, 非空时节点需使用挑战应答认证, 密钥不在网络上传输, default: empty 不认证
+	SecretKeys []string
+	// 会话配置, 与节点协商
+	Session SessionConfig
 	// 其它
 	Timeout time.Duration `validate:"required"` // 连接超时时间 default 2s
 	// private
@@ -49,6 +54,7 @@ type BridgeConfig struct {
 
 type Bridge struct {
 	cfg           BridgeConfig
+	keyring       through.Keyring
 	channel       net.Listener
 	clientSession *connection.Manager // sk ---> session映射
 	serverSession cmap.ConcurrentMap  // addr ---> session映射
@@ -63,6 +69,7 @@ func NewBridge(cfg BridgeConfig, opts ...BridgeOption) *Bridge {
 	b := &Bridge{
 		cfg:           cfg,
 		serverSession: cmap.New(),
+		keyring:       through.NewKeyring(cfg.SecretKeys...),
 		log:           logger.NewDiscard(),
 	}
 
@@ -168,14 +175,43 @@ func (sf *Bridge) handler(inConn net.Conn) {
 		sf.log.Errorf("[ Bridge ] parse negotiate request, %s", err)
 		return
 	}
-	sf.log.Debugf("[ Bridge ] Node connected: type< %d >,sk< %s >,id< %s >", negos.Types, negos.Nego.SecretKey, negos.Nego.Id)
+	// 旧节点忽略回复版本, 新节点使用协商后的版本
+	version := captain.NegotiateVersion(through.Version, negos.Version)
+
+	inConn.SetDeadline(time.Now().Add(sf.cfg.Timeout)) // nolint: errcheck
+	sk, err := sf.keyring.Authenticate(inConn, negos, 0)
+	inConn.SetDeadline(time.Time{}) // nolint: errcheck
+	if err != nil {
+		captain.SendReply(inConn, through.RepAuthFailure, version) // nolint: errcheck
+		inConn.Close()
+		sf.log.Errorf("[ Bridge ] Node type< %d >,id< %s > %s authenticate, %s", negos.Types, negos.Nego.Id, inConn.RemoteAddr(), err)
+		return
+	}
+	negos.Nego.SecretKey = sk
+	// 节点未声明能力时使用默认设置
+	var settings *ddt.Capabilities
+	if negos.Nego.Capabilities != nil && version >= through.Version3 {
+		settings = through.AgreeCapabilities(negos.Nego.Capabilities, sf.cfg.Session.Capabilities())
+	}
+	smuxCfg := smuxConfig(settings)
+	if err = smux.VerifyConfig(smuxCfg); err != nil {
+		captain.SendReply(inConn, through.RepServerFailure, version) // nolint: errcheck
+		inConn.Close()                                               // nolint: errcheck
+		sf.log.Errorf("[ Bridge ] Node type< %d >,id< %s > session config, %+v", negos.Types, negos.Nego.Id, err)
+		return
+	}
+	sf.log.Debugf("[ Bridge ] Node connected: type< %d >,ver< %d >,sk< %s >,id< %s >", negos.Types, negos.Version, negos.Nego.SecretKey, negos.Nego.Id)
 
 	switch negos.Types {
 	case through.TypesServer:
-		session, err := smux.Server(inConn, nil)
+		if err = through.SendNegotiateReply(inConn, through.RepSuccess, version, settings); err != nil {
+			inConn.Close() // nolint: errcheck
+			sf.log.Errorf("[ Bridge ] Node server reply, %+v", err)
+			return
+		}
+		session, err := smux.Server(adornSession(inConn, settings), smuxCfg)
 		if err != nil {
-			inConn.Write([]byte{through.RepServerFailure, through.Version}) // nolint: errcheck
-			inConn.Close()                                                  // nolint: errcheck
+			inConn.Close() // nolint: errcheck
 			sf.log.Errorf("[ Bridge ] Node smux server session, %+v", err)
 			return
 		}
@@ -187,7 +223,6 @@ func (sf *Bridge) handler(inConn net.Conn) {
 			}
 			return newValue
 		})
-		captain.SendReply(inConn, through.RepSuccess, through.Version) // nolint: errcheck
 
 		sf.log.Infof("[ Bridge ] Node server %s connected -- sk< %s >", negos.Nego.Id, negos.Nego.SecretKey)
 		defer func() {
@@ -202,20 +237,28 @@ func (sf *Bridge) handler(inConn net.Conn) {
 			if err != nil {
 				return
 			}
+			if exceedStreams(session, settings) {
+				stream.Close() // nolint: errcheck
+				sf.log.Warnf("[ Bridge ] Node server %s exceed max streams %d", negos.Nego.Id, settings.GetMaxStreams())
+				continue
+			}
 			sword.Go(func() {
 				sf.proxyStream(stream, negos.Nego.SecretKey, negos.Nego.Id)
 			})
 		}
 
 	case through.TypesClient:
-		session, err := smux.Client(inConn, nil)
+		if err = through.SendNegotiateReply(inConn, through.RepSuccess, version, settings); err != nil {
+			inConn.Close() // nolint: errcheck
+			sf.log.Errorf("[ Bridge ] Node client reply, %+v", err)
+			return
+		}
+		session, err := smux.Client(adornSession(inConn, settings), smuxCfg)
 		if err != nil {
-			captain.SendReply(inConn, through.RepServerFailure, through.Version) // nolint: errcheck
-			inConn.Close()                                                       // nolint: errcheck
+			inConn.Close() // nolint: errcheck
 			sf.log.Errorf("[ Bridge ] Node client session, %+v", err)
 			return
 		}
-		captain.SendReply(inConn, through.RepSuccess, through.Version) // nolint: errcheck
 
 		sf.clientSession.Upsert(negos.Nego.SecretKey, session, func(exist bool, valueInMap, newValue interface{}) interface{} {
 			if exist {
@@ -226,7 +269,7 @@ func (sf *Bridge) handler(inConn net.Conn) {
 
 		sf.log.Infof("[ Bridge ] Node client connected -- sk< %s >", negos.Nego.SecretKey)
 	default:
-		captain.SendReply(inConn, through.RepTypesNotSupport, through.Version) // nolint: errcheck
+		captain.SendReply(inConn, through.RepTypesNotSupport, version) // nolint: errcheck
 		sf.log.Errorf("[ Bridge ] Node type unknown < %d >", negos.Types)
 	}
 }
@@ -271,13 +314,13 @@ func (sf *Bridge) proxyStream(inStream *smux.Stream, sk, serverNodeId string) {
 	}
 
 	sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s created", targetStream.ID(), sk, inStream.ID(), serverNodeId)
-	defer func() {
-		targetStream.Close()
-		sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s released", targetStream.ID(), sk, inStream.ID(), serverNodeId)
-	}()
+	defer targetStream.Close()
 
-	err = sword.Binding.Proxy(targetStream, inStream)
-	if err != nil && err != io.EOF {
+	// smux stream 不支持半关闭, 一个方向结束后另一方向将被中止
+	res := sword.Binding.Proxy(targetStream, inStream)
+	sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s released, up %d bytes, down %d bytes",
+		targetStream.ID(), sk, inStream.ID(), serverNodeId, res.Downstream.Written, res.Upstream.Written)
+	if err = res.Err(); err != nil && err != io.EOF {
 		sf.log.Errorf("[ Bridge ] proxying, %s", err)
 	}
 }
@@ -8,7 +8,7 @@ import (
 	"net"
 	"net/url"
 	"strconv"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -16,6 +16,7 @@ import (
 
 	"github.com/things-go/x/extnet"
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
@@ -34,6 +35,9 @@ type ClientConfig struct {
 	Parent     string `validate:"required"`                        // 格式: addr:port default empty
 	Compress   bool   // default false
 	SecretKey  string // default default
+	Auth       bool   // 使用挑战应答认证, SecretKey不在网络上传输, 需bridge配置该密钥, default: false
+	// 会话配置, 与bridge协商
+	Session SessionConfig
 	// tls有效
 	CertFile string // default proxy.crt
 	KeyFile  string // default proxy.key
@@ -51,19 +55,10 @@ type ClientConfig struct {
 	tcpTlsConfig cs.TLSConfig
 }
 
-type ClientUDPConnItem struct {
-	conn           *smux.Stream
-	lastActiveTime int64
-	srcAddr        *net.UDPAddr
-	localAddr      *net.UDPAddr
-	localConn      *net.UDPConn
-	sessId         string
-}
-
 type Client struct {
 	cfg      ClientConfig
-	sessions *smux.Session
-	udpConns *connection.Manager
+	mu       sync.Mutex
+	sessions *smux.Session // 当前会话, 由重连协程设置, mu保护
 	proxyURL *url.URL
 	cancel   context.CancelFunc
 	ctx      context.Context
@@ -75,17 +70,6 @@ var _ services.Service = (*Client)(nil)
 func NewClient(cfg ClientConfig, opts ...ClientOption) *Client {
 	c := &Client{cfg: cfg, log: logger.NewDiscard()}
 
-	c.udpConns = connection.New(time.Second, func(key string, value interface{}, now time.Time) bool {
-		item := value.(*ClientUDPConnItem)
-		if now.Unix()-atomic.LoadInt64(&item.lastActiveTime) > MaxUDPIdleTime {
-			item.conn.Close()
-			item.localConn.Close()
-			c.log.Infof("gc udp conn %s", item.sessId)
-			return true
-		}
-		return false
-	})
-
 	for _, opt := range opts {
 		opt(c)
 	}
@@ -132,10 +116,6 @@ func (sf *Client) Start() (err error) {
 		return
 	}
 
-	sword.Go(func() {
-		sf.udpConns.Watch(sf.ctx)
-	})
-
 	sword.Go(func() {
 		boff := backoff.WithContext(&backoff.ExponentialBackOff{
 			InitialInterval:     time.Second,
@@ -154,43 +134,29 @@ func (sf *Client) Start() (err error) {
 			}
 			defer pConn.Close()
 
-			// through message
-			msg := through.NegotiateRequest{
-				Types:   through.TypesClient,
-				Version: through.Version,
-				Nego: ddt.NegotiateRequest{
-					SecretKey: sf.cfg.SecretKey,
-					Id:        "reserved",
-				},
-			}
-			data, err := msg.Bytes()
-			if err != nil {
-				return err
-			}
-			_, err = pConn.Write(data)
+			pConn.SetDeadline(time.Now().Add(sf.cfg.Timeout)) // nolint: errcheck
+			version, settings, err := through.Negotiate(pConn, through.TypesClient, "reserved", sf.cfg.SecretKey, sf.cfg.Auth, sf.cfg.Session.Capabilities())
+			pConn.SetDeadline(time.Time{}) // nolint: errcheck
 			if err != nil {
-				sf.log.Errorf("[ Client ] connection %s, retrying...", err)
+				sf.log.Errorf("[ Client ] negotiate %s, retrying...", err)
 				return err
 			}
 
-			tr, err := captain.ParseReply(pConn)
-			if err != nil {
-				return err
-			}
-			if tr.Status != through.RepSuccess {
-				err = errors.New("bridge response error")
-				sf.log.Errorf("[ Client ] bridge response %d, retrying...", tr.Status)
-				return err
-			}
-
-			session, err := smux.Server(pConn, nil)
+			session, err := smux.Server(adornSession(pConn, settings), smuxConfig(settings))
 			if err != nil {
 				sf.log.Errorf("[ Client ] session %s, retrying...", err)
 				return err
 			}
 
+			sf.mu.Lock()
+			if sf.ctx.Err() != nil { // 已停止, 不再保存会话
+				sf.mu.Unlock()
+				session.Close()
+				return backoff.Permanent(sf.ctx.Err())
+			}
 			sf.sessions = session
-			sf.log.Infof("[ Client ] node client sk< %s > created", sf.cfg.SecretKey)
+			sf.mu.Unlock()
+			sf.log.Infof("[ Client ] node client sk< %s > created, version< %d >", sf.cfg.SecretKey, version)
 			for {
 				select {
 				case <-sf.ctx.Done():
@@ -204,12 +170,22 @@ func (sf *Client) Start() (err error) {
 					sf.log.Infof("[ Client ] accept stream %s, retrying...", err)
 					return err
 				}
+				if exceedStreams(session, settings) {
+					stream.Close()
+					sf.log.Warnf("[ Client ] exceed max streams %d", settings.GetMaxStreams())
+					continue
+				}
 				sword.Go(func() {
 					hand, err := through.ParseHandshakeRequest(stream)
 					if err != nil {
 						sf.log.Errorf("[ Client ] read stream signal %s", err)
 						return
 					}
+					if !through.SupportNetwork(settings, hand.Hand.Protocol) {
+						stream.Close()
+						sf.log.Errorf("[ Client ] sid< %s >@%s network %s not negotiated", hand.Hand.SessionId, hand.Hand.NodeId, hand.Hand.Protocol)
+						return
+					}
 					localAddr := net.JoinHostPort(hand.Hand.Host, strconv.FormatUint(uint64(hand.Hand.Port), 10))
 					sf.log.Debugf("[ Client ] sid< %s >@%s stream on %s@%s", hand.Hand.SessionId, hand.Hand.NodeId, hand.Hand.Protocol, localAddr)
 					if hand.Hand.Protocol == ddt.Network_UDP {
@@ -229,24 +205,49 @@ func (sf *Client) Stop() {
 	if sf.cancel != nil {
 		sf.cancel()
 	}
+	sf.mu.Lock()
 	if sf.sessions != nil {
 		sf.sessions.Close()
 	}
+	sf.mu.Unlock()
 	sf.log.Infof("node client sk< %s > stopped", sf.cfg.SecretKey)
 }
 
 func (sf *Client) proxyUDP(inConn *smux.Stream, localAddr, sessId string) {
-	var item *ClientUDPConnItem
-	var cacheSrcAddr string
-
+	pc := captain.NewPacketConn(inConn, captain.WithBufferPool(sword.Datagram))
+	relay := sword.Datagram.NewUDPRelay(
+		func(src, _ net.Addr) (net.PacketConn, error) {
+			targetAddr, err := net.ResolveUDPAddr("udp", localAddr)
+			if err != nil {
+				sf.log.Errorf("resolve local udp addr %s fail, %s", localAddr, err)
+				return nil, err
+			}
+			c, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetAddr)
+			if err != nil {
+				sf.log.Errorf("create local udp conn fail, %s", err)
+				return nil, err
+			}
+			sf.log.Infof("udp conn %s connected, connectId: %s", src, sessId)
+			return binding.NewConnectedPacketConn(c), nil
+		},
+		func(src, _ net.Addr, data []byte) error {
+			// 读本地udpConn,写到远端
+			_, err := pc.WriteTo(data, src)
+			return err
+		},
+		binding.WithIdleTimeout(MaxUDPIdleTime*time.Second),
+	)
+	ctx, cancel := context.WithCancel(sf.ctx)
+	sword.Go(func() { relay.Watch(ctx) })
 	defer func() {
+		cancel()
+		relay.Close()
 		inConn.Close()
-		if item != nil {
-			sf.udpConns.Remove(cacheSrcAddr)
-			item.conn.Close()
-			item.localConn.Close()
-		}
+		sf.log.Infof("udp conn %s released", sessId)
 	}()
+
+	buf := sword.Datagram.Get()
+	defer sword.Datagram.Put(buf)
 	for {
 		select {
 		case <-sf.ctx.Done():
@@ -254,42 +255,16 @@ func (sf *Client) proxyUDP(inConn *smux.Stream, localAddr, sessId string) {
 		default:
 		}
 		// 读远端数据,写到本地udpConn
-		da, err := captain.ParseStreamDatagram(inConn)
+		n, src, err := pc.ReadFrom(buf[:cap(buf)])
 		if err != nil {
 			if !extnet.IsErrDeadline(err) && err != io.EOF {
 				sf.log.Errorf("udp packet received from bridge, %s", err)
 			}
 			return
 		}
-		cacheSrcAddr = da.Addr.String()
-		if v, ok := sf.udpConns.Get(cacheSrcAddr); ok {
-			item = v.(*ClientUDPConnItem)
-		} else {
-			_srcAddr, _ := net.ResolveUDPAddr("udp", cacheSrcAddr)
-			zeroAddr, _ := net.ResolveUDPAddr("udp", ":")
-			_localAddr, _ := net.ResolveUDPAddr("udp", localAddr)
-			c, err := net.DialUDP("udp", zeroAddr, _localAddr)
-			if err != nil {
-				sf.log.Errorf("create local udp conn fail, %s", err)
-				return
-			}
-			item = &ClientUDPConnItem{
-				conn:      inConn,
-				srcAddr:   _srcAddr,
-				localAddr: _localAddr,
-				localConn: c,
-				sessId:    sessId,
-			}
-			sf.udpConns.Set(cacheSrcAddr, item)
-			sword.Go(func() {
-				sf.runUdpReceive(cacheSrcAddr, sessId)
-			})
+		if err = relay.Send(src, src, buf[:n]); err != nil {
+			sf.log.Errorf("write udp packet to local %s fail, %s", localAddr, err)
 		}
-
-		atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-		sword.Go(func() {
-			item.localConn.Write(da.Data)
-		})
 	}
 }
 
@@ -314,75 +289,16 @@ func (sf *Client) proxyTCP(inConn net.Conn, localAddr, sessId string) {
 	}
 
 	sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding created", sf.cfg.SecretKey, sessId)
-	defer func() {
-		sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding released", sf.cfg.SecretKey, sessId)
-		targetConn.Close()
-	}()
+	defer targetConn.Close()
 
-	err = sword.Binding.Proxy(inConn, targetConn)
-	if err != nil && err != io.EOF {
+	res := sword.Binding.Proxy(inConn, targetConn)
+	sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding released, up %d bytes, down %d bytes",
+		sf.cfg.SecretKey, sessId, res.Upstream.Written, res.Downstream.Written)
+	if err = res.Err(); err != nil && err != io.EOF {
 		sf.log.Errorf("[ Client ] proxying, %s", err)
 	}
 }
 
-func (sf *Client) runUdpReceive(key, id string) {
-	v, ok := sf.udpConns.Get(key)
-	if !ok {
-		sf.log.Warnf("udp conn not exists for %s, connectId : %s", key, id)
-		return
-	}
-	connItem := v.(*ClientUDPConnItem)
-
-	sf.log.Infof("udp conn %s connected", id)
-	defer func() {
-		sf.udpConns.Remove(key)
-		connItem.conn.Close()
-		connItem.localConn.Close()
-		sf.log.Infof("udp conn %s released", id)
-	}()
-	for {
-		// 读本地udpConn,写到远端
-		buf := sword.Binding.Get()
-		n, err := connItem.localConn.Read(buf[:cap(buf)])
-		if err != nil {
-			sword.Binding.Put(buf)
-			if !extnet.IsErrClosed(err) {
-				sf.log.Errorf("udp conn read udp packet fail , err: %s ", err)
-			}
-			return
-		}
-		atomic.StoreInt64(&connItem.lastActiveTime, time.Now().Unix())
-		sword.Go(func() {
-			defer sword.Binding.Put(buf)
-			as, err := captain.ParseAddrSpec(connItem.srcAddr.String())
-			if err != nil {
-				connItem.localConn.Close()
-				return
-			}
-
-			sData := captain.StreamDatagram{
-				Addr: as,
-				Data: buf[:n],
-			}
-			header, err := sData.Header()
-			if err != nil {
-				connItem.localConn.Close()
-				return
-			}
-			buf := sword.Binding.Get()
-			defer sword.Binding.Put(buf)
-
-			tmpBuf := append(buf, header...)
-			tmpBuf = append(tmpBuf, sData.Data...)
-			_, err = connItem.conn.Write(tmpBuf)
-			if err != nil {
-				connItem.localConn.Close()
-				return
-			}
-		})
-	}
-}
-
 func (sf *Client) dialParent(address string) (net.Conn, error) {
 	d := ccs.Dialer{
 		Protocol: sf.cfg.ParentType,
@@ -0,0 +1,184 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/thinkgos/jocasta/connection/cfault"
+)
+
+// udpEcho 本地udp回显服务
+func udpEcho(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr) // nolint: errcheck
+		}
+	}()
+	return conn
+}
+
+func TestMux_UDP(t *testing.T) {
+	target := udpEcho(t)
+
+	bridge := NewBridge(BridgeConfig{
+		LocalType: "tcp",
+		Local:     "127.0.0.1:0",
+		Timeout:   time.Second * 2,
+	})
+	require.NoError(t, bridge.Start())
+	t.Cleanup(bridge.Stop)
+	bridgeAddr := bridge.channel.Addr().String()
+
+	client := NewClient(ClientConfig{
+		ParentType: "tcp",
+		Parent:     bridgeAddr,
+		SecretKey:  "default",
+		Timeout:    time.Second * 2,
+	})
+	require.NoError(t, client.Start())
+	t.Cleanup(client.Stop)
+
+	server := NewServer(ServerConfig{
+		ParentType: "tcp",
+		Parent:     bridgeAddr,
+		SecretKey:  "default",
+		Timeout:    time.Second * 2,
+		Route:      "udp://127.0.0.1:0@" + target.LocalAddr().String(),
+	})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	serverAddr := server.listener.(*net.UDPConn).LocalAddr().(*net.UDPAddr)
+
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	buf := make([]byte, 64*1024)
+	// 大于 BindingSize 的数据报
+	for _, size := range []int{100, 10000, 60000} {
+		want := bytes.Repeat([]byte{byte(size)}, size)
+		// 节点客户端可能尚未连接bridge, 重试
+		var got []byte
+		for i := 0; i < 20 && got == nil; i++ {
+			_, err = conn.Write(want)
+			require.NoError(t, err)
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500)) // nolint: errcheck
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break
+				}
+				// 丢弃之前重试产生的回复
+				if n == size {
+					got = buf[:n]
+					break
+				}
+			}
+		}
+		require.Equal(t, want, got, "size %d", size)
+	}
+}
+
+// faultProxy 转发到target的tcp代理, 第n(从1开始)个连接的客户端一侧由wrap注入故障, 返回代理地址及已接受的连接数
+func faultProxy(t *testing.T, target string, wrap func(n int, c net.Conn) net.Conn) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	accepted := atomic.NewInt32(0)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fc := wrap(int(accepted.Inc()), c)
+			go func() {
+				defer fc.Close()
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				// 任一方向结束即关闭两端, 故障重置时bridge也能感知
+				done := make(chan struct{}, 2)
+				go func() { io.Copy(upstream, fc); done <- struct{}{} }() // nolint: errcheck
+				go func() { io.Copy(fc, upstream); done <- struct{}{} }() // nolint: errcheck
+				<-done
+			}()
+		}
+	}()
+	return ln.Addr().String(), accepted
+}
+
+func TestMux_ClientReconnect(t *testing.T) {
+	target := udpEcho(t)
+
+	bridge := NewBridge(BridgeConfig{
+		LocalType: "tcp",
+		Local:     "127.0.0.1:0",
+		Timeout:   time.Second * 2,
+	})
+	require.NoError(t, bridge.Start())
+	t.Cleanup(bridge.Stop)
+	bridgeAddr := bridge.channel.Addr().String()
+
+	// 节点客户端经故障代理连接bridge, 所有连接注入延迟, 第一个连接随机重置
+	proxyAddr, accepted := faultProxy(t, bridgeAddr, func(n int, c net.Conn) net.Conn {
+		opts := []cfault.Option{cfault.WithSeed(int64(n)), cfault.WithLatency(time.Millisecond*5, time.Millisecond*5)}
+		if n == 1 {
+			opts = append(opts, cfault.WithResetRate(0.05))
+		}
+		return cfault.New(c, opts...)
+	})
+
+	client := NewClient(ClientConfig{
+		ParentType: "tcp",
+		Parent:     proxyAddr,
+		SecretKey:  "default",
+		Timeout:    time.Second * 2,
+	})
+	require.NoError(t, client.Start())
+	t.Cleanup(client.Stop)
+
+	server := NewServer(ServerConfig{
+		ParentType: "tcp",
+		Parent:     bridgeAddr,
+		SecretKey:  "default",
+		Timeout:    time.Second * 2,
+		Route:      "udp://127.0.0.1:0@" + target.LocalAddr().String(),
+	})
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	serverAddr := server.listener.(*net.UDPConn).LocalAddr().(*net.UDPAddr)
+
+	// 持续发送直到第一个连接被重置, 客户端重连后数据恢复
+	buf := make([]byte, 1024)
+	recovered := false
+	for deadline := time.Now().Add(time.Second * 20); !recovered && time.Now().Before(deadline); {
+		reconnected := accepted.Load() >= 2
+		// 每次使用新的udp连接, 避免复用重置前建立的关联
+		conn, err := net.DialUDP("udp", nil, serverAddr)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 300)) // nolint: errcheck
+		n, err := conn.Read(buf)
+		conn.Close()
+		recovered = reconnected && err == nil && string(buf[:n]) == "hello"
+	}
+	require.True(t, recovered, "client should reconnect after reset, accepted %d", accepted.Load())
+}
@@ -9,7 +9,6 @@ import (
 	"net/url"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -18,6 +17,7 @@ import (
 	"github.com/things-go/x/extnet"
 
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
@@ -35,6 +35,9 @@ type ServerConfig struct {
 	Parent     string `validate:"required"`                        // 格式: addr:port default empty
 	Compress   bool   // default false
 	SecretKey  string // default default
+	Auth       bool   // 使用挑战应答认证, SecretKey不在网络上传输, 需bridge配置该密钥, default: false
+	// 会话配置, 与bridge协商
+	Session SessionConfig
 	// tls有效
 	CertFile string // default proxy.crt
 	KeyFile  string // default proxy.key
@@ -64,20 +67,13 @@ type ServerConfig struct {
 	remote_port uint16
 }
 
-type UDPConnItem struct {
-	conn           net.Conn
-	lastActiveTime int64
-	srcAddr        *net.UDPAddr
-	localAddr      *net.UDPAddr
-	sessId         string
-}
-
 type Server struct {
 	id       string
 	cfg      ServerConfig
 	listener interface{} //net.Listener
 	sessions *smux.Session
-	udpConns *connection.Manager // 本地udp地址 -> 远端连接 映射
+	settings *ddt.Capabilities // 与bridge协商后的会话设置, nil表示默认设置
+	relay    *binding.UDPRelay // 本地udp地址 -> 远端流 关联
 	mu       sync.Mutex
 	proxyURL *url.URL
 	cancel   context.CancelFunc
@@ -94,16 +90,6 @@ func NewServer(cfg ServerConfig, opts ...ServerOption) *Server {
 		log: logger.NewDiscard(),
 	}
 
-	s.udpConns = connection.New(time.Second, func(key string, value interface{}, now time.Time) bool {
-		item := value.(*UDPConnItem)
-		if now.Unix()-atomic.LoadInt64(&item.lastActiveTime) > MaxUDPIdleTime {
-			item.conn.Close()
-			s.log.Infof("gc udp conn %s", item.sessId)
-			return true
-		}
-		return false
-	})
-
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -176,13 +162,14 @@ func (sf *Server) Start() (err error) {
 		sword.Go(
 			func() {
 				defer udpConn.Close()
+				buf := sword.Datagram.Get()
+				defer sword.Datagram.Put(buf)
 				for {
-					buf := make([]byte, 2048)
-					n, srcAddr, err := udpConn.ReadFromUDP(buf)
+					n, srcAddr, err := udpConn.ReadFromUDP(buf[:cap(buf)])
 					if err != nil {
 						return
 					}
-					data := buf[0:n]
+					data := append([]byte(nil), buf[:n]...)
 					sword.Go(func() {
 						sf.handleUDP(udpConn, cs.Message{
 							LocalAddr: addr,
@@ -194,8 +181,26 @@ func (sf *Server) Start() (err error) {
 			},
 		)
 		sf.listener = udpConn
+		sf.relay = sword.Datagram.NewUDPRelay(
+			func(src, _ net.Addr) (net.PacketConn, error) {
+				// 建立一条与远端链接隧道
+				outConn, id, err := sf.dialThroughRemote()
+				if err != nil {
+					sf.log.Errorf("connect to %s fail, %s", sf.cfg.Parent, err)
+					return nil, err
+				}
+				sf.log.Infof("udp conn %s connected, connectId: %s", src, id)
+				return captain.NewPacketConn(outConn, captain.WithBufferPool(sword.Datagram)), nil
+			},
+			func(src, _ net.Addr, data []byte) error {
+				// 从远端接收数据,发送到本地
+				_, err := udpConn.WriteTo(data, src)
+				return err
+			},
+			binding.WithIdleTimeout(MaxUDPIdleTime*time.Second),
+		)
 		sword.Go(func() {
-			sf.udpConns.Watch(sf.ctx)
+			sf.relay.Watch(sf.ctx)
 		})
 		localhostAddr = udpConn.LocalAddr().String()
 	} else {
@@ -228,14 +233,19 @@ func (sf *Server) Stop() {
 	if sf.cancel != nil {
 		sf.cancel()
 	}
+	sf.mu.Lock()
 	if sf.sessions != nil {
 		sf.sessions.Close()
 	}
+	sf.mu.Unlock()
 	if sf.listener != nil {
 		if c, ok := sf.listener.(io.Closer); ok {
 			c.Close()
 		}
 	}
+	if sf.relay != nil {
+		sf.relay.Close()
+	}
 	sf.log.Infof("node server stopped")
 }
 
@@ -285,42 +295,32 @@ func (sf *Server) GetConn() (conn net.Conn, err error) {
 			return
 		}
 
-		// through message
-		var data []byte
-		msg := through.NegotiateRequest{
-			Types:   through.TypesServer,
-			Version: through.Version,
-			Nego: ddt.NegotiateRequest{
-				SecretKey: sf.cfg.SecretKey,
-				Id:        sf.id,
-			},
-		}
-		data, err = msg.Bytes()
-		if err != nil {
-			return
-		}
-		_, err = pConn.Write(data)
+		var version byte
+		var settings *ddt.Capabilities
+		pConn.SetDeadline(time.Now().Add(sf.cfg.Timeout)) // nolint: errcheck
+		version, settings, err = through.Negotiate(pConn, through.TypesServer, sf.id, sf.cfg.SecretKey, sf.cfg.Auth, sf.cfg.Session.Capabilities())
+		pConn.SetDeadline(time.Time{}) // nolint: errcheck
 		if err != nil {
 			_ = pConn.Close()
 			return
 		}
-		var tr captain.Reply
-		tr, err = captain.ParseReply(pConn)
-		if err != nil {
-			_ = pConn.Close()
-			return
+		network := ddt.Network_TCP
+		if sf.cfg.isUDP {
+			network = ddt.Network_UDP
 		}
-		if tr.Status != through.RepSuccess {
-			err = errors.New("bridge response error")
-			return
+		if !through.SupportNetwork(settings, network) {
+			_ = pConn.Close()
+			return nil, fmt.Errorf("network %s not negotiated with bridge", network)
 		}
 
-		sf.sessions, err = smux.Client(pConn, nil)
+		sf.sessions, err = smux.Client(adornSession(pConn, settings), smuxConfig(settings))
 		if err != nil {
+			_ = pConn.Close()
 			return
 		}
 
-		sf.log.Infof("session[%s] created", sf.cfg.SecretKey)
+		sf.settings = settings
+		sf.log.Infof("session[%s] created, version< %d >", sf.cfg.SecretKey, version)
 		sword.Go(func() {
 			t := time.NewTicker(time.Second * 5)
 			defer t.Stop()
@@ -340,6 +340,9 @@ func (sf *Server) GetConn() (conn net.Conn, err error) {
 			}
 		})
 	}
+	if max := sf.settings.GetMaxStreams(); max > 0 && sf.sessions.NumStreams() >= int(max) {
+		return nil, fmt.Errorf("exceed max streams %d", max)
+	}
 	conn, err = sf.sessions.OpenStream()
 	if err != nil {
 		sf.sessions.Close()
@@ -363,88 +366,9 @@ func (sf *Server) dialParent() (net.Conn, error) {
 	return d.Dial("tcp", sf.cfg.Parent)
 }
 
-func (sf *Server) runUDPReceive(key, id string) {
-	v, ok := sf.udpConns.Get(key)
-	if !ok {
-		sf.log.Warnf("udp conn not exists for %s, connectId : %s", key, id)
-		return
-	}
-	udpConnItem := v.(*UDPConnItem)
-
-	sf.log.Infof("udp conn %s connected", id)
-	defer func() {
-		sf.udpConns.Remove(key)
-		udpConnItem.conn.Close()
-		sf.log.Infof("udp conn %s released", id)
-	}()
-
-	for {
-		// 从远端接收数据,发送到本地
-		da, err := captain.ParseStreamDatagram(udpConnItem.conn)
-		if err != nil {
-			if strings.Contains(err.Error(), "n != int(") {
-				continue
-			}
-			if err != io.EOF {
-				sf.log.Errorf("udp conn read udp packet fail, %s ", err)
-			}
-			return
-		}
-		atomic.StoreInt64(&udpConnItem.lastActiveTime, time.Now().Unix())
-		sword.Go(func() {
-			sf.listener.(*net.UDPConn).WriteToUDP(da.Data, udpConnItem.srcAddr)
-		})
-	}
-}
-
 func (sf *Server) handleUDP(_ *net.UDPConn, msg cs.Message) {
-	var udpConnItem *UDPConnItem
-
-	srcAddr := msg.SrcAddr.String()
-	if v, ok := sf.udpConns.Get(srcAddr); ok {
-		udpConnItem = v.(*UDPConnItem)
-	} else {
-		// 不存在,建立一条与远端链接隧道
-		outConn, id, err := sf.dialThroughRemote()
-		if err != nil {
-			sf.log.Errorf("connect to %s fail, %s", sf.cfg.Parent, err)
-			return
-		}
-		udpConnItem = &UDPConnItem{
-			conn:      outConn,
-			srcAddr:   msg.SrcAddr,
-			localAddr: msg.LocalAddr,
-			sessId:    id,
-		}
-		sf.udpConns.Set(srcAddr, udpConnItem)
-		// 从远端接收数据,发送到本地
-		sword.Go(func() {
-			sf.runUDPReceive(srcAddr, id)
-		})
-	}
-	// 读取本地数据, 发送数据到远端
-	atomic.StoreInt64(&udpConnItem.lastActiveTime, time.Now().Unix())
-
-	as, err := captain.ParseAddrSpec(srcAddr)
-	if err != nil {
-		return
-	}
-
-	sData := captain.StreamDatagram{
-		Addr: as,
-		Data: msg.Data,
-	}
-	header, err := sData.Header()
-	if err != nil {
-		return
-	}
-	buf := sword.Binding.Get()
-	defer sword.Binding.Put(buf)
-
-	tmpBuf := append(buf, header...)
-	tmpBuf = append(tmpBuf, sData.Data...)
-	_, err = udpConnItem.conn.Write(tmpBuf)
-	if err != nil {
+	// 读取本地数据, 发送数据到远端, 不存在关联时建立一条与远端链接隧道
+	if err := sf.relay.Send(msg.SrcAddr, msg.SrcAddr, msg.Data); err != nil {
 		sf.log.Errorf("write udp packet to %s fail, %s ", sf.cfg.Parent, err)
 	}
 }
@@ -471,12 +395,12 @@ func (sf *Server) handleTCP(inConn net.Conn) {
 	}
 
 	sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding created", sf.cfg.SecretKey, sessId)
-	defer func() {
-		sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding released", sf.cfg.SecretKey, sessId)
-		targetConn.Close()
-	}()
-	err = sword.Binding.Proxy(targetConn, inConn)
-	if err != nil && err != io.EOF {
+	defer targetConn.Close()
+
+	res := sword.Binding.Proxy(targetConn, inConn)
+	sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding released, up %d bytes, down %d bytes",
+		sf.cfg.SecretKey, sessId, res.Downstream.Written, res.Upstream.Written)
+	if err = res.Err(); err != nil && err != io.EOF {
 		sf.log.Errorf("[ Server ] proxying, %s", err)
 	}
 }
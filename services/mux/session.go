@@ -0,0 +1,83 @@
+package mux
+
+import (
+	"net"
+	"time"
+
+	"github.com/xtaci/smux"
+
+	"github.com/thinkgos/jocasta/connection/csnappy"
+	"github.com/thinkgos/jocasta/pkg/through/ddt"
+)
+
+// SessionConfig 多路复用会话配置, 与bridge协商后生效, 0表示使用默认值
+type SessionConfig struct {
+	SessionCompress   bool          // 协商会话压缩(snappy), 与Compress不同, 对端不支持时不压缩, default: false
+	KeepAliveInterval time.Duration // 保活间隔 default: 10s
+	KeepAliveTimeout  time.Duration // 保活超时 default: 30s
+	MaxStreams        int           // 最大并发流数, default: 0 不限制
+	MaxReceiveBuffer  int           // 会话接收窗口 default: 4MB
+	MaxStreamBuffer   int           // 流接收窗口 default: 64KB
+}
+
+// Capabilities 本端能力
+func (sf SessionConfig) Capabilities() *ddt.Capabilities {
+	caps := &ddt.Capabilities{
+		Compression:       []ddt.Compression{ddt.Compression_NONE},
+		SmuxVersion:       []uint32{1, 2},
+		KeepAliveInterval: uint32(sf.KeepAliveInterval / time.Second),
+		KeepAliveTimeout:  uint32(sf.KeepAliveTimeout / time.Second),
+		MaxStreams:        uint32(sf.MaxStreams),
+		MaxReceiveBuffer:  uint32(sf.MaxReceiveBuffer),
+		MaxStreamBuffer:   uint32(sf.MaxStreamBuffer),
+		Networks:          []ddt.Network{ddt.Network_TCP, ddt.Network_UDP},
+	}
+	if sf.SessionCompress {
+		caps.Compression = []ddt.Compression{ddt.Compression_SNAPPY, ddt.Compression_NONE}
+	}
+	return caps
+}
+
+// smuxConfig 由协商后的设置生成smux配置, settings为nil时使用默认配置
+func smuxConfig(settings *ddt.Capabilities) *smux.Config {
+	cfg := smux.DefaultConfig()
+	if settings == nil {
+		return cfg
+	}
+	if v := settings.GetSmuxVersion(); len(v) > 0 {
+		cfg.Version = int(v[0])
+	}
+	if v := settings.GetKeepAliveInterval(); v > 0 {
+		cfg.KeepAliveInterval = time.Duration(v) * time.Second
+	}
+	if v := settings.GetKeepAliveTimeout(); v > 0 {
+		cfg.KeepAliveTimeout = time.Duration(v) * time.Second
+	}
+	if cfg.KeepAliveTimeout < cfg.KeepAliveInterval {
+		cfg.KeepAliveTimeout = cfg.KeepAliveInterval
+	}
+	if v := settings.GetMaxReceiveBuffer(); v > 0 {
+		cfg.MaxReceiveBuffer = int(v)
+	}
+	if v := settings.GetMaxStreamBuffer(); v > 0 {
+		cfg.MaxStreamBuffer = int(v)
+	}
+	if cfg.MaxStreamBuffer > cfg.MaxReceiveBuffer {
+		cfg.MaxStreamBuffer = cfg.MaxReceiveBuffer
+	}
+	return cfg
+}
+
+// adornSession 按协商后的压缩方法装饰连接
+func adornSession(conn net.Conn, settings *ddt.Capabilities) net.Conn {
+	if c := settings.GetCompression(); len(c) > 0 && c[0] == ddt.Compression_SNAPPY {
+		return csnappy.New(conn)
+	}
+	return conn
+}
+
+// exceedStreams 会话流数是否超过协商后的最大流数
+func exceedStreams(session *smux.Session, settings *ddt.Capabilities) bool {
+	max := settings.GetMaxStreams()
+	return max > 0 && session.NumStreams() > int(max)
+}
@@ -0,0 +1,91 @@
+// Package parent 父级负载均衡的创建及路由规则动作到父级的解析, http, socks, sps, redir服务共用
+package parent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thinkgos/jocasta/core/filter"
+	"github.com/thinkgos/jocasta/core/loadbalance"
+	"github.com/thinkgos/jocasta/pkg/ccs"
+	"github.com/thinkgos/jocasta/pkg/sword"
+)
+
+// ErrRejected 连接被路由规则拒绝
+var ErrRejected = errors.New("rejected by rule")
+
+// ErrNoParent 规则动作为PROXY, 但未配置默认父级
+var ErrNoParent = errors.New("rule action PROXY but no parent")
+
+// ParseAddr 解析父级地址, 格式 addr:port[@weight], 权重缺省或无效时为1
+func ParseAddr(s string) (addr string, weight int) {
+	addrInfo := strings.Split(s, "@")
+	addr, weight = addrInfo[0], 1
+	if len(addrInfo) == 2 {
+		if weight, _ = strconv.Atoi(addrInfo[1]); weight == 0 {
+			weight = 1
+		}
+	}
+	return addr, weight
+}
+
+// ProbeConfig 父级健康检查探针的公共配置, protocol 为jocasta探针的父级代理协议,
+// 父级传输相关的TLSConfig和Dial由服务自行设置
+func ProbeConfig(c ccs.LbConfig, addr, protocol string) loadbalance.ProbeConfig {
+	return loadbalance.ProbeConfig{
+		Type:     c.ProbeOf(addr),
+		URL:      c.ProbeURL,
+		Status:   c.ProbeStatus,
+		Protocol: protocol,
+	}
+}
+
+// NewBalanced 创建父级负载均衡, 父级格式 addr:port[@weight], probe 返回各父级的健康检查探针配置
+func NewBalanced(c ccs.LbConfig, parents []string, probe func(addr string) loadbalance.ProbeConfig,
+	opts ...loadbalance.Option) *loadbalance.Balanced {
+	configs := make([]loadbalance.Config, 0, len(parents))
+	for _, s := range parents {
+		addr, weight := ParseAddr(s)
+		configs = append(configs, loadbalance.Config{
+			Addr:             addr,
+			Weight:           weight,
+			SuccessThreshold: 1,
+			FailureThreshold: 2,
+			Timeout:          c.Timeout,
+			Period:           c.Period,
+			Probe:            probe(addr),
+		})
+	}
+	opts = append(opts, loadbalance.WithGPool(sword.GoPool))
+	return loadbalance.New(c.Method, configs, opts...)
+}
+
+// Match 匹配路由规则选择父级, filters 为nil或无规则匹配时matched为false, 由调用者决定默认行为.
+// 规则动作 DIRECT: 直连, 返回nil; REJECT: 返回ErrRejected; PROXY: 默认父级lb; 其它: 同名父级组
+func Match(filters *filter.Filter, m *filter.Metadata, lb *loadbalance.Balanced,
+	groups map[string]*loadbalance.Balanced) (b *loadbalance.Balanced, matched bool, err error) {
+	if filters == nil {
+		return nil, false, nil
+	}
+	action, ok := filters.MatchRule(m)
+	if !ok {
+		return nil, false, nil
+	}
+	switch action {
+	case filter.ActionDirect:
+		return nil, true, nil
+	case filter.ActionReject:
+		return nil, true, ErrRejected
+	case filter.ActionProxy:
+		if lb == nil {
+			return nil, true, ErrNoParent
+		}
+		return lb, true, nil
+	}
+	if b, ok := groups[string(action)]; ok {
+		return b, true, nil
+	}
+	return nil, true, fmt.Errorf("parent group %s not found", action)
+}
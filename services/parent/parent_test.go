@@ -0,0 +1,97 @@
+package parent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/core/filter"
+	"github.com/thinkgos/jocasta/core/loadbalance"
+	"github.com/thinkgos/jocasta/pkg/ccs"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		s      string
+		addr   string
+		weight int
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", 1},
+		{"127.0.0.1:8080@3", "127.0.0.1:8080", 3},
+		{"127.0.0.1:8080@0", "127.0.0.1:8080", 1},
+		{"127.0.0.1:8080@x", "127.0.0.1:8080", 1},
+	}
+	for _, tt := range tests {
+		addr, weight := ParseAddr(tt.s)
+		assert.Equal(t, tt.addr, addr, tt.s)
+		assert.Equal(t, tt.weight, weight, tt.s)
+	}
+}
+
+func TestNewBalanced(t *testing.T) {
+	c := ccs.LbConfig{
+		Method:  "weight",
+		Timeout: time.Millisecond * 100,
+		Period:  time.Hour,
+		Probe:   "tcp",
+		Probes:  map[string]string{"127.0.0.1:2": "tls"},
+	}
+	probes := make(map[string]string)
+	lb := NewBalanced(c, []string{"127.0.0.1:1@2", "127.0.0.1:2"}, func(addr string) loadbalance.ProbeConfig {
+		pc := ProbeConfig(c, addr, loadbalance.ProbeProtocolSocks5)
+		probes[addr] = pc.Type
+		return pc
+	})
+	defer lb.Close()
+
+	assert.Equal(t, map[string]string{"127.0.0.1:1": "tcp", "127.0.0.1:2": "tls"}, probes)
+}
+
+func TestMatch(t *testing.T) {
+	lb, vip := new(loadbalance.Balanced), new(loadbalance.Balanced)
+	groups := map[string]*loadbalance.Balanced{"vip": vip}
+
+	// 无过滤器, 不匹配
+	_, matched, err := Match(nil, &filter.Metadata{Dst: "a.com:443"}, lb, groups)
+	require.NoError(t, err)
+	require.False(t, matched)
+
+	f := filter.New("intelligent", filter.WithLivenessPeriod(0))
+	defer f.Close()
+	rules, err := filter.ParseRules(strings.NewReader(strings.Join([]string{
+		"DOMAIN,direct.com,DIRECT",
+		"DOMAIN,reject.com,REJECT",
+		"DOMAIN,proxy.com,PROXY",
+		"DOMAIN,vip.com,vip",
+		"DOMAIN,missing.com,missing",
+	}, "\n")))
+	require.NoError(t, err)
+	f.SetRules(rules)
+
+	tests := []struct {
+		dst     string
+		lb      *loadbalance.Balanced
+		want    *loadbalance.Balanced
+		matched bool
+		wantErr bool
+	}{
+		{"none.com:443", lb, nil, false, false},
+		{"direct.com:443", lb, nil, true, false},
+		{"reject.com:443", lb, nil, true, true},
+		{"proxy.com:443", lb, lb, true, false},
+		{"proxy.com:443", nil, nil, true, true},
+		{"vip.com:443", lb, vip, true, false},
+		{"missing.com:443", lb, nil, true, true},
+	}
+	for _, tt := range tests {
+		got, matched, err := Match(f, &filter.Metadata{Dst: tt.dst}, tt.lb, groups)
+		assert.Equal(t, tt.matched, matched, tt.dst)
+		assert.Equal(t, tt.wantErr, err != nil, tt.dst)
+		assert.True(t, tt.want == got, tt.dst)
+	}
+	_, _, err = Match(f, &filter.Metadata{Dst: "reject.com:443"}, lb, groups)
+	assert.Equal(t, ErrRejected, err)
+}
@@ -0,0 +1,17 @@
+package redir
+
+import (
+	"github.com/thinkgos/jocasta/pkg/logger"
+)
+
+// Option 配置选项
+type Option func(r *Redir)
+
+// WithLogger 配置日志
+func WithLogger(l logger.Logger) Option {
+	return func(r *Redir) {
+		if l != nil {
+			r.log = l
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package redir
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// soOriginalDst netfilter SO_ORIGINAL_DST 和 IP6T_SO_ORIGINAL_DST
+const soOriginalDst = 80
+
+// originalDst 获取经iptables REDIRECT重定向前的目标地址
+func originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errNotTCP
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	isIPv4 := tc.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+	var addr *net.TCPAddr
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			// 返回 sockaddr_in, 借用 IPv6Mreq 的16字节缓冲区
+			var mreq *syscall.IPv6Mreq
+			mreq, serr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+			if serr == nil {
+				raw := mreq.Multiaddr
+				addr = &net.TCPAddr{
+					IP:   net.IPv4(raw[4], raw[5], raw[6], raw[7]),
+					Port: int(raw[2])<<8 | int(raw[3]),
+				}
+			}
+			return
+		}
+		// 返回 sockaddr_in6, 借用 IPv6MTUInfo 的缓冲区
+		var info *syscall.IPv6MTUInfo
+		info, serr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst)
+		if serr == nil {
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = &net.TCPAddr{
+				IP:   append(net.IP(nil), info.Addr.Addr[:]...),
+				Port: int(port[0])<<8 | int(port[1]),
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, serr
+}
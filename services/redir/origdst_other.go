@@ -0,0 +1,13 @@
+// +build !linux
+
+package redir
+
+import (
+	"errors"
+	"net"
+)
+
+// originalDst 仅linux支持获取重定向前的目标地址
+func originalDst(net.Conn) (*net.TCPAddr, error) {
+	return nil, errors.New("original destination only supported on linux")
+}
@@ -0,0 +1,485 @@
+// Package redir 透明代理服务, 接收iptables REDIRECT重定向的tcp连接, 按原始目标地址直连或经由父级socks5代理转发,
+// 原始目标为dns服务分配的fake-ip时, 还原为域名后再过滤和选择父级
+package redir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/things-go/encrypt"
+	"github.com/things-go/x/extnet"
+	"github.com/things-go/x/extstr"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/connection/ccrypt"
+	"github.com/thinkgos/jocasta/connection/cpcap"
+	"github.com/thinkgos/jocasta/core/fakeip"
+	"github.com/thinkgos/jocasta/core/filter"
+	"github.com/thinkgos/jocasta/core/idns"
+	"github.com/thinkgos/jocasta/core/loadbalance"
+	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/ccs"
+	"github.com/thinkgos/jocasta/pkg/extcert"
+	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/outil"
+	"github.com/thinkgos/jocasta/pkg/sword"
+	"github.com/thinkgos/jocasta/services"
+	"github.com/thinkgos/jocasta/services/parent"
+)
+
+var errNotTCP = errors.New("not tcp connection")
+
+// Config config
+type Config struct {
+	// parent, 父级为socks5代理(socks或sps服务)
+	ParentType     string   `validate:"omitempty,oneof=tcp tls stcp kcp"` // 父级协议类型 tcp|tls|stcp|kcp, default: empty
+	Parent         []string // 父级地址,格式addr:port[@weight], default: nil
+	ParentCompress bool     // 父级是否传输压缩, default: false
+	ParentKey      string   // 父级连接加密密钥, default: empty
+	ParentAuth     string   // 上级socks5授权用户密码,格式username:password, default: empty
+	// local
+	Local string `validate:"required"` // 本地监听地址, 为iptables REDIRECT的目标端口 default: :28090
+	// tls有效
+	CertFile   string // cert文件 default: proxy.crt
+	KeyFile    string // key文件 default: proxy.key
+	CaCertFile string // ca文件 default: empty
+	// kcp有效
+	SKCPConfig ccs.SKCPConfig
+	// stcp有效
+	// stcp 加密方法 default: aes-192-cfb
+	// stcp 加密密钥 default: thinkgos's_jocasta
+	STCPConfig cs.StcpConfig
+	// 其它
+	Timeout time.Duration `validate:"required"` // 连接父级或真实服务器超时时间, default: 5s
+	Always  bool          // 强制所有连接走代理, default: false
+	// 代理过滤, 仅父级存在时有效
+	FilterConfig ccs.FilterConfig
+	// 路由规则, 优先于代理过滤
+	RuleConfig ccs.RuleConfig
+	// dns域名解析, FakeIPFile 为dns服务的fake-ip映射文件
+	DNSConfig ccs.DNSConfig
+	// 负载均衡
+	LbConfig ccs.LbConfig
+	Debug    bool
+	// 抓包, 记录父级连接的明文数据
+	CaptureConfig ccs.CaptureConfig
+	// private
+	tlsConfig  cs.TLSConfig
+	parentAuth *proxy.Auth
+	groups     map[string][]string
+}
+
+// Redir 透明代理服务
+type Redir struct {
+	cfg            Config
+	channel        net.Listener
+	filters        *filter.Filter
+	lb             *loadbalance.Balanced
+	groups         map[string]*loadbalance.Balanced
+	domainResolver *idns.Resolver
+	fakeIP         *fakeip.Pool
+	capture        *cpcap.Writer
+	userConns      *connection.Manager
+	origDst        func(net.Conn) (*net.TCPAddr, error) // 获取重定向前的目标地址
+	cancel         context.CancelFunc
+	ctx            context.Context
+	log            logger.Logger
+}
+
+var _ services.Service = (*Redir)(nil)
+
+// New new redir service
+func New(cfg Config, opts ...Option) *Redir {
+	r := &Redir{
+		cfg:     cfg,
+		origDst: originalDst,
+		log:     logger.NewDiscard(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.userConns = connection.New(0, nil)
+	return r
+}
+
+func (sf *Redir) inspectConfig() (err error) {
+	if len(sf.cfg.Parent) == 1 && sf.cfg.Parent[0] == "" {
+		sf.cfg.Parent = nil
+	}
+	if err = sword.Validate.Struct(&sf.cfg); err != nil {
+		return
+	}
+	if sf.cfg.groups, err = sf.cfg.RuleConfig.ParseGroups(); err != nil {
+		return
+	}
+	if len(sf.cfg.Parent) == 0 && len(sf.cfg.groups) == 0 {
+		return
+	}
+	if sf.cfg.ParentType == "" {
+		return fmt.Errorf("parent type required for %s", sf.cfg.Parent)
+	}
+	if err = sf.cfg.LbConfig.CheckProbes(loadbalance.ProbeProtocolSocks5); err != nil {
+		return err
+	}
+
+	if sf.cfg.ParentType == "tls" {
+		sf.cfg.tlsConfig.Cert, sf.cfg.tlsConfig.Key, err = extcert.LoadPair(sf.cfg.CertFile, sf.cfg.KeyFile)
+		if err != nil {
+			return
+		}
+		if sf.cfg.CaCertFile != "" {
+			if sf.cfg.tlsConfig.CaCert, err = ioutil.ReadFile(sf.cfg.CaCertFile); err != nil {
+				return fmt.Errorf("read ca file %+v", err)
+			}
+		}
+	}
+
+	// stcp 方法检查
+	if sf.cfg.ParentType == "stcp" && !extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
+		return fmt.Errorf("stcp cipher method support one of %s", strings.Join(encrypt.CipherMethods(), ","))
+	}
+
+	if sf.cfg.ParentAuth != "" {
+		au := strings.Split(sf.cfg.ParentAuth, ":")
+		if len(au) != 2 {
+			return errors.New("parent auth data format invalid")
+		}
+		sf.cfg.parentAuth = &proxy.Auth{User: au[0], Password: au[1]}
+	}
+	return
+}
+
+func (sf *Redir) initService() (err error) {
+	if sf.cfg.DNSConfig.Addr != "" {
+		sf.domainResolver = idns.New(sf.cfg.DNSConfig.Addr, sf.cfg.DNSConfig.TTL)
+	}
+	if sf.cfg.DNSConfig.FakeIPFile != "" {
+		if sf.fakeIP, err = fakeip.Open(sf.cfg.DNSConfig.FakeIPFile); err != nil {
+			return fmt.Errorf("open fake ip file, %s", err)
+		}
+	}
+	if len(sf.cfg.Parent) == 0 && sf.cfg.RuleConfig.File == "" {
+		return nil
+	}
+
+	// init filters
+	filterOpts := []filter.Option{
+		filter.WithTimeout(sf.cfg.Timeout),
+		filter.WithLivenessPeriod(sf.cfg.FilterConfig.Interval),
+		filter.WithPassiveThreshold(sf.cfg.FilterConfig.PassiveThreshold),
+		filter.WithPassiveHalfLife(sf.cfg.FilterConfig.PassiveHalfLife),
+		filter.WithRuleGroups(sf.cfg.groups),
+		filter.WithGPool(sword.GoPool),
+		filter.WithLogger(sf.log),
+	}
+	if sf.domainResolver != nil {
+		filterOpts = append(filterOpts, filter.WithResolver(sf.domainResolver))
+	}
+	if sf.cfg.FilterConfig.CacheFile != "" {
+		filterOpts = append(filterOpts, filter.WithCacheFile(sf.cfg.FilterConfig.CacheFile, sf.cfg.FilterConfig.CacheSavePeriod))
+	}
+	sf.filters = filter.New(sf.cfg.FilterConfig.Intelligent, filterOpts...)
+	count, err := sf.filters.LoadProxyFile(sf.cfg.FilterConfig.ProxyFile)
+	if err != nil {
+		sf.log.Warnf("[ Redir ] load proxy file(%s) %+v", sf.cfg.FilterConfig.ProxyFile, err)
+	} else {
+		sf.log.Debugf("[ Redir ] load proxy file, domains count: %d", count)
+	}
+	count, err = sf.filters.LoadDirectFile(sf.cfg.FilterConfig.DirectFile)
+	if err != nil {
+		sf.log.Warnf("[ Redir ] load direct file(%s) %+v", sf.cfg.FilterConfig.DirectFile, err)
+	} else {
+		sf.log.Debugf("[ Redir ] load direct file, domains count: %d", count)
+	}
+	if sf.cfg.FilterConfig.AutoProxyFile != "" {
+		count, unsupported, err := sf.filters.LoadAutoProxyFile(sf.cfg.FilterConfig.AutoProxyFile)
+		if err != nil {
+			sf.log.Warnf("[ Redir ] load autoproxy file(%s) %+v", sf.cfg.FilterConfig.AutoProxyFile, err)
+		} else {
+			sf.log.Debugf("[ Redir ] load autoproxy file, rules count: %d, unsupported count: %d", count, len(unsupported))
+			for _, line := range unsupported {
+				sf.log.Debugf("[ Redir ] autoproxy unsupported %s", line)
+			}
+		}
+	}
+	if sf.cfg.FilterConfig.CacheFile != "" {
+		count, err := sf.filters.LoadCache()
+		if err != nil {
+			sf.log.Warnf("[ Redir ] load filter cache file(%s) %+v", sf.cfg.FilterConfig.CacheFile, err)
+		} else {
+			sf.log.Debugf("[ Redir ] load filter cache file, domains count: %d", count)
+		}
+	}
+	if sf.cfg.RuleConfig.File != "" {
+		if count, err = sf.filters.LoadRuleFile(sf.cfg.RuleConfig.File); err != nil {
+			return fmt.Errorf("load rule file(%s), %+v", sf.cfg.RuleConfig.File, err)
+		}
+		sf.log.Debugf("[ Redir ] load rule file, rules count: %d", count)
+	}
+
+	// init lb
+	if len(sf.cfg.Parent) > 0 {
+		sf.lb = sf.newBalanced(sf.cfg.Parent)
+	}
+	sf.groups = make(map[string]*loadbalance.Balanced, len(sf.cfg.groups))
+	for name, parents := range sf.cfg.groups {
+		sf.groups[name] = sf.newBalanced(parents)
+	}
+	return nil
+}
+
+// newBalanced 创建父级负载均衡, 父级格式 addr:port[@weight]
+func (sf *Redir) newBalanced(parents []string) *loadbalance.Balanced {
+	return parent.NewBalanced(sf.cfg.LbConfig, parents, sf.probeConfig,
+		loadbalance.WithDNSServer(sf.domainResolver),
+		loadbalance.WithLogger(sf.log),
+		loadbalance.WithEnableDebug(sf.cfg.Debug),
+	)
+}
+
+// probeConfig 父级健康检查探针配置
+func (sf *Redir) probeConfig(addr string) loadbalance.ProbeConfig {
+	c := parent.ProbeConfig(sf.cfg.LbConfig, addr, loadbalance.ProbeProtocolSocks5)
+	if sf.cfg.ParentType == "tls" {
+		c.TLSConfig, _ = sf.cfg.tlsConfig.ClientConfig() // nolint: errcheck
+	}
+	if sf.cfg.ParentType != "ssh" {
+		c.Dial = sf.probeDial
+	}
+	return c
+}
+
+// probeDial 探针经父级传输连接父级
+func (sf *Redir) probeDial(_ context.Context, addr string, _ time.Duration) (net.Conn, error) {
+	return sf.dialParent(addr)
+}
+
+// Start 启动服务
+func (sf *Redir) Start() (err error) {
+	sf.ctx, sf.cancel = context.WithCancel(context.Background())
+	defer func() {
+		if err != nil {
+			sf.Stop()
+		}
+	}()
+
+	if err = sf.inspectConfig(); err != nil {
+		return
+	}
+	if err = sf.initService(); err != nil {
+		return
+	}
+	if sf.capture, err = sf.cfg.CaptureConfig.New(cpcap.WithLogger(sf.log)); err != nil {
+		return fmt.Errorf("new capture, %+v", err)
+	}
+
+	ln, err := net.Listen("tcp", sf.cfg.Local)
+	if err != nil {
+		return err
+	}
+	sf.channel = ln
+	sword.Go(func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sword.Go(func() { sf.handle(conn) })
+		}
+	})
+
+	if len(sf.cfg.Parent) > 0 {
+		sf.log.Infof("[ Redir ] use parent %s< %s >", sf.cfg.ParentType, sf.cfg.Parent)
+	}
+	for name, parents := range sf.cfg.groups {
+		sf.log.Infof("[ Redir ] use parent group %s %s< %s >", name, sf.cfg.ParentType, parents)
+	}
+	if sf.fakeIP != nil {
+		sf.log.Infof("[ Redir ] use fake ip file %s", sf.cfg.DNSConfig.FakeIPFile)
+	}
+	sf.log.Infof("[ Redir ] redir proxy on %s", ln.Addr())
+	return nil
+}
+
+// Stop 停止服务
+func (sf *Redir) Stop() {
+	if sf.cancel != nil {
+		sf.cancel()
+	}
+	if sf.channel != nil {
+		sf.channel.Close()
+	}
+	if sf.filters != nil {
+		if err := sf.filters.Close(); err != nil {
+			sf.log.Warnf("save filter cache file, %v", err)
+		}
+	}
+	if sf.lb != nil {
+		sf.lb.Close()
+	}
+	for _, lb := range sf.groups {
+		lb.Close()
+	}
+	for _, c := range sf.userConns.Items() {
+		c.(net.Conn).Close()
+	}
+	if sf.capture != nil {
+		sf.capture.Close()
+	}
+	sf.log.Infof("[ Redir ] service redir stopped")
+}
+
+// LocalAddr 本地监听地址
+func (sf *Redir) LocalAddr() net.Addr {
+	if sf.channel == nil {
+		return nil
+	}
+	return sf.channel.Addr()
+}
+
+func (sf *Redir) handle(inConn net.Conn) {
+	defer inConn.Close()
+
+	dst, err := sf.origDst(inConn)
+	if err != nil {
+		sf.log.Errorf("[ Redir ] get original destination, %v", err)
+		return
+	}
+	srcAddr := inConn.RemoteAddr().String()
+	targetAddr := sf.fakeIP.Restore(dst.String())
+	if sf.fakeIP != nil && sf.fakeIP.Contains(dst.IP) && targetAddr == dst.String() {
+		sf.log.Errorf("[ Redir ] fake ip %s has no mapping domain", dst)
+		return
+	}
+	// 未被重定向的连接, 防止回环
+	if dst.String() == inConn.LocalAddr().String() {
+		sf.log.Errorf("[ Redir ] dead loop detected, %s", dst)
+		return
+	}
+
+	lb, err := sf.route(srcAddr, targetAddr)
+	if err != nil {
+		sf.log.Warnf("[ Redir ] tcp %s --> %s %v", srcAddr, targetAddr, err)
+		return
+	}
+	useProxy := lb != nil
+	var targetConn net.Conn
+	var lbAddr string
+	if useProxy {
+		lbAddr = lb.Select(srcAddr)
+		if sf.cfg.LbConfig.IsHashTarget() {
+			lbAddr = lb.Select(targetAddr)
+		}
+		dial := cs.Socks5{
+			ProxyHost: lbAddr,
+			Auth:      sf.cfg.parentAuth,
+			Timeout:   sf.cfg.Timeout,
+			Forward:   parentDialer{sf},
+		}
+		targetConn, err = dial.Dial("tcp", targetAddr)
+	} else {
+		targetConn, err = net.DialTimeout("tcp", outil.Resolve(sf.domainResolver, targetAddr), sf.cfg.Timeout)
+		if err != nil && sf.filters != nil {
+			sf.filters.Report(targetAddr, filter.DialConnectFailure)
+		}
+	}
+	if err != nil {
+		sf.log.Errorf("[ Redir ] dial %s, %v", targetAddr, err)
+		return
+	}
+	defer targetConn.Close()
+
+	used := "DIRECT"
+	if useProxy {
+		used = "PROXY"
+		lb.ConnsIncrease(lbAddr)
+		defer lb.ConnsDecrease(lbAddr)
+	}
+
+	sf.userConns.Set(srcAddr, inConn)
+	defer sf.userConns.Remove(srcAddr)
+	sf.log.Infof("[ Redir ] tcp %s --> %s use %s connected", srcAddr, targetAddr, used)
+
+	res := sword.Binding.Proxy(inConn, targetConn)
+	sf.log.Infof("[ Redir ] tcp %s --> %s released, up %d bytes, down %d bytes",
+		srcAddr, targetAddr, res.Upstream.Written, res.Downstream.Written)
+	if !useProxy && sf.filters != nil {
+		if outcome, ok := filter.ClassifyOutcome(res.Upstream.Written, res.Downstream.Written, res.Downstream.Err); ok {
+			sf.filters.Report(targetAddr, outcome)
+		}
+	}
+	if err = res.Err(); err != nil && !errors.Is(err, io.EOF) && !extnet.IsErrClosed(err) {
+		sf.log.Errorf("[ Redir ] proxying, %s", err)
+	}
+}
+
+// route 选择连接使用的父级, 优先匹配路由规则, 无规则匹配时使用代理过滤, 返回nil表示直连
+func (sf *Redir) route(srcAddr, targetAddr string) (*loadbalance.Balanced, error) {
+	m := &filter.Metadata{Src: srcAddr, Dst: targetAddr}
+	if lb, matched, err := parent.Match(sf.filters, m, sf.lb, sf.groups); matched {
+		return lb, err
+	}
+	if sf.isUseProxy(targetAddr) {
+		return sf.lb, nil
+	}
+	return nil, nil
+}
+
+func (sf *Redir) isUseProxy(addr string) bool {
+	if len(sf.cfg.Parent) == 0 {
+		return false
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if extnet.IsDomain(host) && sf.cfg.Always || !extnet.IsIntranet(host) {
+		if sf.cfg.Always {
+			return true
+		}
+		useProxy, isInMap, _, _ := sf.filters.IsProxy(addr)
+		if !isInMap {
+			sf.filters.Add(addr, outil.Resolve(sf.domainResolver, addr))
+		}
+		return useProxy
+	}
+	return false
+}
+
+func (sf *Redir) dialParent(address string) (net.Conn, error) {
+	d := ccs.Dialer{
+		Protocol: sf.cfg.ParentType,
+		Timeout:  sf.cfg.Timeout,
+		Config: ccs.Config{
+			TLSConfig:  sf.cfg.tlsConfig,
+			StcpConfig: sf.cfg.STCPConfig,
+			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+		},
+		AdornChains: connection.AdornConnsChain{
+			connection.AdornSnappy(sf.cfg.ParentCompress),
+			connection.AdornPcap(sf.capture),
+		},
+	}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	// 加密需在socks5握手之前, 握手也经加密传输
+	if sf.cfg.ParentKey != "" {
+		conn = ccrypt.New(conn, ccrypt.Config{Password: sf.cfg.ParentKey})
+	}
+	return conn, nil
+}
+
+// parentDialer 经由父级协议连接父级socks5代理
+type parentDialer struct {
+	redir *Redir
+}
+
+func (sf parentDialer) Dial(_ string, addr string) (net.Conn, error) {
+	return sf.redir.dialParent(addr)
+}
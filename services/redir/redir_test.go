@@ -0,0 +1,133 @@
+package redir
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/connection/ccrypt"
+	"github.com/thinkgos/jocasta/pkg/ccs"
+)
+
+// tcpEcho 本地tcp回显服务
+func tcpEcho(t *testing.T) *net.TCPAddr {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) // nolint: errcheck
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+// handleConn 同步处理一条重定向到dst的连接, 返回客户端收到的数据
+func handleConn(t *testing.T, r *Redir, dst *net.TCPAddr, data string) string {
+	r.origDst = func(net.Conn) (*net.TCPAddr, error) { return dst, nil }
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			got <- ""
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(data))         // nolint: errcheck
+		conn.(*net.TCPConn).CloseWrite() // nolint: errcheck
+		b, _ := ioutil.ReadAll(conn)     // nolint: errcheck
+		got <- string(b)
+	}()
+
+	inConn, err := ln.Accept()
+	require.NoError(t, err)
+	r.handle(inConn)
+
+	select {
+	case s := <-got:
+		return s
+	case <-time.After(time.Second * 3):
+		t.Fatal("client timeout")
+	}
+	return ""
+}
+
+func TestRedir_NoParent(t *testing.T) {
+	r := New(Config{Local: "127.0.0.1:0", Timeout: time.Second})
+	require.NoError(t, r.Start())
+	defer r.Stop()
+	require.Nil(t, r.filters)
+
+	// 直连, 有数据返回
+	assert.Equal(t, "hello", handleConn(t, r, tcpEcho(t), "hello"))
+
+	// 直连失败
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+	assert.Equal(t, "", handleConn(t, r, closed, "hello"))
+}
+
+func TestRedir_ParentKey(t *testing.T) {
+	// 父级按密钥解密, 记录收到的首字节
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	first := make(chan byte, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b := make([]byte, 1)
+				if _, err := io.ReadFull(ccrypt.New(conn, ccrypt.Config{Password: "key"}), b); err == nil {
+					first <- b[0]
+				}
+			}()
+		}
+	}()
+
+	r := New(Config{
+		ParentType: "tcp",
+		Parent:     []string{ln.Addr().String()},
+		ParentKey:  "key",
+		Local:      "127.0.0.1:0",
+		Timeout:    time.Second,
+		Always:     true,
+		LbConfig:   ccs.LbConfig{Method: "roundrobin", Timeout: time.Second, Period: time.Millisecond * 50},
+	})
+	require.NoError(t, r.Start())
+	defer r.Stop()
+	// 等待父级健康检查通过
+	require.Eventually(t, func() bool { return r.lb.Select("") != "" }, time.Second*3, time.Millisecond*20)
+
+	handleConn(t, r, &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 80}, "hello")
+	// socks5握手也需经加密传输, 解密后为版本号0x05
+	select {
+	case b := <-first:
+		assert.Equal(t, byte(0x05), b)
+	case <-time.After(time.Second * 3):
+		t.Fatal("parent receive timeout")
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -27,7 +26,10 @@ import (
 	connection2 "github.com/thinkgos/jocasta/connection"
 	ccrypt "github.com/thinkgos/jocasta/connection/ccrypt"
 	ciol "github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/cpcap"
 	"github.com/thinkgos/jocasta/core/basicAuth"
+	"github.com/thinkgos/jocasta/core/binding"
+	"github.com/thinkgos/jocasta/core/fakeip"
 	"github.com/thinkgos/jocasta/core/filter"
 	"github.com/thinkgos/jocasta/core/idns"
 	"github.com/thinkgos/jocasta/core/loadbalance"
@@ -35,10 +37,12 @@ import (
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/enet"
 	"github.com/thinkgos/jocasta/pkg/extcert"
+	"github.com/thinkgos/jocasta/pkg/fwatch"
 	"github.com/thinkgos/jocasta/pkg/logger"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/services"
+	"github.com/thinkgos/jocasta/services/parent"
 )
 
 type Config struct {
@@ -68,12 +72,16 @@ type Config struct {
 	// 其它
 	Timeout time.Duration // default 5000 单位ms
 	Always  bool          // 强制所有域名走代理 default false
+	// 过滤, 规则和认证文件的变化检查间隔, 文件变化时重新加载, 0 表示不检查 default: 0
+	ReloadInterval time.Duration
 	// 代理过滤
 	// direct 不在blocked都直连
 	// parent 不在direct都走代理
 	// intelligent blocked和direct都没有,智能判断
 	// default intelligent
 	FilterConfig ccs.FilterConfig
+	// 路由规则, 优先于代理过滤
+	RuleConfig ccs.RuleConfig
 	// basic auth 配置
 	AuthConfig ccs.AuthConfig
 	// dns域名解析
@@ -85,40 +93,47 @@ type Config struct {
 	LocalIPS   []string // default empty
 	BindListen bool     // default false
 	Debug      bool
+	// 抓包, 记录本地及父级连接的明文数据
+	CaptureConfig ccs.CaptureConfig
 
 	// private
 	tlsConfig     cs.TLSConfig
 	sshAuthMethod ssh.AuthMethod
 	rateLimit     rate.Limit
 	parentAuth    *proxy.Auth
+	groups        map[string][]string
 }
 
 type Socks struct {
-	cfg                   Config
-	channel               net.Listener
-	socks5Srv             *socks5.Server
-	filters               *filter.Filter
-	basicAuthCenter       *basicAuth.Center
-	lb                    *loadbalance.Balanced
-	domainResolver        *idns.Resolver
-	sshClient             atomic.Value
-	userConns             cmap.ConcurrentMap
-	udpRelatedPacketConns cmap.ConcurrentMap
-	cancel                context.CancelFunc
-	ctx                   context.Context
-	log                   logger.Logger
-	udpLocalKey           []byte
-	udpParentKey          []byte
+	cfg             Config
+	channel         net.Listener
+	socks5Srv       *socks5.Server
+	filters         *filter.Filter
+	basicAuthCenter *basicAuth.Center
+	lb              *loadbalance.Balanced
+	watcher         *fwatch.Watcher
+	groups          map[string]*loadbalance.Balanced
+	domainResolver  *idns.Resolver
+	fakeIP          *fakeip.Pool
+	sshClient       atomic.Value
+	userConns       cmap.ConcurrentMap
+	udpRelays       cmap.ConcurrentMap // udp监听地址 -> *binding.UDPRelay
+	cancel          context.CancelFunc
+	ctx             context.Context
+	log             logger.Logger
+	udpLocalKey     []byte
+	udpParentKey    []byte
+	capture         *cpcap.Writer
 }
 
 var _ services.Service = (*Socks)(nil)
 
 func New(log logger.Logger, cfg Config) *Socks {
 	return &Socks{
-		cfg:                   cfg,
-		userConns:             cmap.New(),
-		udpRelatedPacketConns: cmap.New(),
-		log:                   log,
+		cfg:       cfg,
+		userConns: cmap.New(),
+		udpRelays: cmap.New(),
+		log:       log,
 	}
 }
 
@@ -126,8 +141,11 @@ func (sf *Socks) inspectConfig() (err error) {
 	if len(sf.cfg.Parent) == 1 && (sf.cfg.Parent)[0] == "" {
 		sf.cfg.Parent = []string{}
 	}
+	if sf.cfg.groups, err = sf.cfg.RuleConfig.ParseGroups(); err != nil {
+		return err
+	}
 
-	if sf.cfg.LocalType == "tls" || (sf.cfg.ParentType == "tls" && len(sf.cfg.Parent) > 0) {
+	if sf.cfg.LocalType == "tls" || (sf.cfg.ParentType == "tls" && sf.hasParent()) {
 		sf.cfg.tlsConfig.Cert, sf.cfg.tlsConfig.Key, err = extcert.LoadPair(sf.cfg.CertFile, sf.cfg.KeyFile)
 		if err != nil {
 			return err
@@ -140,14 +158,20 @@ func (sf *Socks) inspectConfig() (err error) {
 		}
 	}
 
-	if len(sf.cfg.Parent) > 0 {
+	if sf.hasParent() {
 		if sf.cfg.ParentType == "" {
 			return fmt.Errorf("parent type required for %s", sf.cfg.Parent)
 		}
 		if !extstr.Contains([]string{"tcp", "tls", "stcp", "kcp", "ssh"}, sf.cfg.ParentType) {
 			return fmt.Errorf("parent type suport <tcp|tls|stcp|kcp|ssh>")
 		}
+		if err = sf.cfg.LbConfig.CheckProbes(loadbalance.ProbeProtocolSocks5); err != nil {
+			return err
+		}
 		if sf.cfg.ParentType == "ssh" {
+			if len(sf.cfg.groups) > 0 {
+				return errors.New("parent group not support ssh parent")
+			}
 			sf.cfg.sshAuthMethod, err = sf.cfg.SSHConfig.Parse()
 			if err != nil {
 				return fmt.Errorf("parse ssh config, %+v", err)
@@ -181,6 +205,12 @@ func (sf *Socks) initService() (err error) {
 	if sf.cfg.DNSConfig.Addr != "" {
 		sf.domainResolver = idns.New(sf.cfg.DNSConfig.Addr, sf.cfg.DNSConfig.TTL)
 	}
+	// init fake ip
+	if sf.cfg.DNSConfig.FakeIPFile != "" {
+		if sf.fakeIP, err = fakeip.Open(sf.cfg.DNSConfig.FakeIPFile); err != nil {
+			return fmt.Errorf("open fake ip file, %s", err)
+		}
+	}
 	// init basic auth
 	if sf.cfg.AuthConfig.File != "" || len(sf.cfg.AuthConfig.UserPasses) > 0 || sf.cfg.AuthConfig.URL != "" {
 		if sf.domainResolver != nil {
@@ -204,13 +234,24 @@ func (sf *Socks) initService() (err error) {
 		}
 	}
 
-	if len(sf.cfg.Parent) > 0 {
+	if len(sf.cfg.Parent) > 0 || sf.cfg.RuleConfig.File != "" {
 		// init filters
-		sf.filters = filter.New(sf.cfg.FilterConfig.Intelligent,
+		filterOpts := []filter.Option{
 			filter.WithTimeout(sf.cfg.Timeout),
 			filter.WithLivenessPeriod(sf.cfg.FilterConfig.Interval),
+			filter.WithPassiveThreshold(sf.cfg.FilterConfig.PassiveThreshold),
+			filter.WithPassiveHalfLife(sf.cfg.FilterConfig.PassiveHalfLife),
+			filter.WithRuleGroups(sf.cfg.groups),
 			filter.WithGPool(sword.GoPool),
-			filter.WithLogger(sf.log))
+			filter.WithLogger(sf.log),
+		}
+		if sf.domainResolver != nil {
+			filterOpts = append(filterOpts, filter.WithResolver(sf.domainResolver))
+		}
+		if sf.cfg.FilterConfig.CacheFile != "" {
+			filterOpts = append(filterOpts, filter.WithCacheFile(sf.cfg.FilterConfig.CacheFile, sf.cfg.FilterConfig.CacheSavePeriod))
+		}
+		sf.filters = filter.New(sf.cfg.FilterConfig.Intelligent, filterOpts...)
 		var count int
 		count, err = sf.filters.LoadProxyFile(sf.cfg.FilterConfig.ProxyFile)
 		if err != nil {
@@ -224,35 +265,44 @@ func (sf *Socks) initService() (err error) {
 		} else {
 			sf.log.Debugf("load direct file, domains count: %d", count)
 		}
-
-		// init lb
-		configs := []loadbalance.Config{}
-
-		for _, addr := range sf.cfg.Parent {
-			_addrInfo := strings.Split(addr, "@")
-			_addr, weight := _addrInfo[0], 1
-
-			if len(_addrInfo) == 2 {
-				weight, _ = strconv.Atoi(_addrInfo[1])
-				if weight == 0 {
-					weight = 1
+		if sf.cfg.FilterConfig.AutoProxyFile != "" {
+			count, unsupported, err := sf.filters.LoadAutoProxyFile(sf.cfg.FilterConfig.AutoProxyFile)
+			if err != nil {
+				sf.log.Warnf("[ Socks ] load autoproxy file(%s) %+v", sf.cfg.FilterConfig.AutoProxyFile, err)
+			} else {
+				sf.log.Debugf("[ Socks ] load autoproxy file, rules count: %d, unsupported count: %d", count, len(unsupported))
+				for _, line := range unsupported {
+					sf.log.Debugf("[ Socks ] autoproxy unsupported %s", line)
 				}
 			}
-			configs = append(configs, loadbalance.Config{
-				Addr:             _addr,
-				Weight:           weight,
-				SuccessThreshold: 1,
-				FailureThreshold: 2,
-				Timeout:          sf.cfg.LbConfig.Timeout,
-				Period:           sf.cfg.LbConfig.RetryTime,
-			})
 		}
-		sf.lb = loadbalance.New(sf.cfg.LbConfig.Method, configs,
-			loadbalance.WithDNSServer(sf.domainResolver),
-			loadbalance.WithLogger(sf.log),
-			loadbalance.WithEnableDebug(sf.cfg.Debug),
-			loadbalance.WithGPool(sword.GoPool),
-		)
+		if sf.cfg.FilterConfig.CacheFile != "" {
+			count, err := sf.filters.LoadCache()
+			if err != nil {
+				sf.log.Warnf("load filter cache file(%s) %+v", sf.cfg.FilterConfig.CacheFile, err)
+			} else {
+				sf.log.Debugf("load filter cache file, domains count: %d", count)
+			}
+		}
+		if sf.cfg.RuleConfig.File != "" {
+			count, err = sf.filters.LoadRuleFile(sf.cfg.RuleConfig.File)
+			if err != nil {
+				return fmt.Errorf("load rule file(%s), %+v", sf.cfg.RuleConfig.File, err)
+			}
+			sf.log.Debugf("load rule file, rules count: %d", count)
+		}
+	}
+	// init lb
+	if len(sf.cfg.Parent) > 0 {
+		sf.lb = sf.newBalanced(sf.cfg.Parent)
+	}
+	sf.groups = make(map[string]*loadbalance.Balanced, len(sf.cfg.groups))
+	for name, parents := range sf.cfg.groups {
+		sf.groups[name] = sf.newBalanced(parents)
+	}
+	// watch files
+	if sf.cfg.ReloadInterval > 0 {
+		sf.watchFiles()
 	}
 	// init ssh connect
 	if sf.cfg.ParentType == "ssh" {
@@ -315,6 +365,9 @@ func (sf *Socks) Start() (err error) {
 	if err = sf.initService(); err != nil {
 		return err
 	}
+	if sf.capture, err = sf.cfg.CaptureConfig.New(cpcap.WithLogger(sf.log)); err != nil {
+		return fmt.Errorf("new capture, %+v", err)
+	}
 
 	var opts []socks5.Option
 
@@ -334,9 +387,12 @@ func (sf *Socks) Start() (err error) {
 			StcpConfig: sf.cfg.STCPConfig,
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
 		},
-		GoPool:      sword.GoPool,
-		AdornChains: connection2.AdornConnsChain{connection2.AdornSnappy(sf.cfg.LocalCompress)},
-		Handler:     cs.HandlerFunc(sf.handle),
+		GoPool: sword.GoPool,
+		AdornChains: connection2.AdornConnsChain{
+			connection2.AdornSnappy(sf.cfg.LocalCompress),
+			connection2.AdornPcap(sf.capture),
+		},
+		Handler: cs.HandlerFunc(sf.handle),
 	}
 
 	sf.channel, err = srv.Listen()
@@ -349,6 +405,9 @@ func (sf *Socks) Start() (err error) {
 	if len(sf.cfg.Parent) > 0 {
 		sf.log.Infof("[ Socks ] use parent %s < %v [ %s ] >", sf.cfg.ParentType, sf.cfg.Parent, strings.ToUpper(sf.cfg.LbConfig.Method))
 	}
+	for name, parents := range sf.cfg.groups {
+		sf.log.Infof("[ Socks ] use parent group %s %s < %v [ %s ] >", name, sf.cfg.ParentType, parents, strings.ToUpper(sf.cfg.LbConfig.Method))
+	}
 	sf.log.Infof("[ Socks ] use proxy %s on %s", sf.cfg.LocalType, sf.channel.Addr().String())
 	return
 }
@@ -360,11 +419,19 @@ func (sf *Socks) Stop() {
 	if sf.channel != nil {
 		sf.channel.Close()
 	}
+	if sf.watcher != nil {
+		sf.watcher.Close()
+	}
 	if sf.lb != nil {
 		sf.lb.Close()
 	}
+	for _, lb := range sf.groups {
+		lb.Close()
+	}
 	if sf.filters != nil {
-		sf.filters.Close()
+		if err := sf.filters.Close(); err != nil {
+			sf.log.Warnf("[ Socks ] save filter cache file, %v", err)
+		}
 	}
 	if sf.cfg.ParentType == "ssh" {
 		sf.sshClient.Load().(*ssh.Client).Close()
@@ -372,8 +439,11 @@ func (sf *Socks) Stop() {
 	for _, c := range sf.userConns.Items() {
 		c.(io.Closer).Close()
 	}
-	for _, c := range sf.udpRelatedPacketConns.Items() {
-		c.(io.Closer).Close()
+	for _, r := range sf.udpRelays.Items() {
+		r.(*binding.UDPRelay).Close()
+	}
+	if sf.capture != nil {
+		sf.capture.Close()
 	}
 	sf.log.Infof("[ Socks ] service stopped")
 }
@@ -390,11 +460,13 @@ func (sf *Socks) handle(inConn net.Conn) {
 
 func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5.Request) error {
 	// Attempt to connect
-	targetConn, lbAddr, err := sf.dialForTcp(ctx, request)
+	targetConn, lb, lbAddr, err := sf.dialForTcp(ctx, request)
 	if err != nil {
 		msg := err.Error()
 		resp := statute.RepHostUnreachable
-		if strings.Contains(msg, "refused") {
+		if errors.Is(err, parent.ErrRejected) {
+			resp = statute.RepRuleFailure
+		} else if strings.Contains(msg, "refused") {
 			resp = statute.RepConnectionRefused
 		} else if strings.Contains(msg, "network is unreachable") {
 			resp = statute.RepNetworkUnreachable
@@ -406,6 +478,11 @@ func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5
 	}
 	defer targetConn.Close()
 
+	inConn, ok := writer.(net.Conn)
+	if !ok {
+		return errors.New("socks5 client writer is not a conn")
+	}
+
 	// Send success
 	if err = socks5.SendReply(writer, statute.RepSuccess, targetConn.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to send reply, %v", err)
@@ -424,43 +501,57 @@ func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5
 		}
 		return newValue
 	})
-	if len(sf.cfg.Parent) > 0 {
-		sf.lb.ConnsIncrease(lbAddr)
+	if lb != nil {
+		lb.ConnsIncrease(lbAddr)
 	}
 	sf.log.Infof("[ Socks ] tcp %s --> %s connected", srcAddr, targetAddr)
 
 	defer func() {
 		sf.log.Infof("[ Socks ] tcp %s --> %s released", srcAddr, targetAddr)
 		sf.userConns.Remove(srcAddr)
-		if len(sf.cfg.Parent) > 0 {
-			sf.lb.ConnsDecrease(lbAddr)
+		if lb != nil {
+			lb.ConnsDecrease(lbAddr)
 		}
 	}()
 
 	// start proxying
-	eCh1 := make(chan error, 1)
-	eCh2 := make(chan error, 1)
-	sword.Go(func() { eCh1 <- sf.socks5Srv.Proxy(targetConn, request.Reader) })
-	sword.Go(func() { eCh2 <- sf.socks5Srv.Proxy(writer, targetConn) })
-	// Wait
-	select {
-	case err = <-eCh1:
-	case err = <-eCh2:
-	}
-	return err
+	res := sword.Binding.Proxy(&socks5Conn{inConn, request.Reader}, targetConn)
+	// 直连时按收发字节数报告连接结果, 用于过滤器被动学习
+	if lb == nil && sf.filters != nil {
+		if outcome, ok := filter.ClassifyOutcome(res.Upstream.Written, res.Downstream.Written, res.Downstream.Err); ok {
+			sf.filters.Report(sf.fakeIP.Restore(targetAddr), outcome)
+		}
+	}
+	return res.Err()
+}
+
+// socks5Conn socks5客户端连接, 从请求的缓冲reader读取, 避免丢失握手时已缓冲的数据
+type socks5Conn struct {
+	net.Conn
+	r io.Reader
 }
 
-func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn net.Conn, lbAddr string, err error) {
+func (sf *socks5Conn) Read(p []byte) (int, error) { return sf.r.Read(p) }
+
+// Unwrap returns the inner conn.
+func (sf *socks5Conn) Unwrap() net.Conn { return sf.Conn }
+
+func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn net.Conn, lb *loadbalance.Balanced, lbAddr string, err error) {
 	srcAddr := request.RemoteAddr.String()
 	localAddr := request.LocalAddr.String()
-	targetAddr := request.DestAddr.String()
+	targetAddr := sf.fakeIP.Restore(request.DestAddr.String())
 
 	if sf.IsDeadLoop(localAddr, targetAddr) {
 		sf.log.Errorf("[ Socks ] dead loop detected , %s", targetAddr)
-		return nil, "", errors.New("dead loop")
+		return nil, nil, "", errors.New("dead loop")
 	}
 
-	useProxy := sf.isUseProxy(targetAddr)
+	lb, err = sf.route(srcAddr, targetAddr, request.AuthContext.Payload["username"])
+	if err != nil {
+		sf.log.Warnf("[ Socks ] %s --> %s %v", srcAddr, targetAddr, err)
+		return nil, nil, "", err
+	}
+	useProxy := lb != nil
 	if useProxy {
 		boff := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), 5)
 		boff = backoff.WithContext(boff, sf.ctx)
@@ -473,10 +564,10 @@ func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn
 			socksAddr := targetAddr
 			if sf.cfg.ParentType != "ssh" {
 				selectAddr := srcAddr
-				if sf.cfg.LbConfig.Method == "hash" && sf.cfg.LbConfig.HashTarget {
+				if sf.cfg.LbConfig.IsHashTarget() {
 					selectAddr = targetAddr
 				}
-				lbAddr = sf.lb.Select(selectAddr)
+				lbAddr = lb.Select(selectAddr)
 				socksAddr = lbAddr
 			}
 
@@ -492,10 +583,13 @@ func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn
 		}, boff)
 	} else {
 		conn, err = sf.dialDirect(outil.Resolve(sf.domainResolver, targetAddr), localAddr)
+		if err != nil && sf.filters != nil {
+			sf.filters.Report(targetAddr, filter.DialConnectFailure)
+		}
 	}
 	if err != nil {
 		sf.log.Warnf("[ Socks ] dial conn fail, %v", err)
-		return nil, "", err
+		return nil, nil, "", err
 	}
 	if useProxy && sf.cfg.ParentKey != "" {
 		conn = ccrypt.New(conn, ccrypt.Config{Password: sf.cfg.ParentKey})
@@ -505,7 +599,7 @@ func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn
 		used = "PROXY"
 	}
 	sf.log.Infof("[ Socks ] %s use %s", targetAddr, used)
-	return conn, lbAddr, nil
+	return conn, lb, lbAddr, nil
 }
 
 func (sf *Socks) IsDeadLoop(inLocalAddr string, outAddr string) bool {
@@ -559,7 +653,10 @@ func (sf *Socks) dialParent(targetAddr string) (outConn net.Conn, err error) {
 				StcpConfig: sf.cfg.STCPConfig,
 				KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
 			},
-			AdornChains: connection2.AdornConnsChain{connection2.AdornSnappy(sf.cfg.ParentCompress)},
+			AdornChains: connection2.AdornConnsChain{
+				connection2.AdornSnappy(sf.cfg.ParentCompress),
+				connection2.AdornPcap(sf.capture),
+			},
 		}
 		outConn, err = d.Dial("tcp", targetAddr)
 	case "ssh":
@@ -633,6 +730,90 @@ func (sf *Socks) proxyAuth(auth proxy.Auth, fromSS bool) *proxy.Auth {
 	return nil
 }
 
+// hasParent 是否配置了父级或父级组
+func (sf *Socks) hasParent() bool {
+	return len(sf.cfg.Parent) > 0 || len(sf.cfg.groups) > 0
+}
+
+// newBalanced 创建父级负载均衡, 父级格式 addr:port[@weight]
+func (sf *Socks) newBalanced(parents []string) *loadbalance.Balanced {
+	return parent.NewBalanced(sf.cfg.LbConfig, parents, sf.probeConfig,
+		loadbalance.WithDNSServer(sf.domainResolver),
+		loadbalance.WithLogger(sf.log),
+		loadbalance.WithEnableDebug(sf.cfg.Debug),
+	)
+}
+
+// probeConfig 父级健康检查探针配置
+func (sf *Socks) probeConfig(addr string) loadbalance.ProbeConfig {
+	c := parent.ProbeConfig(sf.cfg.LbConfig, addr, loadbalance.ProbeProtocolSocks5)
+	if sf.cfg.ParentType == "tls" {
+		c.TLSConfig, _ = sf.cfg.tlsConfig.ClientConfig() // nolint: errcheck
+	}
+	if sf.cfg.ParentType != "ssh" {
+		c.Dial = sf.probeDial
+	}
+	return c
+}
+
+// probeDial 探针经父级传输连接父级
+func (sf *Socks) probeDial(_ context.Context, addr string, _ time.Duration) (net.Conn, error) {
+	conn, err := sf.dialParent(addr)
+	if err != nil {
+		return nil, err
+	}
+	if sf.cfg.ParentKey != "" {
+		conn = ccrypt.New(conn, ccrypt.Config{Password: sf.cfg.ParentKey})
+	}
+	return conn, nil
+}
+
+// watchFiles 监视过滤, 规则和认证文件, 文件变化时重新加载, 加载失败时保留原数据
+func (sf *Socks) watchFiles() {
+	sf.watcher = fwatch.New(sf.cfg.ReloadInterval)
+	if sf.filters != nil {
+		fc := sf.cfg.FilterConfig
+		sf.watcher.Add(func() {
+			stat, err := sf.filters.Reload(fc.ProxyFile, fc.DirectFile, fc.AutoProxyFile)
+			if err != nil {
+				sf.log.Warnf("[ Socks ] reload filter files, %v", err)
+				return
+			}
+			sf.log.Infof("[ Socks ] filter files reloaded, %s", stat)
+		}, fc.ProxyFile, fc.DirectFile, fc.AutoProxyFile)
+		sf.watcher.Add(func() {
+			count, err := sf.filters.LoadRuleFile(sf.cfg.RuleConfig.File)
+			if err != nil {
+				sf.log.Warnf("[ Socks ] reload rule file(%s), %v", sf.cfg.RuleConfig.File, err)
+				return
+			}
+			sf.log.Infof("[ Socks ] rule file reloaded, rules count: %d", count)
+		}, sf.cfg.RuleConfig.File)
+	}
+	if sf.basicAuthCenter != nil {
+		sf.watcher.Add(func() {
+			stat, err := sf.basicAuthCenter.ReloadFromFile(sf.cfg.AuthConfig.File)
+			if err != nil {
+				sf.log.Warnf("[ Socks ] reload auth file(%s), %v", sf.cfg.AuthConfig.File, err)
+				return
+			}
+			sf.log.Infof("[ Socks ] auth file reloaded, users %s", stat)
+		}, sf.cfg.AuthConfig.File)
+	}
+}
+
+// route 选择连接使用的父级, 优先匹配路由规则, 无规则匹配时使用代理过滤, 返回nil表示直连
+func (sf *Socks) route(srcAddr, targetAddr, user string) (*loadbalance.Balanced, error) {
+	m := &filter.Metadata{Src: srcAddr, Dst: targetAddr, User: user}
+	if lb, matched, err := parent.Match(sf.filters, m, sf.lb, sf.groups); matched {
+		return lb, err
+	}
+	if sf.isUseProxy(targetAddr) {
+		return sf.lb, nil
+	}
+	return nil, nil
+}
+
 func (sf *Socks) isUseProxy(addr string) bool {
 	if len(sf.cfg.Parent) > 0 {
 		host, _, _ := net.SplitHostPort(addr)
@@ -15,6 +15,8 @@ import (
 	"github.com/thinkgos/go-socks5/statute"
 	"golang.org/x/net/proxy"
 
+	"github.com/thinkgos/jocasta/core/binding"
+	"github.com/thinkgos/jocasta/core/loadbalance"
 	"github.com/thinkgos/jocasta/core/socks5"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
@@ -25,9 +27,16 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 		return errors.New("ssh not support udp")
 	}
 
-	useProxy := sf.isUseProxy(request.DestAddr.String())
+	lb, err := sf.route(request.RemoteAddr.String(), request.DestAddr.String(), request.AuthContext.Payload["username"])
+	if err != nil {
+		if err := sockv5.SendReply(writer, statute.RepRuleFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return fmt.Errorf("route to %v failed, %v", request.RawDestAddr, err)
+	}
+	useProxy := lb != nil
 
-	outConn, targetUDP, err := sf.dialForUdp(ctx, useProxy, request)
+	outConn, targetUDP, err := sf.dialForUdp(ctx, lb, request)
 	if err != nil {
 		msg := err.Error()
 		resp := statute.RepHostUnreachable
@@ -41,16 +50,12 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 		}
 		return fmt.Errorf("connect to %v failed, %v", request.RawDestAddr, err)
 	}
-	sf.userConns.Set(outConn.LocalAddr().String(), outConn)
-	defer func() {
-		if outConn != nil {
-			sf.userConns.Remove(outConn.LocalAddr().String())
-			outConn.Close()
-		}
-		targetUDP.Close()
-	}()
 	if outConn != nil {
 		sf.userConns.Set(outConn.LocalAddr().String(), outConn)
+		defer func() {
+			sf.userConns.Remove(outConn.LocalAddr().String())
+			outConn.Close()
+		}()
 		go func() {
 			buf := make([]byte, 1)
 			if _, err := outConn.Read(buf); err != nil {
@@ -68,16 +73,60 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 
 	// send BND.ADDR and BND.PORT, client must
 	if err = sockv5.SendReply(writer, statute.RepSuccess, bindLn.LocalAddr()); err != nil {
+		bindLn.Close()
 		return fmt.Errorf("failed to send reply, %v", err)
 	}
 
 	srcAddr := request.RemoteAddr.String()
-	targetAddr := targetUDP.RemoteAddr().String()
+	targetAddr := targetUDP.String()
+
+	// 客户端udp地址 -> 目标udp连接 关联
+	relay := sword.Binding.NewUDPRelay(
+		func(src, _ net.Addr) (net.PacketConn, error) {
+			conn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetUDP)
+			if err != nil {
+				return nil, err
+			}
+			return binding.NewConnectedPacketConn(conn), nil
+		},
+		func(src, _ net.Addr, data []byte) error {
+			// out->local
+			var rawData []byte
+			var err error
+			if useProxy {
+				// forward to local, convert parent data to raw
+				if rawData, err = sf.parentData2Raw(data); err != nil {
+					return nil
+				}
+			} else {
+				rp, err := statute.NewDatagram(targetAddr, data)
+				if err != nil {
+					return nil
+				}
+				rawData = append(rp.Header(), rp.Data...)
+			}
+			lData, err := sf.raw2LocalData(rawData)
+			if err != nil {
+				return nil
+			}
+			if _, err = bindLn.WriteTo(lData, src); err != nil {
+				sf.log.Errorf("write out data to local fail , %s , from : %s", err, src)
+				return err
+			}
+			return nil
+		},
+	)
+	relayCtx, cancel := context.WithCancel(sf.ctx)
+	go relay.Watch(relayCtx)
 
 	sf.log.Infof("proxy udp on %s , for src %s", bindLn.LocalAddr(), srcAddr)
 	sf.userConns.Set(srcAddr, writer)
+	sf.udpRelays.Set(bindLn.LocalAddr().String(), relay)
 	defer func() {
 		sf.userConns.Remove(srcAddr)
+		sf.udpRelays.Remove(bindLn.LocalAddr().String())
+		cancel()
+		relay.Close()
 		bindLn.Close()
 	}()
 
@@ -86,7 +135,7 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 		// read from client and write to remote server
 		buf := sword.Binding.Get()
 		defer func() {
-			targetUDP.Close()
+			relay.Close()
 			bindLn.Close()
 			sword.Binding.Put(buf)
 		}()
@@ -116,65 +165,14 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 				continue
 			}
 
-			if ok := sf.udpRelatedPacketConns.SetIfAbsent(srcAddr.String(), targetUDP); !ok {
-				go func() {
-					// out->local io copy
-					buf := sword.Binding.Get()
-					defer func() {
-						targetUDP.Close()
-						bindLn.Close()
-						sf.udpRelatedPacketConns.Remove(srcAddr.String())
-						sword.Binding.Put(buf)
-					}()
-					for {
-						n, err := targetUDP.Read(buf[:cap(buf)])
-						if err != nil {
-							sf.log.Warnf("read out udp data fail , %s , from : %s", err, srcAddr)
-							if extnet.IsErrClosed(err) {
-								return
-							}
-							continue
-						}
-
-						var rawData []byte
-						if useProxy {
-							// forward to local, convert parent data to raw
-							rawData, err = sf.parentData2Raw(buf[:n])
-							if err != nil {
-								continue
-							}
-						} else {
-							rp, err := statute.NewDatagram(targetAddr, buf[:n])
-							if err != nil {
-								continue
-							}
-							rawData = append(rp.Header(), rp.Data...)
-						}
-						lData, err := sf.raw2LocalData(rawData)
-						if err != nil {
-							continue
-						}
-						_, err = bindLn.WriteTo(lData, srcAddr)
-						if err != nil {
-							sf.log.Errorf("write out data to local fail , %s , from : %s", err, srcAddr)
-							if extnet.IsErrClosed(err) {
-								return
-							}
-							continue
-						}
-					}
-				}()
-			}
-
-			// local -> out io copy
+			// local -> out
 			outData := pk.Data // user data
 			if useProxy {      // forward to parent, convert raw to parent data
 				if outData, err = sf.raw2ParentData(rawData); err != nil {
 					continue
 				}
 			}
-			_, err = targetUDP.Write(outData)
-			if err != nil {
+			if err = relay.Send(srcAddr, targetUDP, outData); err != nil {
 				sf.log.Errorf("send out udp data fail , %s , from : %s", err, srcAddr)
 				if extnet.IsErrClosed(err) {
 					return
@@ -205,13 +203,14 @@ func (sf *Socks) proxyUDP(ctx context.Context, writer io.Writer, request *sockv5
 	}
 }
 
-func (sf *Socks) dialForUdp(ctx context.Context, useProxy bool, request *sockv5.Request) (conn net.Conn, target *net.UDPConn, err error) {
+func (sf *Socks) dialForUdp(ctx context.Context, lb *loadbalance.Balanced, request *sockv5.Request) (conn net.Conn, target *net.UDPAddr, err error) {
 	srcAddr := request.RemoteAddr.String()
 	targetAddr := request.DestAddr.String()
 
+	useProxy := lb != nil
 	if useProxy {
 		//parent proxy
-		lbAddr := sf.lb.Select(srcAddr)
+		lbAddr := lb.Select(srcAddr)
 		conn, err = sf.dialParent(lbAddr)
 		if err != nil {
 			return nil, nil, err
@@ -231,12 +230,11 @@ func (sf *Socks) dialForUdp(ctx context.Context, useProxy bool, request *sockv5.
 	}
 	sf.log.Infof("use proxy %v : udp %s", useProxy, targetAddr)
 
-	destAddr, _ := net.ResolveUDPAddr("udp", targetAddr)
-	target, err = net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, destAddr)
+	target, err = net.ResolveUDPAddr("udp", targetAddr)
 	return
 }
 
-//convert data to raw
+// convert data to raw
 func (sf *Socks) localData2Raw(b []byte) ([]byte, error) {
 	if len(sf.udpLocalKey) > 0 {
 		return outil.DecryptCFB(sf.udpLocalKey, b)
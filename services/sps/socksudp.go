@@ -1,6 +1,7 @@
 package sps
 
 import (
+	"context"
 	"crypto/md5"
 	"fmt"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/socks5"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
@@ -30,7 +32,6 @@ func (sf *SPS) proxyUDP(inConn net.Conn, serverConn *socks5.Server) {
 	sf.log.Infof("proxy udp on %s , for %s", udpListener.LocalAddr(), inconnRemoteAddr)
 	sf.userConns.Set(inconnRemoteAddr, inConn)
 	var (
-		outUDPConn       *net.UDPConn
 		outconn          net.Conn
 		outconnLocalAddr string
 		isClosedErr      = func(err error) bool {
@@ -40,9 +41,8 @@ func (sf *SPS) proxyUDP(inConn net.Conn, serverConn *socks5.Server) {
 	)
 	var clean = func(msg, err string) {
 		raddr := ""
-		if outUDPConn != nil {
-			raddr = outUDPConn.RemoteAddr().String()
-			outUDPConn.Close()
+		if destAddr != nil {
+			raddr = destAddr.String()
 		}
 		if msg != "" {
 			if raddr != "" {
@@ -126,6 +126,46 @@ func (sf *SPS) proxyUDP(inConn net.Conn, serverConn *socks5.Server) {
 	//forward to parent udp
 	//s.log.Printf("parent udp address %s", client.UDPAddr)
 	destAddr, _ = net.ResolveUDPAddr("udp", client.UDPAddr)
+	// 客户端udp地址 -> 父级udp连接 关联
+	relay := sword.Binding.NewUDPRelay(
+		func(src, _ net.Addr) (net.PacketConn, error) {
+			outUDPConn, err := net.DialUDP("udp", localAddr, destAddr)
+			if err != nil {
+				sf.log.Errorf("create out udp conn fail , %s , from : %s", err, src)
+				return nil, err
+			}
+			return binding.NewConnectedPacketConn(outUDPConn), nil
+		},
+		func(src, _ net.Addr, data []byte) error {
+			//forward to local
+			var v []byte
+			var err error
+			//convert parent data to raw
+			if len(sf.udpParentKey) > 0 {
+				v, err = outil.DecryptCFB(sf.udpParentKey, data)
+				if err != nil {
+					sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
+					return nil
+				}
+			} else {
+				v = data
+			}
+			//now v is raw, try convert v to local
+			if len(sf.udpLocalKey) > 0 {
+				v, _ = outil.EncryptCFB(sf.udpLocalKey, v)
+			}
+			_, err = udpListener.WriteTo(v, src)
+			return err
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	sword.Go(func() { relay.Watch(ctx) })
+	sf.udpRelays.Set(udpListener.LocalAddr().String(), relay)
+	defer func() {
+		sf.udpRelays.Remove(udpListener.LocalAddr().String())
+		cancel()
+		relay.Close()
+	}()
 	//relay
 	buf := sword.Binding.Get()
 	defer sword.Binding.Put(buf)
@@ -154,46 +194,10 @@ func (sf *SPS) proxyUDP(inConn net.Conn, serverConn *socks5.Server) {
 		} else {
 			err = p.Parse(buf[:n])
 		}
-		//err = p.Parse(buf[:n])
 		if err != nil {
 			sf.log.Errorf("udp listener parse packet fail, %s", err.Error())
 			continue
 		}
-		if v, ok := sf.udpRelatedPacketConns.Get(srcAddr.String()); !ok {
-			outUDPConn, err = net.DialUDP("udp", localAddr, destAddr)
-			if err != nil {
-				sf.log.Errorf("create out udp conn fail , %s , from : %s", err, srcAddr)
-				continue
-			}
-			sf.udpRelatedPacketConns.Set(srcAddr.String(), outUDPConn)
-			sword.Go(func() {
-				defer func() {
-					sf.udpRelatedPacketConns.Remove(srcAddr.String())
-				}()
-				sword.Binding.RunUDPCopy(udpListener, outUDPConn, srcAddr, 0, func(data []byte) []byte {
-					//forward to local
-					var v []byte
-					//convert parent data to raw
-					if len(sf.udpParentKey) > 0 {
-						v, err = outil.DecryptCFB(sf.udpParentKey, data)
-						if err != nil {
-							sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
-							return []byte{}
-						}
-					} else {
-						v = data
-					}
-					//now v is raw, try convert v to local
-					if len(sf.udpLocalKey) > 0 {
-						v, _ = outil.EncryptCFB(sf.udpLocalKey, v)
-					}
-					return v
-				})
-			})
-
-		} else {
-			outUDPConn = v.(*net.UDPConn)
-		}
 		//local->out io copy
 		//forward to parent
 		//p is raw, now convert it to parent
@@ -203,16 +207,12 @@ func (sf *SPS) proxyUDP(inConn net.Conn, serverConn *socks5.Server) {
 		} else {
 			v = p.Bytes()
 		}
-		_, err = outUDPConn.Write(v)
-		// _, err = outUDPConn.Write(p.Bytes())
-		if err != nil {
+		if err = relay.Send(srcAddr, destAddr, v); err != nil {
 			if isClosedErr(err) {
 				return
 			}
 			sf.log.Errorf("send out udp data fail , %s , from : %s", err, srcAddr)
 			continue
-		} else {
-			//s.log.Printf("send udp data to remote success , len %d, for : %s", len(p.Data()), srcAddr)
 		}
 	}
 
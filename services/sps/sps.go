@@ -2,6 +2,7 @@ package sps
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -22,9 +23,12 @@ import (
 	"github.com/thinkgos/jocasta/connection/cbuffered"
 	"github.com/thinkgos/jocasta/connection/ccrypt"
 	"github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/cpcap"
 	"github.com/thinkgos/jocasta/connection/shadowsocks"
 	"github.com/thinkgos/jocasta/connection/sni"
 	"github.com/thinkgos/jocasta/core/basicAuth"
+	"github.com/thinkgos/jocasta/core/binding"
+	"github.com/thinkgos/jocasta/core/filter"
 	"github.com/thinkgos/jocasta/core/idns"
 	"github.com/thinkgos/jocasta/core/loadbalance"
 	"github.com/thinkgos/jocasta/core/socks5"
@@ -32,10 +36,13 @@ import (
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/enet"
 	"github.com/thinkgos/jocasta/pkg/extcert"
+	"github.com/thinkgos/jocasta/pkg/fwatch"
 	"github.com/thinkgos/jocasta/pkg/httpc"
 	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/services"
+	"github.com/thinkgos/jocasta/services/parent"
 )
 
 type Config struct {
@@ -63,12 +70,16 @@ type Config struct {
 	STCPConfig cs.StcpConfig
 	// 其它
 	Timeout time.Duration // tcp连接到父级或真实服务器超时时间,default 2s
+	// 过滤, 规则和认证文件的变化检查间隔, 文件变化时重新加载, 0 表示不检查 default: 0
+	ReloadInterval time.Duration
 	// basic auth配置
 	AuthConfig ccs.AuthConfig
 	// dns域名解析
 	DNSConfig ccs.DNSConfig
 	// 负载均衡
 	LbConfig ccs.LbConfig
+	// 路由规则, 仅tcp有效, 无规则匹配时使用默认父级
+	RuleConfig ccs.RuleConfig
 
 	ParentServiceType string
 	ParentSSMethod    string
@@ -83,10 +94,13 @@ type Config struct {
 	LocalIPS    []string
 	RawProxyURL string
 	Debug       bool
+	// 抓包, 记录本地及父级连接的明文数据
+	CaptureConfig ccs.CaptureConfig
 
 	// private
 	tcpTlsConfig cs.TLSConfig
 	rateLimit    rate.Limit
+	groups       map[string][]string
 }
 type SPS struct {
 	cfg                   Config
@@ -97,12 +111,17 @@ type SPS struct {
 	localCipher           *shadowsocks.Cipher
 	parentCipher          *shadowsocks.Cipher
 	udpRelatedPacketConns cmap.ConcurrentMap
+	udpRelays             cmap.ConcurrentMap // udp监听地址 -> *binding.UDPRelay
 	lb                    *loadbalance.Balanced
+	watcher               *fwatch.Watcher
+	groups                map[string]*loadbalance.Balanced
+	filters               *filter.Filter
 	udpLocalKey           []byte
 	udpParentKey          []byte
 	proxyURL              *url.URL
 	parentAuthData        *sync.Map
 	parentCipherData      *sync.Map
+	capture               *cpcap.Writer
 	log                   logger.Logger
 }
 
@@ -114,6 +133,7 @@ func New(log logger.Logger, cfg Config) *SPS {
 		serverChannels:        make([]net.Listener, 0),
 		userConns:             cmap.New(),
 		udpRelatedPacketConns: cmap.New(),
+		udpRelays:             cmap.New(),
 		parentAuthData:        &sync.Map{},
 		parentCipherData:      &sync.Map{},
 		log:                   log,
@@ -127,9 +147,15 @@ func (sf *SPS) InspectConfig() (err error) {
 	if len(sf.cfg.Parent) == 0 {
 		return fmt.Errorf("parent required for %s %s", sf.cfg.LocalType, sf.cfg.Local)
 	}
+	if sf.cfg.groups, err = sf.cfg.RuleConfig.ParseGroups(); err != nil {
+		return err
+	}
 	if sf.cfg.ParentType == "" {
 		return fmt.Errorf("parent type unkown,use -T <tls|tcp|kcp>")
 	}
+	if err = sf.cfg.LbConfig.CheckProbes(sf.probeProtocol()); err != nil {
+		return err
+	}
 	if sf.cfg.ParentType == "ss" && (sf.cfg.ParentSSKey == "" || sf.cfg.ParentSSMethod == "") {
 		return fmt.Errorf("ss parent need a ss key, set it by : -J <sskey>")
 	}
@@ -198,64 +224,33 @@ func (sf *SPS) InitService() (err error) {
 	}
 	// init lb
 	if len(sf.cfg.Parent) > 0 {
-		configs := []loadbalance.Config{}
-
-		for _, addr := range sf.cfg.Parent {
-			var _addrInfo []string
-			if strings.Contains(addr, "#") {
-				_s := addr[:strings.Index(addr, "#")]
-				b, err := base64.StdEncoding.DecodeString(_s)
-				if err != nil {
-					sf.log.Errorf("decoding parent auth data [ %s ] fail , error : %s", _s, err)
-					return err
-				}
-				_auth := string(b)
-				_addrInfo = strings.Split(addr[strings.Index(addr, "#")+1:], "@")
-				if sf.cfg.ParentServiceType == "ss" {
-					_s := strings.Split(_auth, ":")
-					m := _s[0]
-					k := _s[1]
-					if m == "" {
-						m = sf.cfg.ParentSSMethod
-					}
-					if k == "" {
-						k = sf.cfg.ParentSSKey
-					}
-					cipher, err := shadowsocks.NewCipher(m, k)
-					if err != nil {
-						sf.log.Errorf("error generating cipher, ssMethod: %s, ssKey: %s, error : %s", m, k, err)
-						return err
-					}
-					sf.parentCipherData.Store(_addrInfo[0], cipher)
-				} else {
-					sf.parentAuthData.Store(_addrInfo[0], _auth)
-				}
-
-			} else {
-				_addrInfo = strings.Split(addr, "@")
-			}
-			_addr, weight := _addrInfo[0], 1
-			if len(_addrInfo) == 2 {
-				weight, _ = strconv.Atoi(_addrInfo[1])
-				if weight == 0 {
-					weight = 1
-				}
-			}
-			configs = append(configs, loadbalance.Config{
-				Addr:             _addr,
-				Weight:           weight,
-				SuccessThreshold: 1,
-				FailureThreshold: 2,
-				Period:           sf.cfg.LbConfig.RetryTime,
-				Timeout:          sf.cfg.LbConfig.Timeout,
-			})
+		if sf.lb, err = sf.newBalanced(sf.cfg.Parent); err != nil {
+			return err
+		}
+	}
+	sf.groups = make(map[string]*loadbalance.Balanced, len(sf.cfg.groups))
+	for name, parents := range sf.cfg.groups {
+		if sf.groups[name], err = sf.newBalanced(parents); err != nil {
+			return err
+		}
+	}
+	// init rules
+	if sf.cfg.RuleConfig.File != "" {
+		// 仅使用规则匹配, 不需要智能探测
+		filterOpts := []filter.Option{filter.WithLivenessPeriod(0), filter.WithRuleGroups(sf.cfg.groups)}
+		if sf.domainResolver != nil {
+			filterOpts = append(filterOpts, filter.WithResolver(sf.domainResolver))
+		}
+		sf.filters = filter.New("intelligent", filterOpts...)
+		count, err := sf.filters.LoadRuleFile(sf.cfg.RuleConfig.File)
+		if err != nil {
+			return fmt.Errorf("load rule file(%s), %+v", sf.cfg.RuleConfig.File, err)
 		}
-		sf.lb = loadbalance.New(sf.cfg.LbConfig.Method, configs,
-			loadbalance.WithDNSServer(sf.domainResolver),
-			loadbalance.WithLogger(sf.log),
-			loadbalance.WithEnableDebug(sf.cfg.Debug),
-			loadbalance.WithGPool(sword.GoPool),
-		)
+		sf.log.Debugf("load rule file, rules count: %d", count)
+	}
+	// watch files
+	if sf.cfg.ReloadInterval > 0 {
+		sf.watchFiles()
 	}
 
 	if sf.cfg.SSMethod != "" && sf.cfg.SSKey != "" {
@@ -282,8 +277,14 @@ func (sf *SPS) Start() (err error) {
 	if err = sf.InitService(); err != nil {
 		return
 	}
+	if sf.capture, err = sf.cfg.CaptureConfig.New(cpcap.WithLogger(sf.log)); err != nil {
+		return fmt.Errorf("new capture, %+v", err)
+	}
 
 	sf.log.Infof("use %s %s parent %v [ %s ]", sf.cfg.ParentType, sf.cfg.ParentServiceType, sf.cfg.Parent, strings.ToUpper(sf.cfg.LbConfig.Method))
+	for name, parents := range sf.cfg.groups {
+		sf.log.Infof("use %s %s parent group %s %v [ %s ]", sf.cfg.ParentType, sf.cfg.ParentServiceType, name, parents, strings.ToUpper(sf.cfg.LbConfig.Method))
+	}
 	for _, addr := range strings.Split(sf.cfg.Local, ",") {
 		if addr != "" {
 			srv := ccs.Server{
@@ -294,9 +295,12 @@ func (sf *SPS) Start() (err error) {
 					StcpConfig: sf.cfg.STCPConfig,
 					KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
 				},
-				GoPool:      sword.GoPool,
-				AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.LocalCompress)},
-				Handler:     cs.HandlerFunc(sf.handle),
+				GoPool: sword.GoPool,
+				AdornChains: connection.AdornConnsChain{
+					connection.AdornSnappy(sf.cfg.LocalCompress),
+					connection.AdornPcap(sf.capture),
+				},
+				Handler: cs.HandlerFunc(sf.handle),
 			}
 
 			sc, err := srv.Listen()
@@ -332,12 +336,27 @@ func (sf *SPS) Stop() {
 			c.(net.Conn).Close()
 		}
 	}
+	if sf.watcher != nil {
+		sf.watcher.Close()
+	}
 	if sf.lb != nil {
 		sf.lb.Close()
 	}
+	for _, lb := range sf.groups {
+		lb.Close()
+	}
+	if sf.filters != nil {
+		sf.filters.Close()
+	}
 	for _, c := range sf.udpRelatedPacketConns.Items() {
 		c.(*net.UDPConn).Close()
 	}
+	for _, r := range sf.udpRelays.Items() {
+		r.(*binding.UDPRelay).Close()
+	}
+	if sf.capture != nil {
+		sf.capture.Close()
+	}
 	sf.log.Infof("service sps stopped")
 }
 func (sf *SPS) handle(inConn net.Conn) {
@@ -388,12 +407,28 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 	var auth = proxy.Auth{}
 	var forwardBytes []byte
 
+	// 回复客户端前匹配路由规则, 拒绝或规则错误时客户端能收到失败回复
+	srcAddr := inConn.RemoteAddr().String()
+	var lb *loadbalance.Balanced
+	routed := false
+	route := func(target, user string) (err error) {
+		if lb, err = sf.route(srcAddr, target, user); err != nil {
+			sf.log.Warnf("%s --> %s %v", srcAddr, target, err)
+			return err
+		}
+		routed = true
+		return nil
+	}
+
 	if enet.IsSocks5(h) {
 		if sf.cfg.DisableSocks5 {
 			return
 		}
 		//socks5 server
 		serverConn := socks5.NewServer(inConn, sf.cfg.Timeout, sf.basicAuthCenter, enableUDP, udpIP, nil)
+		serverConn.SetConnectCheck(func(target string, auth proxy.Auth) error {
+			return route(target, auth.User)
+		})
 
 		if err = serverConn.Handshake(); err != nil {
 			return
@@ -421,13 +456,6 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 			sf.log.Errorf("new http request fail,ERR: %s", err)
 			return
 		}
-		if len(h) >= 7 && strings.ToLower(string(h[:7])) == "connect" {
-			//https
-			request.HTTPSReply()
-			//s.log.Printf("https reply: %s", request.Host)
-		} else {
-			forwardBytes = request.RawHeader
-		}
 		address = request.Host
 		var userpass string
 		if sf.basicAuthCenter != nil {
@@ -440,6 +468,17 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 				auth = proxy.Auth{User: userpassA[0], Password: userpassA[1]}
 			}
 		}
+		if err = route(address, auth.User); err != nil {
+			fmt.Fprint(inConn, "HTTP/1.1 403 Forbidden\r\n\r\n")
+			return
+		}
+		if len(h) >= 7 && strings.ToLower(string(h[:7])) == "connect" {
+			//https
+			request.HTTPSReply()
+			//s.log.Printf("https reply: %s", request.Host)
+		} else {
+			forwardBytes = request.RawHeader
+		}
 	} else {
 		//ss
 		if sf.cfg.DisableSS {
@@ -467,13 +506,70 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 		err = errors.New("unknown request")
 		return
 	}
-	//connect to parent
+	if !routed {
+		if err = route(address, auth.User); err != nil {
+			return
+		}
+	}
 	var outConn net.Conn
-	selectAddr := inConn.RemoteAddr().String()
-	if sf.cfg.LbConfig.Method == "hash" && sf.cfg.LbConfig.HashTarget {
+	var lbAddr string
+
+	if lb == nil {
+		outConn, err = sf.dialDirect(address)
+		if err != nil {
+			sf.log.Errorf("connect to %s , err:%s", address, err)
+			return
+		}
+		forwardBytes = enet.RemoveProxyHeaders(forwardBytes)
+	} else {
+		outConn, lbAddr, forwardBytes, err = sf.dialForParent(lb, inConn.RemoteAddr().String(), address, auth, forwardBytes)
+		if err != nil {
+			return
+		}
+	}
+	//forward client data to target,if necessary.
+	if len(forwardBytes) > 0 {
+		outConn.Write(forwardBytes)
+	}
+
+	if sf.cfg.rateLimit > 0 {
+		outConn = ciol.New(outConn, ciol.WithReadLimiter(sf.cfg.rateLimit))
+	}
+
+	//bind
+	inAddr := inConn.RemoteAddr().String()
+	outAddr := outConn.RemoteAddr().String()
+
+	sf.userConns.Upsert(inAddr, inConn, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		if exist {
+			valueInMap.(net.Conn).Close()
+		}
+		return newValue
+	})
+	if lb != nil {
+		lb.ConnsIncrease(lbAddr)
+	}
+	sf.log.Infof("conn %s - %s connected [%s]", inAddr, outAddr, address)
+
+	defer func() {
+		sf.userConns.Remove(inAddr)
+		if lb != nil {
+			lb.ConnsDecrease(lbAddr)
+		}
+	}()
+	res := sword.Binding.Proxy(inConn, outConn)
+	sf.log.Infof("conn %s - %s released [%s], up %d bytes, down %d bytes",
+		inAddr, outAddr, address, res.Upstream.Written, res.Downstream.Written)
+	return res.Err()
+}
+
+// dialForParent 通过父级连接目标地址, 返回父级连接, 父级地址和需要继续转发的数据
+func (sf *SPS) dialForParent(lb *loadbalance.Balanced, srcAddr, address string, auth proxy.Auth, forwardBytes []byte) (outConn net.Conn, lbAddr string, _ []byte, err error) {
+	selectAddr := srcAddr
+	if sf.cfg.LbConfig.IsHashTarget() {
 		selectAddr = address
 	}
-	lbAddr := sf.lb.Select(selectAddr)
+	lbAddr = lb.Select(selectAddr)
 	outConn, err = sf.dialParent(lbAddr)
 	if err != nil {
 		sf.log.Errorf("connect to %s , err:%s", lbAddr, err)
@@ -571,36 +667,110 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 			return
 		}
 	default:
-		return errors.New("not support")
-	}
-	//forward client data to target,if necessary.
-	if len(forwardBytes) > 0 {
-		outConn.Write(forwardBytes)
+		err = errors.New("not support")
+		return
 	}
+	return outConn, lbAddr, forwardBytes, nil
+}
 
-	if sf.cfg.rateLimit > 0 {
-		outConn = ciol.New(outConn, ciol.WithReadLimiter(sf.cfg.rateLimit))
+// watchFiles 监视过滤, 规则和认证文件, 文件变化时重新加载, 加载失败时保留原数据
+func (sf *SPS) watchFiles() {
+	sf.watcher = fwatch.New(sf.cfg.ReloadInterval)
+	if sf.filters != nil {
+		sf.watcher.Add(func() {
+			count, err := sf.filters.LoadRuleFile(sf.cfg.RuleConfig.File)
+			if err != nil {
+				sf.log.Warnf("reload rule file(%s), %v", sf.cfg.RuleConfig.File, err)
+				return
+			}
+			sf.log.Infof("rule file reloaded, rules count: %d", count)
+		}, sf.cfg.RuleConfig.File)
 	}
+	if sf.basicAuthCenter != nil {
+		sf.watcher.Add(func() {
+			stat, err := sf.basicAuthCenter.ReloadFromFile(sf.cfg.AuthConfig.File)
+			if err != nil {
+				sf.log.Warnf("reload auth file(%s), %v", sf.cfg.AuthConfig.File, err)
+				return
+			}
+			sf.log.Infof("auth file reloaded, users %s", stat)
+		}, sf.cfg.AuthConfig.File)
+	}
+}
 
-	//bind
-	inAddr := inConn.RemoteAddr().String()
-	outAddr := outConn.RemoteAddr().String()
+// route 匹配路由规则选择父级, 无规则匹配时使用默认父级, 返回nil表示直连
+func (sf *SPS) route(srcAddr, targetAddr, user string) (*loadbalance.Balanced, error) {
+	m := &filter.Metadata{Src: srcAddr, Dst: targetAddr, User: user}
+	if lb, matched, err := parent.Match(sf.filters, m, sf.lb, sf.groups); matched {
+		return lb, err
+	}
+	return sf.lb, nil
+}
 
-	sf.userConns.Upsert(inAddr, inConn, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
-		if exist {
-			valueInMap.(net.Conn).Close()
+// newBalanced 创建父级负载均衡, 父级格式 [base64(auth)#]addr:port[@weight]
+func (sf *SPS) newBalanced(parents []string) (*loadbalance.Balanced, error) {
+	addrs := make([]string, 0, len(parents))
+	for _, addr := range parents {
+		if strings.Contains(addr, "#") {
+			_s := addr[:strings.Index(addr, "#")]
+			b, err := base64.StdEncoding.DecodeString(_s)
+			if err != nil {
+				sf.log.Errorf("decoding parent auth data [ %s ] fail , error : %s", _s, err)
+				return nil, err
+			}
+			_auth := string(b)
+			addr = addr[strings.Index(addr, "#")+1:]
+			_addr, _ := parent.ParseAddr(addr)
+			if sf.cfg.ParentServiceType == "ss" {
+				_s := strings.Split(_auth, ":")
+				m := _s[0]
+				k := _s[1]
+				if m == "" {
+					m = sf.cfg.ParentSSMethod
+				}
+				if k == "" {
+					k = sf.cfg.ParentSSKey
+				}
+				cipher, err := shadowsocks.NewCipher(m, k)
+				if err != nil {
+					sf.log.Errorf("error generating cipher, ssMethod: %s, ssKey: %s, error : %s", m, k, err)
+					return nil, err
+				}
+				sf.parentCipherData.Store(_addr, cipher)
+			} else {
+				sf.parentAuthData.Store(_addr, _auth)
+			}
 		}
-		return newValue
-	})
-	sf.lb.ConnsIncrease(lbAddr)
-	sf.log.Infof("conn %s - %s connected [%s]", inAddr, outAddr, address)
+		addrs = append(addrs, addr)
+	}
+	return parent.NewBalanced(sf.cfg.LbConfig, addrs, sf.probeConfig,
+		loadbalance.WithDNSServer(sf.domainResolver),
+		loadbalance.WithLogger(sf.log),
+		loadbalance.WithEnableDebug(sf.cfg.Debug),
+	), nil
+}
 
-	defer func() {
-		sf.log.Infof("conn %s - %s released [%s]", inAddr, outAddr, address)
-		sf.userConns.Remove(inAddr)
-		sf.lb.ConnsDecrease(lbAddr)
-	}()
-	return sword.Binding.Proxy(inConn, outConn)
+// probeConfig 父级健康检查探针配置
+func (sf *SPS) probeConfig(addr string) loadbalance.ProbeConfig {
+	c := parent.ProbeConfig(sf.cfg.LbConfig, addr, sf.probeProtocol())
+	c.Dial = func(_ context.Context, addr string, _ time.Duration) (net.Conn, error) {
+		return sf.dialParent(addr)
+	}
+	if sf.cfg.ParentType == "tls" {
+		c.TLSConfig, _ = sf.cfg.tcpTlsConfig.ClientConfig() // nolint: errcheck
+	}
+	return c
+}
+
+// probeProtocol jocasta探针的父级代理协议
+func (sf *SPS) probeProtocol() string {
+	switch sf.cfg.ParentServiceType {
+	case "socks":
+		return loadbalance.ProbeProtocolSocks5
+	case "http":
+		return loadbalance.ProbeProtocolHTTP
+	}
+	return sf.cfg.ParentServiceType
 }
 
 func (sf *SPS) getParentAuth(lbAddr string) string {
@@ -656,6 +826,10 @@ func (sf *SPS) buildRequest(address string) (buf []byte, err error) {
 	return
 }
 
+func (sf *SPS) dialDirect(address string) (net.Conn, error) {
+	return net.DialTimeout("tcp", outil.Resolve(sf.domainResolver, address), sf.cfg.Timeout)
+}
+
 func (sf *SPS) dialParent(address string) (net.Conn, error) {
 	d := ccs.Dialer{
 		Protocol: sf.cfg.ParentType,
@@ -666,7 +840,10 @@ func (sf *SPS) dialParent(address string) (net.Conn, error) {
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
 			ProxyURL:   sf.proxyURL,
 		},
-		AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.ParentCompress)},
+		AdornChains: connection.AdornConnsChain{
+			connection.AdornSnappy(sf.cfg.ParentCompress),
+			connection.AdornPcap(sf.capture),
+		},
 	}
 	conn, err := d.Dial("tcp", address)
 	if err != nil {
@@ -2,7 +2,7 @@ package sps
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"net"
 	"runtime/debug"
 	"time"
@@ -11,6 +11,8 @@ import (
 
 	"github.com/things-go/x/extnet"
 
+	"github.com/thinkgos/jocasta/core/binding"
+	"github.com/thinkgos/jocasta/core/captain"
 	"github.com/thinkgos/jocasta/core/socks5"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
@@ -25,6 +27,37 @@ func (sf *SPS) RunSSUDP(addr string) (err error) {
 	}
 	sf.log.Infof("ss udp on %s", listener.LocalAddr())
 	sf.udpRelatedPacketConns.Set(addr, listener)
+	// 客户端udp地址 -> 父级udp连接 关联, 首个包的目标地址用于与父级握手
+	relay := sword.Binding.NewUDPRelay(
+		func(src, dst net.Addr) (net.PacketConn, error) {
+			return sf.dialSSUDPParent(src, dst)
+		},
+		func(src, _ net.Addr, data []byte) error {
+			//forward to local
+			var v []byte
+			var err error
+			//convert parent data to raw
+			if len(sf.udpParentKey) > 0 {
+				v, err = outil.DecryptCFB(sf.udpParentKey, data)
+				if err != nil {
+					sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
+					return nil
+				}
+			} else {
+				v = data
+			}
+			if len(v) < 3 {
+				return nil
+			}
+			out, _ := sf.localCipher.Encrypt(v[3:])
+			_, err = listener.WriteTo(out, src)
+			return err
+		},
+		binding.WithIdleTimeout(time.Second*5),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	sword.Go(func() { relay.Watch(ctx) })
+	sf.udpRelays.Set(addr, relay)
 	go func() {
 		defer func() {
 			if e := recover(); e != nil {
@@ -32,7 +65,12 @@ func (sf *SPS) RunSSUDP(addr string) (err error) {
 			}
 		}()
 		buf := sword.Binding.Get()
-		defer sword.Binding.Put(buf)
+		defer func() {
+			sword.Binding.Put(buf)
+			sf.udpRelays.Remove(addr)
+			cancel()
+			relay.Close()
+		}()
 		for {
 			n, srcAddr, err := listener.ReadFrom(buf[:cap(buf)])
 			if err != nil {
@@ -42,115 +80,24 @@ func (sf *SPS) RunSSUDP(addr string) (err error) {
 				}
 				continue
 			}
-			var (
-				inconnRemoteAddr = srcAddr.String()
-				outUDPConn       *net.UDPConn
-				outconn          net.Conn
-				outconnLocalAddr string
-				destAddr         *net.UDPAddr
-				clean            = func(msg, err string) {
-					raddr := ""
-					if outUDPConn != nil {
-						raddr = outUDPConn.RemoteAddr().String()
-						outUDPConn.Close()
-					}
-					if msg != "" {
-						if raddr != "" {
-							sf.log.Errorf("%s , %s , %s -> %s", msg, err, inconnRemoteAddr, raddr)
-						} else {
-							sf.log.Infof("%s , %s , from : %s", msg, err, inconnRemoteAddr)
-						}
-					}
-					sf.userConns.Remove(inconnRemoteAddr)
-					if outconn != nil {
-						outconn.Close()
-					}
-					if outconnLocalAddr != "" {
-						sf.userConns.Remove(outconnLocalAddr)
-					}
-				}
-			)
-			defer clean("", "")
-
-			var data []byte
 
-			data, err = sf.localCipher.Decrypt(buf[:n])
+			data, err := sf.localCipher.Decrypt(buf[:n])
 			if err != nil {
-				return
+				continue
 			}
 			raw := bytes.NewBuffer([]byte{0x00, 0x00, 0x00})
 			raw.Write(data)
 			socksPacket := socks5.NewPacketUDP()
-			err = socksPacket.Parse(raw.Bytes())
-			raw = nil
+			if err = socksPacket.Parse(raw.Bytes()); err != nil {
+				sf.log.Errorf("udp parse error %s", err)
+				continue
+			}
+			dstAddr, err := captain.ParseAddrSpec(socksPacket.Addr())
 			if err != nil {
 				sf.log.Errorf("udp parse error %s", err)
-				return
+				continue
 			}
 
-			if v, ok := sf.udpRelatedPacketConns.Get(inconnRemoteAddr); !ok {
-				//socks client
-				lbAddr := sf.lb.Select(inconnRemoteAddr)
-				outconn, err := sf.dialParent(lbAddr)
-				if err != nil {
-					clean("connnect fail", fmt.Sprintf("%s", err))
-					return
-				}
-
-				client, err := sf.HandshakeSocksParent(sf.getParentAuth(lbAddr), outconn, "udp", socksPacket.Addr(), proxy.Auth{}, true)
-				if err != nil {
-					clean("handshake fail", fmt.Sprintf("%s", err))
-					return
-				}
-
-				outconnLocalAddr = outconn.LocalAddr().String()
-				sf.userConns.Set(outconnLocalAddr, &outconn)
-				go func() {
-					defer func() {
-						if e := recover(); e != nil {
-							sf.log.DPanicf("udp related parent tcp conn read crashed:\n%s\n%s", e, string(debug.Stack()))
-						}
-					}()
-					buf := make([]byte, 1)
-					outconn.SetReadDeadline(time.Time{})
-					if _, err := outconn.Read(buf); err != nil {
-						clean("udp parent tcp conn disconnected", fmt.Sprintf("%s", err))
-					}
-				}()
-				destAddr, _ = net.ResolveUDPAddr("udp", client.UDPAddr)
-				localZeroAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-				outUDPConn, err = net.DialUDP("udp", localZeroAddr, destAddr)
-				if err != nil {
-					sf.log.Errorf("create out udp conn fail , %s , from : %s", err, srcAddr)
-					return
-				}
-				sf.udpRelatedPacketConns.Set(srcAddr.String(), outUDPConn)
-				sword.Go(func() {
-					defer func() {
-						sf.udpRelatedPacketConns.Remove(srcAddr.String())
-					}()
-					sword.Binding.RunUDPCopy(listener, outUDPConn, srcAddr, time.Second*5, func(data []byte) []byte {
-						//forward to local
-						var v []byte
-						//convert parent data to raw
-						if len(sf.udpParentKey) > 0 {
-							v, err = outil.DecryptCFB(sf.udpParentKey, data)
-							if err != nil {
-								sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
-								return []byte{}
-							}
-						} else {
-							v = data
-						}
-						out, _ := sf.localCipher.Encrypt(v[3:])
-						return out
-
-					})
-				})
-
-			} else {
-				outUDPConn = v.(*net.UDPConn)
-			}
 			//forward to parent
 			//p is raw, now convert it to parent
 			var v []byte
@@ -159,9 +106,7 @@ func (sf *SPS) RunSSUDP(addr string) (err error) {
 			} else {
 				v = socksPacket.Bytes()
 			}
-			_, err = outUDPConn.Write(v)
-			socksPacket = socks5.PacketUDP{}
-			if err != nil {
+			if err = relay.Send(srcAddr, &dstAddr, v); err != nil {
 				if extnet.IsErrClosed(err) {
 					return
 				}
@@ -171,3 +116,62 @@ func (sf *SPS) RunSSUDP(addr string) (err error) {
 	}()
 	return
 }
+
+// dialSSUDPParent 与父级socks握手udp关联, 返回连接到父级udp地址的连接
+func (sf *SPS) dialSSUDPParent(src, dst net.Addr) (net.PacketConn, error) {
+	inconnRemoteAddr := src.String()
+	//socks client
+	lbAddr := sf.lb.Select(inconnRemoteAddr)
+	outconn, err := sf.dialParent(lbAddr)
+	if err != nil {
+		sf.log.Errorf("connnect fail , %s , from : %s", err, inconnRemoteAddr)
+		return nil, err
+	}
+	client, err := sf.HandshakeSocksParent(sf.getParentAuth(lbAddr), outconn, "udp", dst.String(), proxy.Auth{}, true)
+	if err != nil {
+		outconn.Close()
+		sf.log.Errorf("handshake fail , %s , from : %s", err, inconnRemoteAddr)
+		return nil, err
+	}
+	destAddr, err := net.ResolveUDPAddr("udp", client.UDPAddr)
+	if err != nil {
+		outconn.Close()
+		return nil, err
+	}
+	outUDPConn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, destAddr)
+	if err != nil {
+		outconn.Close()
+		sf.log.Errorf("create out udp conn fail , %s , from : %s", err, inconnRemoteAddr)
+		return nil, err
+	}
+
+	outconnLocalAddr := outconn.LocalAddr().String()
+	sf.userConns.Set(outconnLocalAddr, &outconn)
+	pc := &ssPacketConn{binding.NewConnectedPacketConn(outUDPConn), outconn}
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				sf.log.DPanicf("udp related parent tcp conn read crashed:\n%s\n%s", e, string(debug.Stack()))
+			}
+		}()
+		buf := make([]byte, 1)
+		outconn.SetReadDeadline(time.Time{}) // nolint: errcheck
+		if _, err := outconn.Read(buf); err != nil {
+			sf.log.Infof("udp parent tcp conn disconnected , %s , from : %s", err, inconnRemoteAddr)
+		}
+		sf.userConns.Remove(outconnLocalAddr)
+		pc.Close()
+	}()
+	return pc, nil
+}
+
+// ssPacketConn 父级udp连接, 关闭时同时关闭与父级的tcp控制连接
+type ssPacketConn struct {
+	net.PacketConn
+	ctrl net.Conn
+}
+
+func (sf *ssPacketConn) Close() error {
+	sf.ctrl.Close()
+	return sf.PacketConn.Close()
+}
@@ -6,20 +6,18 @@ import (
 	"io/ioutil"
 	"net"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/things-go/encrypt"
-	"github.com/things-go/x/extnet"
 	"github.com/things-go/x/extstr"
-	"golang.org/x/sync/singleflight"
 
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
 	"github.com/thinkgos/jocasta/core/idns"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
-	"github.com/thinkgos/jocasta/pkg/enet"
 	"github.com/thinkgos/jocasta/pkg/extcert"
 	"github.com/thinkgos/jocasta/pkg/logger"
 	"github.com/thinkgos/jocasta/pkg/outil"
@@ -53,21 +51,12 @@ type Config struct {
 	tcpTlsConfig cs.TLSConfig
 }
 
-type connItem struct {
-	targetConn     net.Conn
-	srcAddr        *net.UDPAddr
-	lastActiveTime int64
-}
-
 type UDP struct {
 	cfg     Config
 	udpConn *net.UDPConn
-	// parent type = "udp", udp -> udp绑定传输
-	// src地址对udp连接映射
-	// parent type != "udp", udp -> 其它的绑定传输
-	// src地址对其它连接的绑定
-	conns       *connection.Manager
-	single      singleflight.Group
+	// parent type = "udp", src地址对udp连接的关联
+	// parent type != "udp", src地址对其它连接的关联, 以captain.PacketConn封装
+	relay       *binding.UDPRelay
 	dnsResolver *idns.Resolver
 	cancel      context.CancelFunc
 	ctx         context.Context
@@ -87,17 +76,6 @@ func New(cfg Config, opts ...Option) *UDP {
 	for _, opt := range opts {
 		opt(u)
 	}
-
-	u.conns = connection.New(time.Second,
-		func(key string, value interface{}, now time.Time) bool {
-			nowSeconds := now.Unix()
-			item := value.(*connItem)
-			if nowSeconds-atomic.LoadInt64(&item.lastActiveTime) > u.udpIdleTime {
-				item.targetConn.Close()
-				return true
-			}
-			return false
-		})
 	return u
 }
 
@@ -143,6 +121,50 @@ func (sf *UDP) Start() (err error) {
 	if err != nil {
 		return err
 	}
+	dial := func(src, _ net.Addr) (net.PacketConn, error) {
+		targetConn, err := sf.dialParent(outil.Resolve(sf.dnsResolver, sf.cfg.Parent))
+		if err != nil {
+			sf.log.Errorf("[ UDP ] connect to stream parent< %s > fail, %s", sf.cfg.Parent, err)
+			return nil, err
+		}
+		remoteAddr := targetConn.RemoteAddr()
+		sf.log.Infof("[ UDP ] udp conn %s ---> stream %s connected", src, remoteAddr)
+		return &releasedPacketConn{
+			PacketConn: captain.NewPacketConn(targetConn,
+				captain.WithBufferPool(sword.Binding),
+				captain.WithWriteTimeout(sf.cfg.Timeout),
+			),
+			release: func() { sf.log.Infof("[ UDP ] udp conn %s ---> stream %s released", src, remoteAddr) },
+		}, nil
+	}
+	if sf.cfg.ParentType == "udp" {
+		// 每个关联重新解析父级地址, 使用连接的udp conn, 只接收来自父级的包
+		dial = func(src, _ net.Addr) (net.PacketConn, error) {
+			targetAddr, err := net.ResolveUDPAddr("udp", sf.cfg.Parent)
+			if err != nil {
+				sf.log.Errorf("[ UDP ] resolve udp parent addr< %s > fail, %+v", sf.cfg.Parent, err)
+				return nil, err
+			}
+			targetConn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetAddr)
+			if err != nil {
+				sf.log.Errorf("[ UDP ] connect to udp parent addr< %s > fail, %+v", targetAddr, err)
+				return nil, err
+			}
+			sf.log.Infof("[ UDP ] udp conn %s ---> %s connected", src, targetAddr)
+			return &releasedPacketConn{
+				PacketConn: binding.NewConnectedPacketConn(targetConn),
+				release:    func() { sf.log.Infof("[ UDP ] udp conn %s ---> %s released", src, targetAddr) },
+			}, nil
+		}
+	}
+	sf.relay = sword.Binding.NewUDPRelay(dial,
+		func(src, _ net.Addr, data []byte) error {
+			_, err := sf.udpConn.WriteTo(data, src)
+			return err
+		},
+		binding.WithIdleTimeout(time.Duration(sf.udpIdleTime)*time.Second),
+	)
+	sword.Go(func() { sf.relay.Watch(sf.ctx) })
 	sword.Go(
 		func() {
 			defer sf.udpConn.Close()
@@ -164,7 +186,6 @@ func (sf *UDP) Start() (err error) {
 		},
 	)
 
-	sword.Go(func() { sf.conns.Watch(sf.ctx) })
 	sf.log.Infof("[ UDP ] use parent %s< %s >", sf.cfg.Parent, sf.cfg.ParentType)
 	sf.log.Infof("[ UDP ] use proxy udp on %s", sf.udpConn.LocalAddr())
 	return
@@ -177,8 +198,8 @@ func (sf *UDP) Stop() {
 	if sf.udpConn != nil {
 		sf.udpConn.Close()
 	}
-	for _, c := range sf.conns.Items() {
-		c.(*connItem).targetConn.Close()
+	if sf.relay != nil {
+		sf.relay.Close()
 	}
 	sf.log.Infof("[ UDP ] service stopped")
 }
@@ -195,144 +216,15 @@ func (sf *UDP) handle(ln *net.UDPConn, msg cs.Message) {
 }
 
 func (sf *UDP) proxyUdp2Stream(_ *net.UDPConn, msg cs.Message) {
-	srcAddr := msg.SrcAddr.String()
-
-	itm, err, _ := sf.single.Do(srcAddr, func() (interface{}, error) {
-		if v, ok := sf.conns.Get(srcAddr); ok {
-			return v, nil
-		}
-
-		targetConn, err := sf.dialParent(outil.Resolve(sf.dnsResolver, sf.cfg.Parent))
-		if err != nil {
-			sf.log.Errorf("[ UDP ] connect to stream parent< %s > fail, %s", sf.cfg.Parent, err)
-			return nil, err
-		}
-		item := &connItem{
-			targetConn,
-			msg.SrcAddr,
-			time.Now().Unix(),
-		}
-		sf.conns.Set(srcAddr, item)
-		// src ---> parent
-		sword.Go(func() {
-			sf.log.Infof("[ UDP ] udp conn %s ---> stream %s  connected", srcAddr, targetConn.RemoteAddr().String())
-			defer func() {
-				sf.conns.Remove(srcAddr)
-				item.targetConn.Close()
-				sf.log.Infof("[ UDP ] udp conn %s ---> stream %s released", srcAddr, targetConn.RemoteAddr().String())
-			}()
-
-			for {
-				da, err := captain.ParseStreamDatagram(item.targetConn)
-				if err != nil {
-					sf.log.Errorf("[ UDP ] udp conn read from stream parent conn fail, %s ", err)
-					if strings.Contains(err.Error(), "n != int(") {
-						continue
-					}
-					return
-				}
-				atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-				_, err = sf.udpConn.WriteToUDP(da.Data, item.srcAddr)
-				if err != nil {
-					sf.log.Errorf("[ UDP ] udp conn write to local conn fail, %s ", err)
-				}
-			}
-		})
-
-		return item, nil
-	})
-	if err != nil {
-		return
-	}
-
-	// parent ---> src
-	item := itm.(*connItem)
-	atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-	err = enet.WrapWriteTimeout(item.targetConn, sf.cfg.Timeout, func(c net.Conn) error {
-		as, err := captain.ParseAddrSpec(srcAddr)
-		if err != nil {
-			return err
-		}
-		sData := captain.StreamDatagram{
-			Addr: as,
-			Data: msg.Data,
-		}
-		header, err := sData.Header()
-		if err != nil {
-			return err
-		}
-		buf := sword.Binding.Get()
-		defer sword.Binding.Put(buf)
-		tmpBuf := append(buf, header...)
-		tmpBuf = append(tmpBuf, sData.Data...)
-		c.Write(tmpBuf) // nolint: errcheck
-		return nil
-	})
-	if err != nil {
+	// stream parent takes the src address of datagram as the association
+	if err := sf.relay.Send(msg.SrcAddr, msg.SrcAddr, msg.Data); err != nil {
 		sf.log.Errorf("[ UDP ] udp conn write to stream parent conn fail, %s ", err)
 	}
 }
 
 func (sf *UDP) proxyUdp2Udp(_ *net.UDPConn, msg cs.Message) {
-	srcAddr := msg.SrcAddr.String()
-
-	itm, err, _ := sf.single.Do(srcAddr, func() (interface{}, error) {
-		if v, ok := sf.conns.Get(srcAddr); ok {
-			return v, nil
-		}
-
-		targetAddr, err := net.ResolveUDPAddr("udp", sf.cfg.Parent)
-		if err != nil {
-			sf.log.Errorf("[ UDP ] resolve udp parent addr< %s > fail, %+v", sf.cfg.Parent, err)
-			return nil, err
-		}
-		targetConn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetAddr)
-		if err != nil {
-			sf.log.Errorf("[ UDP ] connect to udp parent addr< %s > fail, %+v", targetAddr, err)
-			return nil, err
-		}
-		item := &connItem{
-			targetConn,
-			msg.SrcAddr,
-			time.Now().Unix(),
-		}
-		sf.conns.Set(srcAddr, item)
-		// parent ---> src
-		sword.Go(func() {
-			sf.log.Infof("[ UDP ] udp conn %s ---> %s connected", srcAddr, targetAddr.String())
-			buf := sword.Binding.Get()
-			defer func() {
-				sword.Binding.Put(buf)
-				sf.conns.Remove(srcAddr)
-				item.targetConn.Close()
-				sf.log.Infof("[ UDP ] udp conn %s ---> %s released", srcAddr, targetAddr.String())
-			}()
-			for {
-				n, err := item.targetConn.Read(buf[:cap(buf)])
-				if err != nil {
-					if !extnet.IsErrClosed(err) {
-						sf.log.Warnf("[ UDP ] udp conn read from parent conn fail, %s ", err)
-					}
-					return
-				}
-				atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-				_, err = sf.udpConn.WriteToUDP(buf[:n], item.srcAddr)
-				if err != nil {
-					sf.log.Warnf("[ UDP ] udp conn write to local conn fail, %s ", err)
-					return
-				}
-			}
-		})
-		return item, nil
-	})
-	if err != nil {
-		return
-	}
-	// src ---> parent
-	item := itm.(*connItem)
-	atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-	_, err = item.targetConn.Write(msg.Data)
-	if err != nil {
+	// udp parent is resolved per association, the connected conn ignore the dst address
+	if err := sf.relay.Send(msg.SrcAddr, msg.SrcAddr, msg.Data); err != nil {
 		sf.log.Warnf("[ UDP ] udp conn write to parent conn fail, %s ", err)
 	}
 }
@@ -350,3 +242,16 @@ func (sf *UDP) dialParent(address string) (net.Conn, error) {
 	}
 	return d.Dial("tcp", address)
 }
+
+// releasedPacketConn 关联关闭时记录释放日志
+type releasedPacketConn struct {
+	net.PacketConn
+	once    sync.Once
+	release func()
+}
+
+func (sf *releasedPacketConn) Close() error {
+	err := sf.PacketConn.Close()
+	sf.once.Do(sf.release)
+	return err
+}